@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"go-chat/internal/actors"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"go-chat/shared/markdown"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+)
+
+// maxAccountName bounds the usernames of accounts, in runes
+const maxAccountName = 32
+
+// ensureAccount creates an account named name unless it, or a bot of that
+// name, exists. It returns the token of a new account.
+func ensureAccount(history store.Store, name string) (string, error) {
+	if _, err := accountToken(history, name); !errors.Is(err, store.ErrNotFound) {
+		return "", err
+	}
+	token, hash, err := newToken(accountTokenPrefix)
+	if err != nil {
+		return "", err
+	}
+	account := api.Account{Name: name, CreatedAt: time.Now().UnixMilli()}
+	if err := history.SaveAccount(account, hash); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// authorizeOwner writes an error and reports false unless the request
+// comes from a session of an owner of the room every client joins. It
+// returns the owner's username.
+func authorizeOwner(w http.ResponseWriter, r *http.Request, history store.Store) (string, bool) {
+	username, ok := requireSession(w, r, history)
+	if !ok {
+		return "", false
+	}
+	role, err := history.Role(actors.DefaultRoom, username)
+	if err != nil {
+		log.Error("failed to look up role", "room", actors.DefaultRoom, "username", username, "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "role could not be checked")
+		return "", false
+	}
+	if role != api.RoleOwner {
+		writeError(w, http.StatusForbidden, api.ErrCodeForbidden, "only owners can do this")
+		return "", false
+	}
+	return username, true
+}
+
+// setupAccounts serves the account routes, which share a path
+func setupAccounts(history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case api.RouteAccounts.Method:
+			listAccounts(w, r, history)
+		case api.RouteCreateAccount.Method:
+			createAccount(w, r, history)
+		case api.RouteDeleteAccount.Method:
+			deleteAccount(w, r, history)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listAccounts(w http.ResponseWriter, r *http.Request, history store.Store) {
+	if _, ok := authorizeOwner(w, r, history); !ok {
+		return
+	}
+
+	accounts, err := history.Accounts()
+	if err != nil {
+		log.Error("failed to list accounts", "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "accounts could not be listed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(accounts)
+}
+
+func createAccount(w http.ResponseWriter, r *http.Request, history store.Store) {
+	owner, ok := authorizeOwner(w, r, history)
+	if !ok {
+		return
+	}
+	var req api.CreateAccountRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid JSON body")
+		return
+	}
+	req.Name = strings.TrimPrefix(strings.TrimSpace(req.Name), "@")
+	if !markdown.Mentionable(req.Name) || utf8.RuneCountInString(req.Name) > maxAccountName {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "name must be a valid username")
+		return
+	}
+	if _, err := accountToken(history, req.Name); err == nil {
+		writeError(w, http.StatusConflict, api.ErrCodeInvalidRequest, "an account or bot named "+req.Name+" exists")
+		return
+	}
+
+	token, hash, err := newToken(accountTokenPrefix)
+	if err != nil {
+		log.Error("failed to generate account token", "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "account could not be created")
+		return
+	}
+	account := api.Account{
+		Name:      req.Name,
+		CreatedBy: owner,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if err := history.SaveAccount(account, hash); err != nil {
+		log.Error("failed to save account", "name", account.Name, "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "account could not be created")
+		return
+	}
+	// Guests signed in under the name lose it to the account
+	if err := history.DeleteSessions(account.Name); err != nil {
+		log.Error("failed to end guest sessions", "name", account.Name, "error", err)
+	}
+	log.Info("account created", "name", account.Name, "by", owner)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(api.CreateAccountResponse{Account: account, Token: token})
+}
+
+func deleteAccount(w http.ResponseWriter, r *http.Request, history store.Store) {
+	owner, ok := authorizeOwner(w, r, history)
+	if !ok {
+		return
+	}
+	var req api.DeleteAccountRequest
+	if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if req.Name == owner {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "you cannot delete your own account")
+		return
+	}
+	if _, _, err := history.Account(req.Name); errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, api.ErrCodeNotFound, "no such account")
+		return
+	}
+
+	// The role goes first, so a failure leaves no role without an account
+	err := history.SetRole(actors.DefaultRoom, req.Name, api.RoleMember)
+	if err == nil {
+		err = history.DeleteAccount(req.Name)
+	}
+	if err == nil {
+		err = history.DeleteSessions(req.Name)
+	}
+	if err != nil {
+		log.Error("failed to delete account", "name", req.Name, "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "account could not be deleted")
+		return
+	}
+	log.Info("account deleted", "name", req.Name, "by", owner)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"go-chat/internal/actors"
+	"go-chat/shared/api"
+	"net/http"
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/charmbracelet/log"
+)
+
+// actorTimeout bounds how long an actor may take to answer a REST request
+const actorTimeout = 5 * time.Second
+
+// askActor forwards a REST request to an actor and writes the JSON it
+// answers with as the response
+func askActor(w http.ResponseWriter, engine *actor.Engine, pid *actor.PID, req any) {
+	res, err := engine.Request(pid, req, actorTimeout).Result()
+	if err != nil {
+		log.Error("actor did not respond", "pid", pid, "error", err)
+		writeError(w, http.StatusServiceUnavailable, api.ErrCodeServerError, "actor did not respond")
+		return
+	}
+	response, ok := res.(*actors.APIResponse)
+	if !ok {
+		log.Error("unexpected actor response", "pid", pid, "type", res)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "unexpected actor response")
+		return
+	}
+	if response.Error != "" {
+		switch response.Status {
+		case http.StatusNotFound:
+			writeError(w, http.StatusNotFound, api.ErrCodeNotFound, response.Error)
+		case http.StatusBadRequest:
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, response.Error)
+		case http.StatusForbidden:
+			writeError(w, http.StatusForbidden, api.ErrCodeForbidden, response.Error)
+		default:
+			writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, response.Error)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(response.Data)
+}
+
+// writeError writes a standardized api.ErrorResponse
+func writeError(w http.ResponseWriter, status int, code, details string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(api.ErrorResponse{
+		Error:   http.StatusText(status),
+		Code:    code,
+		Details: details,
+	})
+}
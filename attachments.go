@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat/internal/blob"
+	"go-chat/internal/store"
+	"go-chat/internal/thumbnail"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"go-chat/shared/ws"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+	"github.com/oklog/ulid/v2"
+)
+
+// maxAttachmentName bounds the length of an uploaded file's name, in bytes
+const maxAttachmentName = 255
+
+// multipartOverhead is what an upload may add to the file for the
+// multipart encoding around it
+const multipartOverhead = 64 << 10
+
+// attachmentKey is where the contents of an attachment are kept in the
+// blob store
+func attachmentKey(id string) string {
+	return "attachments/" + id
+}
+
+// thumbnailKey is where the preview of an attachment is kept in the blob
+// store
+func thumbnailKey(id string) string {
+	return "thumbnails/" + id
+}
+
+func setupUploadAttachment(blobs blob.Store, history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteUploadAttachment.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req api.UploadAttachmentRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if req.Username == "" {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "username is required")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, api.MaxAttachmentSize+multipartOverhead)
+		name, data, err := readUpload(r)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge) || len(data) > api.MaxAttachmentSize:
+			writeError(w, http.StatusRequestEntityTooLarge, api.ErrCodeInvalidRequest, fmt.Sprintf("file exceeds %d MB", api.MaxAttachmentSize>>20))
+			return
+		case err != nil:
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		case len(data) == 0:
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "file is empty")
+			return
+		}
+
+		contentType, ok := attachmentType(data)
+		if !ok {
+			writeError(w, http.StatusUnsupportedMediaType, api.ErrCodeInvalidRequest, "unsupported file type")
+			return
+		}
+
+		att := ws.Attachment{
+			ID:          ulid.Make().String(),
+			Name:        attachmentName(name),
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			UploadedBy:  req.Username,
+		}
+		if err := blobs.Put(r.Context(), attachmentKey(att.ID), bytes.NewReader(data), att.Size, contentType); err != nil {
+			log.Error("failed to store attachment", "id", att.ID, "error", err)
+			writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "failed to store file")
+			return
+		}
+
+		// A missing preview is not worth failing the upload over
+		if strings.HasPrefix(contentType, "image/") {
+			thumb, err := thumbnail.Generate(data)
+			switch {
+			case err == nil:
+				if err := blobs.Put(r.Context(), thumbnailKey(att.ID), bytes.NewReader(thumb.Data), int64(len(thumb.Data)), thumb.ContentType); err != nil {
+					log.Error("failed to store thumbnail", "id", att.ID, "error", err)
+				} else {
+					att.Thumbnail = true
+				}
+				att.Width, att.Height = thumb.SourceWidth, thumb.SourceHeight
+			case !errors.Is(err, thumbnail.ErrUnsupported):
+				log.Warn("failed to generate thumbnail", "id", att.ID, "error", err)
+			}
+		}
+
+		if err := history.SaveAttachment(att); err != nil {
+			log.Error("failed to save attachment", "id", att.ID, "error", err)
+			writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "failed to store file")
+			return
+		}
+		log.Info("attachment uploaded", "id", att.ID, "username", att.UploadedBy, "type", att.ContentType, "size", att.Size)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(att)
+	}
+}
+
+// readUpload reads the file part of a multipart upload, keeping at most
+// one byte more than api.MaxAttachmentSize so oversized files are detected
+func readUpload(r *http.Request) (string, []byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, errors.New("multipart form data is required")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil, errors.New(api.AttachmentFormField + " is required")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if part.FormName() != api.AttachmentFormField {
+			part.Close()
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, api.MaxAttachmentSize+1))
+		part.Close()
+		return part.FileName(), data, err
+	}
+}
+
+// attachmentType detects the content type of a file from its contents,
+// and reports whether files of that type may be uploaded
+func attachmentType(data []byte) (string, bool) {
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || !slices.Contains(api.AttachmentTypes, contentType) {
+		return "", false
+	}
+	return contentType, true
+}
+
+// attachmentName reduces an uploaded file name to its last element,
+// without control characters and at most maxAttachmentName bytes long
+func attachmentName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	for len(name) > maxAttachmentName {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+func setupAttachment(blobs blob.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteAttachment.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req api.AttachmentRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if _, err := ulid.ParseStrict(req.ID); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid id")
+			return
+		}
+
+		key := attachmentKey(req.ID)
+		if req.Thumbnail {
+			key = thumbnailKey(req.ID)
+		}
+		obj, err := blobs.Get(r.Context(), key)
+		if errors.Is(err, blob.ErrNotFound) {
+			writeError(w, http.StatusNotFound, api.ErrCodeNotFound, "no such file")
+			return
+		}
+		if err != nil {
+			log.Error("failed to load attachment", "key", key, "error", err)
+			writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "failed to load file")
+			return
+		}
+		defer obj.Close()
+
+		// Uploaded files are never rendered as documents of this origin,
+		// whatever a browser makes of them
+		w.Header().Set("Content-Type", obj.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		if !strings.HasPrefix(obj.ContentType, "image/") {
+			w.Header().Set("Content-Disposition", "attachment")
+		}
+		// The contents behind an ID never change
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		if obj.Size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+		}
+		if _, err := io.Copy(w, obj); err != nil {
+			log.Warn("failed to send attachment", "key", key, "error", err)
+		}
+	}
+}
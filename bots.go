@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"go-chat/internal/actors"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"go-chat/shared/markdown"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+)
+
+// Limits of bot accounts
+const (
+	maxBotName        = 32  // Runes
+	maxBotDescription = 200 // Runes
+)
+
+// setupBots serves the bot account routes, which share a path
+func setupBots(history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case api.RouteBots.Method:
+			listBots(w, r, history)
+		case api.RouteCreateBot.Method:
+			createBot(w, r, history)
+		case api.RouteDeleteBot.Method:
+			deleteBot(w, r, history)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listBots(w http.ResponseWriter, r *http.Request, history store.Store) {
+	if _, ok := authorizeOwner(w, r, history); !ok {
+		return
+	}
+
+	bots, err := history.Bots()
+	if err != nil {
+		log.Error("failed to list bots", "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "bots could not be listed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(bots)
+}
+
+func createBot(w http.ResponseWriter, r *http.Request, history store.Store) {
+	owner, ok := authorizeOwner(w, r, history)
+	if !ok {
+		return
+	}
+	var req api.CreateBotRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid JSON body")
+		return
+	}
+	req.Name = strings.TrimPrefix(strings.TrimSpace(req.Name), "@")
+	if !markdown.Mentionable(req.Name) || utf8.RuneCountInString(req.Name) > maxBotName {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "name must be a valid username")
+		return
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	if utf8.RuneCountInString(req.Description) > maxBotDescription {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "description is too long")
+		return
+	}
+	if _, err := accountToken(history, req.Name); err == nil {
+		writeError(w, http.StatusConflict, api.ErrCodeInvalidRequest, "an account or bot named "+req.Name+" exists")
+		return
+	}
+
+	token, hash, err := newToken(botTokenPrefix)
+	if err != nil {
+		log.Error("failed to generate bot token", "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "bot could not be created")
+		return
+	}
+	bot := api.Bot{
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedBy:   owner,
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+	if err := history.SaveBot(bot, hash); err != nil {
+		log.Error("failed to save bot", "name", bot.Name, "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "bot could not be created")
+		return
+	}
+	// Guests signed in under the name lose it to the bot
+	if err := history.DeleteSessions(bot.Name); err != nil {
+		log.Error("failed to end guest sessions", "name", bot.Name, "error", err)
+	}
+	log.Info("bot created", "name", bot.Name, "by", owner)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(api.CreateBotResponse{Bot: bot, Token: token})
+}
+
+func deleteBot(w http.ResponseWriter, r *http.Request, history store.Store) {
+	owner, ok := authorizeOwner(w, r, history)
+	if !ok {
+		return
+	}
+	var req api.DeleteBotRequest
+	if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if _, _, err := history.Bot(req.Name); errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, api.ErrCodeNotFound, "no such bot")
+		return
+	}
+	err := history.SetRole(actors.DefaultRoom, req.Name, api.RoleMember)
+	if err == nil {
+		err = history.DeleteBot(req.Name)
+	}
+	if err == nil {
+		err = history.DeleteSessions(req.Name)
+	}
+	if err != nil {
+		log.Error("failed to delete bot", "name", req.Name, "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "bot could not be deleted")
+		return
+	}
+	log.Info("bot deleted", "name", req.Name, "by", owner)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
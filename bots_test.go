@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go-chat/internal/actors"
+	"go-chat/internal/blob"
+	"go-chat/internal/broadcast"
+	"go-chat/internal/store"
+	"go-chat/pkg/bot"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"go-chat/shared/ws"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer serves a single instance with an in-memory store, and
+// returns its URL and store
+func newTestServer(t *testing.T) (string, store.Store) {
+	t.Helper()
+	engine, err := actor.NewEngine(actor.NewEngineConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := store.NewMemory()
+	broadcaster := broadcast.NewLocal()
+	users := actors.NewLocalUsers(engine, broadcaster, history)
+	rooms := actors.NewLocalRooms(engine, broadcaster, history, users, nil)
+
+	server := httptest.NewServer(newMux(engine, rooms, users, history, blobs, newHealthChecker(engine, rooms)))
+	t.Cleanup(server.Close)
+	return server.URL, history
+}
+
+// signIn starts a session for username, with the token of its account if
+// it has one
+func signIn(t *testing.T, serverURL, username, token string) string {
+	t.Helper()
+	session, err := apihttp.NewClient(serverURL, api.RouteCreateSession).Request(api.SessionRequest{Username: username, Token: token})
+	if err != nil {
+		t.Fatalf("signing in as %s: %v", username, err)
+	}
+	return session.Token
+}
+
+// TestBotAgainstServer runs a bot created through the API against an
+// in-process server: it is mentioned by a guest, replies in the thread,
+// and posts to the room
+func TestBotAgainstServer(t *testing.T) {
+	serverURL, history := newTestServer(t)
+
+	ownerToken, err := ensureAccount(history, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := history.SetRole(actors.DefaultRoom, "alice", api.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	ownerSession := signIn(t, serverURL, "alice", ownerToken)
+
+	// Only owners may create bots
+	guestSession := signIn(t, serverURL, "carol", "")
+	_, err = apihttp.NewClient(serverURL, api.RouteCreateBot).WithToken(guestSession).Request(api.CreateBotRequest{Name: "echo"})
+	var apiErr *apihttp.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("creating a bot as a guest = %v; want 403", err)
+	}
+	created, err := apihttp.NewClient(serverURL, api.RouteCreateBot).WithToken(ownerSession).Request(api.CreateBotRequest{Name: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The guest joins first, so the bot's JOIN shows it is connected
+	u, _ := url.Parse(serverURL + "/ws")
+	u.Scheme = "ws"
+	u.RawQuery = url.Values{ws.QuerySession: {guestSession}, ws.QueryRoom: {actors.DefaultRoom}}.Encode()
+	guest, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer guest.Close()
+	guest.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	b := bot.New(bot.Config{URL: serverURL, Name: "echo", Token: created.Token})
+	b.OnMessage(func(ctx context.Context, m *bot.Message) {
+		if m.Mentioned(b.Name()) {
+			m.Reply(ctx, strings.TrimSpace(strings.TrimPrefix(m.Text, "@echo")))
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- b.Run(ctx, actors.DefaultRoom)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	// next reads the guest's connection until a message matches
+	next := func(what string, match func(*ws.Message) bool) *ws.Message {
+		t.Helper()
+		for {
+			var msg ws.Message
+			if err := guest.ReadJSON(&msg); err != nil {
+				t.Fatalf("waiting for %s: %v", what, err)
+			}
+			if match(&msg) {
+				return &msg
+			}
+		}
+	}
+	next("the bot to join", func(msg *ws.Message) bool {
+		join, ok := msg.Payload.(*ws.JoinMessage)
+		return ok && join.From == "echo"
+	})
+
+	if err := guest.WriteJSON(&ws.Message{
+		Type:    ws.TypeMessage,
+		Payload: &ws.TextMessage{Nonce: "mention", Text: "@echo hello"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ack := next("the mention to be accepted", func(msg *ws.Message) bool {
+		ack, ok := msg.Payload.(*ws.AckMessage)
+		return ok && ack.Nonce == "mention"
+	}).Payload.(*ws.AckMessage)
+	reply := next("the bot's reply", func(msg *ws.Message) bool {
+		text, ok := msg.Payload.(*ws.TextMessage)
+		return ok && text.From == "echo"
+	}).Payload.(*ws.TextMessage)
+	if reply.Text != "hello" || reply.ParentID != ack.ID {
+		t.Errorf("reply = %q in thread %q; want %q in thread %q", reply.Text, reply.ParentID, "hello", ack.ID)
+	}
+
+	id, err := b.Send(ctx, actors.DefaultRoom, "back")
+	if err != nil || id == "" {
+		t.Errorf("Send = %q, %v", id, err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	backendBinary = "tmp/server"
+
+	// How long the backend gets to exit after an interrupt before it is killed
+	backendStopTimeout = 3 * time.Second
+	// How long to wait for a restarted backend to accept connections
+	backendReadyTimeout = 10 * time.Second
+)
+
+// buildWASM rebuilds the frontend with the same script `make build-wasm` uses
+func buildWASM() error {
+	log.Info("Building WASM...")
+	return run("./scripts/build_wasm.sh")
+}
+
+// backend builds and supervises the chat server process
+type backend struct {
+	addr string
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+}
+
+func newBackend(addr string) *backend {
+	return &backend{addr: addr}
+}
+
+// Restart rebuilds the server binary, replaces the running process and
+// waits until the new one accepts connections. The old process keeps running
+// if the build fails.
+func (b *backend) Restart() error {
+	log.Info("Building server...")
+	if err := os.MkdirAll(filepath.Dir(backendBinary), 0o755); err != nil {
+		return err
+	}
+	if err := run("go", "build", "-o", backendBinary, "."); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stopLocked()
+
+	cmd := exec.Command(backendBinary, "-addr", b.addr)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	b.cmd = cmd
+	log.Info("Server started", "pid", cmd.Process.Pid, "addr", b.addr)
+
+	return waitForListener(b.addr, backendReadyTimeout)
+}
+
+// Stop terminates the running server, if any
+func (b *backend) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopLocked()
+}
+
+func (b *backend) stopLocked() {
+	if b.cmd == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func(cmd *exec.Cmd) {
+		cmd.Wait()
+		close(done)
+	}(b.cmd)
+
+	b.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(backendStopTimeout):
+		log.Warn("server did not stop in time, killing it", "pid", b.cmd.Process.Pid)
+		b.cmd.Process.Kill()
+		<-done
+	}
+	b.cmd = nil
+}
+
+// waitForListener polls addr until it accepts TCP connections
+func waitForListener(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("server did not start listening on %s within %s", addr, timeout)
+}
+
+// run executes a command with its output attached to the dev server's
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWaitForListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	if err := waitForListener(addr, time.Second); err != nil {
+		t.Errorf("waiting for a listener = %v", err)
+	}
+
+	l.Close()
+	if err := waitForListener(addr, 200*time.Millisecond); err == nil {
+		t.Error("waiting for a closed listener succeeded")
+	}
+}
@@ -0,0 +1,82 @@
+// Command devserver runs the chat server with live reload.
+//
+// It watches the source tree, rebuilds the WASM frontend when frontend/ or
+// shared/ changes, restarts the backend when server packages change, and
+// proxies the backend so that every HTML page gets a small script that
+// reloads the browser over a dedicated WebSocket after each rebuild.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func main() {
+	addr := flag.String("addr", ":3000", "address the browser-facing proxy listens on")
+	backendAddr := flag.String("backend", "localhost:8080", "address the backend server listens on")
+	interval := flag.Duration("interval", 500*time.Millisecond, "how often to poll the source tree for changes")
+	flag.Parse()
+
+	hub := newReloadHub()
+	backend := newBackend(*backendAddr)
+
+	if err := buildWASM(); err != nil {
+		log.Error("initial WASM build failed", "error", err)
+	}
+	if err := backend.Restart(); err != nil {
+		log.Error("initial backend start failed", "error", err)
+	}
+
+	w := newWatcher(".", *interval)
+	go w.Run(func(change changeSet) {
+		rebuild(change, backend, hub)
+	})
+
+	proxy, err := newProxy(*backendAddr, hub)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Info("Shutting down dev server")
+		backend.Stop()
+		os.Exit(0)
+	}()
+
+	log.Info("Dev server starting", "addr", *addr, "backend", *backendAddr)
+	if err := http.ListenAndServe(*addr, proxy); err != nil {
+		backend.Stop()
+		log.Fatal(err)
+	}
+}
+
+// rebuild runs the builds required by a change set and reloads the browsers
+// once everything that was rebuilt is up again.
+func rebuild(change changeSet, backend *backend, hub *reloadHub) {
+	log.Info("Change detected", "files", change.files, "wasm", change.wasm, "server", change.server)
+
+	if change.wasm {
+		if err := buildWASM(); err != nil {
+			log.Error("WASM build failed", "error", err)
+			return
+		}
+	}
+
+	if change.server {
+		if err := backend.Restart(); err != nil {
+			log.Error("backend restart failed", "error", err)
+			return
+		}
+	}
+
+	hub.Reload()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/websocket"
+)
+
+// reloadPath is the dedicated WebSocket browsers listen on for reload events
+const reloadPath = "/__devserver/reload"
+
+// reloadScript is injected into every HTML page served through the proxy.
+// It reloads the page on a reload event, and also when the socket comes
+// back after the dev server itself was restarted.
+var reloadScript = fmt.Sprintf(`<script>
+(function() {
+    var url = (location.protocol === "https:" ? "wss://" : "ws://") + location.host + %q;
+    function connect(reconnecting) {
+        var sock = new WebSocket(url);
+        sock.onopen = function() { if (reconnecting) location.reload(); };
+        sock.onmessage = function() { location.reload(); };
+        sock.onclose = function() { setTimeout(function() { connect(true); }, 1000); };
+    }
+    connect(false);
+})();
+</script>
+`, reloadPath)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true // development only
+	},
+}
+
+// reloadHub tracks connected browsers and tells them to reload
+type reloadHub struct {
+	mu    sync.Mutex
+	conns map[*websocket.Conn]bool
+}
+
+func newReloadHub() *reloadHub {
+	return &reloadHub{conns: make(map[*websocket.Conn]bool)}
+}
+
+// ServeHTTP registers a browser until its socket closes
+func (h *reloadHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error("failed to upgrade reload socket", "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	h.conns[conn] = true
+	h.mu.Unlock()
+
+	// The browser never sends anything; reading only detects the close
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	h.mu.Lock()
+	delete(h.conns, conn)
+	h.mu.Unlock()
+	conn.Close()
+}
+
+// Reload sends a reload event to every connected browser
+func (h *reloadHub) Reload() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	log.Info("Reloading browsers", "count", len(h.conns))
+	for conn := range h.conns {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"RELOAD"}`)); err != nil {
+			log.Error("failed to send reload event", "error", err)
+		}
+	}
+}
+
+// newProxy returns a handler that serves the reload socket and forwards
+// everything else, WebSocket upgrades included, to the backend
+func newProxy(backendAddr string, hub *reloadHub) (http.Handler, error) {
+	target, err := url.Parse("http://" + backendAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		// Keep responses uncompressed so the script can be injected
+		r.Header.Del("Accept-Encoding")
+	}
+	proxy.ModifyResponse = injectReloadScript
+
+	mux := http.NewServeMux()
+	mux.Handle(reloadPath, hub)
+	mux.Handle("/", proxy)
+	return mux, nil
+}
+
+// injectReloadScript adds the reload script to HTML responses
+func injectReloadScript(resp *http.Response) error {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if i := bytes.LastIndex(body, []byte("</body>")); i >= 0 {
+		body = append(body[:i:i], append([]byte(reloadScript), body[i:]...)...)
+	} else {
+		body = append(body, reloadScript...)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
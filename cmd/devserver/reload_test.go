@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestProxy checks that HTML pages from the backend get the reload
+// script, and everything else passes through untouched
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, "<html><body><p>chat</p></body></html>")
+		case "/fragment":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<p>chat</p>")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"ok":true}`)
+		}
+	}))
+	defer backend.Close()
+	proxy, err := newProxy(strings.TrimPrefix(backend.URL, "http://"), newReloadHub())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	for path, want := range map[string]string{
+		"/":         "<html><body><p>chat</p>" + reloadScript + "</body></html>",
+		"/fragment": "<p>chat</p>" + reloadScript,
+		"/api":      `{"ok":true}`,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want || resp.ContentLength != int64(len(want)) {
+			t.Errorf("GET %s = %d bytes %q; want %q", path, resp.ContentLength, body, want)
+		}
+	}
+}
+
+// TestReload checks that connected browsers are told to reload
+func TestReload(t *testing.T) {
+	hub := newReloadHub()
+	proxy, err := newProxy("127.0.0.1:1", hub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+reloadPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The hub registers the browser after the handshake
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		hub.mu.Lock()
+		connected := len(hub.conns)
+		hub.mu.Unlock()
+		if connected == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the browser never registered")
+		}
+	}
+
+	hub.Reload()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != `{"type":"RELOAD"}` {
+		t.Errorf("browser received %q, %v; want a RELOAD event", msg, err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// skipDirs are never watched: build output, tooling and the dev server itself
+var skipDirs = map[string]bool{
+	".git":          true,
+	".vscode":       true,
+	"dist":          true,
+	"tmp":           true,
+	"vendor":        true,
+	"cmd/devserver": true,
+}
+
+// generatedFiles are rewritten by the build itself and would otherwise
+// trigger an endless rebuild loop
+var generatedFiles = map[string]bool{
+	"frontend/internal/version.go": true,
+}
+
+// changeSet describes which parts of the application need rebuilding
+type changeSet struct {
+	files  []string
+	wasm   bool // frontend/ or shared/ changed
+	server bool // anything the backend binary is built from changed
+}
+
+// watcher polls a source tree for modified, added or removed files
+type watcher struct {
+	root     string
+	interval time.Duration
+}
+
+func newWatcher(root string, interval time.Duration) *watcher {
+	return &watcher{
+		root:     root,
+		interval: interval,
+	}
+}
+
+// Run polls the tree until the process exits, calling onChange for every
+// detected change set. Polling pauses while onChange runs, so edits made
+// during a build are picked up by the next poll.
+func (w *watcher) Run(onChange func(changeSet)) {
+	prev := w.scan()
+	for range time.Tick(w.interval) {
+		next := w.scan()
+		if change, ok := diff(prev, next); ok {
+			onChange(change)
+		}
+		prev = next
+	}
+}
+
+// scan returns the modification time of every watched file
+func (w *watcher) scan() map[string]time.Time {
+	files := make(map[string]time.Time)
+	err := filepath.WalkDir(w.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // file vanished mid-walk; the next scan will notice
+		}
+		rel, err := filepath.Rel(w.root, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if skipDirs[rel] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if generatedFiles[rel] || !watchedFile(rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files[rel] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		log.Error("failed to scan source tree", "error", err)
+	}
+	return files
+}
+
+func watchedFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".go", ".html", ".mod", ".sum":
+		return true
+	}
+	return false
+}
+
+// diff compares two scans and classifies what changed between them
+func diff(prev, next map[string]time.Time) (changeSet, bool) {
+	var change changeSet
+	for path, mod := range next {
+		if old, ok := prev[path]; !ok || !old.Equal(mod) {
+			change.add(path)
+		}
+	}
+	for path := range prev {
+		if _, ok := next[path]; !ok {
+			change.add(path)
+		}
+	}
+	return change, len(change.files) > 0
+}
+
+func (c *changeSet) add(path string) {
+	c.files = append(c.files, path)
+	switch {
+	case strings.HasPrefix(path, "frontend/"):
+		c.wasm = true
+	case strings.HasPrefix(path, "shared/"):
+		c.wasm = true
+		c.server = true
+	case path == "go.mod" || path == "go.sum":
+		c.wasm = true
+		c.server = true
+	default:
+		c.server = true
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestScan checks that only sources are watched, leaving out build output
+// and the files the build writes
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{
+		"main.go",
+		"go.mod",
+		"frontend/index.html",
+		"frontend/internal/version.go",
+		"shared/ws/messages.go",
+		"dist/main.wasm",
+		"dist/index.html",
+		"cmd/devserver/main.go",
+		"README.md",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for path := range newWatcher(root, time.Second).scan() {
+		got = append(got, path)
+	}
+	slices.Sort(got)
+	want := []string{"frontend/index.html", "go.mod", "main.go", "shared/ws/messages.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scan = %v; want %v", got, want)
+	}
+}
+
+// TestDiff checks that changes rebuild what is built from the files
+func TestDiff(t *testing.T) {
+	then := time.Unix(1_700_000_000, 0)
+	now := then.Add(time.Second)
+	prev := map[string]time.Time{"main.go": then, "frontend/app.go": then, "shared/ws/messages.go": then, "go.sum": then}
+
+	for _, tc := range []struct {
+		name         string
+		next         map[string]time.Time
+		wasm, server bool
+	}{{
+		name:   "backend edited",
+		next:   map[string]time.Time{"main.go": now, "frontend/app.go": then, "shared/ws/messages.go": then, "go.sum": then},
+		server: true,
+	}, {
+		name: "frontend file removed",
+		next: map[string]time.Time{"main.go": then, "shared/ws/messages.go": then, "go.sum": then},
+		wasm: true,
+	}, {
+		name:   "shared file edited",
+		next:   map[string]time.Time{"main.go": then, "frontend/app.go": then, "shared/ws/messages.go": now, "go.sum": then},
+		wasm:   true,
+		server: true,
+	}, {
+		name:   "dependencies changed",
+		next:   map[string]time.Time{"main.go": then, "frontend/app.go": then, "shared/ws/messages.go": then, "go.sum": now},
+		wasm:   true,
+		server: true,
+	}} {
+		change, ok := diff(prev, tc.next)
+		if !ok || len(change.files) != 1 || change.wasm != tc.wasm || change.server != tc.server {
+			t.Errorf("%s: diff = %+v, %v; want one file, wasm %v, server %v", tc.name, change, ok, tc.wasm, tc.server)
+		}
+	}
+
+	if change, ok := diff(prev, prev); ok {
+		t.Errorf("diff of the same scan = %+v", change)
+	}
+}
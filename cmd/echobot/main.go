@@ -0,0 +1,64 @@
+// Command echobot is an example bot built with pkg/bot. It answers
+// messages that mention it with their text, in their thread, and echoes
+// direct messages back to their sender.
+//
+// Create its account as an owner of the room, then run it with the token:
+//
+//	curl -X POST localhost:8080/api/bots -H 'Authorization: Bearer sess_...' -d '{"name":"echo"}'
+//	BOT_TOKEN=bot_... go run ./cmd/echobot -name echo
+package main
+
+import (
+	"context"
+	"flag"
+	"go-chat/pkg/bot"
+	"go-chat/shared/ws"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/charmbracelet/log"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "base URL of the chat server")
+	name := flag.String("name", "echo", "name of the bot account")
+	room := flag.String("room", "general", "room to join")
+	flag.Parse()
+
+	token := os.Getenv("BOT_TOKEN")
+	if token == "" {
+		log.Fatal("BOT_TOKEN is required")
+	}
+
+	b := bot.New(bot.Config{URL: *url, Name: *name, Token: token})
+	b.OnMessage(func(ctx context.Context, m *bot.Message) {
+		if !m.Mentioned(b.Name()) {
+			return
+		}
+		text := strings.Join(strings.Fields(strings.ReplaceAll(m.Text, "@"+b.Name(), "")), " ")
+		if text == "" {
+			return
+		}
+		if _, err := m.Reply(ctx, text); err != nil {
+			log.Error("failed to reply", "room", m.Room, "id", m.ID, "error", err)
+		}
+	})
+	b.OnDirect(func(ctx context.Context, dm *ws.DirectMessage) {
+		if err := b.SendDirect(ctx, dm.From, dm.Text); err != nil {
+			log.Error("failed to answer direct message", "to", dm.From, "error", err)
+		}
+	})
+	b.OnJoin(func(ctx context.Context, room, username string) {
+		log.Info("user joined", "room", room, "username", username)
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info("Echo bot starting", "name", *name, "room", *room, "url", *url)
+	if err := b.Run(ctx, *room); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"go-chat/internal/actors"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"net/http"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/charmbracelet/log"
+)
+
+// authorizeDirect writes an error and reports false unless the request
+// comes from a session of username. An empty username stands for the user
+// of the session, which is returned.
+func authorizeDirect(w http.ResponseWriter, r *http.Request, history store.Store, username string) (string, bool) {
+	caller, ok := requireSession(w, r, history)
+	if !ok {
+		return "", false
+	}
+	if username != "" && username != caller {
+		writeError(w, http.StatusForbidden, api.ErrCodeForbidden, "you can only read your own direct messages")
+		return "", false
+	}
+	return caller, true
+}
+
+func setupConversations(engine *actor.Engine, users actors.Users, history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteConversations.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req api.ConversationsRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		username, ok := authorizeDirect(w, r, history, req.Username)
+		if !ok {
+			return
+		}
+
+		askRegistry(w, engine, users, &actors.ConversationsRequest{Username: username})
+	}
+}
+
+func setupDirectMessages(engine *actor.Engine, users actors.Users, history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteDirectMessages.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req api.DirectMessagesRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if req.With == "" {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "with is required")
+			return
+		}
+		username, ok := authorizeDirect(w, r, history, req.Username)
+		if !ok {
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = api.DefaultDirectMessagesLimit
+		}
+		req.Limit = min(req.Limit, api.MaxDirectMessagesLimit)
+
+		askRegistry(w, engine, users, &actors.DirectMessagesRequest{
+			Username: username,
+			With:     req.With,
+			Limit:    int32(req.Limit),
+		})
+	}
+}
+
+// askRegistry forwards a history request to the registry actor
+func askRegistry(w http.ResponseWriter, engine *actor.Engine, users actors.Users, req any) {
+	registryPID, _, err := users.Registry()
+	if err != nil {
+		log.Error("failed to locate registry", "error", err)
+		writeError(w, http.StatusServiceUnavailable, api.ErrCodeServerError, "registry unavailable")
+		return
+	}
+	askActor(w, engine, registryPID, req)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"net/http"
+	"testing"
+)
+
+// TestDirectMessagesOnlyForCaller checks that direct messages are only
+// served to the user signed in
+func TestDirectMessagesOnlyForCaller(t *testing.T) {
+	serverURL, _ := newTestServer(t)
+	carol := signIn(t, serverURL, "carol", "")
+	signIn(t, serverURL, "dave", "")
+
+	conversations := apihttp.NewClient(serverURL, api.RouteConversations)
+	messages := apihttp.NewClient(serverURL, api.RouteDirectMessages)
+	for name, tc := range map[string]struct {
+		request func() error
+		status  int
+	}{
+		"conversations without a session": {func() error {
+			_, err := conversations.Request(api.ConversationsRequest{Username: "dave"})
+			return err
+		}, http.StatusUnauthorized},
+		"conversations of someone else": {func() error {
+			_, err := conversations.WithToken(carol).Request(api.ConversationsRequest{Username: "dave"})
+			return err
+		}, http.StatusForbidden},
+		"messages of someone else": {func() error {
+			_, err := messages.WithToken(carol).Request(api.DirectMessagesRequest{Username: "dave", With: "carol"})
+			return err
+		}, http.StatusForbidden},
+		"own conversations": {func() error {
+			_, err := conversations.WithToken(carol).Request(api.ConversationsRequest{})
+			return err
+		}, http.StatusOK},
+		"own messages": {func() error {
+			_, err := messages.WithToken(carol).Request(api.DirectMessagesRequest{With: "dave"})
+			return err
+		}, http.StatusOK},
+	} {
+		err := tc.request()
+		status := http.StatusOK
+		var apiErr *apihttp.APIError
+		if errors.As(err, &apiErr) {
+			status = apiErr.StatusCode
+		} else if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if status != tc.status {
+			t.Errorf("%s = %d, %v; want %d", name, status, err, tc.status)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"syscall/js"
+
+	"go-chat/frontend/store"
+	"go-chat/frontend/store/actions"
+	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"go-chat/shared/ws"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/event"
+	"github.com/hexops/vecty/prop"
+)
+
+// parseAttachment reads an attachment of a MESSAGE payload
+func parseAttachment(payload map[string]interface{}) ws.Attachment {
+	id, _ := payload["id"].(string)
+	name, _ := payload["name"].(string)
+	contentType, _ := payload["content_type"].(string)
+	size, _ := payload["size"].(float64)
+	width, _ := payload["width"].(float64)
+	height, _ := payload["height"].(float64)
+	thumbnail, _ := payload["thumbnail"].(bool)
+	uploadedBy, _ := payload["uploaded_by"].(string)
+	return ws.Attachment{
+		ID:          id,
+		Name:        name,
+		ContentType: contentType,
+		Size:        int64(size),
+		Width:       int(width),
+		Height:      int(height),
+		Thumbnail:   thumbnail,
+		UploadedBy:  uploadedBy,
+	}
+}
+
+func (c *Chat) onDragOver(e *vecty.Event) {
+	e.Call("preventDefault") // Allows dropping
+	if !c.dragging {
+		c.dragging = true
+		vecty.Rerender(c)
+	}
+}
+
+func (c *Chat) onDragLeave(e *vecty.Event) {
+	// Moving between children of the drop zone also leaves it
+	if related := e.Get("relatedTarget"); related.Truthy() && e.Get("currentTarget").Call("contains", related).Bool() {
+		return
+	}
+	c.dragging = false
+	vecty.Rerender(c)
+}
+
+func (c *Chat) onDrop(e *vecty.Event) {
+	e.Call("preventDefault") // Keeps the browser from opening the file
+	c.dragging = false
+	c.upload(e.Get("dataTransfer").Get("files"))
+	vecty.Rerender(c)
+}
+
+func (c *Chat) onPickFiles(e *vecty.Event) {
+	c.upload(e.Target.Get("files"))
+	e.Target.Set("value", "") // Lets the same file be picked again
+	vecty.Rerender(c)
+}
+
+func (c *Chat) onRemoveAttachment(id string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		for i, att := range c.attachments {
+			if att.ID == id {
+				c.attachments = append(c.attachments[:i:i], c.attachments[i+1:]...)
+				break
+			}
+		}
+		vecty.Rerender(c)
+	}
+}
+
+// upload uploads a FileList in the background, adding every uploaded file
+// to the attachments of the next message
+func (c *Chat) upload(files js.Value) {
+	for i := 0; i < files.Get("length").Int(); i++ {
+		file := files.Call("item", i)
+		name := file.Get("name").String()
+		if len(c.attachments)+c.uploading >= api.MaxAttachments {
+			dispatcher.Dispatch(&actions.SetError{Message: "at most " + strconv.Itoa(api.MaxAttachments) + " files can be attached"})
+			return
+		}
+		if file.Get("size").Int() > api.MaxAttachmentSize {
+			dispatcher.Dispatch(&actions.SetError{Message: name + " is larger than " + formatSize(api.MaxAttachmentSize)})
+			continue
+		}
+
+		c.uploading++
+		go func() {
+			att, err := uploadFile(file)
+			c.uploading--
+			if err != nil {
+				message := "failed to upload " + name
+				var apiErr *apihttp.APIError
+				if errors.As(err, &apiErr) && apiErr.Details != "" {
+					message += ": " + apiErr.Details
+				}
+				dispatcher.Dispatch(&actions.SetError{Message: message})
+			} else {
+				c.attachments = append(c.attachments, *att)
+			}
+			vecty.Rerender(c)
+		}()
+	}
+}
+
+// uploadFile reads a browser File and uploads it. It blocks, so it must
+// not be called from an event handler.
+func uploadFile(file js.Value) (*ws.Attachment, error) {
+	buffers := make(chan js.Value, 1)
+	failures := make(chan string, 1)
+	onRead := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		buffers <- args[0]
+		return nil
+	})
+	defer onRead.Release()
+	onFail := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		failures <- args[0].Call("toString").String()
+		return nil
+	})
+	defer onFail.Release()
+
+	file.Call("arrayBuffer").Call("then", onRead, onFail)
+	select {
+	case buffer := <-buffers:
+		data := make([]byte, buffer.Get("byteLength").Int())
+		js.CopyBytesToGo(data, js.Global().Get("Uint8Array").New(buffer))
+		return actions.UploadAttachment(store.Username, file.Get("name").String(), data)
+	case failure := <-failures:
+		return nil, errors.New(failure)
+	}
+}
+
+// formatSize formats a number of bytes for people
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return strconv.FormatFloat(float64(size)/(1<<20), 'f', 1, 64) + " MB"
+	case size >= 1<<10:
+		return strconv.FormatFloat(float64(size)/(1<<10), 'f', 1, 64) + " KB"
+	default:
+		return strconv.FormatInt(size, 10) + " B"
+	}
+}
+
+// renderAttachments renders the files attached to a chat message, images
+// as previews linking to the full image
+func renderAttachments(attachments []ws.Attachment) vecty.ComponentOrHTML {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	var items []vecty.MarkupOrChild
+	items = append(items, vecty.Markup(vecty.Class("flex", "flex-wrap", "gap-2", "mt-1")))
+	for _, att := range attachments {
+		url := api.AttachmentURL(att.ID, false)
+		if att.Thumbnail {
+			items = append(items, elem.Anchor(
+				vecty.Markup(
+					vecty.Key(att.ID),
+					prop.Href(url),
+					vecty.Attribute("target", "_blank"),
+					vecty.Attribute("rel", "noopener"),
+				),
+				elem.Image(
+					vecty.Markup(
+						vecty.Class("max-h-48", "max-w-xs", "rounded-lg", "border", "border-gray-200", "dark:border-gray-700"),
+						prop.Src(api.AttachmentURL(att.ID, true)),
+						vecty.Attribute("alt", att.Name),
+						vecty.Attribute("title", att.Name+" ("+formatSize(att.Size)+")"),
+						vecty.Attribute("loading", "lazy"),
+					),
+				),
+			))
+			continue
+		}
+		items = append(items, elem.Anchor(
+			vecty.Markup(
+				vecty.Key(att.ID),
+				vecty.Class(
+					"inline-flex", "items-center", "gap-1", "px-2", "py-1", "rounded-lg", "text-sm",
+					"bg-gray-100", "dark:bg-gray-700",
+					"text-blue-600", "dark:text-blue-400", "hover:underline",
+				),
+				prop.Href(url),
+				vecty.Attribute("download", att.Name),
+			),
+			vecty.Text("📎 "+att.Name),
+			elem.Span(
+				vecty.Markup(vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400")),
+				vecty.Text(formatSize(att.Size)),
+			),
+		))
+	}
+	return elem.Div(items...)
+}
+
+// renderComposerAttachments renders the files that will be attached to
+// the next message, and the uploads still running
+func (c *Chat) renderComposerAttachments() vecty.ComponentOrHTML {
+	if len(c.attachments) == 0 && c.uploading == 0 {
+		return nil
+	}
+
+	var items []vecty.MarkupOrChild
+	items = append(items, vecty.Markup(vecty.Class("flex", "flex-wrap", "items-center", "gap-2", "mb-2")))
+	for _, att := range c.attachments {
+		var preview vecty.ComponentOrHTML = vecty.Text("📎 " + att.Name)
+		if att.Thumbnail {
+			preview = elem.Image(
+				vecty.Markup(
+					vecty.Class("h-12", "w-12", "object-cover", "rounded"),
+					prop.Src(api.AttachmentURL(att.ID, true)),
+					vecty.Attribute("alt", att.Name),
+					vecty.Attribute("title", att.Name),
+				),
+			)
+		}
+		items = append(items, elem.Span(
+			vecty.Markup(
+				vecty.Key(att.ID),
+				vecty.Class(
+					"inline-flex", "items-center", "gap-1", "p-1", "rounded-lg", "text-sm",
+					"bg-gray-100", "dark:bg-gray-700", "text-gray-700", "dark:text-gray-300",
+				),
+			),
+			preview,
+			elem.Button(
+				vecty.Markup(
+					vecty.Class("px-1", "text-gray-500", "hover:text-red-500"),
+					vecty.Attribute("title", "Remove"),
+					event.Click(c.onRemoveAttachment(att.ID)),
+				),
+				vecty.Text("✕"),
+			),
+		))
+	}
+	if c.uploading > 0 {
+		items = append(items, elem.Span(
+			vecty.Markup(vecty.Class("text-sm", "italic", "text-gray-500", "dark:text-gray-400")),
+			vecty.Text("Uploading "+strconv.Itoa(c.uploading)+"…"),
+		))
+	}
+	return elem.Div(items...)
+}
+
+// renderFilePicker renders a button choosing files to attach, for when
+// dragging them onto the chat is not an option
+func (c *Chat) renderFilePicker() vecty.ComponentOrHTML {
+	return elem.Label(
+		vecty.Markup(
+			vecty.Class(
+				"px-4", "py-3", "cursor-pointer", "rounded-lg",
+				"text-gray-700", "dark:text-gray-300",
+				"hover:bg-gray-200", "dark:hover:bg-gray-700",
+				"transition-colors", "duration-200",
+			),
+			vecty.Attribute("title", "Attach files"),
+		),
+		elem.Input(
+			vecty.Markup(
+				vecty.Class("hidden"),
+				prop.Type(prop.TypeFile),
+				vecty.Property("multiple", true),
+				vecty.Attribute("accept", strings.Join(api.AttachmentTypes, ",")),
+				event.Change(c.onPickFiles),
+			),
+		),
+		vecty.Text("📎"),
+	)
+}
@@ -5,12 +5,22 @@ package components
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall/js"
+	"time"
 
+	"go-chat/frontend/internal"
 	"go-chat/frontend/store"
 	"go-chat/frontend/store/actions"
 	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/http"
 	"go-chat/shared/ws"
 
 	"github.com/hexops/vecty"
@@ -19,14 +29,44 @@ import (
 	"github.com/hexops/vecty/prop"
 )
 
+// ackTimeout is how long a sent message may wait for its ACK or NACK
+const ackTimeout = 10 * time.Second
+
 // Chat is the main chat component
 type Chat struct {
 	vecty.Core
-	ws          js.Value
-	input       string
-	typingTimer js.Value
+	ws         js.Value
+	input      string
+	typingSent time.Time // When the last TYPING was sent; zero when not typing
+
+	// editingID is the message being edited in place, with its new text
+	editingID string
+	editText  string
+
+	// pickerID is the message whose emoji picker is open
+	pickerID string
+
+	// readSent is the Seq of the last READ sent on this connection
+	readSent uint64
+
+	// attachments are the uploaded files the next message will carry,
+	// while uploading more are on their way
+	attachments []ws.Attachment
+	uploading   int
+	dragging    bool // Files are being dragged over the chat
+
+	// connectedAt is when the connection opened, in Unix milliseconds
+	connectedAt int64
+	// renamed is set while reconnecting under a new username
+	renamed bool
 }
 
+// recentSeenBy is how many of the latest messages show who has seen them
+const recentSeenBy = 10
+
+// pickerEmoji are the reactions offered by the emoji picker
+var pickerEmoji = []string{"👍", "👎", "😂", "❤️", "🎉", "😮", "😢", "🙏", "🔥", "👀"}
+
 // Mount implements the vecty.Mounter interface
 func (c *Chat) Mount() {
 	log.Printf("🚀 Chat component mounted")
@@ -51,15 +91,66 @@ func NewChat() vecty.ComponentOrHTML {
 		log.Printf("📱 Creating new chat component")
 		chatInstance = &Chat{}
 		chatInstance.connectWS()
+		chatInstance.watchVisibility()
+		fetchCommands()
 	}
 	return chatInstance
 }
 
+// connectWS connects to the room, once the session is known to be valid
 func (c *Chat) connectWS() {
-	ws := js.Global().Get("WebSocket").New("ws://" + js.Global().Get("location").Get("host").String() + "/ws")
+	go func() {
+		if renewSession() {
+			c.dial()
+		}
+	}()
+}
+
+// renewSession checks the session before connecting. Sessions end when the
+// server restarts, so guests sign in again under their name, and account
+// holders are asked for their token. It reports whether to connect.
+func renewSession() bool {
+	_, err := actions.CheckSession(store.Session)
+	var apiErr *http.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 401 {
+		return true // Valid, or unknown until the server is reachable again
+	}
+	session, err := actions.CreateSession(store.Username, "")
+	if err != nil {
+		dispatcher.Dispatch(&actions.SignOut{Reason: "Your session ended, please sign in again"})
+		return false
+	}
+	dispatcher.Dispatch(&actions.SetSession{Username: session.Username, Token: session.Token})
+	return true
+}
+
+// open reports whether the connection is open. There is none until the
+// session has been checked.
+func (c *Chat) open() bool {
+	return c.ws.Truthy() && c.ws.Get("readyState").Int() == 1 // 1 = OPEN
+}
+
+func (c *Chat) dial() {
+	encode := js.Global().Get("encodeURIComponent")
+	url := "ws://" + js.Global().Get("location").Get("host").String() + "/ws" +
+		"?" + ws.QueryBuild + "=" + internal.BuildHash +
+		"&" + ws.QuerySession + "=" + encode.Invoke(store.Session).String()
+	if store.RoomEpoch != "" {
+		// Resume after the last message received before disconnecting
+		url += "&" + ws.QueryEpoch + "=" + store.RoomEpoch +
+			"&" + ws.QuerySince + "=" + strconv.FormatUint(store.LastSeq, 10)
+	}
+	ws := js.Global().Get("WebSocket").New(url)
 
 	ws.Set("onopen", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 		log.Printf("WebSocket connection established")
+		c.readSent = 0
+		c.typingSent = time.Time{}
+		c.connectedAt = time.Now().UnixMilli()
+		// New connections start online
+		if js.Global().Get("document").Get("hidden").Bool() {
+			c.sendPresence()
+		}
 		return nil
 	}))
 
@@ -69,6 +160,21 @@ func (c *Chat) connectWS() {
 	}))
 
 	ws.Set("onclose", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		// Users the server removed rejoin on their own
+		if store.Closed != nil {
+			log.Printf("WebSocket connection closed by the server: %s", store.Closed.Reason)
+			return nil
+		}
+		if store.Session == "" {
+			log.Printf("WebSocket connection closed after signing out")
+			return nil
+		}
+		if c.renamed {
+			log.Printf("WebSocket connection closed, reconnecting as %s", store.Username)
+			c.renamed = false
+			c.connectWS()
+			return nil
+		}
 		log.Printf("WebSocket connection closed, attempting to reconnect in 3 seconds...")
 		js.Global().Call("setTimeout", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 			c.connectWS()
@@ -96,33 +202,139 @@ func (c *Chat) connectWS() {
 		switch msgType {
 		case "MESSAGE":
 			if payload, ok := msg["payload"].(map[string]interface{}); ok {
-				text, _ := payload["text"].(string)
-				from, _ := payload["from"].(string)
-				if text != "" && from != "" {
-					log.Printf("Received chat message from %s: %s", from, text)
-					dispatcher.Dispatch(&actions.AddMessage{
-						Text: text,
-						From: from,
-					})
+				text := parseTextMessage(payload)
+				if text.ID != "" && (text.Text != "" || text.Deleted) && text.From != "" {
+					log.Printf("Received chat message %s from %s: %s", text.ID, text.From, text.Text)
+					dispatcher.Dispatch(&actions.AddMessage{Message: text})
+					c.markRead()
 				}
 			}
+		case "EDIT":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				edit := parseEditMessage(payload)
+				log.Printf("Message %s edited by %s", edit.ID, edit.EditedBy)
+				dispatcher.Dispatch(&actions.EditMessage{ID: edit.ID, Text: edit.Text, EditedAt: edit.EditedAt})
+			}
+		case "DELETE":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				id, _ := payload["id"].(string)
+				deletedBy, _ := payload["deleted_by"].(string)
+				log.Printf("Message %s deleted by %s", id, deletedBy)
+				dispatcher.Dispatch(&actions.DeleteMessage{ID: id})
+			}
+		case "REACT", "UNREACT":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				id, _ := payload["id"].(string)
+				emoji, _ := payload["emoji"].(string)
+				from, _ := payload["from"].(string)
+				dispatcher.Dispatch(&actions.ReactMessage{ID: id, Emoji: emoji, From: from, Add: msgType == "REACT"})
+			}
+		case "THREAD":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				dispatcher.Dispatch(&actions.UpdateThread{Update: parseThreadUpdate(payload)})
+			}
+		case "UNFURL":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				dispatcher.Dispatch(&actions.UnfurlMessage{Unfurl: parseUnfurl(payload)})
+			}
+		case "READ":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				dispatcher.Dispatch(&actions.MarkRead{Marker: parseReadMarker(payload)})
+			}
+		case "READ_STATE":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				dispatcher.Dispatch(&actions.SetReadState{State: parseReadState(payload)})
+				c.markRead()
+			}
+		case "ACK":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				nonce, _ := payload["nonce"].(string)
+				id, _ := payload["id"].(string)
+				command, _ := payload["command"].(string)
+				dispatcher.Dispatch(&actions.AckMessage{Nonce: nonce, ID: id, Command: command})
+			}
+		case "NACK":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				nonce, _ := payload["nonce"].(string)
+				errMsg, _ := payload["error"].(string)
+				dispatcher.Dispatch(&actions.NackMessage{Nonce: nonce, Error: errMsg})
+			}
+		case "SYNC":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				dispatcher.Dispatch(&actions.SyncMessages{Sync: parseSyncMessage(payload)})
+			}
+		case "DIRECT":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				direct := parseDirectMessage(payload)
+				log.Printf("Received direct message from %s to %s", direct.From, direct.To)
+				dispatcher.Dispatch(&actions.AddDirectMessage{Message: direct})
+			}
+		case "MENTION":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				mention := parseMention(payload)
+				log.Printf("Mentioned by %s in %s", mention.From, mention.Room)
+				notifyMention(mention)
+			}
+		case "PRESENCE_SNAPSHOT":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				dispatcher.Dispatch(&actions.SetPresenceSnapshot{Members: parsePresenceSnapshot(payload)})
+				fetchBans()
+				c.rerenderAfterMute()
+			}
+		case "PRESENCE_UPDATE":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				dispatcher.Dispatch(&actions.UpdatePresence{Presence: parsePresence(payload)})
+			}
+		case "KICK", "BAN", "UNBAN", "MUTE", "UNMUTE", "ROLE":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				c.onModeration(msgType, parseModeration(payload))
+			}
+		case "SYSTEM":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				dispatcher.Dispatch(&actions.AddNotice{Notice: parseSystem(payload)})
+			}
+		case "TOPIC":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				c.onTopic(parseTopic(payload))
+			}
+		case "NICK":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				c.onNick(parseNick(payload))
+			}
+		case "CLOSE":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				closed := parseClose(payload)
+				dispatcher.Dispatch(&actions.SetClosed{Close: &closed})
+			}
+		case "RELOAD_REQUIRED":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				currentBuild, _ := payload["current_build"].(string)
+				log.Printf("Server reports a newer frontend build: %s (running %s)", currentBuild, internal.BuildHash)
+				dispatcher.Dispatch(&actions.SetReloadRequired{
+					CurrentBuild: currentBuild,
+				})
+			}
+		case "ERROR":
+			if payload, ok := msg["payload"].(map[string]interface{}); ok {
+				errMsg, _ := payload["error"].(string)
+				retryAfter, _ := payload["retry_after_ms"].(float64)
+				dispatcher.Dispatch(&actions.SetError{Message: errMsg})
+				// Clear the error once the client may send again
+				js.Global().Call("setTimeout", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+					dispatcher.Dispatch(&actions.SetError{})
+					return nil
+				}), math.Max(retryAfter, 3000))
+			}
 		case "TYPING":
 			if payload, ok := msg["payload"].(map[string]interface{}); ok {
 				from, _ := payload["from"].(string)
 				isTyping, _ := payload["is_typing"].(bool)
+				// The server only sends when a user starts or stops typing
 				if from != "" && from != store.Username {
 					dispatcher.Dispatch(&actions.SetTyping{
 						Username: from,
 						IsTyping: isTyping,
 					})
-					// Clear typing indicator after 1 second
-					js.Global().Call("setTimeout", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-						dispatcher.Dispatch(&actions.SetTyping{
-							Username: from,
-							IsTyping: false,
-						})
-						return nil
-					}), 1000)
 				}
 			}
 		}
@@ -132,46 +344,435 @@ func (c *Chat) connectWS() {
 	c.ws = ws
 }
 
-func (c *Chat) onInput(e *vecty.Event) {
-	c.input = e.Target.Get("value").String()
-	vecty.Rerender(c)
+// parseTextMessage reads the payload of a MESSAGE message
+func parseTextMessage(payload map[string]interface{}) ws.TextMessage {
+	id, _ := payload["id"].(string)
+	seq, _ := payload["seq"].(float64)
+	timestamp, _ := payload["timestamp"].(float64)
+	nonce, _ := payload["nonce"].(string)
+	text, _ := payload["text"].(string)
+	from, _ := payload["from"].(string)
+	editedAt, _ := payload["edited_at"].(float64)
+	deleted, _ := payload["deleted"].(bool)
+	action, _ := payload["action"].(bool)
+	parentID, _ := payload["parent_id"].(string)
+	replyCount, _ := payload["reply_count"].(float64)
+	lastReplyAt, _ := payload["last_reply_at"].(float64)
+	rawReactions, _ := payload["reactions"].([]interface{})
+	var reactions []ws.Reaction
+	for _, r := range rawReactions {
+		if reaction, ok := r.(map[string]interface{}); ok {
+			reactions = append(reactions, parseReaction(reaction))
+		}
+	}
+	rawAttachments, _ := payload["attachments"].([]interface{})
+	var attachments []ws.Attachment
+	for _, a := range rawAttachments {
+		if att, ok := a.(map[string]interface{}); ok {
+			attachments = append(attachments, parseAttachment(att))
+		}
+	}
+	mentions := parseStrings(payload["mentions"])
+	links := parseStrings(payload["links"])
+	previews := parsePreviews(payload["previews"])
+	return ws.TextMessage{
+		ID:        id,
+		Seq:       uint64(seq),
+		Timestamp: int64(timestamp),
+		Nonce:     nonce,
+		Text:      text,
+		From:      from,
+		EditedAt:  int64(editedAt),
+		Deleted:   deleted,
+		Action:    action,
+		Reactions: reactions,
 
-	// Send typing notification
-	msg := map[string]interface{}{
-		"type": "TYPING",
-		"payload": map[string]interface{}{
-			"from":      store.Username,
-			"is_typing": true,
-		},
+		ParentID:    parentID,
+		ReplyCount:  int(replyCount),
+		LastReplyAt: int64(lastReplyAt),
+		Attachments: attachments,
+		Mentions:    mentions,
+		Links:       links,
+		Previews:    previews,
+	}
+}
+
+// parseStrings reads a list of strings of a payload
+func parseStrings(value interface{}) []string {
+	raw, _ := value.([]interface{})
+	var list []string
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// parseThreadUpdate reads the payload of a THREAD message
+func parseThreadUpdate(payload map[string]interface{}) ws.ThreadUpdate {
+	parentID, _ := payload["parent_id"].(string)
+	replyCount, _ := payload["reply_count"].(float64)
+	lastReplyAt, _ := payload["last_reply_at"].(float64)
+	return ws.ThreadUpdate{
+		ParentID:    parentID,
+		ReplyCount:  int(replyCount),
+		LastReplyAt: int64(lastReplyAt),
+	}
+}
+
+// parseReadMarker reads the payload of a READ message, or a reader of a
+// READ_STATE
+func parseReadMarker(payload map[string]interface{}) ws.ReadMarker {
+	room, _ := payload["room"].(string)
+	username, _ := payload["username"].(string)
+	seq, _ := payload["seq"].(float64)
+	id, _ := payload["id"].(string)
+	return ws.ReadMarker{
+		Room:     room,
+		Username: username,
+		Seq:      uint64(seq),
+		ID:       id,
+	}
+}
+
+// parseReadState reads the payload of a READ_STATE message
+func parseReadState(payload map[string]interface{}) ws.ReadState {
+	room, _ := payload["room"].(string)
+	readSeq, _ := payload["read_seq"].(float64)
+	unread, _ := payload["unread"].(float64)
+	rawReaders, _ := payload["readers"].([]interface{})
+	readers := make([]ws.ReadMarker, 0, len(rawReaders))
+	for _, r := range rawReaders {
+		if reader, ok := r.(map[string]interface{}); ok {
+			readers = append(readers, parseReadMarker(reader))
+		}
+	}
+	return ws.ReadState{
+		Room:    room,
+		ReadSeq: uint64(readSeq),
+		Unread:  int(unread),
+		Readers: readers,
+	}
+}
+
+// parseReaction reads a reaction of a chat message
+func parseReaction(payload map[string]interface{}) ws.Reaction {
+	emoji, _ := payload["emoji"].(string)
+	rawUsers, _ := payload["users"].([]interface{})
+	users := make([]string, 0, len(rawUsers))
+	for _, u := range rawUsers {
+		if user, ok := u.(string); ok {
+			users = append(users, user)
+		}
+	}
+	return ws.Reaction{
+		Emoji: emoji,
+		Count: len(users),
+		Users: users,
+	}
+}
+
+// parseEditMessage reads the payload of an EDIT message
+func parseEditMessage(payload map[string]interface{}) ws.EditMessage {
+	id, _ := payload["id"].(string)
+	text, _ := payload["text"].(string)
+	editedBy, _ := payload["edited_by"].(string)
+	editedAt, _ := payload["edited_at"].(float64)
+	return ws.EditMessage{
+		ID:       id,
+		Text:     text,
+		EditedBy: editedBy,
+		EditedAt: int64(editedAt),
+	}
+}
+
+// parseSyncMessage reads the payload of a SYNC message
+func parseSyncMessage(payload map[string]interface{}) ws.SyncMessage {
+	room, _ := payload["room"].(string)
+	epoch, _ := payload["epoch"].(string)
+	seq, _ := payload["seq"].(float64)
+	reset, _ := payload["reset"].(bool)
+	rawMessages, _ := payload["messages"].([]interface{})
+	messages := make([]ws.TextMessage, 0, len(rawMessages))
+	for _, m := range rawMessages {
+		if message, ok := m.(map[string]interface{}); ok {
+			messages = append(messages, parseTextMessage(message))
+		}
+	}
+	return ws.SyncMessage{
+		Room:     room,
+		Epoch:    epoch,
+		Seq:      uint64(seq),
+		Reset:    reset,
+		Messages: messages,
+	}
+}
+
+// parseDirectMessage reads the payload of a DIRECT message
+func parseDirectMessage(payload map[string]interface{}) ws.DirectMessage {
+	from, _ := payload["from"].(string)
+	to, _ := payload["to"].(string)
+	text, _ := payload["text"].(string)
+	timestamp, _ := payload["timestamp"].(float64)
+	return ws.DirectMessage{
+		From:      from,
+		To:        to,
+		Text:      text,
+		Timestamp: int64(timestamp),
+	}
+}
+
+// parsePresence reads the payload of a PRESENCE_UPDATE message, or a
+// member of a PRESENCE_SNAPSHOT
+func parsePresence(payload map[string]interface{}) ws.Presence {
+	username, _ := payload["username"].(string)
+	status, _ := payload["status"].(string)
+	lastSeen, _ := payload["last_seen"].(float64)
+	role, _ := payload["role"].(string)
+	mutedUntil, _ := payload["muted_until"].(float64)
+	bot, _ := payload["bot"].(bool)
+	return ws.Presence{
+		Username:   username,
+		Status:     status,
+		LastSeen:   int64(lastSeen),
+		Role:       role,
+		MutedUntil: int64(mutedUntil),
+		Bot:        bot,
+	}
+}
+
+// parsePresenceSnapshot reads the members of a PRESENCE_SNAPSHOT message
+func parsePresenceSnapshot(payload map[string]interface{}) []ws.Presence {
+	rawMembers, _ := payload["members"].([]interface{})
+	members := make([]ws.Presence, 0, len(rawMembers))
+	for _, m := range rawMembers {
+		if member, ok := m.(map[string]interface{}); ok {
+			members = append(members, parsePresence(member))
+		}
+	}
+	return members
+}
+
+// watchVisibility reports the user away while the page is hidden
+func (c *Chat) watchVisibility() {
+	js.Global().Get("document").Call("addEventListener", "visibilitychange", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		c.sendPresence()
+		c.markRead()
+		return nil
+	}))
+}
+
+// markRead tells the server the user read every message received so far,
+// unless the page is hidden
+func (c *Chat) markRead() {
+	if !c.open() {
+		return
+	}
+	if js.Global().Get("document").Get("hidden").Bool() {
+		return
+	}
+	if store.LastSeq <= store.ReadSeq || store.LastSeq <= c.readSent {
+		return
+	}
+
+	c.readSent = store.LastSeq
+	msg := ws.Message{
+		Type:    ws.TypeRead,
+		Payload: ws.ReadMarker{Seq: store.LastSeq},
 	}
 	if data, err := json.Marshal(msg); err == nil {
 		c.ws.Call("send", string(data))
+		log.Printf("Sent read marker: %d", store.LastSeq)
 	}
 }
 
-func (c *Chat) onSend(e *vecty.Event) {
-	if c.input == "" {
+// sendPresence tells the server whether this connection is away
+func (c *Chat) sendPresence() {
+	if !c.open() {
 		return
 	}
 
-	msg := map[string]interface{}{
-		"type": "MESSAGE",
-		"payload": map[string]interface{}{
-			"text": c.input,
-			"from": store.Username,
-		},
+	status := ws.PresenceOnline
+	if js.Global().Get("document").Get("hidden").Bool() {
+		status = ws.PresenceAway
+	}
+	msg := ws.Message{
+		Type:    ws.TypePresenceUpdate,
+		Payload: ws.Presence{Status: status},
+	}
+	if data, err := json.Marshal(msg); err == nil {
+		c.ws.Call("send", string(data))
+		log.Printf("Sent presence: %s", status)
 	}
+}
+
+func (c *Chat) onInput(e *vecty.Event) {
+	c.input = e.Target.Get("value").String()
+	vecty.Rerender(c)
 
+	// Keep the server's typing state alive without sending every keystroke
+	switch {
+	case c.input == "" && !c.typingSent.IsZero():
+		c.sendTyping(false)
+	case c.input != "" && time.Since(c.typingSent) >= ws.TypingTimeout/2:
+		c.sendTyping(true)
+	}
+}
+
+// sendTyping tells the server whether the user is typing
+func (c *Chat) sendTyping(isTyping bool) {
+	if !c.open() {
+		return
+	}
+
+	c.typingSent = time.Time{}
+	if isTyping {
+		c.typingSent = time.Now()
+	}
+	msg := ws.Message{
+		Type:    ws.TypeTyping,
+		Payload: ws.TypingMessage{IsTyping: isTyping},
+	}
 	if data, err := json.Marshal(msg); err == nil {
 		c.ws.Call("send", string(data))
-		log.Printf("Sent message: %s", c.input)
 	}
+}
+
+func (c *Chat) onSend(e *vecty.Event) {
+	if !c.canSend() {
+		return
+	}
+
+	c.send(newNonce(), "", c.input, c.attachments)
 
+	// Sending a message stops the user typing on the server
+	c.typingSent = time.Time{}
 	c.input = ""
+	c.attachments = nil
 	vecty.Rerender(c)
 }
 
+// canSend reports whether there is a message to send, no attachments for
+// it are still uploading, and the user is not muted
+func (c *Chat) canSend() bool {
+	return (c.input != "" || len(c.attachments) > 0) && c.uploading == 0 && mutedUntil(store.Username).IsZero()
+}
+
+// rerenderAfterMute enables the composer again once the current user's
+// mute ends
+func (c *Chat) rerenderAfterMute() {
+	until := mutedUntil(store.Username)
+	if until.IsZero() {
+		return
+	}
+	js.Global().Call("setTimeout", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		vecty.Rerender(c)
+		return nil
+	}), time.Until(until).Milliseconds()+100)
+}
+
+// onRejoin reconnects after the server removed the user from the room
+func (c *Chat) onRejoin(e *vecty.Event) {
+	dispatcher.Dispatch(&actions.SetClosed{})
+	c.connectWS()
+}
+
+func (c *Chat) onRetry(nonce string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		if pending, ok := store.Pending[nonce]; ok {
+			c.send(nonce, pending.ParentID, pending.Text, pending.Attachments)
+		}
+	}
+}
+
+func (c *Chat) onEdit(msg ws.TextMessage) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		c.editingID = msg.ID
+		c.editText = msg.Text
+		vecty.Rerender(c)
+	}
+}
+
+func (c *Chat) onEditInput(e *vecty.Event) {
+	c.editText = e.Target.Get("value").String()
+}
+
+func (c *Chat) onEditKeyDown(e *vecty.Event) {
+	switch e.Get("key").String() {
+	case "Enter":
+		e.Call("preventDefault")
+		if c.editText != "" {
+			c.sendChange(ws.TypeEdit, ws.EditMessage{ID: c.editingID, Text: c.editText})
+		}
+		c.editingID = ""
+		vecty.Rerender(c)
+	case "Escape":
+		c.editingID = ""
+		vecty.Rerender(c)
+	}
+}
+
+func (c *Chat) onDelete(id string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		if js.Global().Call("confirm", "Delete this message?").Bool() {
+			c.sendChange(ws.TypeDelete, ws.DeleteMessage{ID: id})
+		}
+	}
+}
+
+// onReact toggles the current user's reaction to a message
+func (c *Chat) onReact(id, emoji string, reacted bool) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		msgType := ws.TypeReact
+		if reacted {
+			msgType = ws.TypeUnreact
+		}
+		c.sendChange(msgType, ws.ReactionMessage{ID: id, Emoji: emoji})
+		c.pickerID = ""
+		vecty.Rerender(c)
+	}
+}
+
+func (c *Chat) onTogglePicker(id string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		if c.pickerID == id {
+			c.pickerID = ""
+		} else {
+			c.pickerID = id
+		}
+		vecty.Rerender(c)
+	}
+}
+
+func (c *Chat) onToggleSearch(e *vecty.Event) {
+	dispatcher.Dispatch(&actions.ToggleSearch{})
+}
+
+// onOpenThread opens the thread pane of a message and loads its replies
+func (c *Chat) onOpenThread(id string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		dispatcher.Dispatch(&actions.OpenThread{ParentID: id})
+		go func() {
+			replies, err := actions.FetchReplies(id, 0)
+			if err != nil {
+				log.Printf("❌ Failed to fetch replies: %v", err)
+				return
+			}
+			dispatcher.Dispatch(&actions.SetReplies{Parent: replies.Parent, Replies: replies.Replies, HasMore: replies.HasMore})
+		}()
+	}
+}
+
 func (c *Chat) onKeyDown(e *vecty.Event) {
+	// Tab completes the first command suggested
+	if e.Get("key").String() == "Tab" {
+		if matches := c.suggestions(); len(matches) > 0 {
+			e.Call("preventDefault")
+			c.complete(matches[0].Name)
+		}
+		return
+	}
 	// Check if the pressed key is Enter
 	if e.Get("key").String() == "Enter" {
 		// Check if Shift is held down
@@ -198,26 +799,334 @@ func (c *Chat) renderMessageList() vecty.ComponentOrHTML {
 		)
 	}
 
+	readers := seenBy()
 	var messageElements []vecty.MarkupOrChild
-	for _, msg := range store.Messages {
+	for _, id := range store.MessageOrder {
+		msg := store.Messages[id]
 		messageElements = append(messageElements,
 			elem.Div(
 				vecty.Markup(
-					vecty.Class("mb-4", "text-gray-800", "dark:text-gray-200"),
+					vecty.Key(msg.ID),
+					prop.ID(messageElementID(msg.ID)),
+					vecty.Class("group", "mb-4", "text-gray-800", "dark:text-gray-200", "rounded"),
+					vecty.MarkupIf(mentionsMe(msg), vecty.Class("border-l-4", "border-yellow-400", "pl-2")),
+					vecty.MarkupIf(msg.ID == store.HighlightID, vecty.Class("bg-yellow-100", "dark:bg-yellow-900")),
+				),
+				elem.Span(
+					vecty.Markup(
+						vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400", "mr-2"),
+						vecty.Attribute("title", time.UnixMilli(msg.Timestamp).Format(time.RFC1123)),
+					),
+					vecty.Text(time.UnixMilli(msg.Timestamp).Format("15:04")),
 				),
 				elem.Span(
 					vecty.Markup(
 						vecty.Class("font-bold", "text-blue-600", "dark:text-blue-400", "mr-2"),
 					),
-					vecty.Text(msg.From+": "),
+					vecty.Text(authorLabel(msg)),
 				),
-				vecty.Text(msg.Text),
+				c.renderMessageBody(msg),
+				renderAttachments(msg.Attachments),
+				renderPreviews(msg.Previews),
+				c.renderReactions(msg),
+				c.renderReplies(msg),
+				renderSeenBy(readers[msg.ID]),
 			),
 		)
 	}
 	return elem.Div(messageElements...)
 }
 
+// seenBy places every other user under the last of the recent messages
+// they have read, keyed by message ID. Authors are left out of their own
+// messages.
+func seenBy() map[string][]string {
+	recent := store.MessageOrder[max(0, len(store.MessageOrder)-recentSeenBy):]
+	readers := make(map[string][]string)
+	for username, seq := range store.ReadCursors {
+		if username == store.Username {
+			continue
+		}
+		i := sort.Search(len(recent), func(i int) bool {
+			return store.Messages[recent[i]].Seq > seq
+		})
+		if i == 0 {
+			continue // Read none of the recent messages
+		}
+		if msg := store.Messages[recent[i-1]]; msg.From != username {
+			readers[msg.ID] = append(readers[msg.ID], username)
+		}
+	}
+	for _, usernames := range readers {
+		sort.Strings(usernames)
+	}
+	return readers
+}
+
+func renderSeenBy(usernames []string) vecty.ComponentOrHTML {
+	if len(usernames) == 0 {
+		return nil
+	}
+	return elem.Div(
+		vecty.Markup(vecty.Class("text-xs", "text-gray-400", "dark:text-gray-500", "mt-1")),
+		vecty.Text("Seen by "+strings.Join(usernames, ", ")),
+	)
+}
+
+// renderReplies renders the link that opens the thread of a message
+func (c *Chat) renderReplies(msg ws.TextMessage) vecty.ComponentOrHTML {
+	if msg.Deleted && msg.ReplyCount == 0 {
+		return nil
+	}
+
+	label := "Reply"
+	switch {
+	case msg.ReplyCount == 1:
+		label = "1 reply"
+	case msg.ReplyCount > 1:
+		label = strconv.Itoa(msg.ReplyCount) + " replies"
+	}
+	return elem.Button(
+		vecty.Markup(
+			vecty.Class("text-xs", "text-blue-600", "dark:text-blue-400", "hover:underline", "mt-1"),
+			vecty.MarkupIf(msg.ReplyCount == 0, vecty.Class("hidden", "group-hover:inline")),
+			vecty.MarkupIf(msg.LastReplyAt != 0,
+				vecty.Attribute("title", "Last reply "+time.UnixMilli(msg.LastReplyAt).Format(time.RFC1123)),
+			),
+			event.Click(c.onOpenThread(msg.ID)),
+		),
+		vecty.Text("💬 "+label),
+	)
+}
+
+// renderReactions renders the reaction bar of a chat message and, when
+// open, its emoji picker
+func (c *Chat) renderReactions(msg ws.TextMessage) vecty.ComponentOrHTML {
+	if msg.Deleted {
+		return nil
+	}
+
+	var items []vecty.MarkupOrChild
+	items = append(items, vecty.Markup(vecty.Class("flex", "flex-wrap", "items-center", "gap-1", "mt-1")))
+	for _, r := range msg.Reactions {
+		reacted := slices.Contains(r.Users, store.Username)
+		items = append(items, elem.Button(
+			vecty.Markup(
+				vecty.Class(
+					"px-2", "py-0.5", "rounded-full", "text-xs",
+					"border", "border-gray-300", "dark:border-gray-600",
+					"hover:bg-gray-100", "dark:hover:bg-gray-700",
+				),
+				vecty.MarkupIf(reacted, vecty.Class("bg-blue-100", "border-blue-400", "dark:bg-blue-900")),
+				vecty.Attribute("title", strings.Join(r.Users, ", ")),
+				event.Click(c.onReact(msg.ID, r.Emoji, reacted)),
+			),
+			vecty.Text(r.Emoji+" "+strconv.Itoa(r.Count)),
+		))
+	}
+	items = append(items, elem.Button(
+		vecty.Markup(
+			vecty.Class(
+				"px-2", "py-0.5", "rounded-full", "text-xs",
+				"text-gray-500", "dark:text-gray-400",
+				"hover:bg-gray-100", "dark:hover:bg-gray-700",
+			),
+			vecty.MarkupIf(len(msg.Reactions) == 0 && c.pickerID != msg.ID, vecty.Class("hidden", "group-hover:inline")),
+			vecty.Attribute("title", "Add reaction"),
+			event.Click(c.onTogglePicker(msg.ID)),
+		),
+		vecty.Text("☺+"),
+	))
+
+	if c.pickerID == msg.ID {
+		var picker []vecty.MarkupOrChild
+		picker = append(picker, vecty.Markup(vecty.Class(
+			"flex", "gap-1", "p-1",
+			"bg-white", "dark:bg-gray-700",
+			"border", "border-gray-200", "dark:border-gray-600",
+			"rounded-lg", "shadow",
+		)))
+		for _, emoji := range pickerEmoji {
+			reacted := false
+			for _, r := range msg.Reactions {
+				if r.Emoji == emoji {
+					reacted = slices.Contains(r.Users, store.Username)
+				}
+			}
+			picker = append(picker, elem.Button(
+				vecty.Markup(
+					vecty.Class("px-1", "rounded", "hover:bg-gray-100", "dark:hover:bg-gray-600"),
+					event.Click(c.onReact(msg.ID, emoji, reacted)),
+				),
+				vecty.Text(emoji),
+			))
+		}
+		items = append(items, elem.Div(picker...))
+	}
+	return elem.Div(items...)
+}
+
+// renderMessageBody renders the text of a chat message, a tombstone for a
+// deleted one, or an input while the message is being edited
+func (c *Chat) renderMessageBody(msg ws.TextMessage) vecty.ComponentOrHTML {
+	if msg.Deleted {
+		return elem.Span(
+			vecty.Markup(vecty.Class("italic", "text-gray-500", "dark:text-gray-400")),
+			vecty.Text("message deleted"),
+		)
+	}
+
+	if msg.ID == c.editingID {
+		return elem.Input(
+			vecty.Markup(
+				vecty.Class(
+					"p-1", "w-2/3",
+					"border", "border-gray-300", "dark:border-gray-600",
+					"rounded",
+					"bg-white", "dark:bg-gray-700",
+					"text-gray-900", "dark:text-white",
+				),
+				event.Input(c.onEditInput),
+				event.KeyDown(c.onEditKeyDown),
+				prop.Value(c.editText),
+				vecty.Property("autofocus", true),
+			),
+		)
+	}
+
+	var edited, controls vecty.ComponentOrHTML
+	if msg.EditedAt != 0 {
+		edited = elem.Span(
+			vecty.Markup(
+				vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400", "ml-2"),
+				vecty.Attribute("title", time.UnixMilli(msg.EditedAt).Format(time.RFC1123)),
+			),
+			vecty.Text("(edited)"),
+		)
+	}
+	if msg.From == store.Username {
+		controls = elem.Span(
+			vecty.Markup(vecty.Class("hidden", "group-hover:inline", "text-xs", "ml-2", "space-x-2")),
+			elem.Button(
+				vecty.Markup(
+					vecty.Class("text-gray-500", "hover:underline", "dark:text-gray-400"),
+					event.Click(c.onEdit(msg)),
+				),
+				vecty.Text("Edit"),
+			),
+			elem.Button(
+				vecty.Markup(
+					vecty.Class("text-red-500", "hover:underline", "dark:text-red-400"),
+					event.Click(c.onDelete(msg.ID)),
+				),
+				vecty.Text("Delete"),
+			),
+		)
+	}
+	return elem.Span(
+		renderText(msg),
+		edited,
+		controls,
+	)
+}
+
+// authorLabel introduces the text of a chat message with its author, as
+// in "alice: hi", or "* alice waves" for actions posted with /me
+func authorLabel(msg ws.TextMessage) string {
+	if msg.Action && !msg.Deleted {
+		return "* " + msg.From
+	}
+	return msg.From + ": "
+}
+
+// renderText renders the text of a chat message, in italics for actions
+func renderText(msg ws.TextMessage) vecty.ComponentOrHTML {
+	if msg.Action {
+		return elem.Span(
+			vecty.Markup(vecty.Class("italic")),
+			renderMarkdown(msg.Text),
+		)
+	}
+	return renderMarkdown(msg.Text)
+}
+
+// renderPending renders the messages this client sent that the room has
+// not delivered yet, with their delivery state
+func (c *Chat) renderPending() vecty.ComponentOrHTML {
+	if len(store.PendingOrder) == 0 {
+		return nil
+	}
+
+	var pendingElements []vecty.MarkupOrChild
+	for _, nonce := range store.PendingOrder {
+		pending := store.Pending[nonce]
+		if pending.ParentID != "" {
+			continue // Shown in the thread pane
+		}
+
+		pendingElements = append(pendingElements,
+			elem.Div(
+				vecty.Markup(
+					vecty.Key(nonce),
+					vecty.Class("mb-4", "text-gray-500", "dark:text-gray-400"),
+				),
+				elem.Span(
+					vecty.Markup(
+						vecty.Class("font-bold", "text-blue-600", "dark:text-blue-400", "mr-2", "opacity-60"),
+					),
+					vecty.Text(store.Username+": "),
+				),
+				renderMarkdown(pending.Text),
+				renderAttachments(pending.Attachments),
+				renderPendingStatus(pending, c.onRetry(nonce)),
+			),
+		)
+	}
+	return elem.Div(pendingElements...)
+}
+
+// renderPendingStatus renders the delivery state of a pending message
+func renderPendingStatus(pending *store.PendingMessage, onRetry func(*vecty.Event)) vecty.ComponentOrHTML {
+	switch pending.Status {
+	case store.PendingSending:
+		return elem.Span(
+			vecty.Markup(vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400", "ml-2")),
+			vecty.Text("sending…"),
+		)
+	case store.PendingSent:
+		return elem.Span(
+			vecty.Markup(vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400", "ml-2")),
+			vecty.Text("sent"),
+		)
+	case store.PendingFailed:
+		return elem.Span(
+			vecty.Markup(vecty.Class("text-xs", "text-red-500", "dark:text-red-400", "ml-2")),
+			vecty.Text("failed: "+pending.Error+" "),
+			elem.Button(
+				vecty.Markup(
+					vecty.Class("underline", "hover:text-red-700", "dark:hover:text-red-300"),
+					event.Click(onRetry),
+				),
+				vecty.Text("Retry"),
+			),
+		)
+	}
+	return nil
+}
+
+func (c *Chat) renderError() vecty.ComponentOrHTML {
+	if store.Error == "" {
+		return nil
+	}
+
+	return elem.Paragraph(
+		vecty.Markup(
+			vecty.Class("text-red-500", "dark:text-red-400", "text-sm", "mt-2"),
+		),
+		vecty.Text(store.Error),
+	)
+}
+
 func (c *Chat) renderTypingIndicators() vecty.ComponentOrHTML {
 	if len(store.TypingUsers) == 0 {
 		return nil
@@ -246,27 +1155,71 @@ func (c *Chat) renderTypingIndicators() vecty.ComponentOrHTML {
 // Render implements the vecty.Component interface
 func (c *Chat) Render() vecty.ComponentOrHTML {
 	log.Printf("🎨 Chat component rendering")
+	placeholder := "Type a message or drop files..."
+	muted := mutedUntil(store.Username)
+	if !muted.IsZero() {
+		placeholder = "You are muted until " + muted.Format("15:04")
+	}
+
 	result := elem.Div(
 		vecty.Markup(
-			vecty.Class("container", "mx-auto", "p-4", "max-w-4xl"),
+			vecty.Class("container", "mx-auto", "p-4", "max-w-6xl", "rounded-lg"),
+			vecty.MarkupIf(c.dragging, vecty.Class("ring-2", "ring-blue-400", "bg-blue-50", "dark:bg-gray-800")),
+			event.DragOver(c.onDragOver),
+			event.DragLeave(c.onDragLeave),
+			event.Drop(c.onDrop),
 		),
 		elem.Div(
 			vecty.Markup(
-				vecty.Class(
-					"bg-white", "dark:bg-gray-800",
-					"rounded-lg", "shadow-lg",
-					"p-6", "mb-4", "h-96", "overflow-y-auto",
-					"transition-colors", "duration-200",
-					"border", "border-gray-200", "dark:border-gray-700",
+				vecty.Class("flex", "justify-end", "mb-2"),
+			),
+			elem.Button(
+				vecty.Markup(
+					vecty.Class(
+						"px-3", "py-1", "rounded-lg", "text-sm",
+						"text-gray-700", "dark:text-gray-300",
+						"hover:bg-gray-200", "dark:hover:bg-gray-700",
+						"transition-colors", "duration-200",
+					),
+					vecty.MarkupIf(store.SearchOpen, vecty.Class("bg-gray-200", "dark:bg-gray-700")),
+					event.Click(c.onToggleSearch),
+				),
+				vecty.Text("🔍 Search"),
+			),
+		),
+		renderClosed(c.onRejoin),
+		elem.Div(
+			vecty.Markup(
+				vecty.Class("flex", "gap-4", "mb-4"),
+			),
+			&UserList{Moderate: c.Moderate},
+			elem.Div(
+				vecty.Markup(
+					vecty.Class(
+						"flex-1",
+						"bg-white", "dark:bg-gray-800",
+						"rounded-lg", "shadow-lg",
+						"p-6", "h-96", "overflow-y-auto",
+						"transition-colors", "duration-200",
+						"border", "border-gray-200", "dark:border-gray-700",
+					),
 				),
+				c.renderMessageList(),
+				c.renderPending(),
+				renderNotices(),
+				c.renderTypingIndicators(),
 			),
-			c.renderMessageList(),
-			c.renderTypingIndicators(),
+			&SearchPane{},
+			&ThreadPane{Send: c.SendReply, OnRetry: c.onRetry},
+			&DirectPane{Send: c.SendDirect},
 		),
+		c.renderComposerAttachments(),
+		c.renderSuggestions(),
 		elem.Div(
 			vecty.Markup(
 				vecty.Class("flex", "gap-3"),
 			),
+			c.renderFilePicker(),
 			elem.Input(
 				vecty.Markup(
 					vecty.Class(
@@ -279,11 +1232,13 @@ func (c *Chat) Render() vecty.ComponentOrHTML {
 						"focus:ring-2", "focus:ring-blue-500", "dark:focus:ring-blue-400",
 						"focus:border-transparent",
 						"transition-colors", "duration-200",
+						"disabled:opacity-50",
 					),
 					event.Input(c.onInput),
 					event.KeyDown(c.onKeyDown),
 					prop.Value(c.input),
-					prop.Placeholder("Type a message..."),
+					prop.Placeholder(placeholder),
+					prop.Disabled(!muted.IsZero()),
 				),
 			),
 			elem.Button(
@@ -299,11 +1254,12 @@ func (c *Chat) Render() vecty.ComponentOrHTML {
 						"disabled:opacity-50",
 					),
 					event.Click(c.onSend),
-					prop.Disabled(c.input == ""),
+					prop.Disabled(!c.canSend()),
 				),
 				vecty.Text("Send"),
 			),
 		),
+		c.renderError(),
 	)
 
 	return result
@@ -311,23 +1267,104 @@ func (c *Chat) Render() vecty.ComponentOrHTML {
 
 // SendMessage sends a chat message through the WebSocket connection
 func (c *Chat) SendMessage(text string) {
-	if c.ws.Get("readyState").Int() != 1 { // 1 = OPEN
+	c.send(newNonce(), "", text, nil)
+}
+
+// SendReply sends a reply in the thread of a message through the
+// WebSocket connection
+func (c *Chat) SendReply(parentID, text string) {
+	c.send(newNonce(), parentID, text, nil)
+}
+
+func newNonce() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(rand.Int63(), 36)
+}
+
+// send sends a chat message identified by nonce, and marks it failed if
+// the server does not answer within ackTimeout. Messages are sent on user
+// gestures, when the browser lets the page ask to show notifications.
+func (c *Chat) send(nonce, parentID, text string, attachments []ws.Attachment) {
+	dispatcher.Dispatch(&actions.SendMessage{Nonce: nonce, ParentID: parentID, Text: text, Attachments: attachments})
+	requestNotifications()
+
+	if !c.open() {
 		log.Printf("Cannot send message: WebSocket is not connected")
+		dispatcher.Dispatch(&actions.NackMessage{Nonce: nonce, Error: "not connected"})
 		return
 	}
 
 	msg := ws.Message{
 		Type: ws.TypeMessage,
 		Payload: ws.TextMessage{
+			Nonce:       nonce,
+			Text:        text,
+			From:        store.Username,
+			ParentID:    parentID,
+			Attachments: attachments,
+		},
+	}
+
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("error marshaling message: %v", err)
+		dispatcher.Dispatch(&actions.NackMessage{Nonce: nonce, Error: err.Error()})
+		return
+	}
+	c.ws.Call("send", string(msgBytes))
+	log.Printf("Sent message %s: %s", nonce, text)
+
+	js.Global().Call("setTimeout", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if pending, ok := store.Pending[nonce]; ok && pending.Status == store.PendingSending {
+			dispatcher.Dispatch(&actions.NackMessage{Nonce: nonce, Error: "no response from server"})
+		}
+		return nil
+	}), ackTimeout.Milliseconds())
+}
+
+// Moderate sends a moderation action against a user in the room
+func (c *Chat) Moderate(msgType ws.MessageType, mod ws.ModerationMessage) {
+	c.sendChange(msgType, mod)
+}
+
+// sendChange sends an edit, deletion or reaction for a chat message, or a
+// moderation action. The room answers with the change it broadcasts, or
+// with an ERROR.
+func (c *Chat) sendChange(msgType ws.MessageType, payload interface{}) {
+	if !c.open() {
+		log.Printf("Cannot change message: WebSocket is not connected")
+		dispatcher.Dispatch(&actions.SetError{Message: "not connected"})
+		return
+	}
+
+	msg := ws.Message{Type: msgType, Payload: payload}
+	if msgBytes, err := json.Marshal(msg); err == nil {
+		c.ws.Call("send", string(msgBytes))
+		log.Printf("Sent %s", msgType)
+	} else {
+		log.Printf("error marshaling %s: %v", msgType, err)
+	}
+}
+
+// SendDirect sends a direct message to a user through the WebSocket
+// connection
+func (c *Chat) SendDirect(to, text string) {
+	if !c.open() {
+		log.Printf("Cannot send direct message: WebSocket is not connected")
+		return
+	}
+
+	msg := ws.Message{
+		Type: ws.TypeDirect,
+		Payload: ws.DirectMessage{
+			To:   to,
 			Text: text,
-			From: store.Username,
 		},
 	}
 
 	if msgBytes, err := json.Marshal(msg); err == nil {
 		c.ws.Call("send", string(msgBytes))
-		log.Printf("Sent message: %s", text)
+		log.Printf("Sent direct message to %s", to)
 	} else {
-		log.Printf("error marshaling message: %v", err)
+		log.Printf("error marshaling direct message: %v", err)
 	}
 }
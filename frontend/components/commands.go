@@ -0,0 +1,181 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"go-chat/frontend/store"
+	"go-chat/frontend/store/actions"
+	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/event"
+)
+
+// parseSystem reads the payload of a SYSTEM message
+func parseSystem(payload map[string]interface{}) ws.SystemMessage {
+	room, _ := payload["room"].(string)
+	text, _ := payload["text"].(string)
+	timestamp, _ := payload["timestamp"].(float64)
+	return ws.SystemMessage{Room: room, Text: text, Timestamp: int64(timestamp)}
+}
+
+// parseTopic reads the payload of a TOPIC message
+func parseTopic(payload map[string]interface{}) ws.TopicMessage {
+	room, _ := payload["room"].(string)
+	topic, _ := payload["topic"].(string)
+	by, _ := payload["by"].(string)
+	at, _ := payload["at"].(float64)
+	return ws.TopicMessage{Room: room, Topic: topic, By: by, At: int64(at)}
+}
+
+// parseNick reads the payload of a NICK message
+func parseNick(payload map[string]interface{}) ws.NickMessage {
+	room, _ := payload["room"].(string)
+	from, _ := payload["from"].(string)
+	to, _ := payload["to"].(string)
+	return ws.NickMessage{Room: room, From: from, To: to}
+}
+
+// notice shows a notice about the room
+func notice(text string) {
+	dispatcher.Dispatch(&actions.AddNotice{Notice: ws.SystemMessage{
+		Room:      store.Room,
+		Text:      text,
+		Timestamp: time.Now().UnixMilli(),
+	}})
+}
+
+// onTopic shows a topic the room sent, announcing the changes made while
+// connected rather than the topic sent on joining
+func (c *Chat) onTopic(topic ws.TopicMessage) {
+	if topic.At != store.Topic.At && topic.At >= c.connectedAt {
+		if topic.Topic == "" {
+			notice(topic.By + " cleared the topic")
+		} else {
+			notice(topic.By + " set the topic to: " + topic.Topic)
+		}
+	}
+	dispatcher.Dispatch(&actions.SetTopic{Topic: topic})
+}
+
+// onNick announces a user's new username. The server moved the current
+// user's session to their new one already, so they reconnect with it.
+func (c *Chat) onNick(nick ws.NickMessage) {
+	notice(nick.From + " is now known as " + nick.To)
+	if nick.From != store.Username {
+		return
+	}
+	dispatcher.Dispatch(&actions.SetSession{Username: nick.To, Token: store.Session})
+	c.renamed = true
+	c.ws.Call("close")
+}
+
+// fetchCommands loads the slash commands offered while typing
+func fetchCommands() {
+	go func() {
+		commands, err := actions.FetchCommands()
+		if err != nil {
+			log.Printf("❌ Failed to fetch commands: %v", err)
+			return
+		}
+		dispatcher.Dispatch(&actions.SetCommands{Commands: commands})
+	}()
+}
+
+// suggestions returns the commands the current user may run whose names
+// start with the command being typed, until its arguments are
+func (c *Chat) suggestions() []api.Command {
+	prefix, ok := strings.CutPrefix(c.input, "/")
+	if !ok || strings.ContainsFunc(prefix, func(r rune) bool { return r == ' ' || r == '\n' || r == '/' }) {
+		return nil
+	}
+	prefix = strings.ToLower(prefix)
+	rank := api.RoleRank(roleOf(store.Username))
+
+	var matches []api.Command
+	for _, cmd := range store.Commands {
+		if strings.HasPrefix(cmd.Name, prefix) && (cmd.Role == "" || rank >= api.RoleRank(cmd.Role)) {
+			matches = append(matches, cmd)
+		}
+	}
+	return matches
+}
+
+// complete fills the composer with a command, ready for its arguments
+func (c *Chat) complete(name string) {
+	c.input = "/" + name + " "
+	vecty.Rerender(c)
+}
+
+func (c *Chat) onComplete(name string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		c.complete(name)
+	}
+}
+
+// renderSuggestions lists the commands matching what is being typed
+func (c *Chat) renderSuggestions() vecty.ComponentOrHTML {
+	matches := c.suggestions()
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var items []vecty.MarkupOrChild
+	items = append(items, vecty.Markup(
+		vecty.Class(
+			"mb-2", "rounded-lg", "shadow", "overflow-hidden",
+			"bg-white", "dark:bg-gray-800",
+			"border", "border-gray-200", "dark:border-gray-700",
+		),
+	))
+	for i, cmd := range matches {
+		items = append(items, elem.Button(
+			vecty.Markup(
+				vecty.Key(cmd.Name),
+				vecty.Class(
+					"block", "w-full", "text-left", "px-3", "py-1", "text-sm",
+					"text-gray-800", "dark:text-gray-200",
+					"hover:bg-gray-100", "dark:hover:bg-gray-700",
+				),
+				vecty.MarkupIf(i == 0, vecty.Class("bg-gray-50", "dark:bg-gray-900")),
+				event.Click(c.onComplete(cmd.Name)),
+			),
+			elem.Span(
+				vecty.Markup(vecty.Class("font-mono", "font-semibold", "mr-2")),
+				vecty.Text(strings.TrimSpace("/"+cmd.Name+" "+cmd.Usage)),
+			),
+			elem.Span(
+				vecty.Markup(vecty.Class("text-gray-500", "dark:text-gray-400")),
+				vecty.Text(cmd.Description),
+			),
+		))
+	}
+	return elem.Div(items...)
+}
+
+// renderNotices renders the latest notices from the server, such as the
+// replies to slash commands
+func renderNotices() vecty.ComponentOrHTML {
+	if len(store.Notices) == 0 {
+		return nil
+	}
+
+	var notices []vecty.MarkupOrChild
+	for _, n := range store.Notices {
+		notices = append(notices, elem.Div(
+			vecty.Markup(
+				vecty.Class("mb-2", "text-sm", "italic", "whitespace-pre-line", "text-gray-500", "dark:text-gray-400"),
+				vecty.Attribute("title", time.UnixMilli(n.Timestamp).Format(time.RFC1123)),
+			),
+			vecty.Text(n.Text),
+		))
+	}
+	return elem.Div(notices...)
+}
@@ -0,0 +1,139 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"go-chat/frontend/store"
+	"go-chat/frontend/store/actions"
+	"go-chat/frontend/store/dispatcher"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/event"
+	"github.com/hexops/vecty/prop"
+)
+
+// DirectPane shows the direct messages with store.ActiveConversation
+type DirectPane struct {
+	vecty.Core
+	Send  func(to, text string) `vecty:"prop"`
+	input string
+}
+
+func (d *DirectPane) onInput(e *vecty.Event) {
+	d.input = e.Target.Get("value").String()
+	vecty.Rerender(d)
+}
+
+func (d *DirectPane) onSend(e *vecty.Event) {
+	if d.input == "" {
+		return
+	}
+	d.Send(store.ActiveConversation, d.input)
+	d.input = ""
+	vecty.Rerender(d)
+}
+
+func (d *DirectPane) onKeyDown(e *vecty.Event) {
+	if e.Get("key").String() == "Enter" {
+		e.Call("preventDefault")
+		d.onSend(e)
+	}
+}
+
+func (d *DirectPane) onClose(e *vecty.Event) {
+	dispatcher.Dispatch(&actions.OpenConversation{})
+}
+
+func (d *DirectPane) renderMessages() vecty.ComponentOrHTML {
+	messages := store.DirectMessages[store.ActiveConversation]
+	if len(messages) == 0 {
+		return elem.Paragraph(
+			vecty.Markup(
+				vecty.Class("text-gray-500", "dark:text-gray-400", "text-center", "italic"),
+			),
+			vecty.Text("No messages yet"),
+		)
+	}
+
+	var messageElements []vecty.MarkupOrChild
+	for _, msg := range messages {
+		messageElements = append(messageElements,
+			elem.Div(
+				vecty.Markup(
+					vecty.Class("mb-2", "text-gray-800", "dark:text-gray-200"),
+				),
+				elem.Span(
+					vecty.Markup(
+						vecty.Class("font-bold", "text-purple-600", "dark:text-purple-400", "mr-2"),
+					),
+					vecty.Text(msg.From+": "),
+				),
+				renderMarkdown(msg.Text),
+			),
+		)
+	}
+	return elem.Div(messageElements...)
+}
+
+// Render implements the vecty.Component interface
+func (d *DirectPane) Render() vecty.ComponentOrHTML {
+	if store.ActiveConversation == "" {
+		return elem.Div()
+	}
+
+	return elem.Div(
+		vecty.Markup(
+			vecty.Class(
+				"w-72", "shrink-0", "flex", "flex-col",
+				"bg-white", "dark:bg-gray-800",
+				"rounded-lg", "shadow-lg",
+				"p-4", "h-96",
+				"border", "border-purple-300", "dark:border-purple-700",
+			),
+		),
+		elem.Div(
+			vecty.Markup(
+				vecty.Class("flex", "justify-between", "items-center", "mb-2"),
+			),
+			elem.Heading2(
+				vecty.Markup(
+					vecty.Class("font-bold", "text-gray-800", "dark:text-gray-200"),
+				),
+				vecty.Text("@"+store.ActiveConversation),
+			),
+			elem.Button(
+				vecty.Markup(
+					vecty.Class("text-gray-500", "hover:text-gray-700", "dark:hover:text-gray-300"),
+					event.Click(d.onClose),
+				),
+				vecty.Text("✕"),
+			),
+		),
+		elem.Div(
+			vecty.Markup(
+				vecty.Class("flex-1", "overflow-y-auto", "mb-2"),
+			),
+			d.renderMessages(),
+		),
+		elem.Input(
+			vecty.Markup(
+				vecty.Class(
+					"p-2",
+					"border", "border-gray-300", "dark:border-gray-600",
+					"rounded-lg",
+					"bg-white", "dark:bg-gray-700",
+					"text-gray-900", "dark:text-white",
+					"placeholder-gray-500", "dark:placeholder-gray-400",
+					"focus:ring-2", "focus:ring-purple-500",
+					"focus:border-transparent",
+				),
+				event.Input(d.onInput),
+				event.KeyDown(d.onKeyDown),
+				prop.Value(d.input),
+				prop.Placeholder("Message @"+store.ActiveConversation+"..."),
+			),
+		),
+	)
+}
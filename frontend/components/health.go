@@ -4,6 +4,7 @@
 package components
 
 import (
+	"fmt"
 	"go-chat/frontend/store/actions"
 	"go-chat/shared/api"
 	"log"
@@ -16,7 +17,8 @@ import (
 // HealthStatus is a component that displays the server health status
 type HealthStatus struct {
 	vecty.Core
-	health *api.HealthResponse
+	health      *api.HealthResponse
+	unavailable bool
 }
 
 // Mount implements the vecty.Mounter interface
@@ -28,9 +30,12 @@ func (h *HealthStatus) fetchHealth() {
 	health, err := actions.FetchHealthStatus()
 	if err != nil {
 		log.Printf("❌ Failed to fetch health status: %v", err)
+		h.unavailable = true
+		vecty.Rerender(h)
 		return
 	}
 	h.health = health
+	h.unavailable = false
 	vecty.Rerender(h)
 }
 
@@ -40,14 +45,18 @@ func (h *HealthStatus) Render() vecty.ComponentOrHTML {
 	var timestamp string
 	var statusClass string
 
-	if h.health == nil {
+	if h.unavailable {
+		status = api.HealthStatusUnavailable
+		timestamp = "N/A"
+		statusClass = "text-red-500"
+	} else if h.health == nil {
 		status = "Loading..."
 		timestamp = "N/A"
 		statusClass = "text-gray-500"
 	} else {
-		status = h.health.Status
+		status = fmt.Sprintf("%s (%d online)", h.health.Status, h.health.Clients)
 		timestamp = time.Unix(h.health.Timestamp, 0).Format(time.RFC3339)
-		if status == "ok" {
+		if h.health.Status == api.HealthStatusOK {
 			statusClass = "text-green-500"
 		} else {
 			statusClass = "text-red-500"
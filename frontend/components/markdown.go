@@ -0,0 +1,80 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"go-chat/frontend/store"
+	"go-chat/shared/markdown"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/prop"
+)
+
+// renderMarkdown renders the text of a message formatted with the
+// markdown subset. Text only ever becomes text nodes, so nothing a message
+// contains is interpreted as HTML.
+func renderMarkdown(text string) vecty.List {
+	return renderNodes(markdown.Parse(text))
+}
+
+func renderNodes(nodes []markdown.Node) vecty.List {
+	list := make(vecty.List, 0, len(nodes))
+	for _, n := range nodes {
+		list = append(list, renderNode(n))
+	}
+	return list
+}
+
+func renderNode(n markdown.Node) vecty.ComponentOrHTML {
+	switch n.Kind {
+	case markdown.LineBreak:
+		return elem.Break()
+	case markdown.Bold:
+		return elem.Strong(renderNodes(n.Children))
+	case markdown.Italic:
+		return elem.Emphasis(renderNodes(n.Children))
+	case markdown.Code:
+		return elem.Code(
+			vecty.Markup(
+				vecty.Class("px-1", "rounded", "font-mono", "text-sm", "bg-gray-100", "dark:bg-gray-700"),
+			),
+			vecty.Text(n.Text),
+		)
+	case markdown.CodeBlock:
+		return elem.Preformatted(
+			vecty.Markup(
+				vecty.Class(
+					"my-1", "p-2", "rounded", "overflow-x-auto",
+					"font-mono", "text-sm",
+					"bg-gray-100", "dark:bg-gray-900",
+				),
+				vecty.MarkupIf(n.Lang != "", vecty.Attribute("data-lang", n.Lang)),
+			),
+			elem.Code(vecty.Text(n.Text)),
+		)
+	case markdown.Link:
+		// The parser only produces http, https and mailto links
+		return elem.Anchor(
+			vecty.Markup(
+				vecty.Class("text-blue-600", "dark:text-blue-400", "underline", "break-all"),
+				prop.Href(n.URL),
+				vecty.Attribute("target", "_blank"),
+				vecty.Attribute("rel", "noopener noreferrer nofollow"),
+			),
+			renderNodes(n.Children),
+		)
+	case markdown.Mention:
+		return elem.Span(
+			vecty.Markup(
+				vecty.Class("px-1", "rounded", "font-semibold", "text-blue-700", "dark:text-blue-300"),
+				vecty.MarkupIf(n.Text == store.Username, vecty.Class("bg-yellow-200", "dark:bg-yellow-800")),
+				vecty.MarkupIf(n.Text != store.Username, vecty.Class("bg-blue-100", "dark:bg-blue-900")),
+			),
+			vecty.Text("@"+n.Text),
+		)
+	default:
+		return vecty.Text(n.Text)
+	}
+}
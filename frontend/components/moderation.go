@@ -0,0 +1,175 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"go-chat/frontend/store"
+	"go-chat/frontend/store/actions"
+	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"log"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/event"
+)
+
+// muteDuration is how long the user list's Mute button mutes for
+const muteDuration = 10 * time.Minute
+
+// parseModeration reads the payload of a KICK, BAN, UNBAN, MUTE, UNMUTE or
+// ROLE message
+func parseModeration(payload map[string]interface{}) ws.ModerationMessage {
+	username, _ := payload["username"].(string)
+	duration, _ := payload["duration_ms"].(float64)
+	role, _ := payload["role"].(string)
+	reason, _ := payload["reason"].(string)
+	room, _ := payload["room"].(string)
+	by, _ := payload["by"].(string)
+	at, _ := payload["at"].(float64)
+	until, _ := payload["until"].(float64)
+	return ws.ModerationMessage{
+		Username: username,
+		Duration: int64(duration),
+		Role:     role,
+		Reason:   reason,
+		Room:     room,
+		By:       by,
+		At:       int64(at),
+		Until:    int64(until),
+	}
+}
+
+// parseClose reads the payload of a CLOSE message
+func parseClose(payload map[string]interface{}) ws.CloseMessage {
+	reason, _ := payload["reason"].(string)
+	until, _ := payload["until"].(float64)
+	return ws.CloseMessage{Reason: reason, Until: int64(until)}
+}
+
+// onModeration applies a moderation action the room broadcast. Users
+// learn when they may see the bans, and when their mute ends.
+func (c *Chat) onModeration(msgType string, mod ws.ModerationMessage) {
+	dispatcher.Dispatch(&actions.Moderate{Type: ws.MessageType(msgType), Moderation: mod})
+	if mod.Username != store.Username {
+		return
+	}
+	switch ws.MessageType(msgType) {
+	case ws.TypeRole:
+		fetchBans()
+	case ws.TypeMute:
+		c.rerenderAfterMute()
+	}
+}
+
+// roleOf returns the role of a room member
+func roleOf(username string) string {
+	if role := store.Members[username].Role; role != "" {
+		return role
+	}
+	return api.RoleMember
+}
+
+// canModerate reports whether the current user may kick, ban and mute
+// username, mirroring the room's checks
+func canModerate(username string) bool {
+	rank := api.RoleRank(roleOf(store.Username))
+	return username != store.Username &&
+		rank >= api.RoleRank(api.RoleModerator) &&
+		rank > api.RoleRank(roleOf(username))
+}
+
+// mutedUntil returns when the mute of a member ends, or the zero time if
+// they are not muted
+func mutedUntil(username string) time.Time {
+	until := store.Members[username].MutedUntil
+	if until == 0 || time.Now().UnixMilli() >= until {
+		return time.Time{}
+	}
+	return time.UnixMilli(until)
+}
+
+// fetchBans loads the bans of the room if the current user may see them
+func fetchBans() {
+	if api.RoleRank(roleOf(store.Username)) < api.RoleRank(api.RoleModerator) {
+		return
+	}
+	go func() {
+		bans, err := actions.FetchBans(store.Session)
+		if err != nil {
+			log.Printf("❌ Failed to fetch bans: %v", err)
+			return
+		}
+		dispatcher.Dispatch(&actions.SetBans{Bans: bans})
+	}()
+}
+
+// promptReason asks the current user why they act on a user. It reports
+// false when they cancel.
+func promptReason(action, username string) (string, bool) {
+	reason := js.Global().Call("prompt", "Reason to "+action+" "+username+" (optional)", "")
+	if reason.IsNull() {
+		return "", false
+	}
+	return strings.TrimSpace(reason.String()), true
+}
+
+// promptBanDuration asks the current user how long to ban a user for, as
+// a duration like 30m, 12h or 7d; a blank answer bans for good. It reports
+// false when they cancel or answer something else.
+func promptBanDuration(username string) (time.Duration, bool) {
+	answer := js.Global().Call("prompt", "Ban "+username+" for how long? e.g. 30m, 12h or 7d; leave blank to ban for good", "")
+	if answer.IsNull() {
+		return 0, false
+	}
+	text := strings.TrimSpace(answer.String())
+	if text == "" {
+		return 0, true
+	}
+	if d, err := api.ParseDuration(text); err == nil && d > 0 {
+		return d, true
+	}
+	js.Global().Call("alert", "Not a duration: "+text)
+	return 0, false
+}
+
+// renderClosed explains why the server closed the connection, with a way
+// to join again
+func renderClosed(onRejoin func(*vecty.Event)) vecty.ComponentOrHTML {
+	if store.Closed == nil {
+		return nil
+	}
+
+	text := store.Closed.Reason
+	if store.Closed.Until != 0 {
+		text += " (until " + time.UnixMilli(store.Closed.Until).Format("Jan 2 15:04") + ")"
+	}
+	return elem.Div(
+		vecty.Markup(
+			vecty.Class(
+				"flex", "items-center", "gap-3", "mb-4", "p-3", "rounded-lg",
+				"bg-red-100", "dark:bg-red-900", "text-red-800", "dark:text-red-200",
+			),
+		),
+		elem.Span(
+			vecty.Markup(vecty.Class("flex-1")),
+			vecty.Text(text),
+		),
+		elem.Button(
+			vecty.Markup(
+				vecty.Class(
+					"px-3", "py-1", "rounded-lg", "text-sm",
+					"bg-red-500", "dark:bg-red-600", "text-white",
+					"hover:bg-red-600", "dark:hover:bg-red-700",
+				),
+				event.Click(onRejoin),
+			),
+			vecty.Text("Rejoin"),
+		),
+	)
+}
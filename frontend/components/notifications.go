@@ -0,0 +1,88 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"log"
+	"slices"
+	"syscall/js"
+
+	"go-chat/frontend/store"
+	"go-chat/shared/ws"
+)
+
+// maxNotificationText bounds the message text shown in a notification, in
+// runes
+const maxNotificationText = 200
+
+// parseMention reads the payload of a MENTION message
+func parseMention(payload map[string]interface{}) ws.MentionNotification {
+	to, _ := payload["to"].(string)
+	room, _ := payload["room"].(string)
+	messageID, _ := payload["message_id"].(string)
+	parentID, _ := payload["parent_id"].(string)
+	from, _ := payload["from"].(string)
+	text, _ := payload["text"].(string)
+	timestamp, _ := payload["timestamp"].(float64)
+	return ws.MentionNotification{
+		To:        to,
+		Room:      room,
+		MessageID: messageID,
+		ParentID:  parentID,
+		From:      from,
+		Text:      text,
+		Timestamp: int64(timestamp),
+	}
+}
+
+// mentionsMe reports whether a chat message by someone else mentions the
+// current user
+func mentionsMe(msg ws.TextMessage) bool {
+	return msg.From != store.Username && slices.Contains(msg.Mentions, store.Username)
+}
+
+// requestNotifications asks for permission to show browser notifications,
+// unless the user already decided. Browsers only ask from a user gesture,
+// such as sending a message.
+func requestNotifications() {
+	notification := js.Global().Get("Notification")
+	if !notification.Truthy() || notification.Get("permission").String() != "default" {
+		return
+	}
+	notification.Call("requestPermission")
+}
+
+// notifyMention shows a browser notification for a mention while the page
+// is hidden. Clicking it brings the page back, showing the message.
+func notifyMention(mention ws.MentionNotification) {
+	notification := js.Global().Get("Notification")
+	if !notification.Truthy() || notification.Get("permission").String() != "granted" {
+		return
+	}
+	if !js.Global().Get("document").Get("hidden").Bool() {
+		return
+	}
+
+	text := []rune(mention.Text)
+	if len(text) > maxNotificationText {
+		text = append(text[:maxNotificationText], '…')
+	}
+	n := notification.New(mention.From+" mentioned you in #"+mention.Room, map[string]interface{}{
+		"body": string(text),
+		"tag":  mention.MessageID, // Replaces a notification for the same message
+	})
+
+	var onClick js.Func
+	onClick = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		js.Global().Call("focus")
+		n.Call("close")
+		if _, ok := store.Messages[mention.MessageID]; ok {
+			showMessage(mention.MessageID, mention.ParentID)
+		}
+		onClick.Release()
+		return nil
+	})
+	n.Set("onclick", onClick)
+	log.Printf("🔔 Notified of mention by %s in %s", mention.From, mention.Room)
+}
@@ -0,0 +1,114 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"go-chat/shared/ws"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/prop"
+)
+
+// parseLinkPreview reads a link preview of a MESSAGE or UNFURL payload
+func parseLinkPreview(payload map[string]interface{}) ws.LinkPreview {
+	url, _ := payload["url"].(string)
+	title, _ := payload["title"].(string)
+	description, _ := payload["description"].(string)
+	image, _ := payload["image"].(string)
+	siteName, _ := payload["site_name"].(string)
+	return ws.LinkPreview{
+		URL:         url,
+		Title:       title,
+		Description: description,
+		Image:       image,
+		SiteName:    siteName,
+	}
+}
+
+// parsePreviews reads the link previews of a MESSAGE or UNFURL payload
+func parsePreviews(value interface{}) []ws.LinkPreview {
+	raw, _ := value.([]interface{})
+	var previews []ws.LinkPreview
+	for _, p := range raw {
+		if preview, ok := p.(map[string]interface{}); ok {
+			previews = append(previews, parseLinkPreview(preview))
+		}
+	}
+	return previews
+}
+
+// parseUnfurl reads the payload of an UNFURL message
+func parseUnfurl(payload map[string]interface{}) ws.UnfurlMessage {
+	id, _ := payload["id"].(string)
+	return ws.UnfurlMessage{
+		ID:       id,
+		Previews: parsePreviews(payload["previews"]),
+	}
+}
+
+// renderPreviews renders the link previews of a chat message as cards
+// linking to their pages
+func renderPreviews(previews []ws.LinkPreview) vecty.ComponentOrHTML {
+	if len(previews) == 0 {
+		return nil
+	}
+
+	var cards []vecty.MarkupOrChild
+	cards = append(cards, vecty.Markup(vecty.Class("flex", "flex-col", "gap-2", "mt-1")))
+	for _, preview := range previews {
+		var image vecty.ComponentOrHTML
+		if preview.Image != "" {
+			image = elem.Image(
+				vecty.Markup(
+					vecty.Class("w-20", "h-20", "object-cover", "rounded", "flex-shrink-0"),
+					prop.Src(preview.Image),
+					vecty.Attribute("alt", ""),
+					vecty.Attribute("loading", "lazy"),
+					// Sites do not learn where their links were posted
+					vecty.Attribute("referrerpolicy", "no-referrer"),
+				),
+			)
+		}
+
+		var siteName, description vecty.ComponentOrHTML
+		if preview.SiteName != "" {
+			siteName = elem.Div(
+				vecty.Markup(vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400")),
+				vecty.Text(preview.SiteName),
+			)
+		}
+		if preview.Description != "" {
+			description = elem.Div(
+				vecty.Markup(vecty.Class("text-sm", "text-gray-600", "dark:text-gray-300", "line-clamp-2")),
+				vecty.Text(preview.Description),
+			)
+		}
+
+		cards = append(cards, elem.Anchor(
+			vecty.Markup(
+				vecty.Key(preview.URL),
+				vecty.Class(
+					"flex", "gap-3", "max-w-md", "p-2", "rounded-lg", "border-l-4",
+					"border-blue-400", "bg-gray-50", "dark:bg-gray-800",
+					"hover:bg-gray-100", "dark:hover:bg-gray-700",
+				),
+				prop.Href(preview.URL),
+				vecty.Attribute("target", "_blank"),
+				vecty.Attribute("rel", "noopener noreferrer nofollow"),
+			),
+			image,
+			elem.Div(
+				vecty.Markup(vecty.Class("min-w-0")),
+				siteName,
+				elem.Div(
+					vecty.Markup(vecty.Class("font-semibold", "text-blue-600", "dark:text-blue-400", "truncate")),
+					vecty.Text(preview.Title),
+				),
+				description,
+			),
+		))
+	}
+	return elem.Div(cards...)
+}
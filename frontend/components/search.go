@@ -0,0 +1,273 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"go-chat/frontend/store"
+	"go-chat/frontend/store/actions"
+	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/event"
+	"github.com/hexops/vecty/prop"
+)
+
+// SearchPane searches the room's messages and jumps to a result in the
+// message list
+type SearchPane struct {
+	vecty.Core
+	query  string
+	from   string
+	since  string // yyyy-mm-dd from the date input
+	notice string
+}
+
+func (s *SearchPane) onQuery(e *vecty.Event) {
+	s.query = e.Target.Get("value").String()
+}
+
+func (s *SearchPane) onFrom(e *vecty.Event) {
+	s.from = e.Target.Get("value").String()
+}
+
+func (s *SearchPane) onSince(e *vecty.Event) {
+	s.since = e.Target.Get("value").String()
+}
+
+func (s *SearchPane) onKeyDown(e *vecty.Event) {
+	if e.Get("key").String() == "Enter" {
+		e.Call("preventDefault")
+		s.onSearch(e)
+	}
+}
+
+func (s *SearchPane) onClose(e *vecty.Event) {
+	dispatcher.Dispatch(&actions.ToggleSearch{})
+}
+
+func (s *SearchPane) onSearch(e *vecty.Event) {
+	if strings.TrimSpace(s.query) == "" {
+		return
+	}
+
+	req := api.SearchMessagesRequest{
+		Query: s.query,
+		From:  strings.TrimSpace(s.from),
+	}
+	if since, err := time.ParseInLocation("2006-01-02", s.since, time.Local); err == nil {
+		req.Since = since.UnixMilli()
+	}
+	s.notice = ""
+	s.search(req, false)
+}
+
+// onMore loads the next page of the current search
+func (s *SearchPane) onMore(e *vecty.Event) {
+	req := store.SearchRequest
+	req.Offset = len(store.SearchResults)
+	s.search(req, true)
+}
+
+func (s *SearchPane) search(req api.SearchMessagesRequest, appendResults bool) {
+	go func() {
+		results, err := actions.SearchMessages(store.Session, req)
+		if err != nil {
+			log.Printf("❌ Failed to search messages: %v", err)
+			dispatcher.Dispatch(&actions.SetError{Message: "search failed"})
+			return
+		}
+		dispatcher.Dispatch(&actions.SetSearchResults{Request: req, Response: *results, Append: appendResults})
+	}()
+}
+
+// onJump highlights a result in the message list, or in its thread for
+// replies, and scrolls to it
+func (s *SearchPane) onJump(msg ws.TextMessage) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		if _, ok := store.Messages[msg.ID]; !ok {
+			s.notice = "That message is older than the loaded history."
+			vecty.Rerender(s)
+			return
+		}
+
+		s.notice = ""
+		showMessage(msg.ID, msg.ParentID)
+	}
+}
+
+// showMessage highlights a message in the message list, or in its thread
+// for replies, and scrolls to it
+func showMessage(id, parentID string) {
+	if parentID != "" {
+		dispatcher.Dispatch(&actions.OpenThread{ParentID: parentID})
+	}
+	dispatcher.Dispatch(&actions.HighlightMessage{ID: id})
+
+	// Scroll once the highlighted message is rendered
+	js.Global().Call("setTimeout", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if el := js.Global().Get("document").Call("getElementById", messageElementID(id)); el.Truthy() {
+			el.Call("scrollIntoView", map[string]interface{}{"block": "center", "behavior": "smooth"})
+		}
+		return nil
+	}), 0)
+}
+
+// messageElementID is the DOM ID of a rendered chat message
+func messageElementID(id string) string {
+	return "message-" + id
+}
+
+func (s *SearchPane) renderResults() vecty.ComponentOrHTML {
+	if store.SearchRequest.Query == "" {
+		return nil
+	}
+	if len(store.SearchResults) == 0 {
+		return elem.Paragraph(
+			vecty.Markup(
+				vecty.Class("text-gray-500", "dark:text-gray-400", "text-center", "italic"),
+			),
+			vecty.Text("No messages found"),
+		)
+	}
+
+	var items []vecty.MarkupOrChild
+	items = append(items, elem.Paragraph(
+		vecty.Markup(vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400", "mb-2")),
+		vecty.Text(strconv.Itoa(store.SearchTotal)+" results"),
+	))
+	for _, result := range store.SearchResults {
+		msg := result.Message
+		items = append(items, elem.Button(
+			vecty.Markup(
+				vecty.Key(msg.ID),
+				vecty.Class(
+					"block", "w-full", "text-left", "mb-2", "p-2", "rounded-lg",
+					"text-gray-800", "dark:text-gray-200",
+					"hover:bg-gray-100", "dark:hover:bg-gray-700",
+				),
+				event.Click(s.onJump(msg)),
+			),
+			elem.Div(
+				vecty.Markup(vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400")),
+				vecty.Text(msg.From+" · "+time.UnixMilli(msg.Timestamp).Format("Jan 2 15:04")),
+			),
+			elem.Div(
+				vecty.Markup(vecty.Class("text-sm", "truncate")),
+				vecty.Text(msg.Text),
+			),
+		))
+	}
+	if len(store.SearchResults) < store.SearchTotal {
+		items = append(items, elem.Button(
+			vecty.Markup(
+				vecty.Class("text-xs", "text-blue-600", "dark:text-blue-400", "hover:underline"),
+				event.Click(s.onMore),
+			),
+			vecty.Text("More results"),
+		))
+	}
+	return elem.Div(items...)
+}
+
+// Render implements the vecty.Component interface
+func (s *SearchPane) Render() vecty.ComponentOrHTML {
+	if !store.SearchOpen {
+		return elem.Div()
+	}
+
+	inputClasses := vecty.Class(
+		"w-full", "p-2", "mb-2",
+		"border", "border-gray-300", "dark:border-gray-600",
+		"rounded-lg",
+		"bg-white", "dark:bg-gray-700",
+		"text-gray-900", "dark:text-white",
+		"placeholder-gray-500", "dark:placeholder-gray-400",
+		"focus:ring-2", "focus:ring-blue-500",
+		"focus:border-transparent",
+	)
+
+	var notice vecty.ComponentOrHTML
+	if s.notice != "" {
+		notice = elem.Paragraph(
+			vecty.Markup(vecty.Class("text-xs", "text-amber-600", "dark:text-amber-400", "mb-2")),
+			vecty.Text(s.notice),
+		)
+	}
+
+	return elem.Div(
+		vecty.Markup(
+			vecty.Class(
+				"w-72", "shrink-0", "flex", "flex-col",
+				"bg-white", "dark:bg-gray-800",
+				"rounded-lg", "shadow-lg",
+				"p-4", "h-96",
+				"border", "border-gray-200", "dark:border-gray-700",
+			),
+		),
+		elem.Div(
+			vecty.Markup(
+				vecty.Class("flex", "justify-between", "items-center", "mb-2"),
+			),
+			elem.Heading2(
+				vecty.Markup(
+					vecty.Class("font-bold", "text-gray-800", "dark:text-gray-200"),
+				),
+				vecty.Text("Search"),
+			),
+			elem.Button(
+				vecty.Markup(
+					vecty.Class("text-gray-500", "hover:text-gray-700", "dark:hover:text-gray-300"),
+					event.Click(s.onClose),
+				),
+				vecty.Text("✕"),
+			),
+		),
+		elem.Input(
+			vecty.Markup(
+				inputClasses,
+				event.Input(s.onQuery),
+				event.KeyDown(s.onKeyDown),
+				prop.Value(s.query),
+				prop.Placeholder("Search messages..."),
+			),
+		),
+		elem.Div(
+			vecty.Markup(vecty.Class("flex", "gap-2")),
+			elem.Input(
+				vecty.Markup(
+					inputClasses,
+					event.Input(s.onFrom),
+					event.KeyDown(s.onKeyDown),
+					prop.Value(s.from),
+					prop.Placeholder("From user"),
+				),
+			),
+			elem.Input(
+				vecty.Markup(
+					inputClasses,
+					prop.Type(prop.TypeDate),
+					event.Input(s.onSince),
+					prop.Value(s.since),
+					vecty.Attribute("title", "Sent on or after"),
+				),
+			),
+		),
+		notice,
+		elem.Div(
+			vecty.Markup(
+				vecty.Class("flex-1", "overflow-y-auto"),
+			),
+			s.renderResults(),
+		),
+	)
+}
@@ -0,0 +1,234 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"log"
+	"time"
+
+	"go-chat/frontend/store"
+	"go-chat/frontend/store/actions"
+	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/ws"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/event"
+	"github.com/hexops/vecty/prop"
+)
+
+// ThreadPane shows the replies to store.ActiveThread
+type ThreadPane struct {
+	vecty.Core
+	Send    func(parentID, text string)             `vecty:"prop"`
+	OnRetry func(nonce string) func(e *vecty.Event) `vecty:"prop"`
+	input   string
+}
+
+func (t *ThreadPane) onInput(e *vecty.Event) {
+	t.input = e.Target.Get("value").String()
+	vecty.Rerender(t)
+}
+
+func (t *ThreadPane) onSend(e *vecty.Event) {
+	if t.input == "" {
+		return
+	}
+	t.Send(store.ActiveThread, t.input)
+	t.input = ""
+	vecty.Rerender(t)
+}
+
+func (t *ThreadPane) onKeyDown(e *vecty.Event) {
+	if e.Get("key").String() == "Enter" {
+		e.Call("preventDefault")
+		t.onSend(e)
+	}
+}
+
+func (t *ThreadPane) onClose(e *vecty.Event) {
+	dispatcher.Dispatch(&actions.OpenThread{})
+}
+
+// onLoadOlder loads the replies before the oldest loaded one
+func (t *ThreadPane) onLoadOlder(e *vecty.Event) {
+	parentID := store.ActiveThread
+	replies := store.ThreadOrder[parentID]
+	if len(replies) == 0 {
+		return
+	}
+	before := store.Messages[replies[0]].Seq
+	go func() {
+		page, err := actions.FetchReplies(parentID, before)
+		if err != nil {
+			log.Printf("❌ Failed to fetch older replies: %v", err)
+			return
+		}
+		dispatcher.Dispatch(&actions.SetReplies{Parent: page.Parent, Replies: page.Replies, HasMore: page.HasMore})
+	}()
+}
+
+func (t *ThreadPane) renderMessage(msg ws.TextMessage) vecty.ComponentOrHTML {
+	var text vecty.ComponentOrHTML = renderText(msg)
+	if msg.Deleted {
+		text = elem.Span(
+			vecty.Markup(vecty.Class("italic", "text-gray-500", "dark:text-gray-400")),
+			vecty.Text("message deleted"),
+		)
+	}
+
+	var edited vecty.ComponentOrHTML
+	if msg.EditedAt != 0 && !msg.Deleted {
+		edited = elem.Span(
+			vecty.Markup(vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400", "ml-2")),
+			vecty.Text("(edited)"),
+		)
+	}
+
+	return elem.Div(
+		vecty.Markup(
+			vecty.Key(msg.ID),
+			// The root message keeps its ID in the main message list
+			vecty.MarkupIf(msg.ParentID != "", prop.ID(messageElementID(msg.ID))),
+			vecty.Class("mb-2", "text-gray-800", "dark:text-gray-200", "rounded"),
+			vecty.MarkupIf(mentionsMe(msg), vecty.Class("border-l-4", "border-yellow-400", "pl-2")),
+			vecty.MarkupIf(msg.ID == store.HighlightID, vecty.Class("bg-yellow-100", "dark:bg-yellow-900")),
+		),
+		elem.Span(
+			vecty.Markup(
+				vecty.Class("text-xs", "text-gray-500", "dark:text-gray-400", "mr-2"),
+				vecty.Attribute("title", time.UnixMilli(msg.Timestamp).Format(time.RFC1123)),
+			),
+			vecty.Text(time.UnixMilli(msg.Timestamp).Format("15:04")),
+		),
+		elem.Span(
+			vecty.Markup(
+				vecty.Class("font-bold", "text-blue-600", "dark:text-blue-400", "mr-2"),
+			),
+			vecty.Text(authorLabel(msg)),
+		),
+		text,
+		edited,
+		renderAttachments(msg.Attachments),
+		renderPreviews(msg.Previews),
+	)
+}
+
+func (t *ThreadPane) renderReplies() vecty.ComponentOrHTML {
+	var replyElements []vecty.MarkupOrChild
+	if store.ThreadHasMore[store.ActiveThread] {
+		replyElements = append(replyElements, elem.Button(
+			vecty.Markup(
+				vecty.Class("text-xs", "text-blue-600", "dark:text-blue-400", "hover:underline", "mb-2"),
+				event.Click(t.onLoadOlder),
+			),
+			vecty.Text("Load older replies"),
+		))
+	}
+	for _, id := range store.ThreadOrder[store.ActiveThread] {
+		replyElements = append(replyElements, t.renderMessage(store.Messages[id]))
+	}
+	for _, nonce := range store.PendingOrder {
+		pending := store.Pending[nonce]
+		if pending.ParentID != store.ActiveThread {
+			continue
+		}
+		replyElements = append(replyElements, elem.Div(
+			vecty.Markup(
+				vecty.Key(nonce),
+				vecty.Class("mb-2", "text-gray-500", "dark:text-gray-400"),
+			),
+			elem.Span(
+				vecty.Markup(
+					vecty.Class("font-bold", "text-blue-600", "dark:text-blue-400", "mr-2", "opacity-60"),
+				),
+				vecty.Text(store.Username+": "),
+			),
+			renderMarkdown(pending.Text),
+			renderPendingStatus(pending, t.OnRetry(nonce)),
+		))
+	}
+
+	if len(replyElements) == 0 {
+		return elem.Paragraph(
+			vecty.Markup(
+				vecty.Class("text-gray-500", "dark:text-gray-400", "text-center", "italic"),
+			),
+			vecty.Text("No replies yet"),
+		)
+	}
+	return elem.Div(replyElements...)
+}
+
+// Render implements the vecty.Component interface
+func (t *ThreadPane) Render() vecty.ComponentOrHTML {
+	if store.ActiveThread == "" {
+		return elem.Div()
+	}
+
+	var parent vecty.ComponentOrHTML
+	if msg, ok := store.Messages[store.ActiveThread]; ok {
+		parent = elem.Div(
+			vecty.Markup(
+				vecty.Class("pb-2", "mb-2", "border-b", "border-gray-200", "dark:border-gray-700"),
+			),
+			t.renderMessage(msg),
+		)
+	}
+
+	return elem.Div(
+		vecty.Markup(
+			vecty.Class(
+				"w-72", "shrink-0", "flex", "flex-col",
+				"bg-white", "dark:bg-gray-800",
+				"rounded-lg", "shadow-lg",
+				"p-4", "h-96",
+				"border", "border-blue-300", "dark:border-blue-700",
+			),
+		),
+		elem.Div(
+			vecty.Markup(
+				vecty.Class("flex", "justify-between", "items-center", "mb-2"),
+			),
+			elem.Heading2(
+				vecty.Markup(
+					vecty.Class("font-bold", "text-gray-800", "dark:text-gray-200"),
+				),
+				vecty.Text("Thread"),
+			),
+			elem.Button(
+				vecty.Markup(
+					vecty.Class("text-gray-500", "hover:text-gray-700", "dark:hover:text-gray-300"),
+					event.Click(t.onClose),
+				),
+				vecty.Text("✕"),
+			),
+		),
+		elem.Div(
+			vecty.Markup(
+				vecty.Class("flex-1", "overflow-y-auto", "mb-2"),
+			),
+			parent,
+			t.renderReplies(),
+		),
+		elem.Input(
+			vecty.Markup(
+				vecty.Class(
+					"p-2",
+					"border", "border-gray-300", "dark:border-gray-600",
+					"rounded-lg",
+					"bg-white", "dark:bg-gray-700",
+					"text-gray-900", "dark:text-white",
+					"placeholder-gray-500", "dark:placeholder-gray-400",
+					"focus:ring-2", "focus:ring-blue-500",
+					"focus:border-transparent",
+				),
+				event.Input(t.onInput),
+				event.KeyDown(t.onKeyDown),
+				prop.Value(t.input),
+				prop.Placeholder("Reply..."),
+			),
+		),
+	)
+}
@@ -4,20 +4,27 @@
 package components
 
 import (
+	"errors"
 	"go-chat/frontend/store"
 	"go-chat/frontend/store/actions"
 	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/http"
 	"log"
+	"strings"
 
 	"github.com/hexops/vecty"
 	"github.com/hexops/vecty/elem"
 	"github.com/hexops/vecty/event"
 )
 
-// UsernameForm is a component for setting the username
+// UsernameForm is a component for signing in, as a guest or with the token
+// of an account
 type UsernameForm struct {
 	vecty.Core
-	input string
+	input   string
+	token   string
+	signing bool   // A sign in request is on its way
+	err     string // Why signing in failed
 }
 
 func (u *UsernameForm) onInput(e *vecty.Event) {
@@ -25,16 +32,38 @@ func (u *UsernameForm) onInput(e *vecty.Event) {
 	vecty.Rerender(u)
 }
 
+func (u *UsernameForm) onTokenInput(e *vecty.Event) {
+	u.token = e.Target.Get("value").String()
+	vecty.Rerender(u)
+}
+
 func (u *UsernameForm) onSubmit(e *vecty.Event) {
-	if u.input == "" {
+	if u.input == "" || u.signing {
 		return
 	}
 
-	log.Printf("Setting username to: %s", u.input)
-	dispatcher.Dispatch(&actions.SetUsername{
-		Username: u.input,
-	})
+	log.Printf("Signing in as: %s", u.input)
+	u.signing, u.err = true, ""
 	vecty.Rerender(u)
+	go func() {
+		session, err := actions.CreateSession(strings.TrimSpace(u.input), strings.TrimSpace(u.token))
+		u.signing = false
+		if err != nil {
+			u.err = signInError(err)
+			vecty.Rerender(u)
+			return
+		}
+		dispatcher.Dispatch(&actions.SetSession{Username: session.Username, Token: session.Token})
+	}()
+}
+
+// signInError explains why signing in failed
+func signInError(err error) string {
+	var apiErr *http.APIError
+	if errors.As(err, &apiErr) && apiErr.Details != "" {
+		return "Could not sign in: " + apiErr.Details
+	}
+	return "Could not sign in, try again"
 }
 
 // Render implements the vecty.Component interface
@@ -42,6 +71,10 @@ func (u *UsernameForm) Render() vecty.ComponentOrHTML {
 	if store.Username != "" {
 		return nil // Don't render if username is already set
 	}
+	errText := u.err
+	if errText == "" {
+		errText = store.SignInError
+	}
 
 	return elem.Form(
 		vecty.Markup(
@@ -68,6 +101,12 @@ func (u *UsernameForm) Render() vecty.ComponentOrHTML {
 			),
 			vecty.Text("Please enter your username to continue"),
 		),
+		vecty.If(errText != "", elem.Paragraph(
+			vecty.Markup(
+				vecty.Class("text-red-600", "dark:text-red-400", "text-center", "text-sm"),
+			),
+			vecty.Text(errText),
+		)),
 		elem.Input(
 			vecty.Markup(
 				vecty.Class(
@@ -87,6 +126,26 @@ func (u *UsernameForm) Render() vecty.ComponentOrHTML {
 				vecty.Property("required", true),
 			),
 		),
+		elem.Input(
+			vecty.Markup(
+				vecty.Class(
+					"p-3",
+					"border", "border-gray-300", "dark:border-gray-600",
+					"rounded-lg",
+					"bg-white", "dark:bg-gray-700",
+					"text-gray-900", "dark:text-white",
+					"placeholder-gray-500", "dark:placeholder-gray-400",
+					"focus:ring-2", "focus:ring-blue-500", "dark:focus:ring-blue-400",
+					"focus:border-transparent",
+					"transition-colors", "duration-200",
+				),
+				event.Input(u.onTokenInput),
+				vecty.Property("type", "password"),
+				vecty.Property("value", u.token),
+				vecty.Property("placeholder", "Account token, if you have an account"),
+				vecty.Property("autocomplete", "current-password"),
+			),
+		),
 		elem.Button(
 			vecty.Markup(
 				vecty.Class(
@@ -100,7 +159,7 @@ func (u *UsernameForm) Render() vecty.ComponentOrHTML {
 					"disabled:opacity-50",
 				),
 				vecty.Property("type", "submit"),
-				vecty.Property("disabled", u.input == ""),
+				vecty.Property("disabled", u.input == "" || u.signing),
 			),
 			vecty.Text("Join Chat"),
 		),
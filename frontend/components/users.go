@@ -0,0 +1,438 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"go-chat/frontend/store"
+	"go-chat/frontend/store/actions"
+	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/event"
+)
+
+// UserList is the sidebar listing the room's members with their presence,
+// followed by other users the current user can message directly.
+// Moderators also get controls to kick, ban and mute members.
+type UserList struct {
+	vecty.Core
+	Moderate func(msgType ws.MessageType, mod ws.ModerationMessage) // Sends a moderation action
+}
+
+// presenceRank orders members online first, then away, then offline
+var presenceRank = map[string]int{
+	ws.PresenceOnline:  0,
+	ws.PresenceAway:    1,
+	ws.PresenceOffline: 2,
+}
+
+// Mount implements the vecty.Mounter interface
+func (u *UserList) Mount() {
+	go u.fetchConversations()
+}
+
+func (u *UserList) fetchConversations() {
+	conversations, err := actions.FetchConversations(store.Session)
+	if err != nil {
+		log.Printf("❌ Failed to fetch conversations: %v", err)
+		return
+	}
+	dispatcher.Dispatch(&actions.SetConversations{Conversations: conversations})
+}
+
+// users returns the users to list, without duplicates or the current user
+func (u *UserList) users() []string {
+	var members []string
+	for username := range store.Members {
+		if username != store.Username {
+			members = append(members, username)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := store.Members[members[i]], store.Members[members[j]]
+		if presenceRank[a.Status] != presenceRank[b.Status] {
+			return presenceRank[a.Status] < presenceRank[b.Status]
+		}
+		return a.Username < b.Username
+	})
+
+	seen := map[string]bool{store.Username: true}
+	users := members
+	for _, username := range members {
+		seen[username] = true
+	}
+	add := func(username string) {
+		if !seen[username] {
+			seen[username] = true
+			users = append(users, username)
+		}
+	}
+	for _, username := range store.Conversations {
+		add(username)
+	}
+	for _, id := range store.MessageOrder {
+		add(store.Messages[id].From)
+	}
+	return users
+}
+
+// presenceOf returns the presence of a user, who is offline when the room
+// does not know them
+func (u *UserList) presenceOf(username string) ws.Presence {
+	if presence, ok := store.Members[username]; ok {
+		return presence
+	}
+	return ws.Presence{Username: username, Status: ws.PresenceOffline}
+}
+
+func (u *UserList) renderStatusDot(presence ws.Presence) vecty.ComponentOrHTML {
+	dot := "bg-gray-400"
+	switch presence.Status {
+	case ws.PresenceOnline:
+		dot = "bg-green-500"
+	case ws.PresenceAway:
+		dot = "bg-yellow-500"
+	}
+
+	return elem.Span(
+		vecty.Markup(
+			vecty.Class("inline-block", "w-2", "h-2", "rounded-full", "mr-2", dot),
+			vecty.Attribute("title", presence.Status),
+		),
+	)
+}
+
+func (u *UserList) renderLastSeen(presence ws.Presence) vecty.ComponentOrHTML {
+	if presence.Status != ws.PresenceOffline || presence.LastSeen == 0 {
+		return nil
+	}
+
+	return elem.Span(
+		vecty.Markup(
+			vecty.Class("block", "text-xs", "text-gray-500", "dark:text-gray-400"),
+		),
+		vecty.Text("last seen "+time.UnixMilli(presence.LastSeen).Format("Jan 2 15:04")),
+	)
+}
+
+// renderRole marks bots, moderators and owners, and muted users
+func (u *UserList) renderRole(presence ws.Presence) vecty.ComponentOrHTML {
+	var badges vecty.List
+	if presence.Bot {
+		badges = append(badges, elem.Span(
+			vecty.Markup(
+				vecty.Class(
+					"ml-1", "px-1", "rounded", "text-xs",
+					"bg-gray-200", "dark:bg-gray-600",
+					"text-gray-700", "dark:text-gray-200",
+				),
+				vecty.Attribute("title", "bot account"),
+			),
+			vecty.Text("bot"),
+		))
+	}
+	switch presence.Role {
+	case api.RoleOwner, api.RoleModerator:
+		label := "mod"
+		if presence.Role == api.RoleOwner {
+			label = "owner"
+		}
+		badges = append(badges, elem.Span(
+			vecty.Markup(
+				vecty.Class(
+					"ml-1", "px-1", "rounded", "text-xs",
+					"bg-purple-100", "dark:bg-purple-900",
+					"text-purple-800", "dark:text-purple-200",
+				),
+				vecty.Attribute("title", presence.Role),
+			),
+			vecty.Text(label),
+		))
+	}
+	if until := mutedUntil(presence.Username); !until.IsZero() {
+		badges = append(badges, elem.Span(
+			vecty.Markup(
+				vecty.Class("ml-1"),
+				vecty.Attribute("title", "muted until "+until.Format("15:04")),
+			),
+			vecty.Text("🔇"),
+		))
+	}
+	return badges
+}
+
+// renderControls renders the moderation actions the current user may take
+// against a room member
+func (u *UserList) renderControls(username string) vecty.ComponentOrHTML {
+	if _, ok := store.Members[username]; !ok || u.Moderate == nil || !canModerate(username) {
+		return nil
+	}
+
+	controls := []vecty.MarkupOrChild{
+		vecty.Markup(vecty.Class("flex", "flex-wrap", "gap-1", "px-3", "pb-2")),
+		u.renderControl("Kick", u.onKick(username)),
+	}
+	if mutedUntil(username).IsZero() {
+		controls = append(controls, u.renderControl("Mute 10m", u.onModerate(ws.TypeMute, ws.ModerationMessage{
+			Username: username,
+			Duration: muteDuration.Milliseconds(),
+		})))
+	} else {
+		controls = append(controls, u.renderControl("Unmute", u.onModerate(ws.TypeUnmute, ws.ModerationMessage{Username: username})))
+	}
+	if slices.ContainsFunc(store.Bans, func(ban api.Ban) bool { return ban.Username == username }) {
+		controls = append(controls, u.renderControl("Unban", u.onModerate(ws.TypeUnban, ws.ModerationMessage{Username: username})))
+	} else {
+		controls = append(controls, u.renderControl("Ban", u.onBan(username)))
+	}
+	if roleOf(store.Username) == api.RoleOwner {
+		if roleOf(username) == api.RoleModerator {
+			controls = append(controls, u.renderControl("Remove mod", u.onModerate(ws.TypeRole, ws.ModerationMessage{
+				Username: username,
+				Role:     api.RoleMember,
+			})))
+		} else {
+			controls = append(controls, u.renderControl("Make mod", u.onModerate(ws.TypeRole, ws.ModerationMessage{
+				Username: username,
+				Role:     api.RoleModerator,
+			})))
+		}
+	}
+	return elem.Div(controls...)
+}
+
+func (u *UserList) renderControl(label string, onClick func(*vecty.Event)) vecty.ComponentOrHTML {
+	return elem.Button(
+		vecty.Markup(
+			vecty.Class(
+				"px-2", "rounded", "text-xs",
+				"text-gray-600", "dark:text-gray-300",
+				"bg-gray-100", "dark:bg-gray-700",
+				"hover:bg-red-100", "dark:hover:bg-red-900",
+			),
+			event.Click(onClick),
+		),
+		vecty.Text(label),
+	)
+}
+
+func (u *UserList) onModerate(msgType ws.MessageType, mod ws.ModerationMessage) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		u.Moderate(msgType, mod)
+	}
+}
+
+func (u *UserList) onKick(username string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		if reason, ok := promptReason("kick", username); ok {
+			u.Moderate(ws.TypeKick, ws.ModerationMessage{Username: username, Reason: reason})
+		}
+	}
+}
+
+func (u *UserList) onBan(username string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		duration, ok := promptBanDuration(username)
+		if !ok {
+			return
+		}
+		if reason, ok := promptReason("ban", username); ok {
+			u.Moderate(ws.TypeBan, ws.ModerationMessage{
+				Username: username,
+				Duration: duration.Milliseconds(),
+				Reason:   reason,
+			})
+		}
+	}
+}
+
+// renderBans lists the room's bans to moderators, with a way to lift them
+func (u *UserList) renderBans() vecty.ComponentOrHTML {
+	if len(store.Bans) == 0 || api.RoleRank(roleOf(store.Username)) < api.RoleRank(api.RoleModerator) {
+		return nil
+	}
+
+	var items vecty.List
+	for _, ban := range store.Bans {
+		until := "for good"
+		if ban.Until != 0 {
+			until = "until " + time.UnixMilli(ban.Until).Format("Jan 2 15:04")
+		}
+		var unban vecty.ComponentOrHTML
+		if u.Moderate != nil && canModerate(ban.Username) {
+			unban = u.renderControl("Unban", u.onModerate(ws.TypeUnban, ws.ModerationMessage{Username: ban.Username}))
+		}
+		items = append(items, elem.ListItem(
+			vecty.Markup(
+				vecty.Key(ban.Username),
+				vecty.Class("px-3", "py-1", "text-sm", "text-gray-800", "dark:text-gray-200"),
+				vecty.Attribute("title", "banned by "+ban.By+": "+ban.Reason),
+			),
+			vecty.Text(ban.Username),
+			elem.Span(
+				vecty.Markup(vecty.Class("block", "text-xs", "text-gray-500", "dark:text-gray-400")),
+				vecty.Text(until),
+			),
+			unban,
+		))
+	}
+
+	return elem.Div(
+		elem.Heading2(
+			vecty.Markup(
+				vecty.Class("font-bold", "mt-4", "mb-2", "text-gray-800", "dark:text-gray-200"),
+			),
+			vecty.Text("Banned"),
+		),
+		elem.UnorderedList(items),
+	)
+}
+
+func (u *UserList) onOpen(username string) func(*vecty.Event) {
+	return func(e *vecty.Event) {
+		dispatcher.Dispatch(&actions.OpenConversation{With: username})
+		go func() {
+			messages, err := actions.FetchDirectMessages(store.Session, username)
+			if err != nil {
+				log.Printf("❌ Failed to fetch direct messages: %v", err)
+				return
+			}
+			dispatcher.Dispatch(&actions.SetDirectMessages{With: username, Messages: messages})
+		}()
+	}
+}
+
+// renderRooms lists the joined room with its unread count
+func (u *UserList) renderRooms() vecty.ComponentOrHTML {
+	if store.Room == "" {
+		return nil
+	}
+
+	var badge vecty.ComponentOrHTML
+	if store.Unread > 0 {
+		badge = elem.Span(
+			vecty.Markup(
+				vecty.Class(
+					"ml-auto", "px-2", "rounded-full", "text-xs", "font-bold",
+					"bg-blue-500", "dark:bg-blue-600", "text-white",
+				),
+				vecty.Attribute("title", strconv.Itoa(store.Unread)+" unread"),
+			),
+			vecty.Text(strconv.Itoa(store.Unread)),
+		)
+	}
+
+	return elem.Div(
+		elem.Heading2(
+			vecty.Markup(
+				vecty.Class("font-bold", "mb-2", "text-gray-800", "dark:text-gray-200"),
+			),
+			vecty.Text("Rooms"),
+		),
+		elem.Div(
+			vecty.Markup(
+				vecty.Class(
+					"flex", "items-center", "px-3", "py-2", "mb-4", "rounded-lg",
+					"text-gray-800", "dark:text-gray-200",
+					"bg-gray-100", "dark:bg-gray-700",
+				),
+			),
+			vecty.Text("# "+store.Room),
+			badge,
+		),
+		renderTopic(),
+	)
+}
+
+// Render implements the vecty.Component interface
+func (u *UserList) Render() vecty.ComponentOrHTML {
+	users := u.users()
+
+	var items vecty.List
+	for _, username := range users {
+		presence := u.presenceOf(username)
+		classes := vecty.Class(
+			"w-full", "text-left", "px-3", "py-2", "rounded-lg",
+			"text-gray-800", "dark:text-gray-200",
+			"hover:bg-gray-100", "dark:hover:bg-gray-700",
+			"transition-colors", "duration-200",
+		)
+		if username == store.ActiveConversation {
+			classes = vecty.Class(
+				"w-full", "text-left", "px-3", "py-2", "rounded-lg",
+				"bg-blue-100", "dark:bg-blue-900",
+				"text-blue-800", "dark:text-blue-200",
+				"transition-colors", "duration-200",
+			)
+		}
+		items = append(items, elem.ListItem(
+			elem.Button(
+				vecty.Markup(
+					classes,
+					event.Click(u.onOpen(username)),
+				),
+				u.renderStatusDot(presence),
+				vecty.Text(username),
+				u.renderRole(presence),
+				u.renderLastSeen(presence),
+			),
+			u.renderControls(username),
+		))
+	}
+
+	var list vecty.ComponentOrHTML
+	if len(users) == 0 {
+		list = elem.Paragraph(
+			vecty.Markup(
+				vecty.Class("text-gray-500", "dark:text-gray-400", "text-sm", "italic"),
+			),
+			vecty.Text("No one to message yet"),
+		)
+	} else {
+		list = elem.UnorderedList(items)
+	}
+
+	return elem.Div(
+		vecty.Markup(
+			vecty.Class(
+				"w-48", "shrink-0",
+				"bg-white", "dark:bg-gray-800",
+				"rounded-lg", "shadow-lg",
+				"p-4", "h-96", "overflow-y-auto",
+				"border", "border-gray-200", "dark:border-gray-700",
+			),
+		),
+		u.renderRooms(),
+		elem.Heading2(
+			vecty.Markup(
+				vecty.Class("font-bold", "mb-2", "text-gray-800", "dark:text-gray-200"),
+			),
+			vecty.Text("Users"),
+		),
+		list,
+		u.renderBans(),
+	)
+}
+
+// renderTopic renders the topic of the room, if set
+func renderTopic() vecty.ComponentOrHTML {
+	if store.Topic.Topic == "" {
+		return nil
+	}
+	return elem.Paragraph(
+		vecty.Markup(
+			vecty.Class("-mt-3", "mb-4", "px-3", "text-sm", "text-gray-600", "dark:text-gray-400", "break-words"),
+			vecty.Attribute("title", "Set by "+store.Topic.By+" on "+time.UnixMilli(store.Topic.At).Format(time.RFC1123)),
+		),
+		vecty.Text(store.Topic.Topic),
+	)
+}
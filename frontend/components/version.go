@@ -0,0 +1,94 @@
+//go:build wasm
+// +build wasm
+
+package components
+
+import (
+	"go-chat/frontend/internal"
+	"go-chat/frontend/store"
+	"go-chat/frontend/store/actions"
+	"go-chat/frontend/store/dispatcher"
+	"go-chat/shared/api"
+	"log"
+	"syscall/js"
+
+	"github.com/hexops/vecty"
+	"github.com/hexops/vecty/elem"
+	"github.com/hexops/vecty/event"
+)
+
+// VersionBanner tells the user when a newer frontend build is available
+type VersionBanner struct {
+	vecty.Core
+}
+
+// Mount implements the vecty.Mounter interface
+func (v *VersionBanner) Mount() {
+	store.Listeners.Add(v, func() {
+		vecty.Rerender(v)
+	})
+	// The WebSocket handshake also reports stale builds, but it only
+	// happens once a username is set
+	go v.checkVersion()
+}
+
+// Unmount implements the vecty.Unmounter interface
+func (v *VersionBanner) Unmount() {
+	store.Listeners.Remove(v)
+}
+
+func (v *VersionBanner) checkVersion() {
+	version, err := actions.FetchVersion()
+	if err != nil {
+		log.Printf("❌ Failed to check version: %v", err)
+		return
+	}
+	if version.Frontend != api.VersionUnknown && version.Frontend != internal.BuildHash {
+		dispatcher.Dispatch(&actions.SetReloadRequired{
+			CurrentBuild: version.Frontend,
+		})
+	}
+}
+
+func (v *VersionBanner) onReload(e *vecty.Event) {
+	log.Printf("🔁 Reloading to pick up build %s", store.LatestBuild)
+	js.Global().Get("location").Call("reload")
+}
+
+// Render implements the vecty.Component interface
+func (v *VersionBanner) Render() vecty.ComponentOrHTML {
+	if !store.ReloadRequired {
+		return elem.Div()
+	}
+
+	return elem.Div(
+		vecty.Markup(
+			vecty.Class(
+				"flex", "justify-between", "items-center",
+				"mx-4", "mb-4", "p-3",
+				"rounded-lg",
+				"bg-yellow-100", "dark:bg-yellow-900",
+				"text-yellow-900", "dark:text-yellow-100",
+				"border", "border-yellow-300", "dark:border-yellow-700",
+			),
+		),
+		elem.Span(
+			vecty.Text("A new version is available."),
+		),
+		elem.Button(
+			vecty.Markup(
+				vecty.Class(
+					"px-4", "py-1",
+					"bg-yellow-500", "dark:bg-yellow-600",
+					"text-white",
+					"rounded-lg",
+					"hover:bg-yellow-600", "dark:hover:bg-yellow-700",
+					"focus:outline-none", "focus:ring-2", "focus:ring-yellow-500",
+					"transition-colors", "duration-200",
+				),
+				event.Click(v.onReload),
+			),
+			vecty.Text("Reload"),
+		),
+	)
+}
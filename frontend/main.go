@@ -97,6 +97,7 @@ func (a *App) Render() vecty.ComponentOrHTML {
 				&components.DarkModeToggle{},
 			),
 		),
+		&components.VersionBanner{},
 		content,
 	)
 }
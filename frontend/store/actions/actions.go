@@ -6,11 +6,177 @@ package actions
 import (
 	"go-chat/shared/api"
 	"go-chat/shared/http"
+	"go-chat/shared/ws"
 	"log"
 )
 
-// healthClient is a type-safe client for health check requests
-var healthClient = http.NewClient[struct{}, api.HealthResponse]("", api.RouteHealth)
+// healthClient is a type-safe client for readiness check requests
+var healthClient = http.NewClient[struct{}, api.HealthResponse]("", api.RouteHealthReady)
+
+// versionClient is a type-safe client for build version requests
+var versionClient = http.NewClient[struct{}, api.VersionResponse]("", api.RouteVersion)
+
+// conversationsClient is a type-safe client for direct message conversation requests
+var conversationsClient = http.NewClient[api.ConversationsRequest, []api.Conversation]("", api.RouteConversations)
+
+// directMessagesClient is a type-safe client for direct message history requests
+var directMessagesClient = http.NewClient[api.DirectMessagesRequest, []ws.DirectMessage]("", api.RouteDirectMessages)
+
+// repliesClient is a type-safe client for thread reply requests
+var repliesClient = http.NewClient[api.RepliesRequest, api.RepliesResponse]("", api.RouteReplies)
+
+// FetchReplies fetches the latest replies to a message with a Seq below
+// before, or the latest replies when before is zero
+func FetchReplies(parentID string, before uint64) (*api.RepliesResponse, error) {
+	replies, err := repliesClient.Request(api.RepliesRequest{ParentID: parentID, Before: before})
+	if err != nil {
+		log.Printf("❌ Error fetching replies to %s: %v", parentID, err)
+		return nil, err
+	}
+
+	log.Printf("✅ Fetched %d replies to %s", len(replies.Replies), parentID)
+	return replies, nil
+}
+
+// searchClient is a type-safe client for message search requests
+var searchClient = http.NewClient[api.SearchMessagesRequest, api.SearchMessagesResponse]("", api.RouteSearchMessages)
+
+// SearchMessages fetches a page of the messages matching a search on
+// behalf of the user signed in with session
+func SearchMessages(session string, req api.SearchMessagesRequest) (*api.SearchMessagesResponse, error) {
+	results, err := searchClient.WithToken(session).Request(req)
+	if err != nil {
+		log.Printf("❌ Error searching messages for %q: %v", req.Query, err)
+		return nil, err
+	}
+
+	log.Printf("✅ Found %d of %d messages for %q", len(results.Results), results.Total, req.Query)
+	return results, nil
+}
+
+// commandsClient is a type-safe client for slash command requests
+var commandsClient = http.NewClient[struct{}, []api.Command]("", api.RouteCommands)
+
+// FetchCommands fetches the slash commands rooms run
+func FetchCommands() ([]api.Command, error) {
+	commands, err := commandsClient.Request(struct{}{})
+	if err != nil {
+		log.Printf("❌ Error fetching commands: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ Fetched %d commands", len(*commands))
+	return *commands, nil
+}
+
+// bansClient is a type-safe client for room ban requests
+var bansClient = http.NewClient[api.BansRequest, []api.Ban]("", api.RouteBans)
+
+// FetchBans fetches the bans of the room on behalf of a moderator signed
+// in with session
+func FetchBans(session string) ([]api.Ban, error) {
+	bans, err := bansClient.WithToken(session).Request(api.BansRequest{})
+	if err != nil {
+		log.Printf("❌ Error fetching bans: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ Fetched %d bans", len(*bans))
+	return *bans, nil
+}
+
+// uploadClient is a type-safe client for attachment uploads
+var uploadClient = http.NewClient[api.UploadAttachmentRequest, ws.Attachment]("", api.RouteUploadAttachment)
+
+// UploadAttachment uploads a file to attach to a chat message
+func UploadAttachment(username, name string, data []byte) (*ws.Attachment, error) {
+	att, err := uploadClient.Upload(api.UploadAttachmentRequest{Username: username}, api.AttachmentFormField, name, data)
+	if err != nil {
+		log.Printf("❌ Error uploading %s: %v", name, err)
+		return nil, err
+	}
+
+	log.Printf("✅ Uploaded %s as %s", name, att.ID)
+	return att, nil
+}
+
+// FetchConversations fetches the direct message conversations of the user
+// signed in with session
+func FetchConversations(session string) ([]api.Conversation, error) {
+	conversations, err := conversationsClient.WithToken(session).Request(api.ConversationsRequest{})
+	if err != nil {
+		log.Printf("❌ Error fetching conversations: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ Fetched %d conversations", len(*conversations))
+	return *conversations, nil
+}
+
+// FetchDirectMessages fetches the latest direct messages between the user
+// signed in with session and with
+func FetchDirectMessages(session, with string) ([]ws.DirectMessage, error) {
+	messages, err := directMessagesClient.WithToken(session).Request(api.DirectMessagesRequest{With: with})
+	if err != nil {
+		log.Printf("❌ Error fetching direct messages with %s: %v", with, err)
+		return nil, err
+	}
+
+	log.Printf("✅ Fetched %d direct messages with %s", len(*messages), with)
+	return *messages, nil
+}
+
+// Type-safe clients for session requests
+var (
+	createSessionClient = http.NewClient[api.SessionRequest, api.Session]("", api.RouteCreateSession)
+	sessionClient       = http.NewClient[struct{}, api.Session]("", api.RouteSession)
+	endSessionClient    = http.NewClient[struct{}, struct{}]("", api.RouteEndSession)
+)
+
+// CreateSession signs in as username, with the token of the account of
+// that name if it has one
+func CreateSession(username, token string) (*api.Session, error) {
+	session, err := createSessionClient.Request(api.SessionRequest{Username: username, Token: token})
+	if err != nil {
+		log.Printf("❌ Error signing in as %s: %v", username, err)
+		return nil, err
+	}
+
+	log.Printf("✅ Signed in as %s", session.Username)
+	return session, nil
+}
+
+// CheckSession checks that the session with token is still valid, which
+// extends it
+func CheckSession(token string) (*api.Session, error) {
+	session, err := sessionClient.WithToken(token).Request(struct{}{})
+	if err != nil {
+		log.Printf("❌ Error checking session: %v", err)
+		return nil, err
+	}
+	return session, nil
+}
+
+// EndSession signs out of the session with token
+func EndSession(token string) error {
+	if _, err := endSessionClient.WithToken(token).Request(struct{}{}); err != nil {
+		log.Printf("❌ Error signing out: %v", err)
+		return err
+	}
+	return nil
+}
+
+// FetchVersion fetches the server and frontend build hashes from the server
+func FetchVersion() (*api.VersionResponse, error) {
+	version, err := versionClient.Request(struct{}{})
+	if err != nil {
+		log.Printf("❌ Error fetching version: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ Version: %+v", version)
+	return version, nil
+}
 
 // FetchHealthStatus fetches the current health status from the server
 func FetchHealthStatus() (*api.HealthResponse, error) {
@@ -3,15 +3,184 @@
 
 package actions
 
-// SetUsername is an action that sets the current user's username
-type SetUsername struct {
+import (
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+)
+
+// SetSession is an action that signs the current user in, with the token
+// of their session
+type SetSession struct {
 	Username string
+	Token    string
+}
+
+// SignOut is an action that signs the current user out, saying why when
+// their session ended on its own
+type SignOut struct {
+	Reason string
 }
 
-// AddMessage is an action that adds a new chat message
+// AddMessage is an action that adds a new chat message, or replaces the
+// message with the same ID
 type AddMessage struct {
-	Text string
-	From string
+	Message ws.TextMessage
+}
+
+// SendMessage is an action that tracks a chat message this client is
+// sending, or sending again after it failed
+type SendMessage struct {
+	Nonce       string
+	ParentID    string // Set for replies
+	Text        string
+	Attachments []ws.Attachment
+}
+
+// AckMessage is an action that marks a sent chat message as accepted
+type AckMessage struct {
+	Nonce   string
+	ID      string
+	Command string // Set when the message ran a slash command that posted nothing
+}
+
+// NackMessage is an action that marks a sent chat message as failed
+type NackMessage struct {
+	Nonce string
+	Error string
+}
+
+// EditMessage is an action that replaces the text of a chat message
+type EditMessage struct {
+	ID       string
+	Text     string
+	EditedAt int64
+}
+
+// DeleteMessage is an action that replaces a chat message with a tombstone
+type DeleteMessage struct {
+	ID string
+}
+
+// ReactMessage is an action that adds or removes a user's reaction to a
+// chat message
+type ReactMessage struct {
+	ID    string
+	Emoji string
+	From  string
+	Add   bool
+}
+
+// OpenThread is an action that opens the thread pane of a message, or
+// closes it when ParentID is empty
+type OpenThread struct {
+	ParentID string
+}
+
+// SetReplies is an action that adds a page of replies loaded for a thread
+type SetReplies struct {
+	Parent  ws.TextMessage
+	Replies []ws.TextMessage
+	HasMore bool
+}
+
+// UpdateThread is an action that sets the reply count of a message
+type UpdateThread struct {
+	Update ws.ThreadUpdate
+}
+
+// UnfurlMessage is an action that sets the link previews of a chat
+// message
+type UnfurlMessage struct {
+	Unfurl ws.UnfurlMessage
+}
+
+// SetReadState is an action that sets the unread count and read cursors
+// sent when the WebSocket connects
+type SetReadState struct {
+	State ws.ReadState
+}
+
+// MarkRead is an action that moves a user's read cursor forward
+type MarkRead struct {
+	Marker ws.ReadMarker
+}
+
+// SyncMessages is an action that applies the room history sent when the
+// WebSocket connects
+type SyncMessages struct {
+	Sync ws.SyncMessage
+}
+
+// AddDirectMessage is an action that adds a direct message sent or
+// received by the current user
+type AddDirectMessage struct {
+	Message ws.DirectMessage
+}
+
+// SetConversations is an action that sets the current user's direct
+// message conversations
+type SetConversations struct {
+	Conversations []api.Conversation
+}
+
+// SetDirectMessages is an action that sets the history of the
+// conversation with a user
+type SetDirectMessages struct {
+	With     string
+	Messages []ws.DirectMessage
+}
+
+// OpenConversation is an action that opens the direct message pane for a
+// user. An empty With closes it.
+type OpenConversation struct {
+	With string
+}
+
+// SetPresenceSnapshot is an action that replaces the presence of every
+// room member
+type SetPresenceSnapshot struct {
+	Members []ws.Presence
+}
+
+// UpdatePresence is an action that updates the presence of one user
+type UpdatePresence struct {
+	Presence ws.Presence
+}
+
+// SetTopic is an action that sets the topic of the room
+type SetTopic struct {
+	Topic ws.TopicMessage
+}
+
+// AddNotice is an action that shows a notice from the server or about
+// the room, such as the reply to a slash command
+type AddNotice struct {
+	Notice ws.SystemMessage
+}
+
+// SetCommands is an action that sets the slash commands offered while
+// typing
+type SetCommands struct {
+	Commands []api.Command
+}
+
+// Moderate is an action that applies a moderation action the room
+// broadcast to the members it knows about
+type Moderate struct {
+	Type       ws.MessageType
+	Moderation ws.ModerationMessage
+}
+
+// SetBans is an action that replaces the bans of the room, which only
+// moderators can load
+type SetBans struct {
+	Bans []api.Ban
+}
+
+// SetClosed is an action that records why the server closed the
+// WebSocket, so it is not reconnected. A nil Close clears it.
+type SetClosed struct {
+	Close *ws.CloseMessage
 }
 
 // SetTyping is an action that sets a user's typing status
@@ -20,5 +189,33 @@ type SetTyping struct {
 	IsTyping bool
 }
 
+// SetReloadRequired is an action that flags the running frontend as stale
+type SetReloadRequired struct {
+	CurrentBuild string
+}
+
+// SetError is an action that shows an error reported by the server.
+// An empty Message clears it.
+type SetError struct {
+	Message string
+}
+
+// ToggleSearch is an action that opens or closes the search panel
+type ToggleSearch struct{}
+
+// SetSearchResults is an action that sets the results of a search, or
+// appends the next page of them
+type SetSearchResults struct {
+	Request  api.SearchMessagesRequest
+	Response api.SearchMessagesResponse
+	Append   bool
+}
+
+// HighlightMessage is an action that highlights a chat message, or clears
+// the highlight when ID is empty
+type HighlightMessage struct {
+	ID string
+}
+
 // ToggleDarkMode is an action that toggles dark mode
 type ToggleDarkMode struct{}
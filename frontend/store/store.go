@@ -4,23 +4,124 @@
 package store
 
 import (
+	"encoding/json"
 	"go-chat/frontend/store/actions"
 	"go-chat/frontend/store/dispatcher"
 	"go-chat/shared/api"
+	"go-chat/shared/markdown"
+	"go-chat/shared/ws"
 	"log"
+	"slices"
+	"sort"
 	"syscall/js"
 )
 
 var (
-	// Messages represents all chat messages
-	Messages []api.WSChatMessage
+	// Messages holds the room's chat messages keyed by ID
+	Messages = make(map[string]ws.TextMessage)
+
+	// MessageOrder lists the IDs in Messages that are not replies, ordered
+	// by Seq
+	MessageOrder []string
+
+	// ThreadOrder lists the IDs of the replies in Messages, keyed by the
+	// message they reply to and ordered by Seq
+	ThreadOrder = make(map[string][]string)
+
+	// ThreadHasMore records the threads with older replies than the loaded
+	// ones
+	ThreadHasMore = make(map[string]bool)
+
+	// ActiveThread is the message whose thread pane is open
+	ActiveThread string
+
+	// Pending holds the chat messages this client sent until the room
+	// delivers them, keyed by nonce
+	Pending = make(map[string]*PendingMessage)
+
+	// PendingOrder lists the nonces in Pending in the order they were sent
+	PendingOrder []string
+
+	// Room is the name of the room the WebSocket joined
+	Room string
+
+	// ReadSeq is the Seq of the last room message the current user read,
+	// and Unread the number of messages by others after it
+	ReadSeq uint64
+	Unread  int
+
+	// ReadCursors holds the Seq each user read the room up to, keyed by
+	// username
+	ReadCursors = make(map[string]uint64)
+
+	// RoomEpoch and LastSeq identify the last room message received, so a
+	// reconnecting WebSocket can resume after it
+	RoomEpoch string
+	LastSeq   uint64
 
 	// Username represents the current user's username
 	Username string
 
+	// Session is the token of the current user's session, and
+	// SignInError why the last one ended or could not start
+	Session     string
+	SignInError string
+
+	// DirectMessages holds the direct messages of the current user, keyed
+	// by the other user
+	DirectMessages = make(map[string][]ws.DirectMessage)
+
+	// Conversations lists the users the current user exchanged direct
+	// messages with, most recently active first
+	Conversations []string
+
+	// ActiveConversation is the user whose direct message pane is open
+	ActiveConversation string
+
+	// Members holds the presence of the room's members, keyed by username
+	Members = make(map[string]ws.Presence)
+
+	// Topic is the topic of the room, if set
+	Topic ws.TopicMessage
+
+	// Notices are the latest notices from the server, oldest first
+	Notices []ws.SystemMessage
+
+	// Commands are the slash commands offered while typing
+	Commands []api.Command
+
+	// Bans lists the room's bans, most recent first, when the current user
+	// may see them
+	Bans []api.Ban
+
+	// Closed is why the server closed the WebSocket, e.g. a kick or ban;
+	// it is not reconnected while set
+	Closed *ws.CloseMessage
+
 	// TypingUsers represents users who are currently typing
 	TypingUsers = make(map[string]bool)
 
+	// SearchOpen is set while the search panel is shown
+	SearchOpen bool
+
+	// SearchRequest is the latest search, SearchResults the pages of its
+	// results loaded so far, and SearchTotal the number of matches
+	SearchRequest api.SearchMessagesRequest
+	SearchResults []api.SearchResult
+	SearchTotal   int
+
+	// HighlightID is the chat message jumped to from a search result
+	HighlightID string
+
+	// ReloadRequired is set when the server serves a newer frontend build
+	ReloadRequired bool
+
+	// LatestBuild is the frontend build hash the server currently serves
+	LatestBuild string
+
+	// Error is the last error reported by the server, if any
+	Error string
+
 	// IsDarkMode represents the current theme state
 	IsDarkMode bool
 
@@ -28,6 +129,29 @@ var (
 	Listeners = NewListenerRegistry()
 )
 
+// maxNotices bounds the notices kept in Notices
+const maxNotices = 5
+
+// Delivery states of a PendingMessage
+const (
+	PendingSending = "sending" // Waiting for the server to accept the message
+	PendingSent    = "sent"    // Accepted, waiting for the room to deliver it
+	PendingFailed  = "failed"  // Rejected or unanswered; it can be retried
+)
+
+// PendingMessage is a chat message sent by this client that the room has
+// not delivered yet
+type PendingMessage struct {
+	Nonce    string
+	ParentID string // Set for replies
+	Text     string
+	ID       string // Assigned by the server once accepted
+	Status   string
+	Error    string
+
+	Attachments []ws.Attachment
+}
+
 func init() {
 	// Load dark mode preference from localStorage
 	localStorage := js.Global().Get("localStorage")
@@ -37,6 +161,13 @@ func init() {
 		log.Printf("💾 Loaded dark mode from storage: %v", IsDarkMode)
 	}
 
+	// Stay signed in across reloads
+	var saved savedSession
+	if item := localStorage.Call("getItem", sessionKey); !item.IsNull() && json.Unmarshal([]byte(item.String()), &saved) == nil {
+		Username, Session = saved.Username, saved.Token
+		log.Printf("💾 Loaded session of %s from storage", Username)
+	}
+
 	log.Printf("💾 Store initialized | darkMode: %v", IsDarkMode)
 	dispatcher.Register(onAction)
 }
@@ -72,16 +203,226 @@ func (r *ListenerRegistry) Fire() {
 
 func onAction(action interface{}) {
 	switch a := action.(type) {
-	case *actions.SetUsername:
-		Username = a.Username
+	case *actions.SetSession:
+		Username, Session, SignInError = a.Username, a.Token, ""
+		saveSession()
 		log.Printf("👤 Username set to: %s", Username)
 
+	case *actions.SignOut:
+		Username, Session, SignInError = "", "", a.Reason
+		saveSession()
+		log.Printf("👤 Signed out: %s", a.Reason)
+
 	case *actions.AddMessage:
-		Messages = append(Messages, api.WSChatMessage{
-			Text: a.Text,
-			From: a.From,
-		})
-		log.Printf("💬 Message added from %s: %s", a.From, a.Text)
+		addMessage(a.Message)
+		log.Printf("💬 Message %s (seq %d) added from %s: %s", a.Message.ID, a.Message.Seq, a.Message.From, a.Message.Text)
+
+	case *actions.SendMessage:
+		if _, exists := Pending[a.Nonce]; !exists {
+			PendingOrder = append(PendingOrder, a.Nonce)
+		}
+		Pending[a.Nonce] = &PendingMessage{
+			Nonce:    a.Nonce,
+			ParentID: a.ParentID,
+			Text:     a.Text,
+			Status:   PendingSending,
+
+			Attachments: a.Attachments,
+		}
+		log.Printf("📤 Sending message %s", a.Nonce)
+
+	case *actions.AckMessage:
+		pending, ok := Pending[a.Nonce]
+		if !ok {
+			return
+		}
+		if _, delivered := Messages[a.ID]; delivered || a.Command != "" {
+			removePending(a.Nonce)
+		} else {
+			pending.ID = a.ID
+			pending.Status = PendingSent
+		}
+		log.Printf("✅ Message %s accepted as %s", a.Nonce, a.ID)
+
+	case *actions.NackMessage:
+		pending, ok := Pending[a.Nonce]
+		if !ok || pending.Status == PendingSent {
+			return
+		}
+		pending.Status = PendingFailed
+		pending.Error = a.Error
+		log.Printf("❌ Message %s failed: %s", a.Nonce, a.Error)
+
+	case *actions.EditMessage:
+		if msg, ok := Messages[a.ID]; ok {
+			msg.Text = a.Text
+			msg.Mentions, msg.Links = markdown.Extract(a.Text)
+			msg.Previews = ws.MergePreviews(msg.Links, msg.Previews, nil)
+			msg.EditedAt = a.EditedAt
+			Messages[a.ID] = msg
+		}
+		log.Printf("✏️ Message %s edited", a.ID)
+
+	case *actions.DeleteMessage:
+		if msg, ok := Messages[a.ID]; ok {
+			msg.Text = ""
+			msg.Deleted = true
+			msg.Reactions = nil
+			msg.Attachments = nil
+			msg.Mentions, msg.Links = nil, nil
+			msg.Previews = nil
+			Messages[a.ID] = msg
+		}
+		log.Printf("🗑️ Message %s deleted", a.ID)
+
+	case *actions.ReactMessage:
+		if msg, ok := Messages[a.ID]; ok && !msg.Deleted {
+			msg.Reactions, _ = ws.React(msg.Reactions, a.Emoji, a.From, a.Add)
+			Messages[a.ID] = msg
+		}
+		log.Printf("😀 %s reacted %s to message %s (add: %v)", a.From, a.Emoji, a.ID, a.Add)
+
+	case *actions.UnfurlMessage:
+		if msg, ok := Messages[a.Unfurl.ID]; ok && !msg.Deleted {
+			msg.Previews = ws.MergePreviews(msg.Links, msg.Previews, a.Unfurl.Previews)
+			Messages[a.Unfurl.ID] = msg
+		}
+		log.Printf("🔗 Message %s has %d link previews", a.Unfurl.ID, len(a.Unfurl.Previews))
+
+	case *actions.OpenThread:
+		ActiveThread = a.ParentID
+		log.Printf("🧵 Thread opened: %s", ActiveThread)
+
+	case *actions.SetReplies:
+		// Messages received over the WebSocket are at least as recent
+		if _, exists := Messages[a.Parent.ID]; !exists {
+			storeMessage(a.Parent)
+		}
+		for _, m := range a.Replies {
+			if _, exists := Messages[m.ID]; !exists {
+				storeMessage(m)
+			}
+		}
+		ThreadHasMore[a.Parent.ID] = a.HasMore
+		log.Printf("🧵 Loaded %d replies to %s | more: %v", len(a.Replies), a.Parent.ID, a.HasMore)
+
+	case *actions.UpdateThread:
+		if msg, ok := Messages[a.Update.ParentID]; ok {
+			msg.ReplyCount = a.Update.ReplyCount
+			msg.LastReplyAt = a.Update.LastReplyAt
+			Messages[a.Update.ParentID] = msg
+		}
+		log.Printf("🧵 Thread %s has %d replies", a.Update.ParentID, a.Update.ReplyCount)
+
+	case *actions.SetReadState:
+		ReadSeq = a.State.ReadSeq
+		Unread = a.State.Unread
+		ReadCursors = make(map[string]uint64, len(a.State.Readers))
+		for _, r := range a.State.Readers {
+			ReadCursors[r.Username] = r.Seq
+		}
+		log.Printf("👀 Read state | read: %d | unread: %d | readers: %d", ReadSeq, Unread, len(ReadCursors))
+
+	case *actions.MarkRead:
+		ReadCursors[a.Marker.Username] = max(ReadCursors[a.Marker.Username], a.Marker.Seq)
+		if a.Marker.Username == Username && a.Marker.Seq > ReadSeq {
+			ReadSeq = a.Marker.Seq
+			Unread = unreadAfter(ReadSeq)
+		}
+		log.Printf("👀 %s read up to %d", a.Marker.Username, a.Marker.Seq)
+
+	case *actions.SyncMessages:
+		if a.Sync.Reset {
+			Messages = make(map[string]ws.TextMessage)
+			MessageOrder = nil
+			ThreadOrder = make(map[string][]string)
+			ThreadHasMore = make(map[string]bool)
+		}
+		for _, m := range a.Sync.Messages {
+			addMessage(m)
+		}
+		// The room sends who is typing after joining
+		TypingUsers = make(map[string]bool)
+		Room = a.Sync.Room
+		RoomEpoch = a.Sync.Epoch
+		LastSeq = a.Sync.Seq
+		log.Printf("🔁 Synced %d messages | epoch: %s | seq: %d | reset: %v", len(a.Sync.Messages), RoomEpoch, LastSeq, a.Sync.Reset)
+
+	case *actions.AddDirectMessage:
+		with := a.Message.To
+		if with == Username {
+			with = a.Message.From
+		}
+		DirectMessages[with] = append(DirectMessages[with], a.Message)
+		touchConversation(with)
+		log.Printf("✉️ Direct message from %s to %s", a.Message.From, a.Message.To)
+
+	case *actions.SetConversations:
+		Conversations = Conversations[:0]
+		for _, c := range a.Conversations {
+			Conversations = append(Conversations, c.With)
+		}
+		log.Printf("✉️ Loaded %d conversations", len(Conversations))
+
+	case *actions.SetDirectMessages:
+		// Keep messages that arrived while the history was loading
+		messages := a.Messages
+		var latest int64
+		if len(messages) > 0 {
+			latest = messages[len(messages)-1].Timestamp
+		}
+		for _, m := range DirectMessages[a.With] {
+			if m.Timestamp > latest {
+				messages = append(messages, m)
+			}
+		}
+		DirectMessages[a.With] = messages
+		log.Printf("✉️ Loaded %d direct messages with %s", len(messages), a.With)
+
+	case *actions.OpenConversation:
+		ActiveConversation = a.With
+		if a.With != "" {
+			touchConversation(a.With)
+		}
+		log.Printf("✉️ Active conversation: %q", ActiveConversation)
+
+	case *actions.SetPresenceSnapshot:
+		Members = make(map[string]ws.Presence, len(a.Members))
+		for _, m := range a.Members {
+			Members[m.Username] = m
+		}
+		log.Printf("🟢 Presence snapshot with %d members", len(Members))
+
+	case *actions.UpdatePresence:
+		Members[a.Presence.Username] = a.Presence
+		log.Printf("🟢 %s is %s", a.Presence.Username, a.Presence.Status)
+
+	case *actions.SetTopic:
+		Topic = a.Topic
+		log.Printf("📌 Topic: %q", Topic.Topic)
+
+	case *actions.AddNotice:
+		Notices = append(Notices, a.Notice)
+		if len(Notices) > maxNotices {
+			Notices = Notices[len(Notices)-maxNotices:]
+		}
+		log.Printf("ℹ️ %s", a.Notice.Text)
+
+	case *actions.SetCommands:
+		Commands = a.Commands
+
+	case *actions.Moderate:
+		moderate(a.Type, a.Moderation)
+
+	case *actions.SetBans:
+		Bans = a.Bans
+		log.Printf("🔨 Loaded %d bans", len(Bans))
+
+	case *actions.SetClosed:
+		Closed = a.Close
+		if Closed != nil {
+			log.Printf("🚪 Connection closed by the server: %s", Closed.Reason)
+		}
 
 	case *actions.SetTyping:
 		if a.IsTyping {
@@ -92,6 +433,34 @@ func onAction(action interface{}) {
 			log.Printf("⌨️  %s stopped typing", a.Username)
 		}
 
+	case *actions.SetReloadRequired:
+		ReloadRequired = true
+		LatestBuild = a.CurrentBuild
+		log.Printf("🆕 New frontend build available: %s", LatestBuild)
+
+	case *actions.SetError:
+		Error = a.Message
+		if Error != "" {
+			log.Printf("⚠️ Server error: %s", Error)
+		}
+
+	case *actions.ToggleSearch:
+		SearchOpen = !SearchOpen
+		log.Printf("🔍 Search panel open: %v", SearchOpen)
+
+	case *actions.SetSearchResults:
+		if a.Append {
+			SearchResults = append(SearchResults, a.Response.Results...)
+		} else {
+			SearchResults = a.Response.Results
+		}
+		SearchRequest = a.Request
+		SearchTotal = a.Response.Total
+		log.Printf("🔍 %d of %d results for %q", len(SearchResults), SearchTotal, SearchRequest.Query)
+
+	case *actions.HighlightMessage:
+		HighlightID = a.ID
+
 	case *actions.ToggleDarkMode:
 		IsDarkMode = !IsDarkMode
 		// Save dark mode preference to localStorage
@@ -105,3 +474,143 @@ func onAction(action interface{}) {
 
 	Listeners.Fire()
 }
+
+// touchConversation moves the conversation with a user to the front
+func touchConversation(with string) {
+	conversations := []string{with}
+	for _, c := range Conversations {
+		if c != with {
+			conversations = append(conversations, c)
+		}
+	}
+	Conversations = conversations
+}
+
+// addMessage adds a message received over the WebSocket, or replaces the
+// message with the same ID
+func addMessage(msg ws.TextMessage) {
+	if storeMessage(msg) && isUnread(msg, ReadSeq) {
+		Unread++
+	}
+	LastSeq = max(LastSeq, msg.Seq)
+	if msg.Nonce != "" && msg.From == Username {
+		removePending(msg.Nonce)
+	}
+}
+
+// removePending forgets a pending message once the room delivered it
+func removePending(nonce string) {
+	if _, ok := Pending[nonce]; !ok {
+		return
+	}
+	delete(Pending, nonce)
+	for i, n := range PendingOrder {
+		if n == nonce {
+			PendingOrder = append(PendingOrder[:i], PendingOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// storeMessage adds a message to Messages and to MessageOrder or its
+// thread, or replaces the message with the same ID, and reports whether
+// the message is new
+func storeMessage(msg ws.TextMessage) bool {
+	_, exists := Messages[msg.ID]
+	if !exists {
+		if msg.ParentID == "" {
+			MessageOrder = insertMessageID(MessageOrder, msg)
+		} else {
+			ThreadOrder[msg.ParentID] = insertMessageID(ThreadOrder[msg.ParentID], msg)
+		}
+	}
+	Messages[msg.ID] = msg
+	return !exists
+}
+
+// isUnread reports whether msg counts towards Unread for a user who read
+// up to seq, like the server's count: replies and own messages do not
+func isUnread(msg ws.TextMessage, seq uint64) bool {
+	return msg.Seq > seq && msg.ParentID == "" && !msg.Deleted && msg.From != Username
+}
+
+// unreadAfter counts the loaded messages that are unread for a user who
+// read up to seq
+func unreadAfter(seq uint64) int {
+	count := 0
+	for i := len(MessageOrder) - 1; i >= 0; i-- {
+		msg := Messages[MessageOrder[i]]
+		if msg.Seq <= seq {
+			break
+		}
+		if isUnread(msg, seq) {
+			count++
+		}
+	}
+	return count
+}
+
+// insertMessageID adds the ID of a new message to order, keeping it
+// ordered by Seq
+func insertMessageID(order []string, msg ws.TextMessage) []string {
+	i := sort.Search(len(order), func(i int) bool {
+		return Messages[order[i]].Seq > msg.Seq
+	})
+	order = append(order, "")
+	copy(order[i+1:], order[i:])
+	order[i] = msg.ID
+	return order
+}
+
+// moderate updates the room's members and bans with a moderation action.
+// Kicked and banned users keep their presence until they leave.
+func moderate(typ ws.MessageType, mod ws.ModerationMessage) {
+	log.Printf("🔨 %s %s %s", mod.By, typ, mod.Username)
+	member, known := Members[mod.Username]
+	switch typ {
+	case ws.TypeRole:
+		member.Role = mod.Role
+		if member.Role == api.RoleMember {
+			member.Role = ""
+		}
+	case ws.TypeMute:
+		member.MutedUntil = mod.Until
+	case ws.TypeUnmute:
+		member.MutedUntil = 0
+	case ws.TypeBan:
+		Bans = slices.DeleteFunc(Bans, func(ban api.Ban) bool { return ban.Username == mod.Username })
+		Bans = append([]api.Ban{{
+			Room:     mod.Room,
+			Username: mod.Username,
+			By:       mod.By,
+			Reason:   mod.Reason,
+			At:       mod.At,
+			Until:    mod.Until,
+		}}, Bans...)
+	case ws.TypeUnban:
+		Bans = slices.DeleteFunc(Bans, func(ban api.Ban) bool { return ban.Username == mod.Username })
+	}
+	if known {
+		Members[mod.Username] = member
+	}
+}
+
+// sessionKey is where the session is kept in localStorage
+const sessionKey = "session"
+
+type savedSession struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// saveSession keeps the current session in localStorage, or forgets it
+// once signed out
+func saveSession() {
+	localStorage := js.Global().Get("localStorage")
+	if Session == "" {
+		localStorage.Call("removeItem", sessionKey)
+		return
+	}
+	data, _ := json.Marshal(savedSession{Username: Username, Token: Session})
+	localStorage.Call("setItem", sessionKey, string(data))
+}
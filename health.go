@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/actors"
+	"go-chat/shared/api"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+)
+
+// readinessTimeout bounds how long a single component may take to answer
+const readinessTimeout = 2 * time.Second
+
+// healthChecker reports liveness of the process and readiness of the
+// components a request depends on
+type healthChecker struct {
+	engine  *actor.Engine
+	rooms   actors.Rooms
+	started time.Time
+
+	// checks are additional readiness checks keyed by component name
+	checks map[string]func(context.Context) error
+}
+
+func newHealthChecker(engine *actor.Engine, rooms actors.Rooms) *healthChecker {
+	return &healthChecker{
+		engine:  engine,
+		rooms:   rooms,
+		started: time.Now(),
+		checks:  make(map[string]func(context.Context) error),
+	}
+}
+
+// AddCheck registers an extra readiness check, such as a message store ping
+func (h *healthChecker) AddCheck(name string, check func(context.Context) error) {
+	h.checks[name] = check
+}
+
+// live reports that the process is up without touching any component
+func (h *healthChecker) live() api.HealthResponse {
+	return api.HealthResponse{
+		Status:    api.HealthStatusOK,
+		Timestamp: time.Now().Unix(),
+		Uptime:    int64(time.Since(h.started).Seconds()),
+		Version:   currentVersion(),
+	}
+}
+
+// ready round-trips a request to the room actor and runs every registered
+// check concurrently, each bounded by readinessTimeout
+func (h *healthChecker) ready() api.HealthResponse {
+	response := h.live()
+	response.Components = make(map[string]api.ComponentHealth)
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	record := func(name string, start time.Time, err error) {
+		component := api.ComponentHealth{
+			Status:  api.HealthStatusOK,
+			Latency: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			component.Status = api.HealthStatusUnavailable
+			component.Error = err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		response.Components[name] = component
+		if err != nil {
+			response.Status = api.HealthStatusUnavailable
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		status, err := h.checkRoom()
+		if err == nil {
+			mu.Lock()
+			response.Clients = int(status.Clients)
+			mu.Unlock()
+		}
+		record(string(actors.TypeRoom), start, err)
+	}()
+
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+			defer cancel()
+			start := time.Now()
+			record(name, start, check(ctx))
+		}(name, check)
+	}
+
+	wg.Wait()
+	return response
+}
+
+func (h *healthChecker) checkRoom() (*actors.RoomStatus, error) {
+	roomPID, _, err := h.rooms.Lookup(actors.DefaultRoom)
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.engine.Request(roomPID, &actors.HealthCheck{}, readinessTimeout).Result()
+	if err != nil {
+		return nil, fmt.Errorf("room actor did not respond: %w", err)
+	}
+	status, ok := res.(*actors.RoomStatus)
+	if !ok {
+		return nil, fmt.Errorf("unexpected room actor response %T", res)
+	}
+	return status, nil
+}
+
+func setupHealthLive(health *healthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteHealthLive.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeHealth(w, health.live())
+	}
+}
+
+func setupHealthReady(health *healthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteHealthReady.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeHealth(w, health.ready())
+	}
+}
+
+func writeHealth(w http.ResponseWriter, response api.HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status != api.HealthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
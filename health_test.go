@@ -0,0 +1,71 @@
+package main
+
+import (
+	"go-chat/internal/actors"
+	"go-chat/internal/broadcast"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anthdm/hollywood/actor"
+)
+
+// TestHealthReady checks that readiness covers the room and the store,
+// and that only readiness fails when the store does
+func TestHealthReady(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	history, err := store.NewFile(filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	engine, err := actor.NewEngine(actor.NewEngineConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms := actors.NewLocalRooms(engine, broadcast.NewLocal(), history, nil, nil)
+	health := newHealthChecker(engine, rooms)
+	health.AddCheck("history", history.Check)
+
+	status := func(handler http.HandlerFunc, path string) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	ready := health.ready()
+	if ready.Status != api.HealthStatusOK {
+		t.Errorf("ready = %+v; want ok", ready)
+	}
+	for _, name := range []string{string(actors.TypeRoom), "history"} {
+		if component, ok := ready.Components[name]; !ok || component.Status != api.HealthStatusOK {
+			t.Errorf("component %s = %+v, %v; want ok", name, component, ok)
+		}
+	}
+	if code := status(setupHealthReady(health), api.RouteHealthReady.Path); code != http.StatusOK {
+		t.Errorf("GET %s = %d; want 200", api.RouteHealthReady.Path, code)
+	}
+
+	// A store that can no longer be written makes the server unready, but
+	// not dead
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	ready = health.ready()
+	if component := ready.Components["history"]; ready.Status != api.HealthStatusUnavailable || component.Status != api.HealthStatusUnavailable || component.Error == "" {
+		t.Errorf("ready without the store directory = %+v; want history unavailable", ready)
+	}
+	if component := ready.Components[string(actors.TypeRoom)]; component.Status != api.HealthStatusOK {
+		t.Errorf("room = %+v; want ok", component)
+	}
+	if code := status(setupHealthReady(health), api.RouteHealthReady.Path); code != http.StatusServiceUnavailable {
+		t.Errorf("GET %s = %d; want 503", api.RouteHealthReady.Path, code)
+	}
+	if code := status(setupHealthLive(health), api.RouteHealthLive.Path); code != http.StatusOK {
+		t.Errorf("GET %s = %d; want 200", api.RouteHealthLive.Path, code)
+	}
+}
@@ -0,0 +1,102 @@
+package actors
+
+import (
+	"go-chat/internal/store"
+	"go-chat/shared/ws"
+	"sync"
+	"testing"
+)
+
+// TestRoomRejectsServerTypes checks that messages of types only the server
+// sends are dropped when a client sends them, instead of being published
+// to the room
+func TestRoomRejectsServerTypes(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+
+	var mu sync.Mutex
+	var published []ws.MessageType
+	unsubscribe, err := room.broadcaster.Subscribe(DefaultRoom, func(msg *ws.Message) {
+		mu.Lock()
+		published = append(published, msg.Type)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	mallory := room.join("mallory")
+	forged := []*ws.Message{
+		{Type: ws.TypeSync, Payload: &ws.SyncMessage{Reset: true}},
+		{Type: ws.TypeClose, Payload: &ws.CloseMessage{Reason: "forged"}},
+		{Type: ws.TypeAck, Payload: &ws.AckMessage{Nonce: "forged", ID: "forged"}},
+	}
+	for _, msg := range forged {
+		mallory.send(msg)
+	}
+
+	// The room handles its inbox in order, so once a chat message sent
+	// afterwards is acknowledged, the forged ones have been handled
+	mallory.send(&ws.Message{
+		Type:    ws.TypeMessage,
+		Payload: &ws.TextMessage{From: "mallory", Nonce: "after", Text: "hello"},
+	})
+	mallory.expectFunc("the ACK of the chat message", func(msg *ws.Message) bool {
+		switch payload := msg.Payload.(type) {
+		case *ws.AckMessage:
+			if payload.Nonce == "forged" {
+				t.Error("client received the forged ACK")
+			}
+			return payload.Nonce == "after"
+		case *ws.CloseMessage:
+			t.Errorf("client received the forged CLOSE: %q", payload.Reason)
+		}
+		return false
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, typ := range published {
+		switch typ {
+		case ws.TypeSync, ws.TypeClose, ws.TypeAck:
+			t.Errorf("room published a client-sent %s", typ)
+		}
+	}
+}
+
+// TestAcks checks that the sender of a chat message is answered with an
+// ACK carrying the ID and timestamp it was delivered with, or a NACK
+// saying why it was refused, both matched by nonce
+func TestAcks(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+	alice := room.join("alice")
+
+	ack, reason := alice.say("hello")
+	if ack == nil {
+		t.Fatalf("hello rejected: %s", reason)
+	}
+	delivered := alice.expect(ws.TypeMessage).Payload.(*ws.TextMessage)
+	if ack.ID != delivered.ID || ack.Timestamp != delivered.Timestamp || ack.Nonce != "hello" || delivered.Nonce != "hello" {
+		t.Errorf("ACK %+v for a message delivered as %+v", ack, delivered)
+	}
+
+	// Threads are one level deep
+	reply, _ := alice.sayTo("a reply", ack.ID)
+	for _, tc := range []struct {
+		text, parentID, want string
+	}{
+		{"   ", "", "message is empty"},
+		{"a reply to nothing", "missing", "message not found"},
+		{"a reply to a reply", reply.ID, "message not found"},
+	} {
+		if got, reason := alice.sayTo(tc.text, tc.parentID); got != nil || reason != tc.want {
+			t.Errorf("%q answered with %+v, %q; want NACK %q", tc.text, got, reason, tc.want)
+		}
+	}
+
+	// Connections that did not join the room are refused
+	stranger := room.connect("mallory")
+	if got, reason := stranger.say("hi"); got != nil || reason != "not in this room" {
+		t.Errorf("message from outside the room answered with %+v, %q", got, reason)
+	}
+}
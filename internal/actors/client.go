@@ -2,6 +2,7 @@ package actors
 
 import (
 	"go-chat/shared/ws"
+	"time"
 
 	"github.com/anthdm/hollywood/actor"
 	"github.com/charmbracelet/log"
@@ -23,23 +24,49 @@ func NewClient(conn *websocket.Conn) actor.Producer {
 // Receive implements actor.Receiver
 func (c *ClientActor) Receive(ctx *actor.Context) {
 	switch msg := ctx.Message().(type) {
-	case *actor.Started:
+	case actor.Started:
 		log.Info("ClientActor started")
-	case *actor.Stopped:
+	case actor.Stopped:
 		log.Info("ClientActor stopped")
 	case *ws.Message:
-		switch msg.Type {
-		case ws.TypeMessage, ws.TypeTyping:
-			err := c.conn.WriteJSON(msg)
-			if err != nil {
-				log.Error("failed to write message", "error", err)
-			}
-		case ws.TypePing:
-			pongMsg := &ws.Message{Type: ws.TypePong}
-			err := c.conn.WriteJSON(pongMsg)
-			if err != nil {
-				log.Error("failed to send pong", "error", err)
-			}
+		c.handleMessage(msg)
+	case *WSFrame:
+		wsMsg, err := msg.Message()
+		if err != nil {
+			log.Error("failed to decode remote message", "error", err)
+			return
+		}
+		c.handleMessage(wsMsg)
+	}
+}
+
+func (c *ClientActor) handleMessage(msg *ws.Message) {
+	switch msg.Type {
+	case ws.TypeMessage, ws.TypeSync, ws.TypeEdit, ws.TypeDelete, ws.TypeReact, ws.TypeUnreact, ws.TypeThread, ws.TypeRead, ws.TypeReadState, ws.TypeMention, ws.TypeUnfurl,
+		ws.TypeSystem, ws.TypeTopic, ws.TypeNick,
+		ws.TypeTyping, ws.TypeDirect, ws.TypeJoin, ws.TypeLeave,
+		ws.TypeKick, ws.TypeBan, ws.TypeUnban, ws.TypeMute, ws.TypeUnmute, ws.TypeRole,
+		ws.TypePresenceSnapshot, ws.TypePresenceUpdate, ws.TypeAck, ws.TypeNack,
+		ws.TypeReloadRequired, ws.TypeError:
+		err := c.conn.WriteJSON(msg)
+		if err != nil {
+			log.Error("failed to write message", "error", err)
+		}
+	case ws.TypeClose:
+		// The read loop of the connection ends once it is closed, and
+		// takes the client out of its room
+		if err := c.conn.WriteJSON(msg); err != nil {
+			log.Error("failed to write message", "error", err)
+		}
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
+		c.conn.Close()
+	case ws.TypePing:
+		pongMsg := &ws.Message{Type: ws.TypePong}
+		err := c.conn.WriteJSON(pongMsg)
+		if err != nil {
+			log.Error("failed to send pong", "error", err)
 		}
 	}
 }
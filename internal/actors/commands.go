@@ -0,0 +1,384 @@
+package actors
+
+import (
+	"errors"
+	"fmt"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	"go-chat/shared/markdown"
+	"go-chat/shared/ws"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/charmbracelet/log"
+)
+
+// Limits on the arguments of slash commands
+const (
+	maxTopic    = 300 // Runes
+	maxUsername = 32  // Runes, for /nick
+)
+
+// command is a slash command rooms run from chat messages. run returns
+// the error to answer the sender with.
+type command struct {
+	api.Command
+	run func(r *RoomActor, c *commandContext) error
+}
+
+// commandContext is a slash command being run on behalf of its sender
+type commandContext struct {
+	ctx      *actor.Context
+	cmd      *command
+	sender   *actor.PID // Nil for messages not read from a connection
+	msg      *ws.Message
+	text     *ws.TextMessage // The chat message the command was typed in
+	username string
+	role     string
+	args     string // Everything after the command's name, trimmed
+	posted   bool   // The command posted a chat message, which the room ACKs
+}
+
+// usage returns the error for a command run with the wrong arguments
+func (c *commandContext) usage() error {
+	return errors.New(strings.TrimSpace("usage: /" + c.cmd.Name + " " + c.cmd.Usage))
+}
+
+// commandRegistry holds the slash commands rooms run, by name
+type commandRegistry struct {
+	commands map[string]*command
+}
+
+func newCommandRegistry() *commandRegistry {
+	return &commandRegistry{commands: make(map[string]*command)}
+}
+
+// register adds a command. Names are unique.
+func (reg *commandRegistry) register(cmd *command) {
+	if _, ok := reg.commands[cmd.Name]; ok {
+		panic("command registered twice: /" + cmd.Name)
+	}
+	reg.commands[cmd.Name] = cmd
+}
+
+func (reg *commandRegistry) lookup(name string) (*command, bool) {
+	cmd, ok := reg.commands[name]
+	return cmd, ok
+}
+
+// list describes the registered commands, ordered by name
+func (reg *commandRegistry) list() []api.Command {
+	list := make([]api.Command, 0, len(reg.commands))
+	for _, cmd := range reg.commands {
+		list = append(list, cmd.Command)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// commands are the slash commands every room runs
+var commands = newCommandRegistry()
+
+func init() {
+	for _, cmd := range []*command{
+		{api.Command{Name: "help", Description: "List the commands you can use"}, cmdHelp},
+		{api.Command{Name: "me", Usage: "<action>", Description: "Say what you are doing, as in /me waves"}, cmdMe},
+		{api.Command{Name: "join", Usage: "<#room>", Description: "Join a room; this server runs #" + DefaultRoom + " only"}, cmdJoin},
+		{api.Command{Name: "nick", Usage: "<username>", Description: "Change your username; your role stays with the old one"}, cmdNick},
+		{api.Command{Name: "topic", Usage: "[topic | -]", Description: "Show the room's topic; moderators can set it, or clear it with -"}, cmdTopic},
+		{api.Command{Name: "kick", Usage: "<username> [reason]", Description: "Remove a user from the room", Role: api.RoleModerator}, moderationCommand(ws.TypeKick)},
+		{api.Command{Name: "ban", Usage: "<username> [duration] [reason]", Description: "Keep a user out of the room, for a duration like 12h or 7d or for good", Role: api.RoleModerator}, moderationCommand(ws.TypeBan)},
+		{api.Command{Name: "unban", Usage: "<username>", Description: "Let a banned user back in", Role: api.RoleModerator}, moderationCommand(ws.TypeUnban)},
+		{api.Command{Name: "mute", Usage: "<username> <duration> [reason]", Description: "Keep a user from posting for a duration like 10m", Role: api.RoleModerator}, moderationCommand(ws.TypeMute)},
+		{api.Command{Name: "unmute", Usage: "<username>", Description: "Let a muted user post again", Role: api.RoleModerator}, moderationCommand(ws.TypeUnmute)},
+		{api.Command{Name: "mod", Usage: "<username>", Description: "Make a user a moderator", Role: api.RoleOwner}, roleCommand(api.RoleModerator)},
+		{api.Command{Name: "unmod", Usage: "<username>", Description: "Make a moderator a member again", Role: api.RoleOwner}, roleCommand(api.RoleMember)},
+	} {
+		commands.register(cmd)
+	}
+}
+
+// Commands describes the slash commands rooms run, ordered by name
+func Commands() []api.Command {
+	return commands.list()
+}
+
+// parseCommand splits a chat message typed as /name arguments. Names are
+// made of letters only, so text starting with a path such as /usr/bin is
+// not a command.
+func parseCommand(text string) (name, args string, ok bool) {
+	rest, ok := strings.CutPrefix(text, "/")
+	if !ok {
+		return "", "", false
+	}
+	name, args = cutArg(rest)
+	if name == "" || strings.ContainsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		return "", "", false
+	}
+	return strings.ToLower(name), args, true
+}
+
+// cutArg splits the first whitespace separated argument off s
+func cutArg(s string) (arg, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexFunc(s, unicode.IsSpace); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+// runCommand runs the slash command typed in a chat message and answers
+// the sender with an ACK naming it, or a NACK saying why it failed
+func (r *RoomActor) runCommand(ctx *actor.Context, sender *actor.PID, msg *ws.Message, text *ws.TextMessage, name, args string) {
+	// Sending anything stops the user typing, as for chat messages
+	r.stopTyping(text.From)
+
+	cmd, ok := commands.lookup(name)
+	if !ok {
+		r.nack(ctx, sender, text.Nonce, "unknown command /"+name+"; try /help")
+		return
+	}
+	role, err := r.store.Role(r.name, text.From)
+	if err != nil {
+		log.Error("failed to look up role", "room", r.name, "username", text.From, "error", err)
+		r.nack(ctx, sender, text.Nonce, "command could not be run")
+		return
+	}
+	if cmd.Role != "" && api.RoleRank(role) < api.RoleRank(cmd.Role) {
+		r.nack(ctx, sender, text.Nonce, "only "+cmd.Role+"s can use /"+name)
+		return
+	}
+
+	c := &commandContext{
+		ctx:      ctx,
+		cmd:      cmd,
+		sender:   sender,
+		msg:      msg,
+		text:     text,
+		username: text.From,
+		role:     role,
+		args:     args,
+	}
+	if err := cmd.run(r, c); err != nil {
+		r.nack(ctx, sender, text.Nonce, err.Error())
+		return
+	}
+	if !c.posted && sender != nil {
+		SendWS(ctx.Engine(), sender, &ws.Message{
+			Type: ws.TypeAck,
+			Payload: &ws.AckMessage{
+				Nonce:     text.Nonce,
+				Timestamp: time.Now().UnixMilli(),
+				Command:   name,
+			},
+		})
+	}
+}
+
+// reply sends the sender of a command a SYSTEM notice
+func (r *RoomActor) reply(c *commandContext, text string) {
+	if c.sender == nil {
+		return
+	}
+	SendWS(c.ctx.Engine(), c.sender, &ws.Message{
+		Type: ws.TypeSystem,
+		Payload: &ws.SystemMessage{
+			Room:      r.name,
+			Text:      text,
+			Timestamp: time.Now().UnixMilli(),
+		},
+	})
+}
+
+func cmdHelp(r *RoomActor, c *commandContext) error {
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, cmd := range commands.list() {
+		if cmd.Role != "" && api.RoleRank(c.role) < api.RoleRank(cmd.Role) {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s: %s", strings.TrimSpace("/"+cmd.Name+" "+cmd.Usage), cmd.Description)
+	}
+	b.WriteString("\nStart a message with // to send it as it is.")
+	r.reply(c, b.String())
+	return nil
+}
+
+func cmdMe(r *RoomActor, c *commandContext) error {
+	if c.args == "" {
+		return c.usage()
+	}
+	c.text.Text = c.args
+	c.text.Action = true
+	c.posted = true
+	r.acceptChat(c.ctx, c.sender, c.msg, c.text)
+	return nil
+}
+
+// cmdJoin answers /join. Only the default room can be joined, so it has
+// nowhere to take anyone yet.
+func cmdJoin(r *RoomActor, c *commandContext) error {
+	room, rest := cutArg(c.args)
+	room = strings.TrimPrefix(room, "#")
+	if room == "" || rest != "" {
+		return c.usage()
+	}
+	if room != r.name {
+		return errors.New("there is no room #" + room + "; this server runs #" + DefaultRoom + " only")
+	}
+	r.reply(c, "You are in #"+r.name+" already")
+	return nil
+}
+
+func cmdNick(r *RoomActor, c *commandContext) error {
+	to, rest := cutArg(c.args)
+	to = strings.TrimPrefix(to, "@")
+	if to == "" || rest != "" {
+		return c.usage()
+	}
+	if !markdown.Mentionable(to) || utf8.RuneCountInString(to) > maxUsername {
+		return fmt.Errorf("%q is not a valid username", to)
+	}
+	if to == c.username {
+		return errors.New("you are already " + to)
+	}
+	if r.mutedUntil(c.username, time.Now()) != 0 {
+		return errors.New("you cannot change your name while muted")
+	}
+	// Accounts and bots sign in with the token of their name, and guests
+	// keep theirs while signed in, so nobody else takes either. Roles stay
+	// with a name, so names holding one are not free either.
+	now := time.Now()
+	fromAccount, err := r.hasAccount(c.username)
+	var toAccount bool
+	toRole := api.RoleMember
+	if err == nil {
+		toAccount, err = r.hasAccount(to)
+	}
+	if err == nil {
+		toRole, err = r.store.Role(r.name, to)
+	}
+	if err != nil {
+		log.Error("failed to look up username", "username", to, "error", err)
+		return errors.New("your name could not be changed")
+	}
+	if fromAccount {
+		return errors.New("accounts cannot change their name")
+	}
+	if toAccount || toRole != api.RoleMember || r.presence.member(to) {
+		return errors.New(to + " is taken")
+	}
+	if _, banned := r.banned(to, now); banned {
+		return errors.New(to + " is banned")
+	}
+	// The sessions of the guest take the name before anyone is told, so no
+	// one signs in under it in between. Clients reconnect with them.
+	err = r.store.RenameSessions(c.username, to, now.UnixMilli())
+	if errors.Is(err, store.ErrTaken) {
+		return errors.New(to + " is taken")
+	} else if err != nil {
+		log.Error("failed to rename sessions", "from", c.username, "to", to, "error", err)
+		return errors.New("your name could not be changed")
+	}
+
+	r.publish(&ws.Message{
+		Type:    ws.TypeNick,
+		Payload: &ws.NickMessage{Room: r.name, From: c.username, To: to},
+	})
+	return nil
+}
+
+func cmdTopic(r *RoomActor, c *commandContext) error {
+	if c.args == "" {
+		if r.topic.Topic == "" {
+			r.reply(c, "#"+r.name+" has no topic")
+		} else {
+			r.reply(c, "The topic of #"+r.name+" is: "+r.topic.Topic+" (set by "+r.topic.By+")")
+		}
+		return nil
+	}
+	if api.RoleRank(c.role) < api.RoleRank(api.RoleModerator) {
+		return errors.New("only moderators can change the topic")
+	}
+
+	topic := strings.Join(strings.Fields(c.args), " ")
+	if topic == "-" {
+		topic = ""
+	}
+	if runes := []rune(topic); len(runes) > maxTopic {
+		topic = string(runes[:maxTopic])
+	}
+	r.publish(&ws.Message{
+		Type: ws.TypeTopic,
+		Payload: &ws.TopicMessage{
+			Room:  r.name,
+			Topic: topic,
+			By:    c.username,
+			At:    time.Now().UnixMilli(),
+		},
+	})
+	return nil
+}
+
+// moderationCommand returns the handler of a command taking a moderation
+// action against the user named by its first argument, with the same
+// checks as the action sent as a WebSocket message
+func moderationCommand(typ ws.MessageType) func(r *RoomActor, c *commandContext) error {
+	return func(r *RoomActor, c *commandContext) error {
+		username, rest := cutArg(c.args)
+		username = strings.TrimPrefix(username, "@")
+		if username == "" {
+			return c.usage()
+		}
+
+		mod := &ws.ModerationMessage{Username: username}
+		switch typ {
+		case ws.TypeBan, ws.TypeMute:
+			// The duration is optional for bans, so a reason may come first
+			arg, after := cutArg(rest)
+			if duration, err := api.ParseDuration(arg); err == nil && duration > 0 {
+				mod.Duration = duration.Milliseconds()
+				rest = after
+			} else if typ == ws.TypeMute {
+				return c.usage()
+			}
+			mod.Reason = rest
+		case ws.TypeKick:
+			mod.Reason = rest
+		default:
+			if rest != "" {
+				return c.usage()
+			}
+		}
+		return r.moderateAs(c.username, typ, mod)
+	}
+}
+
+// roleCommand returns the handler of a command giving the user named by
+// its argument a role
+func roleCommand(role string) func(r *RoomActor, c *commandContext) error {
+	return func(r *RoomActor, c *commandContext) error {
+		username, rest := cutArg(c.args)
+		username = strings.TrimPrefix(username, "@")
+		if username == "" || rest != "" {
+			return c.usage()
+		}
+		return r.moderateAs(c.username, ws.TypeRole, &ws.ModerationMessage{Username: username, Role: role})
+	}
+}
+
+// moderateAs publishes a moderation action taken by username, if they may
+// take it
+func (r *RoomActor) moderateAs(username string, typ ws.MessageType, mod *ws.ModerationMessage) error {
+	if err := r.authorizeModeration(username, typ, mod); err != nil {
+		return err
+	}
+	r.publish(&ws.Message{Type: typ, Payload: mod})
+	return nil
+}
@@ -0,0 +1,82 @@
+package actors
+
+import (
+	"errors"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"testing"
+)
+
+// TestNickRejectsReservedNames checks that /nick does not hand out the
+// names of role holders and bots, which others act under
+func TestNickRejectsReservedNames(t *testing.T) {
+	history := store.NewMemory()
+	if err := history.SetRole(DefaultRoom, "bob", api.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.SaveBot(api.Bot{Name: "deploy"}, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	mallory := newTestRoom(t, history).join("mallory")
+
+	for command, want := range map[string]string{
+		"/nick bob":    "bob is taken",
+		"/nick deploy": "deploy is taken",
+	} {
+		if _, got := mallory.say(command); got != want {
+			t.Errorf("%s rejected with %q; want %q", command, got, want)
+		}
+	}
+	if _, got := mallory.say("/nick carol"); got != "" {
+		t.Errorf("/nick carol rejected with %q", got)
+	}
+}
+
+// TestNickMovesSessions checks that /nick gives the guest's sessions the
+// new name before announcing it, and refuses names others signed in with
+func TestNickMovesSessions(t *testing.T) {
+	history := store.NewMemory()
+	for hash, username := range map[string]string{"mallory": "mallory", "dave": "dave"} {
+		if err := history.ClaimSession(hash, username, 1<<62, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mallory := newTestRoom(t, history).join("mallory")
+
+	if _, got := mallory.say("/nick dave"); got != "dave is taken" {
+		t.Errorf("/nick dave rejected with %q; want dave is taken", got)
+	}
+	if _, got := mallory.say("/nick carol"); got != "" {
+		t.Fatalf("/nick carol rejected with %q", got)
+	}
+	nick := mallory.expect(ws.TypeNick).Payload.(*ws.NickMessage)
+	if nick.From != "mallory" || nick.To != "carol" {
+		t.Errorf("NICK = %+v; want mallory to carol", nick)
+	}
+	if username, err := history.Session("mallory", 0); err != nil || username != "carol" {
+		t.Errorf("session after /nick = %q, %v; want carol", username, err)
+	}
+	if err := history.ClaimSession("other", "carol", 1<<62, 0); !errors.Is(err, store.ErrTaken) {
+		t.Errorf("claiming the new name = %v; want ErrTaken", err)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	alice := newTestRoom(t, store.NewMemory()).join("alice")
+
+	for command, want := range map[string]string{
+		"/join":              "usage: /join <#room>",
+		"/join #random":      "there is no room #random; this server runs #" + DefaultRoom + " only",
+		"/join #general now": "usage: /join <#room>",
+	} {
+		if _, got := alice.say(command); got != want {
+			t.Errorf("%s rejected with %q; want %q", command, got, want)
+		}
+	}
+	// The notice comes before the ACK, which say waits for
+	alice.send(&ws.Message{Type: ws.TypeMessage, Payload: &ws.TextMessage{Text: "/join #" + DefaultRoom}})
+	if notice := alice.expect(ws.TypeSystem).Payload.(*ws.SystemMessage); notice.Text != "You are in #"+DefaultRoom+" already" {
+		t.Errorf("/join #%s answered %q", DefaultRoom, notice.Text)
+	}
+}
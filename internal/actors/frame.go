@@ -0,0 +1,78 @@
+package actors
+
+import (
+	"encoding/json"
+	"go-chat/shared/ws"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/charmbracelet/log"
+)
+
+// SendWS delivers a WebSocket message to a client or room actor. Actors on
+// other nodes receive it wrapped in a WSFrame, since only protobuf messages
+// can cross hollywood's remote.
+func SendWS(engine *actor.Engine, pid *actor.PID, msg *ws.Message) {
+	if pid.Address == engine.Address() {
+		engine.Send(pid, msg)
+		return
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Error("failed to encode message for remote actor", "pid", pid, "type", msg.Type, "error", err)
+		return
+	}
+	engine.Send(pid, &WSFrame{Data: data})
+}
+
+// inbound is a WebSocket message read from a client's connection, for an
+// actor on the same node
+type inbound struct {
+	sender *actor.PID
+	msg    *ws.Message
+}
+
+// SendWSFrom is SendWS for messages read from the connection of the client
+// actor sender, so the receiving room or registry can answer the client
+func SendWSFrom(engine *actor.Engine, pid, sender *actor.PID, msg *ws.Message) {
+	if pid.Address == engine.Address() {
+		engine.Send(pid, &inbound{sender: sender, msg: msg})
+		return
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Error("failed to encode message for remote actor", "pid", pid, "type", msg.Type, "error", err)
+		return
+	}
+	engine.Send(pid, &WSFrame{Data: data, Sender: sender})
+}
+
+// Message decodes the WebSocket message carried by the frame
+func (f *WSFrame) Message() (*ws.Message, error) {
+	var msg ws.Message
+	if err := json.Unmarshal(f.Data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// respondJSON answers a request made on behalf of a REST route with the
+// JSON encoded result, or with err
+func respondJSON(ctx *actor.Context, result any, err error) {
+	if err == nil {
+		var data []byte
+		if data, err = json.Marshal(result); err == nil {
+			ctx.Respond(&APIResponse{Data: data})
+			return
+		}
+	}
+	log.Error("failed to answer request", "pid", ctx.PID(), "error", err)
+	ctx.Respond(&APIResponse{Error: err.Error()})
+}
+
+// respondStatus answers a request made on behalf of a REST route with an
+// error the client caused, such as http.StatusNotFound
+func respondStatus(ctx *actor.Context, status int, message string) {
+	ctx.Respond(&APIResponse{Error: message, Status: int32(status)})
+}
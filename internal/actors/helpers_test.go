@@ -0,0 +1,159 @@
+package actors
+
+import (
+	"go-chat/internal/broadcast"
+	"go-chat/internal/store"
+	"go-chat/shared/ws"
+	"testing"
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+)
+
+// testTimeout bounds how long a test waits for a message
+const testTimeout = 5 * time.Second
+
+// testRoom is the default room of an engine of its own, shared through a
+// local broadcaster
+type testRoom struct {
+	t           *testing.T
+	engine      *actor.Engine
+	broadcaster *broadcast.Local
+	store       store.Store
+	users       *LocalUsers
+	pid         *actor.PID
+}
+
+// newTestRoom starts the default room, keeping its history in history
+func newTestRoom(t *testing.T, history store.Store) *testRoom {
+	t.Helper()
+	engine, err := actor.NewEngine(actor.NewEngineConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcaster := broadcast.NewLocal()
+	users := NewLocalUsers(engine, broadcaster, history)
+	rooms := NewLocalRooms(engine, broadcaster, history, users, nil)
+	pid, _, err := rooms.Lookup(DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRoom{t: t, engine: engine, broadcaster: broadcaster, store: history, users: users, pid: pid}
+}
+
+// testClient is a connection of a user to a testRoom. Everything sent to
+// it arrives on received.
+type testClient struct {
+	t        *testing.T
+	room     *testRoom
+	pid      *actor.PID
+	username string
+	received chan *ws.Message
+}
+
+// join connects a client of username to the room, and waits until it was
+// synced
+func (r *testRoom) join(username string) *testClient {
+	r.t.Helper()
+	c, _ := r.resume(username, "", 0)
+	return c
+}
+
+// resume connects a client of username that saw the room up to the
+// message since of epoch, and returns it with the SYNC it was sent
+func (r *testRoom) resume(username, epoch string, since uint64) (*testClient, *ws.SyncMessage) {
+	r.t.Helper()
+	c := r.connect(username)
+	r.engine.Send(r.pid, &ClientJoined{ClientPID: c.pid, Username: username, Epoch: epoch, Since: since})
+	return c, c.expect(ws.TypeSync).Payload.(*ws.SyncMessage)
+}
+
+// connect spawns a client of username without joining it to the room
+func (r *testRoom) connect(username string) *testClient {
+	c := &testClient{t: r.t, room: r, username: username, received: make(chan *ws.Message, 256)}
+	c.pid = r.engine.SpawnFunc(func(ctx *actor.Context) {
+		if msg, ok := ctx.Message().(*ws.Message); ok {
+			c.received <- msg
+		}
+	}, "test-client")
+	return c
+}
+
+// leave disconnects the client from the room
+func (c *testClient) leave() {
+	c.room.engine.Send(c.room.pid, &ClientLeft{ClientPID: c.pid, Username: c.username})
+}
+
+// send sends msg to the room as the client
+func (c *testClient) send(msg *ws.Message) {
+	SendWSFrom(c.room.engine, c.room.pid, c.pid, msg)
+}
+
+// say sends text as a chat message, using it as the nonce, and returns
+// the ACK it was accepted with or the error it was rejected with
+func (c *testClient) say(text string) (*ws.AckMessage, string) {
+	c.t.Helper()
+	return c.sayTo(text, "")
+}
+
+// sayTo is say for a reply to the message parentID
+func (c *testClient) sayTo(text, parentID string) (*ws.AckMessage, string) {
+	c.t.Helper()
+	c.send(&ws.Message{
+		Type:    ws.TypeMessage,
+		Payload: &ws.TextMessage{From: c.username, Nonce: text, Text: text, ParentID: parentID},
+	})
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-c.received:
+			switch payload := msg.Payload.(type) {
+			case *ws.AckMessage:
+				if payload.Nonce == text {
+					return payload, ""
+				}
+			case *ws.NackMessage:
+				if payload.Nonce == text {
+					return nil, payload.Error
+				}
+			}
+		case <-timeout:
+			c.t.Fatalf("%q was never answered", text)
+		}
+	}
+}
+
+// post sends text as a chat message and returns the ID it was given
+func (c *testClient) post(text string) string {
+	c.t.Helper()
+	ack, reason := c.say(text)
+	if ack == nil {
+		c.t.Fatalf("%q rejected: %s", text, reason)
+	}
+	return ack.ID
+}
+
+// expect waits for the next message of type typ sent to the client,
+// skipping others
+func (c *testClient) expect(typ ws.MessageType) *ws.Message {
+	c.t.Helper()
+	return c.expectFunc(string(typ), func(msg *ws.Message) bool { return msg.Type == typ })
+}
+
+// expectFunc waits for the next message sent to the client that match
+// reports true for, skipping others. what describes it when none arrives.
+func (c *testClient) expectFunc(what string, match func(*ws.Message) bool) *ws.Message {
+	c.t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-c.received:
+			if match(msg) {
+				return msg
+			}
+		case <-timeout:
+			c.t.Fatalf("%s never received %s", c.username, what)
+			return nil
+		}
+	}
+}
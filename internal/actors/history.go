@@ -0,0 +1,107 @@
+package actors
+
+import (
+	"go-chat/shared/ws"
+	"slices"
+)
+
+// historySize is how many of its latest messages a room keeps at least for
+// replaying to reconnecting clients. Up to twice as many are kept, so that
+// trimming is amortized.
+const historySize = 500
+
+// history keeps the latest messages delivered to a room, in Seq order and
+// without gaps. It is only used from the room's Receive.
+type history struct {
+	messages []ws.TextMessage
+}
+
+// add records a message; its Seq must follow the previous message's
+func (h *history) add(msg ws.TextMessage) {
+	h.messages = append(h.messages, msg)
+	if len(h.messages) >= 2*historySize {
+		h.messages = append(h.messages[:0:0], h.messages[len(h.messages)-historySize:]...)
+	}
+}
+
+// since returns the messages after seq, or false when messages after seq
+// are no longer kept
+func (h *history) since(seq uint64) ([]ws.TextMessage, bool) {
+	if len(h.messages) == 0 {
+		return nil, true
+	}
+	oldest := h.messages[0].Seq
+	if seq+1 < oldest {
+		return nil, false
+	}
+	if skip := seq + 1 - oldest; skip < uint64(len(h.messages)) {
+		return append([]ws.TextMessage(nil), h.messages[skip:]...), true
+	}
+	return nil, true
+}
+
+// recent returns the latest historySize messages
+func (h *history) recent() []ws.TextMessage {
+	messages := h.messages
+	if len(messages) > historySize {
+		messages = messages[len(messages)-historySize:]
+	}
+	return append([]ws.TextMessage(nil), messages...)
+}
+
+// find returns the kept message with the given ID, or nil. The pointer is
+// only valid until the next add.
+func (h *history) find(id string) *ws.TextMessage {
+	for i := len(h.messages) - 1; i >= 0; i-- {
+		if h.messages[i].ID == id {
+			return &h.messages[i]
+		}
+	}
+	return nil
+}
+
+// replies returns the latest limit replies to parentID with a Seq below
+// before, or below any Seq when before is zero, and whether older ones are
+// kept
+func (h *history) replies(parentID string, before uint64, limit int) ([]ws.TextMessage, bool) {
+	var replies []ws.TextMessage
+	more := false
+	for i := len(h.messages) - 1; i >= 0; i-- {
+		msg := h.messages[i]
+		if msg.ParentID != parentID || (before != 0 && msg.Seq >= before) {
+			continue
+		}
+		if len(replies) == limit {
+			more = true
+			break
+		}
+		replies = append(replies, msg)
+	}
+	slices.Reverse(replies)
+	return replies, more
+}
+
+// at returns the kept message with the given Seq, or nil. The pointer is
+// only valid until the next add.
+func (h *history) at(seq uint64) *ws.TextMessage {
+	if len(h.messages) == 0 || seq < h.messages[0].Seq {
+		return nil
+	}
+	if i := seq - h.messages[0].Seq; i < uint64(len(h.messages)) {
+		return &h.messages[i]
+	}
+	return nil
+}
+
+// unread counts the kept messages after seq that username has not sent,
+// leaving out replies and deleted messages
+func (h *history) unread(seq uint64, username string) int {
+	count := 0
+	for i := len(h.messages) - 1; i >= 0 && h.messages[i].Seq > seq; i-- {
+		msg := h.messages[i]
+		if msg.ParentID == "" && !msg.Deleted && msg.From != username {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,133 @@
+package actors
+
+import (
+	"fmt"
+	"go-chat/internal/store"
+	"go-chat/shared/ws"
+	"testing"
+)
+
+// filledHistory returns a history of the messages 1 to n, where every
+// third message is a reply to message 1 sent by bob
+func filledHistory(n int) *history {
+	h := &history{}
+	for seq := uint64(1); seq <= uint64(n); seq++ {
+		msg := ws.TextMessage{ID: fmt.Sprint(seq), Seq: seq, From: "alice"}
+		if seq%3 == 0 {
+			msg.ParentID, msg.From = "1", "bob"
+		}
+		h.add(msg)
+	}
+	return h
+}
+
+// seqs returns the Seq of every message
+func seqs(messages []ws.TextMessage) []uint64 {
+	seqs := make([]uint64, len(messages))
+	for i, msg := range messages {
+		seqs[i] = msg.Seq
+	}
+	return seqs
+}
+
+// TestHistoryTrim checks that a history keeps at least its latest
+// historySize messages, and that clients further behind must reset
+func TestHistoryTrim(t *testing.T) {
+	h := filledHistory(2*historySize + 10)
+	if got := len(h.messages); got < historySize || got >= 2*historySize {
+		t.Fatalf("%d messages kept; want %d to %d", got, historySize, 2*historySize-1)
+	}
+	oldest := h.messages[0].Seq
+
+	if _, ok := h.since(oldest - 2); ok {
+		t.Errorf("since(%d) resumed, though message %d is gone", oldest-2, oldest-1)
+	}
+	if got, ok := h.since(oldest - 1); !ok || len(got) != len(h.messages) || got[0].Seq != oldest {
+		t.Errorf("since(%d) = %d messages from %v, %v; want all kept", oldest-1, len(got), seqs(got[:1]), ok)
+	}
+	if got, ok := h.since(2*historySize + 10); !ok || len(got) != 0 {
+		t.Errorf("since the latest = %v, %v; want nothing", seqs(got), ok)
+	}
+	if got := h.recent(); len(got) != historySize || got[historySize-1].Seq != 2*historySize+10 {
+		t.Errorf("recent = %d messages up to %v; want the latest %d", len(got), seqs(got[len(got)-1:]), historySize)
+	}
+
+	if got := h.at(oldest); got == nil || got.Seq != oldest {
+		t.Errorf("at(%d) = %+v", oldest, got)
+	}
+	if got := h.at(oldest - 1); got != nil {
+		t.Errorf("at(%d) = %+v; want nil, as it is gone", oldest-1, got)
+	}
+	if got := h.find(fmt.Sprint(oldest)); got == nil || got.Seq != oldest {
+		t.Errorf("find(%d) = %+v", oldest, got)
+	}
+}
+
+// TestHistoryUnread checks that only root messages of others count as
+// unread
+func TestHistoryUnread(t *testing.T) {
+	h := filledHistory(10)
+	h.find("10").Deleted = true
+
+	// 4, 5, 7 and 8: 6 and 9 are replies, and 10 was deleted
+	if got := h.unread(3, "bob"); got != 4 {
+		t.Errorf("unread after 3 = %d; want 4", got)
+	}
+	if got := h.unread(3, "alice"); got != 0 {
+		t.Errorf("unread of alice's own messages = %d", got)
+	}
+	if got := h.unread(10, "bob"); got != 0 {
+		t.Errorf("unread after the latest = %d", got)
+	}
+}
+
+// TestHistoryReplies checks that replies are paged newest first, returned
+// oldest first
+func TestHistoryReplies(t *testing.T) {
+	h := filledHistory(10)
+
+	replies, more := h.replies("1", 0, 2)
+	if got := seqs(replies); fmt.Sprint(got) != "[6 9]" || !more {
+		t.Errorf("latest replies = %v, %v; want [6 9] and more", got, more)
+	}
+	replies, more = h.replies("1", 6, 2)
+	if got := seqs(replies); fmt.Sprint(got) != "[3]" || more {
+		t.Errorf("replies before 6 = %v, %v; want [3] only", got, more)
+	}
+	if replies, _ := h.replies("2", 0, 2); len(replies) != 0 {
+		t.Errorf("replies to 2 = %v", seqs(replies))
+	}
+}
+
+// TestSync checks that reconnecting clients get the messages they missed,
+// and everything else the room keeps otherwise
+func TestSync(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+	alice := room.join("alice")
+	for _, text := range []string{"one", "two", "three"} {
+		alice.post(text)
+	}
+	_, sync := room.resume("bob", "", 0)
+	epoch, latest := sync.Epoch, sync.Seq
+
+	_, sync = room.resume("bob", epoch, latest-1)
+	if sync.Reset || sync.Seq != latest || len(sync.Messages) != 1 || sync.Messages[0].Text != "three" {
+		t.Errorf("SYNC after %d = %+v; want three only", latest-1, sync)
+	}
+	_, sync = room.resume("bob", epoch, latest)
+	if sync.Reset || len(sync.Messages) != 0 {
+		t.Errorf("SYNC of the latest = %+v; want nothing", sync)
+	}
+
+	// Clients that saw another run of the room, or numbers it did not
+	// reach, start over
+	for _, tc := range []struct {
+		epoch string
+		since uint64
+	}{{"other", latest - 1}, {epoch, latest + 1}} {
+		_, sync = room.resume("bob", tc.epoch, tc.since)
+		if !sync.Reset || sync.Epoch != epoch || len(sync.Messages) != 3 || sync.Messages[0].Text != "one" {
+			t.Errorf("SYNC after %d of %s = %+v; want a reset to all messages", tc.since, tc.epoch, sync)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package actors
+
+import (
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMuteSurvivesRestart checks that a mute is kept in the store, so a
+// room started again, or on another instance, still enforces it
+func TestMuteSurvivesRestart(t *testing.T) {
+	history := store.NewMemory()
+	if err := history.SetRole(DefaultRoom, "alice", api.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	room := newTestRoom(t, history)
+	alice, bob := room.join("alice"), room.join("bob")
+
+	alice.send(&ws.Message{
+		Type:    ws.TypeMute,
+		Payload: &ws.ModerationMessage{Username: "bob", Duration: time.Hour.Milliseconds()},
+	})
+	bob.expect(ws.TypeMute)
+	if _, reason := bob.say("hello"); !strings.HasPrefix(reason, "you are muted until") {
+		t.Errorf("muted user's message rejected with %q; want the mute", reason)
+	}
+
+	bob = newTestRoom(t, history).join("bob")
+	if _, reason := bob.say("hello again"); !strings.HasPrefix(reason, "you are muted until") {
+		t.Errorf("muted user's message in the restarted room rejected with %q; want the mute", reason)
+	}
+
+	// Unmuting lifts it for good too
+	alice.send(&ws.Message{Type: ws.TypeUnmute, Payload: &ws.ModerationMessage{Username: "bob"}})
+	alice.expect(ws.TypeUnmute)
+	if mutes, _ := history.Mutes(DefaultRoom); len(mutes) != 0 {
+		t.Errorf("mutes after unmuting = %v; want none", mutes)
+	}
+}
@@ -0,0 +1,153 @@
+package actors
+
+import (
+	"go-chat/shared/ws"
+	"sort"
+	"time"
+)
+
+// offlineRetention is how long users stay listed after their last
+// connection closed
+const offlineRetention = 24 * time.Hour
+
+// presence tracks the connections of each user in a room and derives the
+// user's status from them. It is only used from the room's Receive.
+type presence struct {
+	users       map[string]*userPresence
+	connections map[string]string // PID string → username
+}
+
+type userPresence struct {
+	connections map[string]string // PID string → ws.PresenceOnline or ws.PresenceAway
+	lastSeen    time.Time
+}
+
+func newPresence() *presence {
+	return &presence{
+		users:       make(map[string]*userPresence),
+		connections: make(map[string]string),
+	}
+}
+
+// status is online if any connection is online, away if all of them are
+// away, and offline without connections
+func (u *userPresence) status() string {
+	if len(u.connections) == 0 {
+		return ws.PresenceOffline
+	}
+	for _, status := range u.connections {
+		if status == ws.PresenceOnline {
+			return ws.PresenceOnline
+		}
+	}
+	return ws.PresenceAway
+}
+
+// connect adds a connection of username and reports whether the user's
+// status changed
+func (p *presence) connect(pid, username string, now time.Time) bool {
+	user, ok := p.users[username]
+	if !ok {
+		user = &userPresence{connections: make(map[string]string)}
+		p.users[username] = user
+	}
+	before := user.status()
+	user.connections[pid] = ws.PresenceOnline
+	user.lastSeen = now
+	p.connections[pid] = username
+	return user.status() != before
+}
+
+// disconnect removes a connection and reports its user and whether the
+// user's status changed
+func (p *presence) disconnect(pid string, now time.Time) (string, bool) {
+	username, ok := p.connections[pid]
+	if !ok {
+		return "", false
+	}
+	delete(p.connections, pid)
+
+	user := p.users[username]
+	before := user.status()
+	delete(user.connections, pid)
+	user.lastSeen = now
+	p.prune(now)
+	return username, user.status() != before
+}
+
+// setStatus marks a connection online or away and reports its user and
+// whether the user's status changed
+func (p *presence) setStatus(pid, status string, now time.Time) (string, bool) {
+	username, ok := p.connections[pid]
+	if !ok || (status != ws.PresenceOnline && status != ws.PresenceAway) {
+		return "", false
+	}
+
+	user := p.users[username]
+	before := user.status()
+	user.connections[pid] = status
+	if user.status() == before {
+		return username, false
+	}
+	user.lastSeen = now
+	return username, true
+}
+
+// username returns the user a connection belongs to
+func (p *presence) username(pid string) (string, bool) {
+	username, ok := p.connections[pid]
+	return username, ok
+}
+
+// pids returns the PID strings of the connections of a user
+func (p *presence) pids(username string) []string {
+	user, ok := p.users[username]
+	if !ok {
+		return nil
+	}
+	pids := make([]string, 0, len(user.connections))
+	for pid := range user.connections {
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// member reports whether a user is connected or was recently seen
+func (p *presence) member(username string) bool {
+	_, ok := p.users[username]
+	return ok
+}
+
+// get returns the presence of a user
+func (p *presence) get(username string) ws.Presence {
+	user, ok := p.users[username]
+	if !ok {
+		return ws.Presence{Username: username, Status: ws.PresenceOffline}
+	}
+	return ws.Presence{
+		Username: username,
+		Status:   user.status(),
+		LastSeen: user.lastSeen.UnixMilli(),
+	}
+}
+
+// snapshot returns the presence of every tracked user, ordered by username
+func (p *presence) snapshot() []ws.Presence {
+	members := make([]ws.Presence, 0, len(p.users))
+	for username := range p.users {
+		members = append(members, p.get(username))
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Username < members[j].Username
+	})
+	return members
+}
+
+// prune forgets users that have been offline for offlineRetention
+func (p *presence) prune(now time.Time) {
+	for username, user := range p.users {
+		if len(user.connections) == 0 && now.Sub(user.lastSeen) > offlineRetention {
+			delete(p.users, username)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package actors
+
+import (
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPresenceStatus(t *testing.T) {
+	p := newPresence()
+	now := time.UnixMilli(1000)
+
+	// The first connection brings a user online, the second changes nothing
+	if !p.connect("phone", "alice", now) {
+		t.Error("connecting alice did not change her status")
+	}
+	if p.connect("laptop", "alice", now) {
+		t.Error("connecting alice again changed her status")
+	}
+
+	// Alice is away once every connection is
+	if username, changed := p.setStatus("phone", ws.PresenceAway, now); username != "alice" || changed {
+		t.Errorf("setStatus(phone, away) = %q, %v; want alice, unchanged", username, changed)
+	}
+	if _, changed := p.setStatus("laptop", ws.PresenceAway, now.Add(time.Second)); !changed {
+		t.Error("alice is not away with every connection away")
+	}
+	if got := p.get("alice"); got.Status != ws.PresenceAway || got.LastSeen != 2000 {
+		t.Errorf("get(alice) = %+v; want away, last seen at 2000", got)
+	}
+	if _, changed := p.setStatus("laptop", ws.PresenceAway, now); changed {
+		t.Error("setting the same status again changed it")
+	}
+	if username, changed := p.setStatus("laptop", "busy", now); username != "" || changed {
+		t.Errorf("setStatus(laptop, busy) = %q, %v; want it ignored", username, changed)
+	}
+	if username, changed := p.setStatus("tablet", ws.PresenceOnline, now); username != "" || changed {
+		t.Errorf("setStatus of an unknown connection = %q, %v; want it ignored", username, changed)
+	}
+
+	// One connection back online is enough
+	if _, changed := p.setStatus("phone", ws.PresenceOnline, now); !changed || p.get("alice").Status != ws.PresenceOnline {
+		t.Error("alice is not online with a connection online")
+	}
+
+	// Closing the online connection leaves the away one
+	if username, changed := p.disconnect("phone", now); username != "alice" || !changed || p.get("alice").Status != ws.PresenceAway {
+		t.Errorf("disconnect(phone) = %q, %v, %s; want alice, changed to away", username, changed, p.get("alice").Status)
+	}
+	if username, changed := p.disconnect("laptop", now.Add(time.Minute)); username != "alice" || !changed {
+		t.Errorf("disconnect(laptop) = %q, %v; want alice, changed", username, changed)
+	}
+	if got := p.get("alice"); got.Status != ws.PresenceOffline || got.LastSeen != 61000 {
+		t.Errorf("get(alice) = %+v; want offline, last seen at 61000", got)
+	}
+	if username, changed := p.disconnect("laptop", now); username != "" || changed {
+		t.Errorf("disconnecting twice = %q, %v", username, changed)
+	}
+}
+
+func TestPresenceMembers(t *testing.T) {
+	p := newPresence()
+	now := time.UnixMilli(0)
+	p.connect("1", "carol", now)
+	p.connect("2", "alice", now)
+	p.connect("3", "alice", now)
+	p.connect("4", "bob", now)
+	p.disconnect("4", now)
+
+	if username, ok := p.username("3"); !ok || username != "alice" {
+		t.Errorf("username(3) = %q, %v; want alice", username, ok)
+	}
+	if _, ok := p.username("4"); ok {
+		t.Error("closed connection still has a user")
+	}
+	if pids := p.pids("alice"); len(pids) != 2 {
+		t.Errorf("pids(alice) = %q; want 2 connections", pids)
+	}
+	if pids := p.pids("dave"); pids != nil {
+		t.Errorf("pids(dave) = %q; want none", pids)
+	}
+
+	var usernames []string
+	for _, member := range p.snapshot() {
+		usernames = append(usernames, member.Username)
+	}
+	if want := []string{"alice", "bob", "carol"}; !reflect.DeepEqual(usernames, want) {
+		t.Errorf("snapshot lists %q; want %q", usernames, want)
+	}
+	if !p.member("bob") || p.member("dave") {
+		t.Error("offline bob should be a member and dave should not")
+	}
+	if got := p.get("dave"); got.Status != ws.PresenceOffline {
+		t.Errorf("get(dave) = %+v; want offline", got)
+	}
+}
+
+// TestPresencePrune checks that offline users are forgotten once they have
+// been gone for offlineRetention, and connected ones never are
+func TestPresencePrune(t *testing.T) {
+	p := newPresence()
+	start := time.UnixMilli(0)
+	p.connect("1", "alice", start)
+	p.connect("2", "bob", start)
+	p.disconnect("2", start)
+	p.connect("3", "carol", start)
+
+	// Pruning happens as connections close
+	p.disconnect("3", start.Add(offlineRetention))
+	if !p.member("bob") {
+		t.Error("bob was forgotten before offlineRetention passed")
+	}
+	p.connect("4", "dave", start)
+	p.disconnect("4", start.Add(offlineRetention+time.Millisecond))
+	if p.member("bob") {
+		t.Error("bob is remembered after offlineRetention passed")
+	}
+	if !p.member("alice") || !p.member("carol") || !p.member("dave") {
+		t.Error("users connected or recently seen were forgotten")
+	}
+}
+
+// setPresence marks the client's connection online or away, as the
+// WebSocket handler does for PRESENCE_UPDATE messages
+func (c *testClient) setPresence(status string) {
+	c.room.engine.Send(c.room.pid, &SetPresence{ClientPID: c.pid, Status: status})
+}
+
+// expectPresence waits for the next presence update sent to the client
+func (c *testClient) expectPresence() *ws.Presence {
+	c.t.Helper()
+	return c.expect(ws.TypePresenceUpdate).Payload.(*ws.Presence)
+}
+
+// TestRoomPresence checks the presence updates a room sends as users
+// connect, go away and leave, and the snapshot joining users get
+func TestRoomPresence(t *testing.T) {
+	history := store.NewMemory()
+	if err := history.SetRole(DefaultRoom, "carol", api.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	room := newTestRoom(t, history)
+	alice := room.join("alice")
+	// Alice is told of her own arrival too
+	alice.expect(ws.TypeJoin)
+	alice.expectPresence()
+
+	bob := room.connect("bob")
+	room.engine.Send(room.pid, &ClientJoined{ClientPID: bob.pid, Username: "bob"})
+	snapshot := bob.expect(ws.TypePresenceSnapshot).Payload.(*ws.PresenceSnapshot)
+	if len(snapshot.Members) != 2 || snapshot.Members[0].Username != "alice" || snapshot.Members[1].Username != "bob" {
+		t.Errorf("bob's snapshot = %+v; want alice and bob", snapshot.Members)
+	}
+	if join := alice.expect(ws.TypeJoin).Payload.(*ws.JoinMessage); join.From != "bob" {
+		t.Errorf("JOIN from %q; want bob", join.From)
+	}
+	if p := alice.expectPresence(); p.Username != "bob" || p.Status != ws.PresenceOnline {
+		t.Errorf("PRESENCE_UPDATE = %+v; want bob online", p)
+	}
+
+	// A second connection changes nothing, and bob is only away once
+	// both are
+	bobPhone := room.join("bob")
+	bob.setPresence(ws.PresenceAway)
+	bobPhone.setPresence(ws.PresenceAway)
+	if p := alice.expectPresence(); p.Username != "bob" || p.Status != ws.PresenceAway {
+		t.Errorf("PRESENCE_UPDATE = %+v; want bob away", p)
+	}
+
+	// Roles are part of presence
+	room.join("carol")
+	if p := alice.expectPresence(); p.Username != "carol" || p.Role != api.RoleModerator {
+		t.Errorf("PRESENCE_UPDATE = %+v; want carol, a moderator", p)
+	}
+
+	// bob leaves with his last connection
+	bobPhone.leave()
+	bob.leave()
+	if leave := alice.expect(ws.TypeLeave).Payload.(*ws.LeaveMessage); leave.From != "bob" {
+		t.Errorf("LEAVE from %q; want bob", leave.From)
+	}
+	if p := alice.expectPresence(); p.Username != "bob" || p.Status != ws.PresenceOffline || p.LastSeen == 0 {
+		t.Errorf("PRESENCE_UPDATE = %+v; want bob offline, last seen", p)
+	}
+
+	// and is still listed as recently seen
+	dave := room.connect("dave")
+	room.engine.Send(room.pid, &ClientJoined{ClientPID: dave.pid, Username: "dave"})
+	snapshot = dave.expect(ws.TypePresenceSnapshot).Payload.(*ws.PresenceSnapshot)
+	statuses := make(map[string]string)
+	for _, p := range snapshot.Members {
+		statuses[p.Username] = p.Status
+	}
+	want := map[string]string{"alice": ws.PresenceOnline, "bob": ws.PresenceOffline, "carol": ws.PresenceOnline, "dave": ws.PresenceOnline}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("dave's snapshot = %v; want %v", statuses, want)
+	}
+}
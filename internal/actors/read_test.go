@@ -0,0 +1,96 @@
+package actors
+
+import (
+	"go-chat/internal/store"
+	"go-chat/shared/ws"
+	"testing"
+)
+
+// read sends a read marker for seq as the client
+func (c *testClient) read(seq uint64) {
+	c.send(&ws.Message{Type: ws.TypeRead, Payload: &ws.ReadMarker{Seq: seq}})
+}
+
+// expectRead waits for the next read marker sent to the client
+func (c *testClient) expectRead() *ws.ReadMarker {
+	c.t.Helper()
+	return c.expect(ws.TypeRead).Payload.(*ws.ReadMarker)
+}
+
+// TestReadCursors checks that read cursors only move forward, are shared
+// with the room and tell joining users what they have not read
+func TestReadCursors(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+	alice, bob := room.join("alice"), room.join("bob")
+	ids := []string{alice.post("one"), alice.post("two"), alice.post("three")}
+
+	bob.read(2)
+	if marker := alice.expectRead(); marker.Username != "bob" || marker.Seq != 2 || marker.ID != ids[1] {
+		t.Errorf("READ = %+v; want bob at 2, %s", marker, ids[1])
+	}
+	// Going back moves nothing, and reading past the end stops at the
+	// latest message
+	bob.read(1)
+	bob.read(99)
+	if marker := alice.expectRead(); marker.Seq != 3 || marker.ID != ids[2] {
+		t.Errorf("READ = %+v; want bob at 3, %s", marker, ids[2])
+	}
+
+	carol := room.join("carol")
+	state := carol.expect(ws.TypeReadState).Payload.(*ws.ReadState)
+	if state.ReadSeq != 0 || state.Unread != 3 {
+		t.Errorf("carol's READ_STATE = %+v; want 3 unread", state)
+	}
+	if len(state.Readers) != 1 || state.Readers[0].Username != "bob" || state.Readers[0].Seq != 3 {
+		t.Errorf("readers = %+v; want bob at 3", state.Readers)
+	}
+
+	// Messages of the user themselves are not unread
+	bob.post("four")
+	alice.post("five")
+	state = room.join("bob").expect(ws.TypeReadState).Payload.(*ws.ReadState)
+	if state.ReadSeq != 3 || state.Unread != 1 {
+		t.Errorf("bob's READ_STATE = %+v; want read up to 3, 1 unread", state)
+	}
+}
+
+// TestReadCursorsSurviveRestart checks that a room started again on the
+// same store keeps its history and numbering, so read cursors and resume
+// points stay valid
+func TestReadCursorsSurviveRestart(t *testing.T) {
+	history := store.NewMemory()
+	before := newTestRoom(t, history)
+	alice, sync := before.resume("alice", "", 0)
+	for _, text := range []string{"one", "two", "three"} {
+		alice.post(text)
+	}
+	alice.read(2)
+	alice.expectRead()
+	before.engine.Poison(before.pid).Wait()
+
+	after := newTestRoom(t, history)
+	alice, resumed := after.resume("alice", sync.Epoch, 3)
+	if resumed.Epoch != sync.Epoch || resumed.Seq != 3 || resumed.Reset || len(resumed.Messages) != 0 {
+		t.Errorf("SYNC after restart = %+v; want epoch %s resumed at 3", resumed, sync.Epoch)
+	}
+	state := alice.expect(ws.TypeReadState).Payload.(*ws.ReadState)
+	if state.ReadSeq != 2 {
+		t.Errorf("READ_STATE after restart = %+v; want read up to 2", state)
+	}
+
+	// A client that missed messages gets them, and numbering goes on
+	_, missed := after.resume("bob", sync.Epoch, 1)
+	if missed.Reset || len(missed.Messages) != 2 || missed.Messages[0].Text != "two" {
+		t.Errorf("SYNC since 1 = %+v; want two and three", missed)
+	}
+	ack, _ := alice.say("four")
+	if ack == nil || ack.ID == "" {
+		t.Fatal("four was not accepted")
+	}
+	if msg := alice.expectFunc("four", func(msg *ws.Message) bool {
+		text, ok := msg.Payload.(*ws.TextMessage)
+		return ok && text.Text == "four"
+	}); msg.Payload.(*ws.TextMessage).Seq != 4 {
+		t.Errorf("four numbered %d; want 4", msg.Payload.(*ws.TextMessage).Seq)
+	}
+}
@@ -0,0 +1,205 @@
+package actors
+
+import (
+	"go-chat/internal/broadcast"
+	"go-chat/internal/metrics"
+	"go-chat/internal/store"
+	"go-chat/shared/ws"
+	"sync"
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/charmbracelet/log"
+)
+
+// directChannel is the broadcaster channel direct messages are published
+// on. It cannot clash with a room, since room names never start with @.
+const directChannel = "@direct"
+
+// RegistryActor tracks every connection of every user and routes direct
+// messages to all connections of their sender and recipient, and the
+// mentions rooms send to all connections of the mentioned user. Both go
+// through the broadcaster like room messages, so registries on other
+// instances sharing a message bus deliver them too.
+type RegistryActor struct {
+	users       map[string]map[string]*actor.PID // username → PID string → PID
+	broadcaster broadcast.Broadcaster
+	store       store.Store
+	unsubscribe func()
+}
+
+// NewRegistry creates a new registry actor producer
+func NewRegistry(broadcaster broadcast.Broadcaster, store store.Store) actor.Producer {
+	return func() actor.Receiver {
+		return &RegistryActor{
+			users:       make(map[string]map[string]*actor.PID),
+			broadcaster: broadcaster,
+			store:       store,
+		}
+	}
+}
+
+// Receive implements actor.Receiver
+func (r *RegistryActor) Receive(ctx *actor.Context) {
+	switch msg := ctx.Message().(type) {
+	case actor.Started:
+		log.Info("RegistryActor started", "pid", ctx.PID())
+		engine, self := ctx.Engine(), ctx.PID()
+		unsubscribe, err := r.broadcaster.Subscribe(directChannel, func(msg *ws.Message) {
+			engine.Send(self, &delivered{msg: msg})
+		})
+		if err != nil {
+			log.Error("failed to subscribe registry to broadcaster", "error", err)
+		}
+		r.unsubscribe = unsubscribe
+
+	case actor.Stopped:
+		if r.unsubscribe != nil {
+			r.unsubscribe()
+			r.unsubscribe = nil
+		}
+		log.Info("RegistryActor stopped", "users", len(r.users))
+
+	case *ClientJoined:
+		connections, ok := r.users[msg.Username]
+		if !ok {
+			connections = make(map[string]*actor.PID)
+			r.users[msg.Username] = connections
+		}
+		connections[msg.ClientPID.String()] = msg.ClientPID
+		log.Debug("user connection registered", "username", msg.Username, "connections", len(connections))
+
+	case *ClientLeft:
+		connections := r.users[msg.Username]
+		delete(connections, msg.ClientPID.String())
+		if len(connections) == 0 {
+			delete(r.users, msg.Username)
+		}
+		log.Debug("user connection unregistered", "username", msg.Username, "connections", len(connections))
+
+	case *ws.Message:
+		r.publish(ctx, msg)
+
+	case *inbound:
+		r.publish(ctx, msg.msg)
+
+	case *WSFrame:
+		wsMsg, err := msg.Message()
+		if err != nil {
+			log.Error("failed to decode remote message", "error", err)
+			return
+		}
+		r.publish(ctx, wsMsg)
+
+	case *delivered:
+		r.deliver(ctx, msg.msg)
+
+	case *ConversationsRequest:
+		conversations, err := r.store.Conversations(msg.Username)
+		respondJSON(ctx, conversations, err)
+
+	case *DirectMessagesRequest:
+		messages, err := r.store.DirectMessages(msg.Username, msg.With, int(msg.Limit))
+		respondJSON(ctx, messages, err)
+	}
+}
+
+// publish stamps a direct message sent by a client and hands it to the
+// broadcaster. The sender is set by the connection, not the client.
+// Mentions come from rooms, which resolved and stamped them already;
+// connections only forward direct messages here.
+func (r *RegistryActor) publish(ctx *actor.Context, msg *ws.Message) {
+	if _, ok := msg.Payload.(*ws.MentionNotification); ok && msg.Type == ws.TypeMention {
+		if err := r.broadcaster.Publish(directChannel, msg); err != nil {
+			log.Error("failed to publish mention", "error", err)
+		}
+		return
+	}
+
+	direct, ok := msg.Payload.(*ws.DirectMessage)
+	if msg.Type != ws.TypeDirect || !ok {
+		log.Warn("registry ignoring message", "type", msg.Type)
+		return
+	}
+	if direct.To == "" || direct.Text == "" {
+		r.reject(ctx, direct.From, "direct messages need a recipient and text")
+		return
+	}
+
+	direct.Timestamp = time.Now().UnixMilli()
+	if err := r.broadcaster.Publish(directChannel, msg); err != nil {
+		log.Error("failed to publish direct message", "error", err)
+		r.reject(ctx, direct.From, "direct message could not be sent")
+	}
+}
+
+// deliver persists a published direct message and sends it to every local
+// connection of its sender and recipient. Mentions are sent to every local
+// connection of the mentioned user.
+func (r *RegistryActor) deliver(ctx *actor.Context, msg *ws.Message) {
+	if mention, ok := msg.Payload.(*ws.MentionNotification); ok {
+		r.sendTo(ctx, mention.To, msg)
+		return
+	}
+
+	direct, ok := msg.Payload.(*ws.DirectMessage)
+	if !ok {
+		log.Error("unexpected direct message payload", "payload", msg.Payload)
+		return
+	}
+	if err := r.store.SaveDirect(*direct); err != nil {
+		log.Error("failed to save direct message", "from", direct.From, "to", direct.To, "error", err)
+	}
+
+	r.sendTo(ctx, direct.To, msg)
+	if direct.From != direct.To {
+		r.sendTo(ctx, direct.From, msg)
+	}
+}
+
+// reject tells every connection of username that their message failed
+func (r *RegistryActor) reject(ctx *actor.Context, username, reason string) {
+	r.sendTo(ctx, username, &ws.Message{
+		Type:    ws.TypeError,
+		Payload: &ws.ErrorMessage{Error: reason},
+	})
+}
+
+func (r *RegistryActor) sendTo(ctx *actor.Context, username string, msg *ws.Message) {
+	for _, pid := range r.users[username] {
+		SendWS(ctx.Engine(), pid, msg)
+	}
+}
+
+// Users locates the registry actor
+type Users interface {
+	// Registry returns the PID of the registry and a channel that is closed
+	// once that PID stops being valid, like Rooms.Lookup
+	Registry() (*actor.PID, <-chan struct{}, error)
+}
+
+// LocalUsers spawns the registry on the local engine
+type LocalUsers struct {
+	engine   *actor.Engine
+	producer actor.Producer
+
+	once sync.Once
+	pid  *actor.PID
+}
+
+// NewLocalUsers creates a Users whose registry lives in this process
+func NewLocalUsers(engine *actor.Engine, broadcaster broadcast.Broadcaster, store store.Store) *LocalUsers {
+	return &LocalUsers{
+		engine:   engine,
+		producer: metrics.InstrumentActor(string(TypeRegistry), NewRegistry(broadcaster, store)),
+	}
+}
+
+// Registry implements Users. The local registry lives as long as the
+// process, so the returned channel is never closed.
+func (u *LocalUsers) Registry() (*actor.PID, <-chan struct{}, error) {
+	u.once.Do(func() {
+		u.pid = u.engine.Spawn(u.producer, string(TypeRegistry), actor.WithID(RegistryID))
+	})
+	return u.pid, nil, nil
+}
@@ -0,0 +1,175 @@
+package actors
+
+import (
+	"fmt"
+	"go-chat/internal/store"
+	"go-chat/shared/ws"
+	"testing"
+)
+
+// registerTo connects a client of username to the registry of room,
+// without joining the room
+func registerTo(room *testRoom, username string) *testClient {
+	c := room.connect(username)
+	c.register()
+	return c
+}
+
+// register adds the client to the connections of its user in the registry
+func (c *testClient) register() {
+	registry, _, _ := c.room.users.Registry()
+	c.room.engine.Send(registry, &ClientJoined{ClientPID: c.pid, Username: c.username})
+}
+
+// direct sends a direct message from the client through the registry
+func (c *testClient) direct(to, text string) {
+	registry, _, _ := c.room.users.Registry()
+	c.room.engine.Send(registry, &ws.Message{
+		Type:    ws.TypeDirect,
+		Payload: &ws.DirectMessage{From: c.username, To: to, Text: text},
+	})
+}
+
+// expectDirect waits for the next message sent to the client, which must
+// be the direct message text
+func (c *testClient) expectDirect(text string) *ws.DirectMessage {
+	c.t.Helper()
+	msg := c.expectFunc("a message", func(*ws.Message) bool { return true })
+	direct, ok := msg.Payload.(*ws.DirectMessage)
+	if !ok || direct.Text != text {
+		c.t.Fatalf("%s received %s %+v; want the direct message %q", c.username, msg.Type, msg.Payload, text)
+	}
+	return direct
+}
+
+// TestRegistryDirectMessages checks that direct messages reach every
+// connection of their sender and recipient, only those, and are saved
+func TestRegistryDirectMessages(t *testing.T) {
+	history := store.NewMemory()
+	room := newTestRoom(t, history)
+	alice := registerTo(room, "alice")
+	bobPhone, bobLaptop := registerTo(room, "bob"), registerTo(room, "bob")
+	carol := registerTo(room, "carol")
+
+	alice.direct("bob", "hi bob")
+	for _, c := range []*testClient{alice, bobPhone, bobLaptop} {
+		if direct := c.expectDirect("hi bob"); direct.From != "alice" || direct.Timestamp == 0 {
+			t.Errorf("%s received %+v; want it from alice, stamped", c.username, direct)
+		}
+	}
+	// Carol was not part of it: the next message she receives is her own
+	carol.direct("carol", "note to self")
+	carol.expectDirect("note to self")
+
+	// A closed connection no longer receives anything
+	registry, _, _ := room.users.Registry()
+	room.engine.Send(registry, &ClientLeft{ClientPID: bobLaptop.pid, Username: "bob"})
+	alice.direct("bob", "still there?")
+	bobPhone.expectDirect("still there?")
+	alice.expectDirect("still there?")
+	select {
+	case msg := <-bobLaptop.received:
+		t.Errorf("closed connection received %s %+v", msg.Type, msg.Payload)
+	default:
+	}
+
+	conversations, err := history.Conversations("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 1 || conversations[0].With != "bob" || conversations[0].Count != 2 {
+		t.Errorf("Conversations(alice) = %+v; want 2 messages with bob", conversations)
+	}
+}
+
+// TestRegistryRejectsEmptyDirectMessages checks that the sender is told
+// when a direct message lacks a recipient or text
+func TestRegistryRejectsEmptyDirectMessages(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+	alice := registerTo(room, "alice")
+
+	for _, to := range []string{"", "bob"} {
+		text := ""
+		if to == "" {
+			text = "to no one"
+		}
+		alice.direct(to, text)
+		msg := alice.expect(ws.TypeError)
+		if payload := msg.Payload.(*ws.ErrorMessage); payload.Error != "direct messages need a recipient and text" {
+			t.Errorf("direct message to %q with %q rejected with %q", to, text, payload.Error)
+		}
+	}
+}
+
+// TestRegistryMentions checks that mentions sent by rooms reach every
+// connection of the mentioned user
+func TestRegistryMentions(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+	bob := room.join("bob")
+	bob.register()
+	// Another connection of bob's, which did not join the room
+	bobPhone := registerTo(room, "bob")
+	alice := room.join("alice")
+	alice.register()
+
+	alice.post("hi @bob")
+	for _, c := range []*testClient{bob, bobPhone} {
+		msg := c.expect(ws.TypeMention)
+		if mention := msg.Payload.(*ws.MentionNotification); mention.From != "alice" || mention.Room != DefaultRoom || mention.Text != "hi @bob" {
+			t.Errorf("mention = %+v; want alice's message in %s", mention, DefaultRoom)
+		}
+	}
+	// Only bob was mentioned: the first mention alice receives is bob's
+	bob.post("hi @alice")
+	if msg := alice.expect(ws.TypeMention); msg.Payload.(*ws.MentionNotification).From != "bob" {
+		t.Errorf("alice received %+v; want bob's mention", msg.Payload)
+	}
+}
+
+// TestMentions checks who a chat message notifies: room members it
+// mentions other than its sender, up to maxMentionNotifications of them
+func TestMentions(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+	alice := room.join("alice")
+	alice.register()
+	carol := registerTo(room, "carol") // Not a member of the room
+	var members []*testClient
+	text := "@carol @alice"
+	for i := range maxMentionNotifications + 1 {
+		member := room.join(fmt.Sprintf("user%02d", i))
+		member.register()
+		members = append(members, member)
+		text += " @" + member.username
+	}
+
+	root := alice.post("ping")
+	id := alice.post(text)
+	if _, reason := alice.sayTo("@"+members[0].username+" in a thread", root); reason != "" {
+		t.Fatal(reason)
+	}
+	got := synced(t, room, id).Mentions
+	if len(got) != maxMentionNotifications+3 || got[0] != "carol" || got[1] != "alice" {
+		t.Errorf("mentions = %v; want everyone in order", got)
+	}
+
+	for _, member := range members[:maxMentionNotifications] {
+		mention := member.expect(ws.TypeMention).Payload.(*ws.MentionNotification)
+		if mention.To != member.username || mention.MessageID != id || mention.From != "alice" || mention.Text != text {
+			t.Errorf("%s was notified of %+v; want alice's message %s", member.username, mention, id)
+		}
+	}
+	mention := members[0].expect(ws.TypeMention).Payload.(*ws.MentionNotification)
+	if mention.ParentID != root || mention.Text != "@"+members[0].username+" in a thread" {
+		t.Errorf("mention in a thread = %+v; want the reply to %s", mention, root)
+	}
+
+	// Those left out receive the next mention first, carol once a member
+	room.join("carol")
+	for _, c := range []*testClient{alice, carol, members[maxMentionNotifications]} {
+		want := "@" + c.username + " again"
+		members[0].post(want)
+		if mention := c.expect(ws.TypeMention).Payload.(*ws.MentionNotification); mention.Text != want {
+			t.Errorf("%s was first notified of %q; want %q", c.username, mention.Text, want)
+		}
+	}
+}
@@ -1,25 +1,112 @@
 package actors
 
 import (
+	"context"
+	"errors"
+	"go-chat/internal/broadcast"
+	"go-chat/internal/metrics"
+	"go-chat/internal/store"
+	"go-chat/internal/unfurl"
+	"go-chat/shared/api"
+	"go-chat/shared/markdown"
 	"go-chat/shared/ws"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/anthdm/hollywood/actor"
 	"github.com/charmbracelet/log"
+	"github.com/oklog/ulid/v2"
 )
 
-// RoomActor manages a group of connected clients
+// Limits on reactions, so a message's aggregate stays small
+const (
+	maxEmojiLength = 32 // Bytes; enough for emoji joined with ZWJ sequences
+	maxReactions   = 20 // Different emoji per message
+)
+
+// maxMentionNotifications bounds the users notified of one message
+const maxMentionNotifications = 20
+
+// maxUnfurls bounds the links of one message that get a preview
+const maxUnfurls = 3
+
+// Limits on moderation actions
+const (
+	maxModerationReason   = 200                  // Runes
+	maxModerationDuration = 365 * 24 * time.Hour // Of a ban or mute
+)
+
+// auditActions names the moderation message types in the audit log
+var auditActions = map[ws.MessageType]string{
+	ws.TypeKick:   api.AuditKick,
+	ws.TypeBan:    api.AuditBan,
+	ws.TypeUnban:  api.AuditUnban,
+	ws.TypeMute:   api.AuditMute,
+	ws.TypeUnmute: api.AuditUnmute,
+	ws.TypeRole:   api.AuditRole,
+}
+
+// RoomActor manages a group of connected clients. Messages for the room
+// are published through a broadcast.Broadcaster, and everything delivered
+// back from it is sent to the room's local clients.
 type RoomActor struct {
-	clients map[string]*actor.PID
-	mu      sync.RWMutex
+	name        string
+	clients     map[string]*actor.PID
+	mu          sync.RWMutex
+	done        chan struct{}
+	epoch       string // Identifies the numbering of messages, kept in store
+	seq         uint64 // Seq of the last message delivered to the room
+	history     history
+	presence    *presence
+	typing      *typing
+	typingTick  *actor.SendRepeater // Runs while anyone is typing
+	mutes       map[string]int64    // Username → Unix milliseconds their mute ends, as kept in store
+	topic       ws.TopicMessage
+	broadcaster broadcast.Broadcaster
+	store       store.Store
+	users       Users
+	unfurler    *unfurl.Client
+	unsubscribe func()
+}
+
+// delivered wraps a message the broadcaster delivered for this room
+type delivered struct {
+	msg *ws.Message
 }
 
-// NewRoom creates a new room actor producer
-func NewRoom() actor.Producer {
+// typingTick makes the room expire typing users that went quiet
+type typingTick struct{}
+
+// unfurled carries the link previews fetched for a message back to the
+// room
+type unfurled struct {
+	id       string
+	previews []ws.LinkPreview
+}
+
+// NewRoom creates a new room actor producer. The room is named after the
+// ID it is spawned with, e.g. actor.WithID(DefaultRoom). The latest
+// messages and their numbering, read cursors, edit history, user roles,
+// bans, mutes and the audit log are kept in store. Mentions are sent to
+// users through the registry of users. Links in messages are previewed
+// with unfurler, unless it is nil.
+func NewRoom(broadcaster broadcast.Broadcaster, store store.Store, users Users, unfurler *unfurl.Client) actor.Producer {
 	return func() actor.Receiver {
 		return &RoomActor{
-			clients: make(map[string]*actor.PID),
+			clients:     make(map[string]*actor.PID),
+			done:        make(chan struct{}),
+			presence:    newPresence(),
+			typing:      newTyping(),
+			mutes:       make(map[string]int64),
+			broadcaster: broadcaster,
+			store:       store,
+			users:       users,
+			unfurler:    unfurler,
 		}
 	}
 }
@@ -27,32 +114,108 @@ func NewRoom() actor.Producer {
 // Receive implements actor.Receiver
 func (r *RoomActor) Receive(ctx *actor.Context) {
 	switch msg := ctx.Message().(type) {
-	case *actor.Started:
-		log.Info("RoomActor started")
+	case actor.Started:
+		r.name = strings.TrimPrefix(ctx.PID().ID, string(TypeRoom)+"/")
+		if mutes, err := r.store.Mutes(r.name); err != nil {
+			log.Error("failed to load mutes", "room", r.name, "error", err)
+		} else {
+			r.mutes = mutes
+		}
+		metrics.RoomClients.WithLabelValues(r.name).Set(0)
+
+		// Deliveries arrive on the broadcaster's goroutines, so route them
+		// through the inbox
+		engine, self := ctx.Engine(), ctx.PID()
+		unsubscribe, err := r.broadcaster.Subscribe(r.name, func(msg *ws.Message) {
+			engine.Send(self, &delivered{msg: msg})
+		})
+		if err != nil {
+			log.Error("failed to subscribe room to broadcaster", "room", r.name, "error", err)
+		}
+		r.unsubscribe = unsubscribe
+
+		// Loaded after subscribing, so messages saved meanwhile are both
+		// loaded and delivered rather than neither
+		r.loadHistory()
+		log.Info("RoomActor started", "room", r.name, "epoch", r.epoch, "seq", r.seq, "pid", ctx.PID())
+
 		// Start periodic client cleanup
-		go r.periodicCleanup(ctx)
+		go r.periodicCleanup(ctx.Engine())
 
-	case *actor.Stopped:
-		log.Info("RoomActor stopped", "total_clients", len(r.clients))
+	case actor.Stopped:
+		select {
+		case <-r.done: // Stopped can be delivered twice around a restart
+		default:
+			close(r.done)
+		}
+		if r.unsubscribe != nil {
+			r.unsubscribe()
+			r.unsubscribe = nil
+		}
+		if r.typingTick != nil {
+			r.typingTick.Stop()
+			r.typingTick = nil
+		}
+		log.Info("RoomActor stopped", "room", r.name, "total_clients", len(r.clients))
+		metrics.RoomClients.DeleteLabelValues(r.name)
 
 	case *ClientJoined:
+		// Banned users are told why and never join
+		if ban, ok := r.banned(msg.Username, time.Now()); ok {
+			log.Info("banned user refused", "room", r.name, "username", msg.Username, "until", ban.Until)
+			reason := "You are banned from #" + r.name + " by " + ban.By
+			if ban.Reason != "" {
+				reason += ": " + ban.Reason
+			}
+			SendWS(ctx.Engine(), msg.ClientPID, &ws.Message{
+				Type:    ws.TypeClose,
+				Payload: &ws.CloseMessage{Reason: reason, Until: ban.Until},
+			})
+			return
+		}
+
 		r.mu.Lock()
 		r.clients[msg.ClientPID.String()] = msg.ClientPID
 		clientCount := len(r.clients)
 		r.mu.Unlock()
+		metrics.RoomClients.WithLabelValues(r.name).Set(float64(clientCount))
 
 		log.Info("client joined room",
 			"pid", msg.ClientPID.String(),
+			"username", msg.Username,
 			"total_clients", clientCount)
 
-		// Broadcast join message to all clients
-		joinMsg := &ws.Message{
-			Type: ws.TypeJoin,
-			Payload: &ws.JoinMessage{
-				From: msg.Username,
+		r.sync(ctx, msg)
+
+		changed := r.presence.connect(msg.ClientPID.String(), msg.Username, time.Now())
+		SendWS(ctx.Engine(), msg.ClientPID, &ws.Message{
+			Type: ws.TypePresenceSnapshot,
+			Payload: &ws.PresenceSnapshot{
+				Room:    r.name,
+				Members: r.members(),
 			},
+		})
+		r.sendReadState(ctx, msg)
+		if r.topic.Topic != "" {
+			SendWS(ctx.Engine(), msg.ClientPID, &ws.Message{Type: ws.TypeTopic, Payload: &r.topic})
+		}
+		for _, username := range r.typing.users() {
+			SendWS(ctx.Engine(), msg.ClientPID, &ws.Message{
+				Type:    ws.TypeTyping,
+				Payload: &ws.TypingMessage{From: username, IsTyping: true},
+			})
+		}
+
+		// Only the first connection of a user joins them to the room
+		if changed {
+			r.publish(&ws.Message{
+				Type: ws.TypeJoin,
+				Payload: &ws.JoinMessage{
+					From: msg.Username,
+				},
+			})
+			r.publishPresence(msg.Username)
 		}
-		r.broadcastMessage(ctx, joinMsg)
 
 	case *ClientLeft:
 		r.mu.Lock()
@@ -60,53 +223,1096 @@ func (r *RoomActor) Receive(ctx *actor.Context) {
 			delete(r.clients, msg.ClientPID.String())
 			clientCount := len(r.clients)
 			r.mu.Unlock()
+			metrics.RoomClients.WithLabelValues(r.name).Set(float64(clientCount))
 
 			log.Info("client left room",
 				"pid", msg.ClientPID.String(),
+				"username", msg.Username,
 				"total_clients", clientCount)
 
-			// Broadcast leave message
-			leaveMsg := &ws.Message{
-				Type: ws.TypeLeave,
-				Payload: &ws.LeaveMessage{
-					From: msg.Username,
-				},
+			// Only the last connection of a user makes them leave the room
+			if username, changed := r.presence.disconnect(msg.ClientPID.String(), time.Now()); changed {
+				r.publish(&ws.Message{
+					Type: ws.TypeLeave,
+					Payload: &ws.LeaveMessage{
+						From: username,
+					},
+				})
+				r.publishPresence(username)
+				if r.presence.get(username).Status == ws.PresenceOffline {
+					r.stopTyping(username)
+				}
 			}
-			r.broadcastMessage(ctx, leaveMsg)
 		} else {
 			r.mu.Unlock()
 		}
 
+	case *typingTick:
+		for _, username := range r.typing.expire(time.Now()) {
+			r.publishTyping(username, false)
+		}
+		if r.typing.idle() && r.typingTick != nil {
+			r.typingTick.Stop()
+			r.typingTick = nil
+		}
+
+	case *SetPresence:
+		if username, changed := r.presence.setStatus(msg.ClientPID.String(), msg.Status, time.Now()); changed {
+			r.publishPresence(username)
+		}
+
+	case *MembersRequest:
+		respondJSON(ctx, r.members(), nil)
+
+	case *AuditLogRequest:
+		if r.authorizeModerator(ctx, msg.Username) {
+			entries, err := r.store.AuditLog(r.name, int(msg.Limit))
+			respondJSON(ctx, entries, err)
+		}
+
+	case *BansRequest:
+		if r.authorizeModerator(ctx, msg.Username) {
+			bans, err := r.bans(time.Now())
+			respondJSON(ctx, bans, err)
+		}
+
+	case *RepliesRequest:
+		parent := r.history.find(msg.ParentID)
+		if parent == nil || parent.ParentID != "" {
+			respondStatus(ctx, http.StatusNotFound, "message not found")
+			return
+		}
+		replies, more := r.history.replies(msg.ParentID, msg.Before, int(msg.Limit))
+		respondJSON(ctx, api.RepliesResponse{Parent: *parent, Replies: replies, HasMore: more}, nil)
+
+	case *unfurled:
+		r.publish(&ws.Message{
+			Type:    ws.TypeUnfurl,
+			Payload: &ws.UnfurlMessage{ID: msg.id, Previews: msg.previews},
+		})
+
+	case *HealthCheck:
+		r.mu.RLock()
+		clientCount := len(r.clients)
+		r.mu.RUnlock()
+		ctx.Respond(&RoomStatus{Room: r.name, Clients: int32(clientCount)})
+
 	case *ws.Message:
-		r.broadcastMessage(ctx, msg)
+		r.accept(ctx, nil, msg)
+
+	case *inbound:
+		r.accept(ctx, msg.sender, msg.msg)
+
+	case *WSFrame:
+		wsMsg, err := msg.Message()
+		if err != nil {
+			log.Error("failed to decode remote message", "error", err)
+			return
+		}
+		r.accept(ctx, msg.Sender, wsMsg)
+
+	case *delivered:
+		for _, broadcast := range r.apply(msg.msg) {
+			r.broadcastMessage(ctx, broadcast)
+		}
+		r.enforce(ctx, msg.msg)
+	}
+}
+
+// accept validates and stamps a message sent by a client before
+// publishing it. Messages of types only the server sends are dropped.
+func (r *RoomActor) accept(ctx *actor.Context, sender *actor.PID, msg *ws.Message) {
+	if !msg.Type.FromClient() {
+		log.Warn("room dropping message clients may not send", "room", r.name, "type", msg.Type, "sender", sender)
+		return
+	}
+
+	switch payload := msg.Payload.(type) {
+	case *ws.TextMessage:
+		r.acceptText(ctx, sender, msg, payload)
+	case *ws.EditMessage:
+		r.acceptEdit(ctx, sender, msg, payload)
+	case *ws.DeleteMessage:
+		r.acceptDelete(ctx, sender, msg, payload)
+	case *ws.ReactionMessage:
+		r.acceptReaction(ctx, sender, msg, payload)
+	case *ws.ReadMarker:
+		r.acceptRead(ctx, sender, msg, payload)
+	case *ws.TypingMessage:
+		r.acceptTyping(ctx, sender, payload)
+	case *ws.ModerationMessage:
+		r.acceptModeration(ctx, sender, msg, payload)
+	default:
+		// Presence and direct messages are handled before reaching the
+		// room, and a message without a payload has nothing to accept
+		log.Warn("room ignoring message", "room", r.name, "type", msg.Type, "sender", sender)
+	}
+}
+
+// acceptText runs the slash command a chat message holds, or else accepts
+// it as a chat message. Text starting with two slashes is sent with one.
+func (r *RoomActor) acceptText(ctx *actor.Context, sender *actor.PID, msg *ws.Message, text *ws.TextMessage) {
+	if sender != nil {
+		if _, ok := r.presence.username(sender.String()); !ok {
+			r.nack(ctx, sender, text.Nonce, "not in this room")
+			return
+		}
+	}
+	text.Action = false // Only /me makes actions
+	if escaped, ok := strings.CutPrefix(text.Text, "//"); ok {
+		text.Text = "/" + escaped
+	} else if name, args, ok := parseCommand(text.Text); ok {
+		r.runCommand(ctx, sender, msg, text, name, args)
+		return
+	}
+	r.acceptChat(ctx, sender, msg, text)
+}
+
+// acceptChat stamps a chat message with its ID and server timestamp,
+// publishes it, and answers the sender, if known, with an ACK or NACK
+func (r *RoomActor) acceptChat(ctx *actor.Context, sender *actor.PID, msg *ws.Message, text *ws.TextMessage) {
+	if until := r.mutedUntil(text.From, time.Now()); until != 0 {
+		r.nack(ctx, sender, text.Nonce, "you are muted until "+time.UnixMilli(until).UTC().Format(time.RFC1123))
+		return
+	}
+	if strings.TrimSpace(text.Text) == "" && len(text.Attachments) == 0 {
+		r.nack(ctx, sender, text.Nonce, "message is empty")
+		return
+	}
+	attachments, reason := r.attachments(text)
+	if reason != "" {
+		r.nack(ctx, sender, text.Nonce, reason)
+		return
+	}
+	if text.ParentID != "" {
+		// Threads are one level deep, so replies go to the root message
+		parent := r.history.find(text.ParentID)
+		if parent == nil || parent.Deleted || parent.ParentID != "" {
+			r.nack(ctx, sender, text.Nonce, "message not found")
+			return
+		}
+	}
+	text.ID = ulid.Make().String()
+	text.Timestamp = time.Now().UnixMilli()
+	text.Seq = 0
+	text.EditedAt, text.Deleted, text.Reactions = 0, false, nil
+	text.ReplyCount, text.LastReplyAt = 0, 0
+	text.Attachments = attachments
+	text.Mentions, text.Links = markdown.Extract(text.Text)
+
+	if err := r.broadcaster.Publish(r.name, msg); err != nil {
+		log.Error("failed to publish message", "room", r.name, "type", msg.Type, "error", err)
+		r.nack(ctx, sender, text.Nonce, "message could not be sent")
+		return
+	}
+	r.stopTyping(text.From)
+	if sender != nil {
+		SendWS(ctx.Engine(), sender, &ws.Message{
+			Type: ws.TypeAck,
+			Payload: &ws.AckMessage{
+				Nonce:     text.Nonce,
+				ID:        text.ID,
+				Timestamp: text.Timestamp,
+			},
+		})
+	}
+	r.notifyMentions(ctx, text)
+	r.unfurl(ctx, text.ID, text.Links)
+}
+
+// notifyMentions sends a MENTION to every member of the room mentioned in
+// a published chat message, other than its sender. They go through the
+// registry, which reaches the users' connections in any room.
+func (r *RoomActor) notifyMentions(ctx *actor.Context, text *ws.TextMessage) {
+	var mentioned []string
+	for _, username := range text.Mentions {
+		if username != text.From && r.presence.member(username) {
+			mentioned = append(mentioned, username)
+		}
+	}
+	if len(mentioned) == 0 {
+		return
+	}
+	if len(mentioned) > maxMentionNotifications {
+		mentioned = mentioned[:maxMentionNotifications]
+	}
+
+	registry, _, err := r.users.Registry()
+	if err != nil {
+		log.Error("failed to locate registry", "room", r.name, "error", err)
+		return
+	}
+	for _, username := range mentioned {
+		SendWS(ctx.Engine(), registry, &ws.Message{
+			Type: ws.TypeMention,
+			Payload: &ws.MentionNotification{
+				To:        username,
+				Room:      r.name,
+				MessageID: text.ID,
+				ParentID:  text.ParentID,
+				From:      text.From,
+				Text:      text.Text,
+				Timestamp: text.Timestamp,
+			},
+		})
+	}
+}
+
+// unfurl fetches previews of the links of a message in the background,
+// which the room publishes as an UNFURL once they arrive
+func (r *RoomActor) unfurl(ctx *actor.Context, id string, links []string) {
+	if r.unfurler == nil || len(links) == 0 {
+		return
+	}
+	links = links[:min(len(links), maxUnfurls)]
+
+	engine, self := ctx.Engine(), ctx.PID()
+	go func() {
+		previews := make([]ws.LinkPreview, len(links))
+		var wg sync.WaitGroup
+		for i, link := range links {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				preview, err := r.unfurler.Unfurl(context.Background(), link)
+				if err != nil {
+					log.Debug("no link preview", "room", r.name, "url", link, "error", err)
+					return
+				}
+				previews[i] = preview
+			}()
+		}
+		wg.Wait()
+
+		previews = slices.DeleteFunc(previews, func(p ws.LinkPreview) bool { return p.URL == "" })
+		if len(previews) > 0 {
+			engine.Send(self, &unfurled{id: id, previews: previews})
+		}
+	}()
+}
+
+// attachments looks up the files a chat message references by ID. Only
+// files uploaded by the sender may be attached. A non-empty reason is
+// returned when the message must be rejected.
+func (r *RoomActor) attachments(text *ws.TextMessage) ([]ws.Attachment, string) {
+	if len(text.Attachments) == 0 {
+		return nil, ""
+	}
+	if len(text.Attachments) > api.MaxAttachments {
+		return nil, "too many attachments"
+	}
+
+	attachments := make([]ws.Attachment, 0, len(text.Attachments))
+	for _, ref := range text.Attachments {
+		if slices.ContainsFunc(attachments, func(a ws.Attachment) bool { return a.ID == ref.ID }) {
+			continue
+		}
+		att, err := r.store.Attachment(ref.ID)
+		if errors.Is(err, store.ErrNotFound) || (err == nil && att.UploadedBy != text.From) {
+			return nil, "attachment not found"
+		}
+		if err != nil {
+			log.Error("failed to load attachment", "room", r.name, "id", ref.ID, "error", err)
+			return nil, "attachment could not be loaded"
+		}
+		attachments = append(attachments, att)
+	}
+	return attachments, ""
+}
+
+// acceptEdit publishes an edit made by the message's author or a moderator
+func (r *RoomActor) acceptEdit(ctx *actor.Context, sender *actor.PID, msg *ws.Message, edit *ws.EditMessage) {
+	if strings.TrimSpace(edit.Text) == "" {
+		r.reject(ctx, sender, "message is empty")
+		return
+	}
+	username, ok := r.authorizeChange(ctx, sender, edit.ID)
+	if !ok {
+		return
+	}
+	if r.mutedUntil(username, time.Now()) != 0 {
+		r.reject(ctx, sender, "you are muted")
+		return
+	}
+
+	edit.EditedBy = username
+	edit.EditedAt = time.Now().UnixMilli()
+	r.publish(msg)
+
+	_, links := markdown.Extract(edit.Text)
+	r.unfurl(ctx, edit.ID, links)
+}
+
+// acceptDelete publishes a deletion made by the message's author or a
+// moderator
+func (r *RoomActor) acceptDelete(ctx *actor.Context, sender *actor.PID, msg *ws.Message, del *ws.DeleteMessage) {
+	username, ok := r.authorizeChange(ctx, sender, del.ID)
+	if !ok {
+		return
+	}
+
+	del.DeletedBy = username
+	del.DeletedAt = time.Now().UnixMilli()
+	r.publish(msg)
+}
+
+// acceptReaction publishes a reaction added or removed by a client in the
+// room
+func (r *RoomActor) acceptReaction(ctx *actor.Context, sender *actor.PID, msg *ws.Message, reaction *ws.ReactionMessage) {
+	if sender == nil {
+		return
+	}
+	username, ok := r.presence.username(sender.String())
+	if !ok {
+		r.reject(ctx, sender, "not in this room")
+		return
+	}
+	if r.mutedUntil(username, time.Now()) != 0 {
+		r.reject(ctx, sender, "you are muted")
+		return
+	}
+	if reaction.Emoji == "" || len(reaction.Emoji) > maxEmojiLength || strings.ContainsFunc(reaction.Emoji, unicode.IsSpace) {
+		r.reject(ctx, sender, "invalid emoji")
+		return
+	}
+
+	original := r.history.find(reaction.ID)
+	if original == nil || original.Deleted {
+		r.reject(ctx, sender, "message not found")
+		return
+	}
+	if msg.Type == ws.TypeReact && len(original.Reactions) >= maxReactions {
+		if !slices.ContainsFunc(original.Reactions, func(r ws.Reaction) bool { return r.Emoji == reaction.Emoji }) {
+			r.reject(ctx, sender, "too many different reactions")
+			return
+		}
+	}
+
+	reaction.From = username
+	r.publish(msg)
+}
+
+// acceptRead publishes a read marker for the message at the Seq a client
+// read up to
+func (r *RoomActor) acceptRead(ctx *actor.Context, sender *actor.PID, msg *ws.Message, marker *ws.ReadMarker) {
+	if sender == nil {
+		return
+	}
+	username, ok := r.presence.username(sender.String())
+	if !ok {
+		r.reject(ctx, sender, "not in this room")
+		return
+	}
+	read := r.history.at(min(marker.Seq, r.seq))
+	if read == nil {
+		return
+	}
+
+	marker.Room = r.name
+	marker.Username = username
+	marker.Seq = read.Seq
+	marker.ID = read.ID
+	r.publish(msg)
+}
+
+// acceptTyping coalesces the TYPING messages of a user into a start, and
+// an explicit or timed out stop, which are the only ones published
+func (r *RoomActor) acceptTyping(ctx *actor.Context, sender *actor.PID, typing *ws.TypingMessage) {
+	username := typing.From
+	if sender != nil {
+		var ok bool
+		if username, ok = r.presence.username(sender.String()); !ok {
+			return
+		}
+	}
+
+	if !typing.IsTyping {
+		r.stopTyping(username)
+		return
+	}
+	if r.mutedUntil(username, time.Now()) != 0 {
+		return
+	}
+	if r.typing.start(username, time.Now()) {
+		r.publishTyping(username, true)
+	}
+	if r.typingTick == nil {
+		tick := ctx.Engine().SendRepeat(ctx.PID(), &typingTick{}, time.Second)
+		r.typingTick = &tick
+	}
+}
+
+// stopTyping publishes that username stopped typing, if they were
+func (r *RoomActor) stopTyping(username string) {
+	if r.typing.stop(username) {
+		r.publishTyping(username, false)
+	}
+}
+
+func (r *RoomActor) publishTyping(username string, isTyping bool) {
+	r.publish(&ws.Message{
+		Type:    ws.TypeTyping,
+		Payload: &ws.TypingMessage{From: username, IsTyping: isTyping},
+	})
+}
+
+// authorizeChange returns the username of the client sender if it may
+// edit or delete the message with the given ID, and rejects the change
+// otherwise. Only messages still in the room's history can be changed.
+func (r *RoomActor) authorizeChange(ctx *actor.Context, sender *actor.PID, id string) (string, bool) {
+	if sender == nil {
+		return "", false
+	}
+	username, ok := r.presence.username(sender.String())
+	if !ok {
+		r.reject(ctx, sender, "not in this room")
+		return "", false
+	}
+
+	original := r.history.find(id)
+	if original == nil || original.Deleted {
+		r.reject(ctx, sender, "message not found")
+		return "", false
+	}
+	if original.From == username {
+		return username, true
+	}
+
+	role, err := r.store.Role(r.name, username)
+	if err != nil {
+		log.Error("failed to look up role", "room", r.name, "username", username, "error", err)
+		r.reject(ctx, sender, "message could not be changed")
+		return "", false
+	}
+	if api.RoleRank(role) < api.RoleRank(api.RoleModerator) {
+		r.reject(ctx, sender, "only the author or a moderator can change this message")
+		return "", false
+	}
+	return username, true
+}
+
+// acceptModeration publishes a moderation action sent by a client in the
+// room, if its user may take it
+func (r *RoomActor) acceptModeration(ctx *actor.Context, sender *actor.PID, msg *ws.Message, mod *ws.ModerationMessage) {
+	if sender == nil {
+		return
+	}
+	username, ok := r.presence.username(sender.String())
+	if !ok {
+		r.reject(ctx, sender, "not in this room")
+		return
+	}
+	if err := r.authorizeModeration(username, msg.Type, mod); err != nil {
+		r.reject(ctx, sender, err.Error())
+		return
+	}
+	r.publish(msg)
+}
+
+// authorizeModeration checks that username may take a moderation action
+// against its target, and stamps it. Moderators act on members, owners on
+// moderators too, and only owners change roles. The error returned says
+// why the action may not be taken.
+func (r *RoomActor) authorizeModeration(username string, typ ws.MessageType, mod *ws.ModerationMessage) error {
+	mod.Username = strings.TrimSpace(mod.Username)
+	if mod.Username == "" {
+		return errors.New("username is required")
+	}
+	if mod.Username == username {
+		return errors.New("you cannot moderate yourself")
+	}
+
+	role, err := r.store.Role(r.name, username)
+	if err != nil {
+		log.Error("failed to look up role", "room", r.name, "username", username, "error", err)
+		return errors.New("action could not be taken")
+	}
+	targetRole, err := r.store.Role(r.name, mod.Username)
+	if err != nil {
+		log.Error("failed to look up role", "room", r.name, "username", mod.Username, "error", err)
+		return errors.New("action could not be taken")
+	}
+	if api.RoleRank(role) < api.RoleRank(api.RoleModerator) || api.RoleRank(role) <= api.RoleRank(targetRole) {
+		return errors.New("you may not moderate " + mod.Username)
+	}
+
+	now := time.Now()
+	duration := time.Duration(mod.Duration) * time.Millisecond
+	mod.Until = 0
+	switch typ {
+	case ws.TypeRole:
+		if role != api.RoleOwner {
+			return errors.New("only an owner can change roles")
+		}
+		if mod.Role != api.RoleModerator && mod.Role != api.RoleMember {
+			return errors.New("invalid role")
+		}
+		if mod.Role != api.RoleMember {
+			ok, err := r.hasAccount(mod.Username)
+			if err != nil {
+				log.Error("failed to look up account", "username", mod.Username, "error", err)
+				return errors.New("action could not be taken")
+			}
+			if !ok {
+				return errors.New(mod.Username + " has no account; only accounts can hold a role")
+			}
+		}
+	case ws.TypeBan, ws.TypeMute:
+		if mod.Duration < 0 || duration > maxModerationDuration || (typ == ws.TypeMute && mod.Duration == 0) {
+			return errors.New("invalid duration")
+		}
+		if mod.Duration > 0 {
+			mod.Until = now.Add(duration).UnixMilli()
+		}
+	}
+	if typ != ws.TypeRole {
+		mod.Role = ""
+	}
+	if typ != ws.TypeBan && typ != ws.TypeMute {
+		mod.Duration = 0
+	}
+
+	mod.Reason = strings.TrimSpace(mod.Reason)
+	if runes := []rune(mod.Reason); len(runes) > maxModerationReason {
+		mod.Reason = string(runes[:maxModerationReason])
+	}
+	mod.Room = r.name
+	mod.By = username
+	mod.At = now.UnixMilli()
+	return nil
+}
+
+// hasAccount reports whether username is the name of an account or a bot,
+// which only the holder of its token can sign in as
+func (r *RoomActor) hasAccount(username string) (bool, error) {
+	_, _, err := r.store.Account(username)
+	if errors.Is(err, store.ErrNotFound) {
+		_, _, err = r.store.Bot(username)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// authorizeModerator answers a request with http.StatusForbidden unless
+// username is a moderator or owner of the room
+func (r *RoomActor) authorizeModerator(ctx *actor.Context, username string) bool {
+	role, err := r.store.Role(r.name, username)
+	if err != nil {
+		respondJSON(ctx, nil, err)
+		return false
+	}
+	if api.RoleRank(role) < api.RoleRank(api.RoleModerator) {
+		respondStatus(ctx, http.StatusForbidden, "only moderators can see this")
+		return false
+	}
+	return true
+}
+
+// mutedUntil returns the Unix milliseconds the mute of a user ends, or zero
+// if they are not muted. Expired mutes are lifted.
+func (r *RoomActor) mutedUntil(username string, now time.Time) int64 {
+	until, ok := r.mutes[username]
+	if !ok {
+		return 0
+	}
+	if now.UnixMilli() >= until {
+		delete(r.mutes, username)
+		if err := r.store.DeleteMute(r.name, username); err != nil {
+			log.Error("failed to lift expired mute", "room", r.name, "username", username, "error", err)
+		}
+		return 0
+	}
+	return until
+}
+
+// banned returns the ban keeping a user out of the room, if any. Expired
+// bans are lifted.
+func (r *RoomActor) banned(username string, now time.Time) (api.Ban, bool) {
+	ban, err := r.store.Ban(r.name, username)
+	if errors.Is(err, store.ErrNotFound) {
+		return api.Ban{}, false
+	}
+	if err != nil {
+		// Rather let a banned user in than lock everyone out
+		log.Error("failed to look up ban", "room", r.name, "username", username, "error", err)
+		return api.Ban{}, false
+	}
+	if ban.Until != 0 && now.UnixMilli() >= ban.Until {
+		if err := r.store.DeleteBan(r.name, username); err != nil {
+			log.Error("failed to lift expired ban", "room", r.name, "username", username, "error", err)
+		}
+		return api.Ban{}, false
+	}
+	return ban, true
+}
+
+// bans returns the bans of the room in force at now
+func (r *RoomActor) bans(now time.Time) ([]api.Ban, error) {
+	bans, err := r.store.Bans(r.name)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(bans, func(ban api.Ban) bool {
+		return ban.Until != 0 && now.UnixMilli() >= ban.Until
+	}), nil
+}
+
+// members returns the presence of the room's members with their roles and
+// mutes
+func (r *RoomActor) members() []ws.Presence {
+	members := r.presence.snapshot()
+	for i := range members {
+		members[i] = r.member(members[i])
+	}
+	return members
+}
+
+// member adds the role and mute of a user to their presence, and whether
+// they are a bot
+func (r *RoomActor) member(p ws.Presence) ws.Presence {
+	role, err := r.store.Role(r.name, p.Username)
+	if err != nil {
+		log.Error("failed to look up role", "room", r.name, "username", p.Username, "error", err)
+	}
+	if role != api.RoleMember {
+		p.Role = role
+	}
+	p.MutedUntil = r.mutedUntil(p.Username, time.Now())
+	_, _, err = r.store.Bot(p.Username)
+	p.Bot = err == nil
+	return p
+}
+
+// reject tells a client why its request failed
+func (r *RoomActor) reject(ctx *actor.Context, sender *actor.PID, reason string) {
+	SendWS(ctx.Engine(), sender, &ws.Message{
+		Type:    ws.TypeError,
+		Payload: &ws.ErrorMessage{Error: reason},
+	})
+}
+
+// nack tells the sender of a chat message that it was rejected
+func (r *RoomActor) nack(ctx *actor.Context, sender *actor.PID, nonce, reason string) {
+	if sender == nil {
+		return
+	}
+	SendWS(ctx.Engine(), sender, &ws.Message{
+		Type:    ws.TypeNack,
+		Payload: &ws.NackMessage{Nonce: nonce, Error: reason},
+	})
+}
+
+// apply updates the room's history with a delivered message. Chat
+// messages are numbered here rather than on publish, which gives every room
+// instance sharing a broadcaster a gap free order of the messages it
+// delivered, in the order it delivered them. Edits and deletions replace
+// the text of the message they change, and the replaced text is saved as a
+// revision. Messages are saved to the store as they change, which keeps
+// them searchable. Reactions are aggregated on the message they react to, replies
+// counted on their root message, read markers saved as read cursors, link
+// previews kept on the message for the links it still has, moderation
+// actions applied to the room's bans, mutes and roles, and topics kept.
+// It returns the messages to broadcast to the room's clients: msg, any
+// update it caused, or nothing when msg changed nothing.
+func (r *RoomActor) apply(msg *ws.Message) []*ws.Message {
+	switch payload := msg.Payload.(type) {
+	case *ws.TextMessage:
+		// Loaded from the store already, numbered by whoever saved it
+		if r.history.find(payload.ID) != nil {
+			return nil
+		}
+		r.seq++
+		payload.Seq = r.seq
+		broadcast := []*ws.Message{msg}
+		if parent := r.parent(payload); parent != nil {
+			parent.ReplyCount++
+			parent.LastReplyAt = payload.Timestamp
+			r.saveMessage(parent)
+			broadcast = append(broadcast, &ws.Message{
+				Type: ws.TypeThread,
+				Payload: &ws.ThreadUpdate{
+					ParentID:    parent.ID,
+					ReplyCount:  parent.ReplyCount,
+					LastReplyAt: parent.LastReplyAt,
+				},
+			})
+		}
+		r.history.add(*payload)
+		r.saveMessage(payload)
+		return broadcast
+
+	case *ws.EditMessage:
+		if original := r.history.find(payload.ID); original != nil {
+			r.saveRevision(original, payload.EditedBy, payload.EditedAt, false)
+			if payload.EditedBy != original.From {
+				r.saveAudit(api.AuditEntry{
+					Action:    api.AuditEdit,
+					Actor:     payload.EditedBy,
+					Target:    original.From,
+					MessageID: original.ID,
+					At:        payload.EditedAt,
+				})
+			}
+			original.Text = payload.Text
+			original.EditedAt = payload.EditedAt
+			original.Mentions, original.Links = markdown.Extract(payload.Text)
+			original.Previews = ws.MergePreviews(original.Links, original.Previews, nil)
+			r.saveMessage(original)
+		}
+
+	case *ws.DeleteMessage:
+		if original := r.history.find(payload.ID); original != nil {
+			r.saveRevision(original, payload.DeletedBy, payload.DeletedAt, true)
+			if payload.DeletedBy != original.From {
+				r.saveAudit(api.AuditEntry{
+					Action:    api.AuditDelete,
+					Actor:     payload.DeletedBy,
+					Target:    original.From,
+					MessageID: original.ID,
+					At:        payload.DeletedAt,
+				})
+			}
+			original.Text = ""
+			original.Deleted = true
+			original.Reactions = nil
+			original.Attachments = nil
+			original.Mentions, original.Links = nil, nil
+			original.Previews = nil
+			r.saveMessage(original)
+		}
+
+	case *ws.UnfurlMessage:
+		// Links may have changed since the previews were fetched
+		original := r.history.find(payload.ID)
+		if original == nil || original.Deleted {
+			return nil
+		}
+		previews := ws.MergePreviews(original.Links, original.Previews, payload.Previews)
+		if len(previews) == 0 && len(original.Previews) == 0 {
+			return nil
+		}
+		original.Previews = previews
+		payload.Previews = previews
+		r.saveMessage(original)
+
+	case *ws.ReactionMessage:
+		if original := r.history.find(payload.ID); original != nil && !original.Deleted {
+			// React copies rather than modifies, since SYNC messages may
+			// still be encoding the previous reactions
+			reactions, changed := ws.React(original.Reactions, payload.Emoji, payload.From, msg.Type == ws.TypeReact)
+			if changed {
+				original.Reactions = reactions
+				r.saveMessage(original)
+			}
+		}
+
+	case *ws.ReadMarker:
+		if !r.saveReadCursor(payload) {
+			return nil
+		}
+
+	case *ws.ModerationMessage:
+		r.moderate(msg.Type, payload)
+
+	case *ws.TopicMessage:
+		r.topic = *payload
+	}
+	return []*ws.Message{msg}
+}
+
+// moderate applies a moderation action to the room's bans, mutes and
+// roles, and records it in the audit log
+func (r *RoomActor) moderate(typ ws.MessageType, mod *ws.ModerationMessage) {
+	var err error
+	switch typ {
+	case ws.TypeBan:
+		err = r.store.SaveBan(api.Ban{
+			Room:     r.name,
+			Username: mod.Username,
+			By:       mod.By,
+			Reason:   mod.Reason,
+			At:       mod.At,
+			Until:    mod.Until,
+		})
+	case ws.TypeUnban:
+		err = r.store.DeleteBan(r.name, mod.Username)
+	case ws.TypeMute:
+		r.mutes[mod.Username] = mod.Until
+		err = r.store.SaveMute(r.name, mod.Username, mod.Until)
+	case ws.TypeUnmute:
+		delete(r.mutes, mod.Username)
+		err = r.store.DeleteMute(r.name, mod.Username)
+	case ws.TypeRole:
+		err = r.store.SetRole(r.name, mod.Username, mod.Role)
+	}
+	if err != nil {
+		log.Error("failed to apply moderation", "room", r.name, "type", typ, "username", mod.Username, "error", err)
+	}
+
+	r.saveAudit(api.AuditEntry{
+		Action: auditActions[typ],
+		Actor:  mod.By,
+		Target: mod.Username,
+		Role:   mod.Role,
+		Reason: mod.Reason,
+		Until:  mod.Until,
+		At:     mod.At,
+	})
+}
+
+// enforce acts on the local connections of a user a delivered moderation
+// action targets: kicked and banned users are told why and disconnected,
+// and muted users stop typing
+func (r *RoomActor) enforce(ctx *actor.Context, msg *ws.Message) {
+	mod, ok := msg.Payload.(*ws.ModerationMessage)
+	if !ok {
+		return
+	}
+
+	var reason string
+	switch msg.Type {
+	case ws.TypeKick:
+		reason = "You were kicked from #" + r.name + " by " + mod.By
+	case ws.TypeBan:
+		reason = "You were banned from #" + r.name + " by " + mod.By
+	case ws.TypeMute:
+		r.stopTyping(mod.Username)
+		return
+	default:
+		return
+	}
+	if mod.Reason != "" {
+		reason += ": " + mod.Reason
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, pid := range r.presence.pids(mod.Username) {
+		if client, ok := r.clients[pid]; ok {
+			SendWS(ctx.Engine(), client, &ws.Message{
+				Type:    ws.TypeClose,
+				Payload: &ws.CloseMessage{Reason: reason, Until: mod.Until},
+			})
+		}
 	}
 }
 
+// saveReadCursor moves the read cursor of a user forward to the message a
+// read marker names, translating its Seq to this room's numbering, and
+// reports whether the cursor moved
+func (r *RoomActor) saveReadCursor(marker *ws.ReadMarker) bool {
+	read := r.history.find(marker.ID)
+	if read == nil {
+		return false
+	}
+	cursors, err := r.store.ReadCursors(r.name)
+	if err != nil {
+		log.Error("failed to load read cursors", "room", r.name, "error", err)
+		return false
+	}
+	if cursor, ok := cursors[marker.Username]; ok && cursor.Epoch == r.epoch && cursor.Seq >= read.Seq {
+		return false
+	}
+
+	marker.Seq = read.Seq
+	err = r.store.SetReadCursor(r.name, marker.Username, api.ReadCursor{
+		Epoch:     r.epoch,
+		Seq:       read.Seq,
+		MessageID: read.ID,
+		ReadAt:    time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Error("failed to save read cursor", "room", r.name, "username", marker.Username, "error", err)
+		return false
+	}
+	return true
+}
+
+// parent returns the kept message a reply belongs to, or nil
+func (r *RoomActor) parent(reply *ws.TextMessage) *ws.TextMessage {
+	if reply.ParentID == "" {
+		return nil
+	}
+	return r.history.find(reply.ParentID)
+}
+
+// loadHistory resumes the numbering of the room's messages kept in the
+// store, and the latest of them. Without a store to resume from, the room
+// starts a numbering of its own.
+func (r *RoomActor) loadHistory() {
+	epoch, err := r.store.RoomEpoch(r.name, ulid.Make().String())
+	if err != nil {
+		log.Error("failed to load room epoch", "room", r.name, "error", err)
+		r.epoch = ulid.Make().String()
+		return
+	}
+	messages, err := r.store.RoomMessages(r.name, historySize)
+	if err != nil {
+		log.Error("failed to load room history", "room", r.name, "error", err)
+		r.epoch = ulid.Make().String()
+		return
+	}
+
+	// History has no gaps, so only the run of messages leading up to the
+	// latest is kept
+	start := len(messages) - 1
+	for start > 0 && messages[start-1].Seq+1 == messages[start].Seq {
+		start--
+	}
+	r.epoch = epoch
+	if len(messages) > 0 {
+		for _, msg := range messages[start:] {
+			r.history.add(msg)
+		}
+		r.seq = messages[len(messages)-1].Seq
+	}
+}
+
+func (r *RoomActor) saveMessage(msg *ws.TextMessage) {
+	if err := r.store.SaveMessage(r.name, *msg); err != nil {
+		log.Error("failed to save message", "room", r.name, "id", msg.ID, "error", err)
+	}
+}
+
+func (r *RoomActor) saveAudit(entry api.AuditEntry) {
+	entry.Room = r.name
+	if err := r.store.SaveAudit(entry); err != nil {
+		log.Error("failed to save audit entry", "room", r.name, "action", entry.Action, "error", err)
+	}
+}
+
+func (r *RoomActor) saveRevision(original *ws.TextMessage, changedBy string, changedAt int64, deleted bool) {
+	err := r.store.SaveRevision(api.MessageRevision{
+		MessageID: original.ID,
+		Room:      r.name,
+		Text:      original.Text,
+		ChangedBy: changedBy,
+		ChangedAt: changedAt,
+		Deleted:   deleted,
+	})
+	if err != nil {
+		log.Error("failed to save message revision", "room", r.name, "id", original.ID, "error", err)
+	}
+}
+
+// sync sends a joining client the messages it missed since it last saw
+// the room, or the room's recent history when it cannot resume. Messages
+// delivered afterwards follow it in the client's inbox, so the client sees
+// every message exactly once.
+func (r *RoomActor) sync(ctx *actor.Context, joined *ClientJoined) {
+	payload := &ws.SyncMessage{
+		Room:  r.name,
+		Epoch: r.epoch,
+		Seq:   r.seq,
+	}
+
+	var resumed bool
+	if joined.Epoch == r.epoch && joined.Since <= r.seq {
+		payload.Messages, resumed = r.history.since(joined.Since)
+	}
+	if !resumed {
+		payload.Reset = true
+		payload.Messages = r.history.recent()
+	}
+
+	log.Debug("syncing client",
+		"pid", joined.ClientPID.String(),
+		"since", joined.Since,
+		"seq", r.seq,
+		"reset", payload.Reset,
+		"messages", len(payload.Messages))
+	SendWS(ctx.Engine(), joined.ClientPID, &ws.Message{Type: ws.TypeSync, Payload: payload})
+}
+
+// sendReadState tells a joining client how far its user and everyone else
+// read the room
+func (r *RoomActor) sendReadState(ctx *actor.Context, joined *ClientJoined) {
+	cursors, err := r.store.ReadCursors(r.name)
+	if err != nil {
+		log.Error("failed to load read cursors", "room", r.name, "error", err)
+		return
+	}
+
+	// Cursors from an earlier epoch point into a numbering that is gone
+	state := &ws.ReadState{Room: r.name, Readers: []ws.ReadMarker{}}
+	for username, cursor := range cursors {
+		if cursor.Epoch != r.epoch {
+			continue
+		}
+		state.Readers = append(state.Readers, ws.ReadMarker{
+			Room:     r.name,
+			Username: username,
+			Seq:      cursor.Seq,
+			ID:       cursor.MessageID,
+		})
+		if username == joined.Username {
+			state.ReadSeq = cursor.Seq
+		}
+	}
+	sort.Slice(state.Readers, func(i, j int) bool {
+		return state.Readers[i].Username < state.Readers[j].Username
+	})
+	state.Unread = r.history.unread(state.ReadSeq, joined.Username)
+
+	SendWS(ctx.Engine(), joined.ClientPID, &ws.Message{Type: ws.TypeReadState, Payload: state})
+}
+
+// publish hands a message to the broadcaster, which delivers it back to
+// this room and to the room's subscribers on other instances
+func (r *RoomActor) publish(msg *ws.Message) {
+	if err := r.broadcaster.Publish(r.name, msg); err != nil {
+		log.Error("failed to publish message", "room", r.name, "type", msg.Type, "error", err)
+	}
+}
+
+// publishPresence publishes the current presence of a user
+func (r *RoomActor) publishPresence(username string) {
+	presence := r.member(r.presence.get(username))
+	r.publish(&ws.Message{
+		Type:    ws.TypePresenceUpdate,
+		Payload: &presence,
+	})
+}
+
+// broadcastMessage sends a message to every client connected to this room
 func (r *RoomActor) broadcastMessage(ctx *actor.Context, msg *ws.Message) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	start := time.Now()
+	defer func() {
+		metrics.BroadcastDuration.WithLabelValues(r.name).Observe(time.Since(start).Seconds())
+	}()
+
 	log.Debug("broadcasting message",
 		"type", msg.Type,
 		"total_clients", len(r.clients))
 
 	for pid, client := range r.clients {
-		ctx.Engine().Send(client, msg)
+		SendWS(ctx.Engine(), client, msg)
 		log.Debug("sent message to client", "pid", pid, "type", msg.Type)
 	}
 }
 
-func (r *RoomActor) periodicCleanup(ctx *actor.Context) {
+func (r *RoomActor) periodicCleanup(engine *actor.Engine) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+
 		r.mu.Lock()
 		initialCount := len(r.clients)
 
 		// Verify each client is still alive
 		for pid, client := range r.clients {
-			ctx.Engine().Send(client, &ws.Message{Type: ws.TypePing})
+			SendWS(engine, client, &ws.Message{Type: ws.TypePing})
 			log.Debug("sent ping to client", "pid", pid)
 		}
 
@@ -0,0 +1,294 @@
+package actors
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// stored returns the message with id as the room saved it to history
+func stored(t *testing.T, history store.Store, query, id string) ws.TextMessage {
+	t.Helper()
+	results, err := history.SearchMessages(api.SearchMessagesRequest{Room: DefaultRoom, Query: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results.Results {
+		if result.Message.ID == id {
+			return result.Message
+		}
+	}
+	t.Fatalf("message %s was not saved", id)
+	return ws.TextMessage{}
+}
+
+// synced returns the message with id from the history sent to a client
+// joining the room
+func synced(t *testing.T, room *testRoom, id string) ws.TextMessage {
+	t.Helper()
+	c := room.connect("observer")
+	room.engine.Send(room.pid, &ClientJoined{ClientPID: c.pid, Username: c.username})
+	defer c.leave()
+	for _, msg := range c.expect(ws.TypeSync).Payload.(*ws.SyncMessage).Messages {
+		if msg.ID == id {
+			return msg
+		}
+	}
+	t.Fatalf("message %s was not synced", id)
+	return ws.TextMessage{}
+}
+
+// TestReactRoundTrip checks that reactions are added and removed on the
+// message they react to, for the room's clients and in the store
+func TestReactRoundTrip(t *testing.T) {
+	history := store.NewMemory()
+	room := newTestRoom(t, history)
+	alice, bob := room.join("alice"), room.join("bob")
+	id := alice.post("ship it")
+
+	react := func(c *testClient, typ ws.MessageType, emoji string) {
+		t.Helper()
+		c.send(&ws.Message{Type: typ, Payload: &ws.ReactionMessage{ID: id, Emoji: emoji}})
+		got := alice.expect(typ).Payload.(*ws.ReactionMessage)
+		if got.ID != id || got.Emoji != emoji || got.From != c.username {
+			t.Errorf("%s delivered as %+v", typ, got)
+		}
+	}
+	react(bob, ws.TypeReact, "👍")
+	react(alice, ws.TypeReact, "👍")
+	react(alice, ws.TypeReact, "🎉")
+	want := []ws.Reaction{
+		{Emoji: "👍", Count: 2, Users: []string{"bob", "alice"}},
+		{Emoji: "🎉", Count: 1, Users: []string{"alice"}},
+	}
+	if got := synced(t, room, id).Reactions; !reflect.DeepEqual(got, want) {
+		t.Errorf("synced reactions = %+v; want %+v", got, want)
+	}
+	if got := stored(t, history, "ship", id).Reactions; !reflect.DeepEqual(got, want) {
+		t.Errorf("saved reactions = %+v; want %+v", got, want)
+	}
+
+	react(bob, ws.TypeUnreact, "👍")
+	react(alice, ws.TypeUnreact, "🎉")
+	want = []ws.Reaction{{Emoji: "👍", Count: 1, Users: []string{"alice"}}}
+	if got := synced(t, room, id).Reactions; !reflect.DeepEqual(got, want) {
+		t.Errorf("synced reactions after removing = %+v; want %+v", got, want)
+	}
+	if got := stored(t, history, "ship", id).Reactions; !reflect.DeepEqual(got, want) {
+		t.Errorf("saved reactions after removing = %+v; want %+v", got, want)
+	}
+
+	// Reactions to messages that are not there are refused
+	bob.send(&ws.Message{Type: ws.TypeReact, Payload: &ws.ReactionMessage{ID: "missing", Emoji: "👍"}})
+	if got := bob.expect(ws.TypeError).Payload.(*ws.ErrorMessage).Error; got != "message not found" {
+		t.Errorf("reaction to a missing message rejected with %q", got)
+	}
+}
+
+// texts collects the next n chat messages sent to the client
+func (c *testClient) texts(n int) []ws.TextMessage {
+	c.t.Helper()
+	var texts []ws.TextMessage
+	for len(texts) < n {
+		msg := c.expect(ws.TypeMessage)
+		texts = append(texts, *msg.Payload.(*ws.TextMessage))
+	}
+	return texts
+}
+
+// TestMessageOrdering checks that the room gives every chat message a
+// unique ID, a server timestamp and the next Seq, whatever the client
+// sent, and that every client sees the same order
+func TestMessageOrdering(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+	alice, bob := room.join("alice"), room.join("bob")
+
+	start := time.Now().UnixMilli()
+	const n = 20
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range n / 2 {
+			bob.send(&ws.Message{Type: ws.TypeMessage, Payload: &ws.TextMessage{From: "bob", Text: fmt.Sprint("bob ", i)}})
+		}
+	}()
+	for i := range n / 2 {
+		alice.send(&ws.Message{Type: ws.TypeMessage, Payload: &ws.TextMessage{
+			From:      "alice",
+			Text:      fmt.Sprint("alice ", i),
+			ID:        "forged",
+			Seq:       999,
+			Timestamp: 1,
+		}})
+	}
+	<-done
+
+	seen, bobSaw := alice.texts(n), bob.texts(n)
+	if !reflect.DeepEqual(seen, bobSaw) {
+		t.Errorf("alice and bob saw different messages:\n%+v\n%+v", seen, bobSaw)
+	}
+	ids := make(map[string]bool)
+	next := map[string]int{}
+	for i, msg := range seen {
+		if msg.Seq != uint64(i+1) {
+			t.Errorf("message %d has Seq %d", i+1, msg.Seq)
+		}
+		if _, err := ulid.ParseStrict(msg.ID); err != nil || ids[msg.ID] {
+			t.Errorf("message %d has ID %q; want a new ULID", i+1, msg.ID)
+		}
+		ids[msg.ID] = true
+		if msg.Timestamp < start || msg.Timestamp > time.Now().UnixMilli() {
+			t.Errorf("message %d stamped %d; want the time it was sent", i+1, msg.Timestamp)
+		}
+		if i > 0 && msg.Timestamp < seen[i-1].Timestamp {
+			t.Errorf("message %d stamped before the one it follows", i+1)
+		}
+		// Each sender's messages keep the order they were sent in
+		if want := fmt.Sprint(msg.From, " ", next[msg.From]); msg.Text != want {
+			t.Errorf("message %d is %q; want %q", i+1, msg.Text, want)
+		}
+		next[msg.From]++
+	}
+}
+
+// TestEditAndDelete checks that messages are changed by their author or a
+// moderator only, and that the text they replace is kept as a revision
+func TestEditAndDelete(t *testing.T) {
+	history := store.NewMemory()
+	if err := history.SetRole(DefaultRoom, "carol", api.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	room := newTestRoom(t, history)
+	alice, bob, carol := room.join("alice"), room.join("bob"), room.join("carol")
+	id := alice.post("helo wrold")
+
+	rejected := func(c *testClient, msg *ws.Message, want string) {
+		t.Helper()
+		c.send(msg)
+		if got := c.expect(ws.TypeError).Payload.(*ws.ErrorMessage).Error; got != want {
+			t.Errorf("%s by %s rejected with %q; want %q", msg.Type, c.username, got, want)
+		}
+	}
+	edit := func(text string) *ws.Message {
+		return &ws.Message{Type: ws.TypeEdit, Payload: &ws.EditMessage{ID: id, Text: text}}
+	}
+	rejected(bob, edit("hijacked"), "only the author or a moderator can change this message")
+	rejected(alice, edit("  "), "message is empty")
+
+	alice.send(edit("hello world"))
+	if got := bob.expect(ws.TypeEdit).Payload.(*ws.EditMessage); got.ID != id || got.Text != "hello world" || got.EditedBy != "alice" || got.EditedAt == 0 {
+		t.Errorf("EDIT delivered as %+v", got)
+	}
+	if got := synced(t, room, id); got.Text != "hello world" || got.EditedAt == 0 {
+		t.Errorf("synced message after editing = %+v", got)
+	}
+	if got := stored(t, history, "world", id); got.Text != "hello world" {
+		t.Errorf("saved message after editing = %+v", got)
+	}
+	if results, _ := history.SearchMessages(api.SearchMessagesRequest{Query: "wrold"}); results.Total != 0 {
+		t.Errorf("the edited out text is still found: %+v", results)
+	}
+
+	// A moderator deletes it; that is audited, as it is not theirs
+	carol.send(&ws.Message{Type: ws.TypeDelete, Payload: &ws.DeleteMessage{ID: id}})
+	if got := bob.expect(ws.TypeDelete).Payload.(*ws.DeleteMessage); got.ID != id || got.DeletedBy != "carol" || got.DeletedAt == 0 {
+		t.Errorf("DELETE delivered as %+v", got)
+	}
+	if got := synced(t, room, id); !got.Deleted || got.Text != "" {
+		t.Errorf("synced message after deleting = %+v; want a tombstone", got)
+	}
+	rejected(alice, edit("too late"), "message not found")
+
+	revisions, err := history.Revisions(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 ||
+		revisions[0].Text != "helo wrold" || revisions[0].ChangedBy != "alice" || revisions[0].Deleted ||
+		revisions[1].Text != "hello world" || revisions[1].ChangedBy != "carol" || !revisions[1].Deleted {
+		t.Errorf("revisions = %+v; want alice's edit and carol's deletion", revisions)
+	}
+	entries, err := history.AuditLog(DefaultRoom, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != api.AuditDelete || entries[0].Actor != "carol" || entries[0].Target != "alice" {
+		t.Errorf("audit log = %+v; want carol deleting alice's message", entries)
+	}
+}
+
+// replies asks the room for the replies to parentID like the REST route
+// does, returning the status it failed with or the replies
+func replies(t *testing.T, room *testRoom, parentID string, before uint64, limit int32) (api.RepliesResponse, int32) {
+	t.Helper()
+	res, err := room.engine.Request(room.pid, &RepliesRequest{ParentID: parentID, Before: before, Limit: limit}, testTimeout).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := res.(*APIResponse)
+	if response.Error != "" {
+		return api.RepliesResponse{}, response.Status
+	}
+	var replies api.RepliesResponse
+	if err := json.Unmarshal(response.Data, &replies); err != nil {
+		t.Fatal(err)
+	}
+	return replies, 0
+}
+
+// TestThreads checks that replies are counted on their root message, live
+// and in history, and are paged through newest first
+func TestThreads(t *testing.T) {
+	history := store.NewMemory()
+	room := newTestRoom(t, history)
+	alice, bob := room.join("alice"), room.join("bob")
+	root := alice.post("release notes?")
+
+	var seqs []uint64
+	for i, text := range []string{"draft is up", "looks good", "shipped"} {
+		if _, reason := bob.sayTo(text, root); reason != "" {
+			t.Fatalf("%q rejected: %s", text, reason)
+		}
+		reply := alice.expectFunc(text, func(msg *ws.Message) bool {
+			text, ok := msg.Payload.(*ws.TextMessage)
+			return ok && text.ParentID == root
+		}).Payload.(*ws.TextMessage)
+		seqs = append(seqs, reply.Seq)
+
+		update := alice.expect(ws.TypeThread).Payload.(*ws.ThreadUpdate)
+		if update.ParentID != root || update.ReplyCount != i+1 || update.LastReplyAt != reply.Timestamp {
+			t.Errorf("THREAD after %q = %+v; want %d replies, the last at %d", text, update, i+1, reply.Timestamp)
+		}
+	}
+	if got := synced(t, room, root); got.ReplyCount != 3 || got.LastReplyAt == 0 {
+		t.Errorf("synced root = %+v; want 3 replies", got)
+	}
+	if got := stored(t, history, "release", root); got.ReplyCount != 3 {
+		t.Errorf("saved root = %+v; want 3 replies", got)
+	}
+
+	page, status := replies(t, room, root, 0, 2)
+	if status != 0 || page.Parent.ID != root || !page.HasMore || len(page.Replies) != 2 ||
+		page.Replies[0].Text != "looks good" || page.Replies[1].Text != "shipped" {
+		t.Errorf("latest replies = %+v, %d; want the last two and more", page, status)
+	}
+	page, _ = replies(t, room, root, seqs[1], 2)
+	if page.HasMore || len(page.Replies) != 1 || page.Replies[0].Text != "draft is up" {
+		t.Errorf("replies before %d = %+v; want the first one only", seqs[1], page)
+	}
+
+	// Only root messages have replies
+	for _, id := range []string{"missing", page.Replies[0].ID} {
+		if _, status := replies(t, room, id, 0, 10); status != http.StatusNotFound {
+			t.Errorf("replies to %s answered with %d; want 404", id, status)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package actors
+
+import (
+	"go-chat/internal/broadcast"
+	"go-chat/internal/metrics"
+	"go-chat/internal/store"
+	"go-chat/internal/unfurl"
+	"sync"
+
+	"github.com/anthdm/hollywood/actor"
+)
+
+// Rooms locates room actors by name, spawning them on first use
+type Rooms interface {
+	// Lookup returns the PID of the named room and a channel that is closed
+	// once that PID stops being valid, e.g. because the node hosting the
+	// room left the cluster. Clients of the room should then reconnect.
+	Lookup(name string) (*actor.PID, <-chan struct{}, error)
+}
+
+// LocalRooms spawns every room on the local engine
+type LocalRooms struct {
+	engine      *actor.Engine
+	broadcaster broadcast.Broadcaster
+	store       store.Store
+	users       Users
+	unfurler    *unfurl.Client
+
+	mu   sync.Mutex
+	pids map[string]*actor.PID
+}
+
+// NewLocalRooms creates a Rooms whose actors live in this process. With a
+// message bus broadcaster, each instance runs its own actor per room and
+// the bus joins them into one room. Rooms send mentions through the
+// registry of users and preview links with unfurler, if not nil.
+func NewLocalRooms(engine *actor.Engine, broadcaster broadcast.Broadcaster, store store.Store, users Users, unfurler *unfurl.Client) *LocalRooms {
+	return &LocalRooms{
+		engine:      engine,
+		broadcaster: broadcaster,
+		store:       store,
+		users:       users,
+		unfurler:    unfurler,
+		pids:        make(map[string]*actor.PID),
+	}
+}
+
+// Lookup implements Rooms. Local rooms live as long as the process, so the
+// returned channel is never closed.
+func (r *LocalRooms) Lookup(name string) (*actor.PID, <-chan struct{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pid, ok := r.pids[name]
+	if !ok {
+		producer := metrics.InstrumentActor(string(TypeRoom), NewRoom(r.broadcaster, r.store, r.users, r.unfurler))
+		pid = r.engine.Spawn(producer, string(TypeRoom), actor.WithID(name))
+		r.pids[name] = pid
+	}
+	return pid, nil, nil
+}
@@ -1,25 +1,20 @@
 package actors
 
-import (
-	"github.com/anthdm/hollywood/actor"
-)
-
 // Type represents different types of actors
 type Type string
 
 const (
-	TypeClient Type = "client"
-	TypeRoom   Type = "room"
+	TypeClient   Type = "client"
+	TypeRoom     Type = "room"
+	TypeRegistry Type = "registry"
 )
 
-// ClientJoined is sent when a new client joins the room
-type ClientJoined struct {
-	ClientPID *actor.PID
-	Username  string
-}
+// DefaultRoom is the name of the room every client joins
+const DefaultRoom = "general"
+
+// RegistryID is the ID of the single registry actor
+const RegistryID = "users"
 
-// ClientLeft is sent when a client leaves the room
-type ClientLeft struct {
-	ClientPID *actor.PID
-	Username  string
-}
+// The messages exchanged between actors (ClientJoined, ClientLeft,
+// HealthCheck, RoomStatus, WSFrame, SetPresence and the REST requests) are
+// defined in messages.proto so they can cross cluster nodes.
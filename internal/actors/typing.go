@@ -0,0 +1,61 @@
+package actors
+
+import (
+	"go-chat/shared/ws"
+	"sort"
+	"time"
+)
+
+// typing tracks the users typing in a room until they stop, send a
+// message, leave, or send no TYPING for ws.TypingTimeout. It is only used
+// from the room's Receive.
+type typing struct {
+	deadlines map[string]time.Time // username → when they stop typing
+}
+
+func newTyping() *typing {
+	return &typing{deadlines: make(map[string]time.Time)}
+}
+
+// start marks username typing for another ws.TypingTimeout and reports
+// whether they just started
+func (t *typing) start(username string, now time.Time) bool {
+	_, typing := t.deadlines[username]
+	t.deadlines[username] = now.Add(ws.TypingTimeout)
+	return !typing
+}
+
+// stop reports whether username was typing
+func (t *typing) stop(username string) bool {
+	_, typing := t.deadlines[username]
+	delete(t.deadlines, username)
+	return typing
+}
+
+// expire stops and returns the users whose deadline passed
+func (t *typing) expire(now time.Time) []string {
+	var expired []string
+	for username, deadline := range t.deadlines {
+		if !now.Before(deadline) {
+			expired = append(expired, username)
+			delete(t.deadlines, username)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// idle reports whether nobody is typing
+func (t *typing) idle() bool {
+	return len(t.deadlines) == 0
+}
+
+// users returns the users typing, ordered by username
+func (t *typing) users() []string {
+	users := make([]string, 0, len(t.deadlines))
+	for username := range t.deadlines {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	return users
+}
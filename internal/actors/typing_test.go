@@ -0,0 +1,80 @@
+package actors
+
+import (
+	"go-chat/internal/store"
+	"go-chat/shared/ws"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTypingDeadlines checks that users count as typing until they stop
+// or ws.TypingTimeout passed since they last typed
+func TestTypingDeadlines(t *testing.T) {
+	typing := newTyping()
+	now := time.Unix(1_700_000_000, 0)
+
+	if !typing.start("bob", now) || !typing.start("alice", now) {
+		t.Error("start of new typists = false")
+	}
+	if typing.start("bob", now.Add(ws.TypingTimeout/2)) {
+		t.Error("start of bob again = true")
+	}
+	if got := typing.users(); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("users = %v; want alice and bob", got)
+	}
+
+	// Typing again moved bob's deadline
+	if got := typing.expire(now.Add(ws.TypingTimeout)); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("expired after the timeout = %v; want alice", got)
+	}
+	if typing.idle() {
+		t.Error("idle while bob types")
+	}
+	if !typing.stop("bob") || typing.stop("bob") {
+		t.Error("stop of bob did not report once that bob typed")
+	}
+	if !typing.idle() {
+		t.Errorf("not idle with %v typing", typing.users())
+	}
+}
+
+// TestRoomTyping checks that the room publishes when a user starts and
+// stops typing, and not every TYPING they send
+func TestRoomTyping(t *testing.T) {
+	room := newTestRoom(t, store.NewMemory())
+	alice, bob := room.join("alice"), room.join("bob")
+	isTyping := func(want bool) {
+		t.Helper()
+		typing := alice.expect(ws.TypeTyping).Payload.(*ws.TypingMessage)
+		if typing.From != "bob" || typing.IsTyping != want {
+			t.Errorf("TYPING = %+v; want bob typing %v", typing, want)
+		}
+	}
+	typing := func(isTyping bool) {
+		// Clients cannot type for others
+		bob.send(&ws.Message{Type: ws.TypeTyping, Payload: &ws.TypingMessage{From: "alice", IsTyping: isTyping}})
+	}
+
+	// Sending a message stops typing
+	typing(true)
+	typing(true)
+	isTyping(true)
+	bob.post("hi")
+	isTyping(false)
+
+	typing(true)
+	isTyping(true)
+	typing(false)
+	isTyping(false)
+
+	// Who types is sent to those joining, and stops when they leave
+	typing(true)
+	isTyping(true)
+	carol := room.join("carol")
+	if typing := carol.expect(ws.TypeTyping).Payload.(*ws.TypingMessage); typing.From != "bob" || !typing.IsTyping {
+		t.Errorf("TYPING sent on join = %+v; want bob typing", typing)
+	}
+	bob.leave()
+	isTyping(false)
+}
@@ -0,0 +1,48 @@
+// Package blob stores the contents of uploaded files, either on the local
+// filesystem or in an S3-compatible object store.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// ErrNotFound is returned when a key has no blob
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned for keys that are not slash separated,
+// unrooted paths without . or .. elements
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store keeps blobs addressed by key. Keys are slash separated paths such
+// as "attachments/01H...". Implementations must be safe for concurrent use.
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any blob
+	// already stored there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get opens the blob stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (*Object, error)
+
+	// Delete removes the blob stored under key. Deleting a missing blob is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is an open blob
+type Object struct {
+	io.ReadCloser
+	ContentType string
+	Size        int64 // Bytes
+}
+
+// validKey checks that key cannot escape the root of a store
+func validKey(key string) error {
+	if !fs.ValidPath(key) || key == "." || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local is a Store keeping blobs as files below a directory. Each blob's
+// content type is kept in a file of the same name in a separate tree.
+type Local struct {
+	dir string
+}
+
+// NewLocal creates a Store in dir, creating the directory if needed
+func NewLocal(dir string) (*Local, error) {
+	for _, sub := range []string{"blobs", "types", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create blob directory: %w", err)
+		}
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) path(tree, key string) string {
+	return filepath.Join(l.dir, tree, filepath.FromSlash(key))
+}
+
+// Put implements Store. Blobs are written to a temporary file first, so
+// readers never see a partial blob.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if err := l.write(l.path("types", key), strings.NewReader(contentType), int64(len(contentType))); err != nil {
+		return err
+	}
+	return l.write(l.path("blobs", key), r, size)
+}
+
+// write atomically replaces the file at path with size bytes read from r
+func (l *Local) write(path string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Join(l.dir, "tmp"), "put-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err := io.CopyN(tmp, r, size); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	return nil
+}
+
+// Get implements Store
+func (l *Local) Get(ctx context.Context, key string) (*Object, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(l.path("blobs", key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+
+	contentType := "application/octet-stream"
+	if data, err := os.ReadFile(l.path("types", key)); err == nil && len(data) > 0 {
+		contentType = string(data)
+	}
+	return &Object{ReadCloser: f, ContentType: contentType, Size: info.Size()}, nil
+}
+
+// Delete implements Store
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	for _, tree := range []string{"blobs", "types"} {
+		if err := os.Remove(l.path(tree, key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete blob: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Package broadcast fans room messages out to subscribers, either within
+// this process or across server instances through a message bus.
+package broadcast
+
+import (
+	"go-chat/shared/ws"
+	"sync"
+)
+
+// Broadcaster delivers messages published to a room to every subscriber of
+// that room
+type Broadcaster interface {
+	// Publish sends msg to every subscriber of room. With a message bus
+	// this includes subscribers in other server instances.
+	Publish(room string, msg *ws.Message) error
+
+	// Subscribe calls deliver for every message published to room until
+	// unsubscribe is called. deliver may be called from any goroutine.
+	Subscribe(room string, deliver func(*ws.Message)) (unsubscribe func(), err error)
+}
+
+// Local is an in-process Broadcaster. Every instance using it has its own,
+// disjoint set of rooms.
+type Local struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[string]map[int]func(*ws.Message)
+}
+
+// NewLocal creates an in-process Broadcaster
+func NewLocal() *Local {
+	return &Local{
+		subs: make(map[string]map[int]func(*ws.Message)),
+	}
+}
+
+// Publish implements Broadcaster. Subscribers are called synchronously.
+func (l *Local) Publish(room string, msg *ws.Message) error {
+	l.mu.RLock()
+	subs := make([]func(*ws.Message), 0, len(l.subs[room]))
+	for _, deliver := range l.subs[room] {
+		subs = append(subs, deliver)
+	}
+	l.mu.RUnlock()
+
+	for _, deliver := range subs {
+		deliver(msg)
+	}
+	return nil
+}
+
+// Subscribe implements Broadcaster
+func (l *Local) Subscribe(room string, deliver func(*ws.Message)) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nextID++
+	id := l.nextID
+	if l.subs[room] == nil {
+		l.subs[room] = make(map[int]func(*ws.Message))
+	}
+	l.subs[room][id] = deliver
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs[room], id)
+		if len(l.subs[room]) == 0 {
+			delete(l.subs, room)
+		}
+	}, nil
+}
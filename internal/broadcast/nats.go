@@ -0,0 +1,80 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-chat/shared/ws"
+
+	"github.com/charmbracelet/log"
+	"github.com/nats-io/nats.go"
+)
+
+// subjectPrefix namespaces room subjects on the bus
+const subjectPrefix = "chat.room."
+
+// NATS is a Broadcaster backed by a NATS server, so every instance
+// connected to the same server shares its rooms
+type NATS struct {
+	conn *nats.Conn
+}
+
+// NewNATS connects to the NATS server at url. The connection reconnects
+// indefinitely if the server goes away.
+func NewNATS(url string) (*NATS, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("go-chat"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Warn("disconnected from NATS", "error", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Info("reconnected to NATS", "url", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS at %s: %w", url, err)
+	}
+	return &NATS{conn: conn}, nil
+}
+
+// Publish implements Broadcaster
+func (n *NATS) Publish(room string, msg *ws.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return n.conn.Publish(subjectPrefix+room, data)
+}
+
+// Subscribe implements Broadcaster. deliver is called from the NATS
+// client's goroutine for the subscription.
+func (n *NATS) Subscribe(room string, deliver func(*ws.Message)) (func(), error) {
+	sub, err := n.conn.Subscribe(subjectPrefix+room, func(m *nats.Msg) {
+		var msg ws.Message
+		if err := json.Unmarshal(m.Data, &msg); err != nil {
+			log.Error("failed to decode message from NATS", "subject", m.Subject, "error", err)
+			return
+		}
+		deliver(&msg)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to room %q: %w", room, err)
+	}
+
+	return func() {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Error("failed to unsubscribe from NATS", "subject", sub.Subject, "error", err)
+		}
+	}, nil
+}
+
+// Check round-trips to the NATS server, for readiness checks
+func (n *NATS) Check(ctx context.Context) error {
+	return n.conn.FlushWithContext(ctx)
+}
+
+// Close drains subscriptions and closes the connection
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,126 @@
+package broadcast
+
+import (
+	"go-chat/shared/ws"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats-server/v2/test"
+)
+
+// runServer starts an embedded NATS server on port, or any free port when
+// it is -1
+func runServer(t *testing.T, port int) *server.Server {
+	t.Helper()
+	opts := test.DefaultTestOptions
+	opts.Port = port
+	s := test.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// newBroadcaster connects a NATS broadcaster to s
+func newBroadcaster(t *testing.T, s *server.Server) *NATS {
+	t.Helper()
+	n, err := NewNATS(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// subscribe subscribes to room and returns the channel messages arrive on
+func subscribe(t *testing.T, n *NATS, room string) <-chan *ws.Message {
+	t.Helper()
+	received := make(chan *ws.Message, 16)
+	unsubscribe, err := n.Subscribe(room, func(msg *ws.Message) {
+		received <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(unsubscribe)
+	// The server knows of the subscription once it answered a flush
+	if err := n.conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+// expectText waits for a chat message with text
+func expectText(t *testing.T, received <-chan *ws.Message, text string) {
+	t.Helper()
+	select {
+	case msg := <-received:
+		payload, ok := msg.Payload.(*ws.TextMessage)
+		if !ok || payload.Text != text {
+			t.Fatalf("received %s %+v; want a message saying %q", msg.Type, msg.Payload, text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message saying %q arrived", text)
+	}
+}
+
+func publishText(t *testing.T, n *NATS, room, text string) {
+	t.Helper()
+	err := n.Publish(room, &ws.Message{
+		Type:    ws.TypeMessage,
+		Payload: &ws.TextMessage{ID: text, From: "alice", Text: text},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNATSAcrossBroadcasters checks that a message published by one
+// instance reaches the subscribers of the room on another, and no other
+// room
+func TestNATSAcrossBroadcasters(t *testing.T) {
+	s := runServer(t, -1)
+	a, b := newBroadcaster(t, s), newBroadcaster(t, s)
+
+	general := subscribe(t, b, "general")
+	random := subscribe(t, b, "random")
+
+	publishText(t, a, "general", "hello")
+	expectText(t, general, "hello")
+
+	// Messages of a room arrive in order, so had the other room's message
+	// reached general, it would come before the next one
+	publishText(t, a, "random", "elsewhere")
+	expectText(t, random, "elsewhere")
+	publishText(t, a, "general", "again")
+	expectText(t, general, "again")
+}
+
+// TestNATSReconnect checks that broadcasters keep their subscriptions
+// when the NATS server restarts
+func TestNATSReconnect(t *testing.T) {
+	s := runServer(t, -1)
+	a, b := newBroadcaster(t, s), newBroadcaster(t, s)
+	received := subscribe(t, b, "general")
+
+	port := s.Addr().(*net.TCPAddr).Port
+	s.Shutdown()
+	s.WaitForShutdown()
+	runServer(t, port)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !a.conn.IsConnected() || !b.conn.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatal("broadcasters did not reconnect")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Subscriptions are sent again before the connection counts as
+	// connected, so they are known once the server answers a flush
+	if err := b.conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	publishText(t, a, "general", "back")
+	expectText(t, received, "back")
+}
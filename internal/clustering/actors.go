@@ -0,0 +1,125 @@
+package clustering
+
+import (
+	"fmt"
+	"go-chat/internal/actors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/anthdm/hollywood/cluster"
+	"github.com/charmbracelet/log"
+)
+
+// Actors places every room, and the user registry, on exactly one cluster
+// member and lets clients on any member reach them by PID
+type Actors struct {
+	cluster *cluster.Cluster
+
+	mu     sync.Mutex
+	actors map[string]*clusterActor // keyed by kind/ID
+}
+
+type clusterActor struct {
+	pid  *actor.PID
+	gone chan struct{}
+}
+
+func newActors(c *cluster.Cluster) *Actors {
+	a := &Actors{
+		cluster: c,
+		actors:  make(map[string]*clusterActor),
+	}
+	eventPID := c.Engine().SpawnFunc(a.handleEvent, "activations")
+	c.Engine().Subscribe(eventPID)
+	return a
+}
+
+// Lookup implements actors.Rooms
+func (a *Actors) Lookup(name string) (*actor.PID, <-chan struct{}, error) {
+	return a.locate(actors.TypeRoom, name)
+}
+
+// Registry implements actors.Users
+func (a *Actors) Registry() (*actor.PID, <-chan struct{}, error) {
+	return a.locate(actors.TypeRegistry, actors.RegistryID)
+}
+
+// locate returns the actor of the given kind and ID. An actor already
+// active anywhere in the cluster is reused; otherwise it is activated on
+// the member chosen by ownerOf, so members racing to activate the same
+// actor pick the same one.
+func (a *Actors) locate(kind actors.Type, id string) (*actor.PID, <-chan struct{}, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := string(kind) + "/" + id
+	if located, ok := a.actors[key]; ok {
+		return located.pid, located.gone, nil
+	}
+
+	pid := a.cluster.GetActivated(key)
+	if pid == nil {
+		config := cluster.NewActivationConfig().
+			WithID(id).
+			WithSelectMemberFunc(ownerOf(key))
+		pid = a.cluster.Activate(string(kind), config)
+	}
+	if pid == nil {
+		return nil, nil, fmt.Errorf("failed to activate %s %q", kind, id)
+	}
+
+	log.Info("actor located", "kind", kind, "id", id, "pid", pid)
+	located := &clusterActor{pid: pid, gone: make(chan struct{})}
+	a.actors[key] = located
+	return located.pid, located.gone, nil
+}
+
+// ownerOf picks the member hosting an actor by rendezvous hashing, which
+// spreads actors over members and only moves the actors of a member that
+// left
+func ownerOf(key string) cluster.SelectMemberFunc {
+	return func(details cluster.ActivationDetails) *cluster.Member {
+		var (
+			owner *cluster.Member
+			best  uint64
+		)
+		for _, member := range details.Members {
+			h := fnv.New64a()
+			h.Write([]byte(key))
+			h.Write([]byte{0})
+			h.Write([]byte(member.ID))
+			if score := h.Sum64(); owner == nil || score > best {
+				owner, best = member, score
+			}
+		}
+		return owner
+	}
+}
+
+// handleEvent forgets actors whose member left or became unreachable, and
+// closes their gone channels so connected clients move to the actor's new
+// home
+func (a *Actors) handleEvent(ctx *actor.Context) {
+	switch msg := ctx.Message().(type) {
+	case cluster.MemberLeaveEvent:
+		a.forget(func(pid *actor.PID) bool { return pid.Address == msg.Member.Host })
+	case cluster.DeactivationEvent:
+		a.forget(func(pid *actor.PID) bool { return pid.Equals(msg.PID) })
+	case actor.RemoteUnreachableEvent:
+		a.forget(func(pid *actor.PID) bool { return pid.Address == msg.ListenAddr })
+	}
+}
+
+func (a *Actors) forget(match func(*actor.PID) bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, located := range a.actors {
+		if match(located.pid) {
+			log.Warn("actor lost its host", "actor", key, "pid", located.pid)
+			close(located.gone)
+			delete(a.actors, key)
+		}
+	}
+}
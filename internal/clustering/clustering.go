@@ -0,0 +1,96 @@
+package clustering
+
+import (
+	"fmt"
+	"go-chat/internal/actors"
+	"go-chat/internal/broadcast"
+	"go-chat/internal/metrics"
+	"go-chat/internal/store"
+	"go-chat/internal/unfurl"
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/anthdm/hollywood/cluster"
+	"github.com/anthdm/hollywood/remote"
+	"github.com/charmbracelet/log"
+)
+
+// Start creates an actor engine reachable by other members, joins the
+// cluster and returns the engine together with the cluster-wide Actors.
+// Rooms and the user registry keep their history in store, and rooms
+// preview links with unfurler, if not nil.
+func Start(config Config, store store.Store, unfurler *unfurl.Client) (*actor.Engine, *Actors, error) {
+	config = config.withDefaults()
+
+	r := remote.New(config.ListenAddr, remote.NewConfig())
+	engine, err := actor.NewEngine(actor.NewEngineConfig().WithRemote(r))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var provider cluster.Producer
+	switch config.Discovery {
+	case DiscoveryStatic:
+		provider = newStaticProvider(config.Peers)
+	case DiscoveryMDNS:
+		providerConfig := cluster.NewSelfManagedConfig()
+		for _, peer := range config.Peers {
+			if peer.ID != config.ID {
+				providerConfig = providerConfig.WithBootstrapMember(peer)
+			}
+		}
+		provider = cluster.NewSelfManagedProvider(providerConfig)
+	default:
+		return nil, nil, fmt.Errorf("unknown cluster discovery %q", config.Discovery)
+	}
+
+	c, err := cluster.New(cluster.NewConfig().
+		WithEngine(engine).
+		WithID(config.ID).
+		WithListenAddr(config.ListenAddr).
+		WithProvider(provider))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Each room, and the registry, lives on one member, which reaches
+	// clients on other members through remoting, so fan-out stays
+	// in-process. Rooms find the registry through the cluster too.
+	clusterActors := newActors(c)
+	room := actors.NewRoom(broadcast.NewLocal(), store, clusterActors, unfurler)
+	c.RegisterKind(string(actors.TypeRoom), metrics.InstrumentActor(string(actors.TypeRoom), room), cluster.NewKindConfig())
+	registry := actors.NewRegistry(broadcast.NewLocal(), store)
+	c.RegisterKind(string(actors.TypeRegistry), metrics.InstrumentActor(string(actors.TypeRegistry), registry), cluster.NewKindConfig())
+	c.Start()
+
+	log.Info("Cluster member started", "id", config.ID, "addr", config.ListenAddr, "discovery", config.Discovery)
+	waitForPeers(c, config)
+
+	return engine, clusterActors, nil
+}
+
+// waitForPeers gives the configured peers a chance to join before rooms are
+// activated, so members starting together agree on where rooms live
+func waitForPeers(c *cluster.Cluster, config Config) {
+	deadline := time.Now().Add(config.JoinTimeout)
+	for {
+		joined := make(map[string]bool)
+		for _, member := range c.Members() {
+			joined[member.ID] = true
+		}
+
+		var missing []string
+		for _, peer := range config.Peers {
+			if peer.ID != config.ID && !joined[peer.ID] {
+				missing = append(missing, peer.ID)
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Warn("starting without some cluster peers", "missing", missing)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
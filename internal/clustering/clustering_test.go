@@ -0,0 +1,108 @@
+package clustering
+
+import (
+	"fmt"
+	"go-chat/internal/actors"
+	"go-chat/internal/store"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/anthdm/hollywood/cluster"
+)
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers(" a@127.0.0.1:4000, ,127.0.0.1:4001,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cluster.MemberAddr{
+		{ID: "a", ListenAddr: "127.0.0.1:4000"},
+		{ID: "127.0.0.1:4001", ListenAddr: "127.0.0.1:4001"},
+	}
+	if !reflect.DeepEqual(peers, want) {
+		t.Errorf("ParsePeers = %+v; want %+v", peers, want)
+	}
+
+	for _, s := range []string{"@127.0.0.1:4000", "a@"} {
+		if _, err := ParsePeers(s); err == nil {
+			t.Errorf("ParsePeers(%q) succeeded", s)
+		}
+	}
+}
+
+// TestOwnerOf checks that every member agrees on where an actor lives,
+// and that only the actors of a member that left move
+func TestOwnerOf(t *testing.T) {
+	a, b, c := &cluster.Member{ID: "a"}, &cluster.Member{ID: "b"}, &cluster.Member{ID: "c"}
+	owned := make(map[*cluster.Member]int)
+	for i := range 300 {
+		key := fmt.Sprintf("room/%d", i)
+		owner := ownerOf(key)(cluster.ActivationDetails{Members: []*cluster.Member{a, b, c}})
+		if other := ownerOf(key)(cluster.ActivationDetails{Members: []*cluster.Member{c, a, b}}); other != owner {
+			t.Fatalf("%s is owned by %s or %s, depending on the order of members", key, owner.ID, other.ID)
+		}
+		owned[owner]++
+
+		after := ownerOf(key)(cluster.ActivationDetails{Members: []*cluster.Member{a, b}})
+		if owner != c && after != owner {
+			t.Errorf("%s moved from %s to %s when c left", key, owner.ID, after.ID)
+		}
+	}
+	for _, member := range []*cluster.Member{a, b, c} {
+		if owned[member] < 50 {
+			t.Errorf("%s owns %d of 300 actors", member.ID, owned[member])
+		}
+	}
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// TestStart checks that members started with each other as peers find
+// the same room and registry
+func TestStart(t *testing.T) {
+	peers, err := ParsePeers(freeAddr(t) + "," + freeAddr(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Members wait for each other, so they start together
+	history := store.NewMemory()
+	members := make([]*Actors, len(peers))
+	errs := make(chan error, len(peers))
+	for i, peer := range peers {
+		go func() {
+			var err error
+			_, members[i], err = Start(Config{ListenAddr: peer.ListenAddr, Peers: peers, JoinTimeout: 10 * time.Second}, history, nil)
+			errs <- err
+		}()
+	}
+	for range peers {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, locate := range map[string]func(*Actors) (*actor.PID, <-chan struct{}, error){
+		"room":     func(a *Actors) (*actor.PID, <-chan struct{}, error) { return a.Lookup(actors.DefaultRoom) },
+		"registry": (*Actors).Registry,
+	} {
+		first, _, err := locate(members[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second, _, err := locate(members[1]); err != nil || !second.Equals(first) {
+			t.Errorf("members located the %s at %s and %s, %v", name, first, second, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package clustering runs the actor engine as a member of a hollywood
+// cluster, so that several server processes share their rooms.
+package clustering
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anthdm/hollywood/cluster"
+)
+
+// Discovery modes
+const (
+	// DiscoveryStatic only joins the members listed in Config.Peers. It
+	// works anywhere, including several processes on one machine.
+	DiscoveryStatic = "static"
+	// DiscoveryMDNS also announces and discovers members on the local
+	// network with multicast DNS.
+	DiscoveryMDNS = "mdns"
+)
+
+// Config describes how this server joins the cluster
+type Config struct {
+	// ListenAddr is where this member accepts messages from other members
+	ListenAddr string
+	// ID uniquely identifies this member; it defaults to ListenAddr
+	ID string
+	// Discovery is DiscoveryStatic or DiscoveryMDNS
+	Discovery string
+	// Peers are the members to join on startup. An entry for this member
+	// itself is ignored, so every member can be given the same list.
+	Peers []cluster.MemberAddr
+	// JoinTimeout bounds how long Start waits for Peers to become members
+	// before serving rooms
+	JoinTimeout time.Duration
+}
+
+// ParsePeers parses a comma separated list of "id@host:port" or
+// "host:port" entries. Entries without an ID use their address as ID,
+// matching the default member ID.
+func ParsePeers(s string) ([]cluster.MemberAddr, error) {
+	var peers []cluster.MemberAddr
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, addr, ok := strings.Cut(entry, "@")
+		if !ok {
+			id, addr = entry, entry
+		}
+		if id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid cluster peer %q, expected id@host:port", entry)
+		}
+		peers = append(peers, cluster.MemberAddr{ID: id, ListenAddr: addr})
+	}
+	return peers, nil
+}
+
+func (c Config) withDefaults() Config {
+	if c.ID == "" {
+		c.ID = c.ListenAddr
+	}
+	if c.Discovery == "" {
+		c.Discovery = DiscoveryStatic
+	}
+	if c.JoinTimeout == 0 {
+		c.JoinTimeout = 5 * time.Second
+	}
+	return c
+}
@@ -0,0 +1,131 @@
+package clustering
+
+import (
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/anthdm/hollywood/cluster"
+	"github.com/charmbracelet/log"
+)
+
+const memberPingInterval = 2 * time.Second
+
+type (
+	memberPing  struct{}
+	memberLeave struct{ listenAddr string }
+)
+
+// staticProvider is a cluster membership provider that only talks to a
+// fixed list of peers. It follows hollywood's self-managed provider minus
+// the mDNS announcements, which are unavailable on many hosts and make
+// several members on one machine indistinguishable. Peers that are down
+// are retried on every ping, so members may start in any order.
+type staticProvider struct {
+	cluster *cluster.Cluster
+	peers   []cluster.MemberAddr
+	members *cluster.MemberSet
+
+	pid         *actor.PID
+	pinger      actor.SendRepeater
+	eventSubPID *actor.PID
+}
+
+func newStaticProvider(peers []cluster.MemberAddr) cluster.Producer {
+	return func(c *cluster.Cluster) actor.Producer {
+		return func() actor.Receiver {
+			return &staticProvider{
+				cluster: c,
+				peers:   peers,
+				members: cluster.NewMemberSet(),
+			}
+		}
+	}
+}
+
+// Receive implements actor.Receiver
+func (p *staticProvider) Receive(ctx *actor.Context) {
+	switch msg := ctx.Message().(type) {
+	case actor.Started:
+		p.pid = ctx.PID()
+		p.members.Add(p.cluster.Member())
+		p.sendMembersToAgent()
+
+		p.eventSubPID = ctx.SpawnChildFunc(p.handleEvent, "event")
+		p.cluster.Engine().Subscribe(p.eventSubPID)
+
+		p.handshakePeers(ctx)
+		p.pinger = ctx.SendRepeat(ctx.PID(), memberPing{}, memberPingInterval)
+
+	case actor.Stopped:
+		p.pinger.Stop()
+		p.cluster.Engine().Unsubscribe(p.eventSubPID)
+
+	case *cluster.Handshake:
+		p.addMembers(msg.Member)
+		ctx.Engine().Send(ctx.Sender(), &cluster.Members{
+			Members: p.members.Slice(),
+		})
+
+	case *cluster.Members:
+		p.addMembers(msg.Members...)
+
+	case memberPing:
+		p.pingMembers(ctx)
+		p.handshakePeers(ctx)
+
+	case memberLeave:
+		if member := p.members.GetByHost(msg.listenAddr); member != nil {
+			log.Info("cluster member left", "id", member.ID, "host", member.Host)
+			p.members.Remove(member)
+			p.sendMembersToAgent()
+		}
+	}
+}
+
+// handshakePeers introduces this member to every configured peer that has
+// not joined yet
+func (p *staticProvider) handshakePeers(ctx *actor.Context) {
+	self := p.cluster.Member()
+	for _, peer := range p.peers {
+		if peer.ID == self.ID || p.members.Contains(&cluster.Member{ID: peer.ID}) {
+			continue
+		}
+		peerPID := actor.NewPID(peer.ListenAddr, "provider/"+peer.ID)
+		ctx.Engine().SendWithSender(peerPID, &cluster.Handshake{Member: self}, ctx.PID())
+	}
+}
+
+// pingMembers keeps the streams to every member busy so an unreachable
+// member is noticed through a RemoteUnreachableEvent
+func (p *staticProvider) pingMembers(ctx *actor.Context) {
+	p.members.ForEach(func(member *cluster.Member) bool {
+		if member.ID != p.cluster.ID() {
+			ctx.Send(actor.NewPID(member.Host, "provider/"+member.ID), &actor.Ping{From: ctx.PID()})
+		}
+		return true
+	})
+}
+
+func (p *staticProvider) addMembers(members ...*cluster.Member) {
+	for _, member := range members {
+		if !p.members.Contains(member) {
+			log.Info("cluster member joined", "id", member.ID, "host", member.Host)
+			p.members.Add(member)
+		}
+	}
+	p.sendMembersToAgent()
+}
+
+// sendMembersToAgent hands the full member list to the local cluster agent,
+// which diffs it against its own view
+func (p *staticProvider) sendMembersToAgent() {
+	p.cluster.Engine().Send(p.cluster.PID(), &cluster.Members{
+		Members: p.members.Slice(),
+	})
+}
+
+func (p *staticProvider) handleEvent(ctx *actor.Context) {
+	if msg, ok := ctx.Message().(actor.RemoteUnreachableEvent); ok {
+		ctx.Send(p.pid, memberLeave{listenAddr: msg.ListenAddr})
+	}
+}
@@ -0,0 +1,146 @@
+// Package metrics exposes server metrics in the Prometheus text format.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is where the metrics endpoint is served
+const Path = "/metrics"
+
+const namespace = "chat"
+
+// UnknownType labels messages of types clients may not send
+const UnknownType = "unknown"
+
+var (
+	// RoomClients is the number of clients connected to each room
+	RoomClients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "room_clients",
+		Help:      "Number of clients connected to a room.",
+	}, []string{"room"})
+
+	// Messages counts WebSocket messages received from clients within their
+	// rate limits by ws.MessageType, or UnknownType for types clients may
+	// not send
+	Messages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_total",
+		Help:      "WebSocket messages received from clients, by message type.",
+	}, []string{"type"})
+
+	// BroadcastDuration is how long a room takes to fan a message out to its clients
+	BroadcastDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "broadcast_duration_seconds",
+		Help:      "Time taken to fan a message out to every client in a room.",
+		Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+	}, []string{"room"})
+
+	// HTTPRequestDuration is the latency of API routes keyed by Route.Path
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP API requests, by route path, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// ActorMessages counts messages processed by actors of each kind.
+	// Hollywood does not expose inbox depth, so processing rate and
+	// ActorReceiveDuration are the closest signals of a backed-up mailbox.
+	ActorMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actor_messages_processed_total",
+		Help:      "Messages processed by actors, by actor kind.",
+	}, []string{"kind"})
+
+	// ActorReceiveDuration is how long actors of each kind spend per message
+	ActorReceiveDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "actor_receive_duration_seconds",
+		Help:      "Time actors spend handling a single message, by actor kind.",
+		Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+	}, []string{"kind"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RoomClients,
+		Messages,
+		BroadcastDuration,
+		HTTPRequestDuration,
+		ActorMessages,
+		ActorReceiveDuration,
+	)
+}
+
+// Handler serves all registered metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// InstrumentRoute records the latency of every request to h under the given
+// route path
+func InstrumentRoute(path string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		HTTPRequestDuration.
+			WithLabelValues(path, r.Method, strconv.Itoa(sw.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// InstrumentActor wraps a producer so that every message handled by the
+// produced actors is counted and timed under the given kind
+func InstrumentActor(kind string, p actor.Producer) actor.Producer {
+	processed := ActorMessages.WithLabelValues(kind)
+	duration := ActorReceiveDuration.WithLabelValues(kind)
+	return func() actor.Receiver {
+		return &instrumentedActor{
+			receiver:  p(),
+			processed: processed,
+			duration:  duration,
+		}
+	}
+}
+
+// instrumentedActor records metrics around an actor's Receive. Unlike
+// hollywood middleware it travels with the producer, so it also applies to
+// actors spawned by cluster activation.
+type instrumentedActor struct {
+	receiver  actor.Receiver
+	processed prometheus.Counter
+	duration  prometheus.Observer
+}
+
+func (a *instrumentedActor) Receive(ctx *actor.Context) {
+	start := time.Now()
+	a.receiver.Receive(ctx)
+	a.duration.Observe(time.Since(start).Seconds())
+	a.processed.Inc()
+}
+
+// statusWriter remembers the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
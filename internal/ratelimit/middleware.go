@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"go-chat/shared/api"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Middleware rejects requests with 429 Too Many Requests once the bucket
+// for the key returned by keyFunc is empty
+func Middleware(l *Limiter, keyFunc func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter := l.Allow(keyFunc(r))
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(api.ErrorResponse{
+				Error:   "rate limit exceeded",
+				Code:    api.ErrCodeRateLimited,
+				Details: "retry after " + strconv.Itoa(seconds) + "s",
+			})
+		})
+	}
+}
+
+// ClientIP keys requests by the remote address of the connection
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,141 @@
+// Package ratelimit implements token bucket rate limiting for WebSocket
+// messages and HTTP routes.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit configures a token bucket: Burst tokens at most, refilled at Rate
+// tokens per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Bucket is a token bucket safe for concurrent use
+type Bucket struct {
+	limit Limit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a full bucket
+func NewBucket(limit Limit) *Bucket {
+	return &Bucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket. When the bucket is empty it returns
+// false and how long until the next token is available.
+func (b *Bucket) Allow() (bool, time.Duration) {
+	return AllowAll(b)
+}
+
+// AllowAll takes a token from each of distinct buckets, or from none when
+// any is empty, so a message refused by one budget does not use up the
+// others. It then returns false and how long until every bucket has a
+// token. Buckets are locked in the order given, so callers sharing buckets
+// must pass them in the same order.
+func AllowAll(buckets ...*Bucket) (bool, time.Duration) {
+	for _, b := range buckets {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, b := range buckets {
+		b.refill(now)
+		wait = max(wait, b.wait())
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// wait returns how long until the bucket has a token
+func (b *Bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.limit.Rate * float64(time.Second)))
+}
+
+// idle reports whether the bucket has been full for a while, so forgetting
+// it is indistinguishable from keeping it
+func (b *Bucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+func (b *Bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+}
+
+// sweepInterval is how often a Limiter drops buckets of idle keys
+const sweepInterval = time.Minute
+
+// Limiter keeps a separate bucket per key, such as a username or client IP
+type Limiter struct {
+	limit Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+// NewLimiter creates a limiter applying limit to every key independently
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit:     limit,
+		buckets:   make(map[string]*Bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket for key, see Bucket.Allow
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.Bucket(key).Allow()
+}
+
+// Bucket returns the bucket for key, e.g. to take a token from it along
+// with others with AllowAll
+func (l *Limiter) Bucket(key string) *Bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = NewBucket(l.limit)
+		l.buckets[key] = bucket
+	}
+	return bucket
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.idle(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
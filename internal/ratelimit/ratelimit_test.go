@@ -0,0 +1,44 @@
+package ratelimit
+
+import "testing"
+
+// slow refills so slowly that no test sees a token come back
+var slow = Limit{Rate: 0.001, Burst: 2}
+
+// TestAllowAllTakesAllOrNothing checks that a token is only taken when
+// every bucket has one
+func TestAllowAllTakesAllOrNothing(t *testing.T) {
+	full, empty := NewBucket(slow), NewBucket(slow)
+	empty.Allow()
+	empty.Allow()
+
+	if ok, wait := AllowAll(full, empty); ok || wait <= 0 {
+		t.Fatalf("AllowAll with an empty bucket = %v, %v; want false and a wait", ok, wait)
+	}
+	// The full bucket kept both its tokens
+	for i := range slow.Burst {
+		if ok, _ := full.Allow(); !ok {
+			t.Fatalf("token %d of the full bucket was taken", i+1)
+		}
+	}
+	if ok, _ := full.Allow(); ok {
+		t.Error("full bucket had more tokens than its burst")
+	}
+}
+
+// TestLimiterKeys checks that keys have buckets of their own
+func TestLimiterKeys(t *testing.T) {
+	l := NewLimiter(Limit{Rate: 0.001, Burst: 1})
+	if ok, _ := l.Allow("carol"); !ok {
+		t.Fatal("first message of carol refused")
+	}
+	if ok, _ := l.Allow("carol"); ok {
+		t.Error("carol went over her burst")
+	}
+	if ok, _ := l.Allow("dave"); !ok {
+		t.Error("dave was limited by carol's bucket")
+	}
+	if l.Bucket("carol") != l.Bucket("carol") {
+		t.Error("Bucket returned another bucket for the same key")
+	}
+}
@@ -0,0 +1,210 @@
+// Package search implements an in-process full-text index of room messages
+package search
+
+import (
+	"go-chat/shared/ws"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Query selects indexed messages. Every term of Text must occur in a
+// message; the other fields are ignored when empty.
+type Query struct {
+	Text   string
+	Room   string
+	From   string // Username of the author
+	Since  int64  // Unix milliseconds, inclusive
+	Until  int64  // Unix milliseconds, exclusive
+	Offset int
+	Limit  int
+}
+
+// Result is a message matching a Query
+type Result struct {
+	Room    string
+	Message ws.TextMessage
+}
+
+// Index is an inverted index from terms to the messages containing them.
+// It is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	limit    int
+	docs     map[string]document            // message ID → document
+	postings map[string]map[string]struct{} // term → message IDs
+	order    []string                       // message IDs, oldest indexed first; may hold removed IDs
+}
+
+type document struct {
+	room  string
+	msg   ws.TextMessage
+	terms []string
+}
+
+// NewIndex creates an empty index of at most limit messages. Past it, the
+// message indexed first is evicted. A limit of zero keeps every message.
+func NewIndex(limit int) *Index {
+	return &Index{
+		limit:    limit,
+		docs:     make(map[string]document),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// Add indexes a message, replacing an earlier version with the same ID.
+// Deleted messages are removed instead.
+func (i *Index) Add(room string, msg ws.TextMessage) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	_, replaced := i.docs[msg.ID]
+	i.remove(msg.ID)
+	if msg.Deleted {
+		return
+	}
+	// An edit keeps the place of the message it replaces
+	if !replaced {
+		i.evict()
+		i.order = append(i.order, msg.ID)
+	}
+
+	terms := Tokenize(msg.Text)
+	i.docs[msg.ID] = document{room: room, msg: msg, terms: terms}
+	for _, term := range terms {
+		ids, ok := i.postings[term]
+		if !ok {
+			ids = make(map[string]struct{})
+			i.postings[term] = ids
+		}
+		ids[msg.ID] = struct{}{}
+	}
+}
+
+// Remove drops a message from the index
+func (i *Index) Remove(id string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(id)
+}
+
+func (i *Index) remove(id string) {
+	doc, ok := i.docs[id]
+	if !ok {
+		return
+	}
+	delete(i.docs, id)
+	for _, term := range doc.terms {
+		delete(i.postings[term], id)
+		if len(i.postings[term]) == 0 {
+			delete(i.postings, term)
+		}
+	}
+}
+
+// evict drops the messages indexed first to make room for another
+func (i *Index) evict() {
+	if i.limit <= 0 {
+		return
+	}
+	for len(i.docs) >= i.limit && len(i.order) > 0 {
+		id := i.order[0]
+		i.order = i.order[1:]
+		i.remove(id)
+	}
+	// Removed messages linger in order until they are reached; compact it
+	// before they outnumber the indexed ones
+	if len(i.order) > 2*i.limit {
+		i.order = slices.DeleteFunc(i.order, func(id string) bool {
+			_, ok := i.docs[id]
+			return !ok
+		})
+	}
+}
+
+// Search returns a page of the messages matching q, newest first, and the
+// total number of matches
+func (i *Index) Search(q Query) ([]Result, int) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var matches []document
+	for _, id := range i.candidates(Tokenize(q.Text)) {
+		doc := i.docs[id]
+		if q.Room != "" && doc.room != q.Room ||
+			q.From != "" && doc.msg.From != q.From ||
+			q.Since != 0 && doc.msg.Timestamp < q.Since ||
+			q.Until != 0 && doc.msg.Timestamp >= q.Until {
+			continue
+		}
+		matches = append(matches, doc)
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].msg.Timestamp != matches[b].msg.Timestamp {
+			return matches[a].msg.Timestamp > matches[b].msg.Timestamp
+		}
+		return matches[a].msg.ID > matches[b].msg.ID
+	})
+
+	total := len(matches)
+	start := min(max(q.Offset, 0), total)
+	end := total
+	if q.Limit > 0 {
+		end = min(start+q.Limit, total)
+	}
+	results := make([]Result, 0, end-start)
+	for _, doc := range matches[start:end] {
+		results = append(results, Result{Room: doc.room, Message: doc.msg})
+	}
+	return results, total
+}
+
+// candidates returns the IDs of the messages containing every term, or of
+// every message without terms
+func (i *Index) candidates(terms []string) []string {
+	if len(terms) == 0 {
+		ids := make([]string, 0, len(i.docs))
+		for id := range i.docs {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	// Intersect starting from the rarest term
+	sort.Slice(terms, func(a, b int) bool {
+		return len(i.postings[terms[a]]) < len(i.postings[terms[b]])
+	})
+	var ids []string
+	for id := range i.postings[terms[0]] {
+		matchesAll := true
+		for _, term := range terms[1:] {
+			if _, ok := i.postings[term][id]; !ok {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Tokenize splits text into its distinct lower case words
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	seen := make(map[string]struct{}, len(words))
+	terms := words[:0]
+	for _, word := range words {
+		if _, ok := seen[word]; !ok {
+			seen[word] = struct{}{}
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
@@ -0,0 +1,132 @@
+package search
+
+import (
+	"go-chat/shared/ws"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// ids returns the IDs of the messages in results
+func ids(results []Result) []string {
+	ids := make([]string, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.Message.ID)
+	}
+	return ids
+}
+
+func TestTokenize(t *testing.T) {
+	for text, want := range map[string][]string{
+		"Deploying the release":      {"deploying", "the", "release"},
+		"release, RELEASE! release?": {"release"},
+		"v1.2 is out — grüße @bob":   {"v1", "2", "is", "out", "grüße", "bob"},
+		"https://e.com/a_b":          {"https", "e", "com", "a", "b"},
+		"  ...  ":                    {},
+		"日本語 text":                   {"日本語", "text"},
+		"don't":                      {"don", "t"},
+	} {
+		if got := Tokenize(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("Tokenize(%q) = %q; want %q", text, got, want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	index := NewIndex(0)
+	for _, msg := range []struct {
+		room string
+		ws.TextMessage
+	}{
+		{"general", ws.TextMessage{ID: "1", From: "alice", Text: "deploying the release", Timestamp: 1000}},
+		{"general", ws.TextMessage{ID: "2", From: "bob", Text: "Release notes are up", Timestamp: 2000}},
+		{"general", ws.TextMessage{ID: "3", From: "alice", Text: "lunch anyone?", Timestamp: 3000}},
+		{"random", ws.TextMessage{ID: "4", From: "bob", Text: "the release party", Timestamp: 4000}},
+	} {
+		index.Add(msg.room, msg.TextMessage)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query Query
+		want  []string
+		total int
+	}{
+		{"term", Query{Text: "release"}, []string{"4", "2", "1"}, 3},
+		{"case", Query{Text: "RELEASE"}, []string{"4", "2", "1"}, 3},
+		{"every term", Query{Text: "the release"}, []string{"4", "1"}, 2},
+		{"no match", Query{Text: "release dinner"}, []string{}, 0},
+		{"partial word", Query{Text: "releas"}, []string{}, 0},
+		{"room", Query{Text: "release", Room: "general"}, []string{"2", "1"}, 2},
+		{"author", Query{Text: "release", From: "alice"}, []string{"1"}, 1},
+		{"since", Query{Text: "release", Since: 2000}, []string{"4", "2"}, 2},
+		{"until", Query{Text: "release", Until: 2000}, []string{"1"}, 1},
+		{"no terms", Query{Room: "general"}, []string{"3", "2", "1"}, 3},
+		{"limit", Query{Text: "release", Limit: 2}, []string{"4", "2"}, 3},
+		{"offset", Query{Text: "release", Offset: 2, Limit: 2}, []string{"1"}, 3},
+		{"offset past the end", Query{Text: "release", Offset: 5}, []string{}, 3},
+		{"negative offset", Query{Text: "release", Offset: -1, Limit: 1}, []string{"4"}, 3},
+	} {
+		results, total := index.Search(tc.query)
+		if got := ids(results); !reflect.DeepEqual(got, tc.want) || total != tc.total {
+			t.Errorf("%s: Search(%+v) = %q of %d; want %q of %d", tc.name, tc.query, got, total, tc.want, tc.total)
+		}
+	}
+}
+
+// TestSearchEditsAndDeletes checks that a message is found by its latest
+// text only, and not once it was deleted
+func TestSearchEditsAndDeletes(t *testing.T) {
+	index := NewIndex(0)
+	index.Add("general", ws.TextMessage{ID: "1", Text: "deploying the release"})
+	index.Add("general", ws.TextMessage{ID: "1", Text: "deploying the hotfix", EditedAt: 1})
+
+	if results, _ := index.Search(Query{Text: "release"}); len(results) != 0 {
+		t.Errorf("search for the edited out word = %q", ids(results))
+	}
+	if results, _ := index.Search(Query{Text: "hotfix"}); !reflect.DeepEqual(ids(results), []string{"1"}) {
+		t.Errorf("search for the edited in word = %q; want [1]", ids(results))
+	}
+
+	index.Add("general", ws.TextMessage{ID: "1", Deleted: true})
+	if results, total := index.Search(Query{Text: "hotfix"}); total != 0 {
+		t.Errorf("search after deleting = %q", ids(results))
+	}
+	index.Add("general", ws.TextMessage{ID: "2", Text: "hotfix"})
+	index.Remove("2")
+	if results, total := index.Search(Query{}); total != 0 {
+		t.Errorf("search after removing = %q", ids(results))
+	}
+	if len(index.postings) != 0 {
+		t.Errorf("postings of removed messages are kept: %v", index.postings)
+	}
+}
+
+// TestSearchLimit checks that the index evicts the messages indexed first
+// once it is full, and that edits keep their place
+func TestSearchLimit(t *testing.T) {
+	index := NewIndex(3)
+	for i := range 3 {
+		index.Add("general", ws.TextMessage{ID: strconv.Itoa(i), Text: "hello", Timestamp: int64(i)})
+	}
+	// Editing the oldest message does not save it from eviction
+	index.Add("general", ws.TextMessage{ID: "0", Text: "hello again", Timestamp: 0, EditedAt: 1})
+	index.Add("general", ws.TextMessage{ID: "3", Text: "hello", Timestamp: 3})
+	if results, total := index.Search(Query{Text: "hello"}); total != 3 || !reflect.DeepEqual(ids(results), []string{"3", "2", "1"}) {
+		t.Errorf("search = %q of %d; want [3 2 1] of 3", ids(results), total)
+	}
+
+	// Deleted messages free their place, and do not pile up. The first
+	// message added evicts 1 before it is deleted.
+	for i := 4; i < 100; i++ {
+		id := strconv.Itoa(i)
+		index.Add("general", ws.TextMessage{ID: id, Text: "hello", Timestamp: int64(i)})
+		index.Add("general", ws.TextMessage{ID: id, Deleted: true})
+	}
+	if results, total := index.Search(Query{Text: "hello"}); total != 2 || !reflect.DeepEqual(ids(results), []string{"3", "2"}) {
+		t.Errorf("search after deleting = %q of %d; want [3 2] of 2", ids(results), total)
+	}
+	if len(index.order) > 2*index.limit {
+		t.Errorf("%d IDs kept in order for %d messages", len(index.order), len(index.docs))
+	}
+}
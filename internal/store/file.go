@@ -0,0 +1,377 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File is a Store that keeps accounts, bots, roles, bans, mutes, the audit
+// log, direct messages, attachment records, and the latest messages and
+// read cursors of rooms in a JSON file, so they survive restarts. Sessions
+// and revisions are kept in memory like Memory does, and the search index
+// is rebuilt from the messages kept.
+//
+// A file has a single writer: NewFile locks it until Close, so a second
+// process using the same file fails to open it rather than overwrite what
+// the first one saves. Instances of one deployment share the store of one
+// of them through Serve and Remote instead.
+type File struct {
+	*Memory
+	path string
+	lock *os.File   // Held open while the file is in use
+	mu   sync.Mutex // Serializes writing the file
+}
+
+// fileState is the part of the store written to the file
+type fileState struct {
+	Roles    map[string]map[string]string  `json:"roles"` // Room → username → role
+	Bans     map[string]map[string]api.Ban `json:"bans"`  // Room → username → ban
+	Mutes    map[string]map[string]int64   `json:"mutes"` // Room → username → Unix milliseconds the mute ends
+	Audit    map[string][]api.AuditEntry   `json:"audit"` // Room → entries, oldest first
+	Accounts []fileAccount                 `json:"accounts"`
+	Bots     []fileBot                     `json:"bots"`
+	Direct   []fileConversation            `json:"direct"`
+
+	Rooms       map[string]fileRoom                  `json:"rooms"`
+	Cursors     map[string]map[string]api.ReadCursor `json:"cursors"`     // Room → username → cursor
+	Attachments []ws.Attachment                      `json:"attachments"` // Records only; contents are in the blob store
+}
+
+type fileAccount struct {
+	api.Account
+	TokenHash string `json:"token_hash"`
+}
+
+type fileBot struct {
+	api.Bot
+	TokenHash string `json:"token_hash"`
+}
+
+// fileRoom is the numbering of a room's messages and the latest of them
+type fileRoom struct {
+	Epoch    string           `json:"epoch"`
+	Messages []ws.TextMessage `json:"messages"` // In Seq order
+}
+
+// fileConversation is the direct messages between two users
+type fileConversation struct {
+	Users    [2]string          `json:"users"`
+	Count    int                `json:"count"` // Including messages no longer kept
+	Messages []ws.DirectMessage `json:"messages"`
+}
+
+// ErrLocked is returned by NewFile when another process uses the file
+var ErrLocked = errors.New("store file is in use by another process")
+
+// NewFile creates a store kept in the file at path, loading what it holds.
+// The directory of the file is created if missing, and the file itself on
+// the first change.
+func NewFile(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	f := &File{Memory: NewMemory(), path: path, lock: lock}
+	if err := f.load(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close releases the file for other processes. The store must not be
+// changed afterwards.
+func (f *File) Close() error {
+	return f.lock.Close()
+}
+
+// load reads the state kept in the file, if it exists
+func (f *File) load() error {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state fileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("reading %s: %w", f.path, err)
+	}
+
+	for room, roles := range state.Roles {
+		f.roles[room] = roles
+	}
+	for room, bans := range state.Bans {
+		f.bans[room] = bans
+	}
+	for room, mutes := range state.Mutes {
+		f.mutes[room] = mutes
+	}
+	for room, entries := range state.Audit {
+		f.audit[room] = entries
+	}
+	for _, account := range state.Accounts {
+		f.accounts[account.Name] = userAccount{account: account.Account, tokenHash: account.TokenHash}
+	}
+	for _, bot := range state.Bots {
+		f.bots[bot.Name] = botAccount{bot: bot.Bot, tokenHash: bot.TokenHash}
+	}
+	for _, conversation := range state.Direct {
+		key := newPair(conversation.Users[0], conversation.Users[1])
+		f.direct[key] = conversation.Messages
+		f.counts[key] = conversation.Count
+	}
+	for _, att := range state.Attachments {
+		f.attachments[att.ID] = att
+	}
+	for room, cursors := range state.Cursors {
+		f.cursors[room] = cursors
+	}
+	for room, log := range state.Rooms {
+		f.rooms[room] = &roomLog{epoch: log.Epoch, messages: log.Messages}
+		for _, msg := range log.Messages {
+			f.index.Add(room, msg)
+		}
+	}
+	return nil
+}
+
+// SetRole implements Store
+func (f *File) SetRole(room, username, role string) error {
+	if err := f.Memory.SetRole(room, username, role); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// SaveBan implements Store
+func (f *File) SaveBan(ban api.Ban) error {
+	if err := f.Memory.SaveBan(ban); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// DeleteBan implements Store
+func (f *File) DeleteBan(room, username string) error {
+	if err := f.Memory.DeleteBan(room, username); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// SaveMute implements Store
+func (f *File) SaveMute(room, username string, until int64) error {
+	if err := f.Memory.SaveMute(room, username, until); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// DeleteMute implements Store
+func (f *File) DeleteMute(room, username string) error {
+	if err := f.Memory.DeleteMute(room, username); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// SaveAudit implements Store
+func (f *File) SaveAudit(entry api.AuditEntry) error {
+	if err := f.Memory.SaveAudit(entry); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// SaveDirect implements Store
+func (f *File) SaveDirect(msg ws.DirectMessage) error {
+	if err := f.Memory.SaveDirect(msg); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// SetReadCursor implements Store
+func (f *File) SetReadCursor(room, username string, cursor api.ReadCursor) error {
+	if err := f.Memory.SetReadCursor(room, username, cursor); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// SaveMessage implements Store
+func (f *File) SaveMessage(room string, msg ws.TextMessage) error {
+	if err := f.Memory.SaveMessage(room, msg); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// RoomEpoch implements Store
+func (f *File) RoomEpoch(room, epoch string) (string, error) {
+	kept, err := f.Memory.RoomEpoch(room, epoch)
+	if err != nil || kept != epoch {
+		return kept, err
+	}
+	return kept, f.save()
+}
+
+// SaveAttachment implements Store
+func (f *File) SaveAttachment(att ws.Attachment) error {
+	if err := f.Memory.SaveAttachment(att); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// SaveAccount implements Store
+func (f *File) SaveAccount(account api.Account, tokenHash string) error {
+	if err := f.Memory.SaveAccount(account, tokenHash); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// DeleteAccount implements Store
+func (f *File) DeleteAccount(name string) error {
+	if err := f.Memory.DeleteAccount(name); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// SaveBot implements Store
+func (f *File) SaveBot(bot api.Bot, tokenHash string) error {
+	if err := f.Memory.SaveBot(bot, tokenHash); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// DeleteBot implements Store
+func (f *File) DeleteBot(name string) error {
+	if err := f.Memory.DeleteBot(name); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// Check implements Store by creating a file next to the store file, as
+// saving it does
+func (f *File) Check(ctx context.Context) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".check.*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// save writes the current state to the file. It is written to a temporary
+// file first, so a crash leaves either the old or the new state behind.
+func (f *File) save() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.Marshal(f.snapshot())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// snapshot copies the state kept in the file
+func (f *File) snapshot() fileState {
+	f.Memory.mu.RLock()
+	defer f.Memory.mu.RUnlock()
+
+	state := fileState{
+		Roles: make(map[string]map[string]string, len(f.roles)),
+		Bans:  make(map[string]map[string]api.Ban, len(f.bans)),
+		Mutes: make(map[string]map[string]int64, len(f.mutes)),
+		Audit: make(map[string][]api.AuditEntry, len(f.audit)),
+	}
+	for room, roles := range f.roles {
+		copied := make(map[string]string, len(roles))
+		for username, role := range roles {
+			copied[username] = role
+		}
+		state.Roles[room] = copied
+	}
+	for room, bans := range f.bans {
+		copied := make(map[string]api.Ban, len(bans))
+		for username, ban := range bans {
+			copied[username] = ban
+		}
+		state.Bans[room] = copied
+	}
+	for room, mutes := range f.mutes {
+		copied := make(map[string]int64, len(mutes))
+		for username, until := range mutes {
+			copied[username] = until
+		}
+		state.Mutes[room] = copied
+	}
+	for room, entries := range f.audit {
+		state.Audit[room] = append([]api.AuditEntry{}, entries...)
+	}
+	for _, account := range f.accounts {
+		state.Accounts = append(state.Accounts, fileAccount{Account: account.account, TokenHash: account.tokenHash})
+	}
+	for _, bot := range f.bots {
+		state.Bots = append(state.Bots, fileBot{Bot: bot.bot, TokenHash: bot.tokenHash})
+	}
+	for key, messages := range f.direct {
+		state.Direct = append(state.Direct, fileConversation{
+			Users:    [2]string{key.a, key.b},
+			Count:    f.counts[key],
+			Messages: append([]ws.DirectMessage{}, messages...),
+		})
+	}
+	for _, att := range f.attachments {
+		state.Attachments = append(state.Attachments, att)
+	}
+	state.Cursors = make(map[string]map[string]api.ReadCursor, len(f.cursors))
+	for room, cursors := range f.cursors {
+		copied := make(map[string]api.ReadCursor, len(cursors))
+		for username, cursor := range cursors {
+			copied[username] = cursor
+		}
+		state.Cursors[room] = copied
+	}
+	state.Rooms = make(map[string]fileRoom, len(f.rooms))
+	for room, log := range f.rooms {
+		state.Rooms[room] = fileRoom{Epoch: log.epoch, Messages: append([]ws.TextMessage{}, log.messages...)}
+	}
+	return state
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"errors"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestFileReload checks that accounts, bots, roles, bans, mutes and the
+// audit log are still there after reopening the file, and sessions are not
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "store.json")
+
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveAccount(api.Account{Name: "alice", CreatedAt: 1}, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveBot(api.Bot{Name: "deploy", CreatedBy: "alice"}, "bothash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetRole("general", "alice", api.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveBan(api.Ban{Room: "general", Username: "mallory", By: "alice", At: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveMute("general", "mallory", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveAudit(api.AuditEntry{Room: "general", Action: api.AuditBan, Actor: "alice", Target: "mallory", At: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveSession("session", "alice", 1<<62); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account, hash, err := reopened.Account("alice"); err != nil || account.CreatedAt != 1 || hash != "hash" {
+		t.Errorf("Account(alice) = %+v, %q, %v", account, hash, err)
+	}
+	if bot, hash, err := reopened.Bot("deploy"); err != nil || bot.CreatedBy != "alice" || hash != "bothash" {
+		t.Errorf("Bot(deploy) = %+v, %q, %v", bot, hash, err)
+	}
+	if role, err := reopened.Role("general", "alice"); err != nil || role != api.RoleOwner {
+		t.Errorf("Role(general, alice) = %q, %v; want %q", role, err, api.RoleOwner)
+	}
+	if ban, err := reopened.Ban("general", "mallory"); err != nil || ban.By != "alice" {
+		t.Errorf("Ban(general, mallory) = %+v, %v", ban, err)
+	}
+	if mutes, err := reopened.Mutes("general"); err != nil || mutes["mallory"] != 5 {
+		t.Errorf("Mutes(general) = %v, %v; want mallory muted until 5", mutes, err)
+	}
+	if entries, err := reopened.AuditLog("general", 10); err != nil || len(entries) != 1 || entries[0].Target != "mallory" {
+		t.Errorf("AuditLog(general) = %+v, %v", entries, err)
+	}
+	if _, err := reopened.Session("session", 0); err != ErrNotFound {
+		t.Errorf("Session after reopening = %v; want ErrNotFound", err)
+	}
+
+	// Lifting the ban is kept too
+	if err := reopened.DeleteBan("general", "mallory"); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+	again, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := again.Ban("general", "mallory"); err != ErrNotFound {
+		t.Errorf("Ban after lifting it = %v; want ErrNotFound", err)
+	}
+}
+
+// TestFileLocked checks that a file is used by one store at a time
+func TestFileLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFile(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("NewFile of a file in use = %v; want ErrLocked", err)
+	}
+
+	f.Close()
+	again, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile after closing = %v", err)
+	}
+	again.Close()
+}
+
+// TestFileDirectMessages checks that direct messages, and how many were
+// exchanged, survive reopening the file
+func TestFileDirectMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := []ws.DirectMessage{
+		{From: "alice", To: "bob", Text: "hi", Timestamp: 1},
+		{From: "bob", To: "alice", Text: "hello", Timestamp: 2},
+		{From: "carol", To: "alice", Text: "hey", Timestamp: 3},
+	}
+	for _, msg := range sent {
+		if err := f.SaveDirect(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if messages, err := reopened.DirectMessages("bob", "alice", 0); err != nil || !reflect.DeepEqual(messages, sent[:2]) {
+		t.Errorf("DirectMessages(bob, alice) = %+v, %v; want %+v", messages, err, sent[:2])
+	}
+	conversations, err := reopened.Conversations("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []api.Conversation{
+		{With: "carol", LastMessage: sent[2], Count: 1},
+		{With: "bob", LastMessage: sent[1], Count: 2},
+	}
+	if !reflect.DeepEqual(conversations, want) {
+		t.Errorf("Conversations(alice) = %+v; want %+v", conversations, want)
+	}
+}
+
+// TestFileAttachments checks that attachment records survive reopening the
+// file, so messages keep referencing their files after a restart
+func TestFileAttachments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	att := ws.Attachment{ID: "att", Name: "photo.png", ContentType: "image/png", Size: 42, Width: 4, Height: 3, Thumbnail: true, UploadedBy: "alice"}
+	if err := f.SaveAttachment(att); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got, err := reopened.Attachment("att"); err != nil || got != att {
+		t.Errorf("Attachment(att) = %+v, %v; want %+v", got, err, att)
+	}
+	if _, err := reopened.Attachment("missing"); err != ErrNotFound {
+		t.Errorf("Attachment(missing) = %v; want ErrNotFound", err)
+	}
+}
+
+// TestFileRoomHistory checks that rooms find their numbering, latest
+// messages and read cursors after reopening the file, and that the kept
+// messages are searchable again
+func TestFileRoomHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch, err := f.RoomEpoch("general", "first")
+	if err != nil || epoch != "first" {
+		t.Fatalf("RoomEpoch of a new room = %q, %v; want first", epoch, err)
+	}
+	for i, text := range []string{"deploying the release", "lunch anyone?"} {
+		if err := f.SaveMessage("general", ws.TextMessage{ID: text, Seq: uint64(i + 1), Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cursor := api.ReadCursor{Epoch: "first", Seq: 1, MessageID: "deploying the release"}
+	if err := f.SetReadCursor("general", "alice", cursor); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if epoch, err := reopened.RoomEpoch("general", "second"); err != nil || epoch != "first" {
+		t.Errorf("RoomEpoch after reopening = %q, %v; want first", epoch, err)
+	}
+	messages, err := reopened.RoomMessages("general", 10)
+	if err != nil || len(messages) != 2 || messages[0].Seq != 1 || messages[1].Text != "lunch anyone?" {
+		t.Errorf("RoomMessages after reopening = %+v, %v; want both messages", messages, err)
+	}
+	if cursors, err := reopened.ReadCursors("general"); err != nil || cursors["alice"] != cursor {
+		t.Errorf("ReadCursors after reopening = %+v, %v; want alice's", cursors, err)
+	}
+	results, err := reopened.SearchMessages(api.SearchMessagesRequest{Query: "release", Room: "general"})
+	if err != nil || results.Total != 1 {
+		t.Errorf("SearchMessages after reopening = %+v, %v; want the release message", results, err)
+	}
+}
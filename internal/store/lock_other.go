@@ -0,0 +1,12 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+// lockFile opens the file at path, creating it if missing. Only Unix
+// systems lock it, so elsewhere nothing keeps two processes from using a
+// store file at once.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+}
@@ -0,0 +1,653 @@
+package store
+
+import (
+	"context"
+	"go-chat/internal/search"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxDirectMessages bounds the messages kept per conversation
+const maxDirectMessages = 1000
+
+// maxAuditEntries bounds the moderation actions kept per room
+const maxAuditEntries = 1000
+
+// maxIndexedMessages bounds the room messages kept for search, across rooms
+const maxIndexedMessages = 100_000
+
+// maxRoomMessages bounds the latest messages kept per room for RoomMessages
+const maxRoomMessages = 1000
+
+// pair identifies a conversation regardless of who sent a message
+type pair struct {
+	a, b string
+}
+
+func newPair(x, y string) pair {
+	if x > y {
+		x, y = y, x
+	}
+	return pair{a: x, b: y}
+}
+
+// other returns the user in the pair that is not username
+func (p pair) other(username string) string {
+	if p.a == username {
+		return p.b
+	}
+	return p.a
+}
+
+// Memory is a Store that keeps history in process memory, so it is lost
+// when the server restarts
+type Memory struct {
+	mu          sync.RWMutex
+	direct      map[pair][]ws.DirectMessage
+	counts      map[pair]int
+	revisions   map[string][]api.MessageRevision     // keyed by message ID
+	roles       map[string]map[string]string         // room → username → role
+	bans        map[string]map[string]api.Ban        // room → username → ban
+	mutes       map[string]map[string]int64          // room → username → Unix milliseconds the mute ends
+	audit       map[string][]api.AuditEntry          // room → entries, oldest first
+	cursors     map[string]map[string]api.ReadCursor // room → username → cursor
+	rooms       map[string]*roomLog                  // room → its latest messages
+	index       *search.Index                        // Safe for concurrent use on its own
+	attachments map[string]ws.Attachment
+	bots        map[string]botAccount  // keyed by name
+	accounts    map[string]userAccount // keyed by name
+	sessions    map[string]session     // keyed by token hash
+}
+
+// roomLog is the numbering of a room's messages and the latest of them
+type roomLog struct {
+	epoch    string
+	messages []ws.TextMessage // In Seq order
+}
+
+// botAccount is a bot with the hash of its token
+type botAccount struct {
+	bot       api.Bot
+	tokenHash string
+}
+
+// userAccount is the account of a person with the hash of its token
+type userAccount struct {
+	account   api.Account
+	tokenHash string
+}
+
+// session is a signed in user
+type session struct {
+	username string
+	expires  int64 // Unix milliseconds
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		direct:      make(map[pair][]ws.DirectMessage),
+		counts:      make(map[pair]int),
+		revisions:   make(map[string][]api.MessageRevision),
+		roles:       make(map[string]map[string]string),
+		bans:        make(map[string]map[string]api.Ban),
+		mutes:       make(map[string]map[string]int64),
+		audit:       make(map[string][]api.AuditEntry),
+		cursors:     make(map[string]map[string]api.ReadCursor),
+		rooms:       make(map[string]*roomLog),
+		index:       search.NewIndex(maxIndexedMessages),
+		attachments: make(map[string]ws.Attachment),
+		bots:        make(map[string]botAccount),
+		accounts:    make(map[string]userAccount),
+		sessions:    make(map[string]session),
+	}
+}
+
+// SaveDirect implements Store
+func (m *Memory) SaveDirect(msg ws.DirectMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := newPair(msg.From, msg.To)
+	messages := append(m.direct[key], msg)
+	if len(messages) > maxDirectMessages {
+		messages = messages[len(messages)-maxDirectMessages:]
+	}
+	m.direct[key] = messages
+	m.counts[key]++
+	return nil
+}
+
+// Conversations implements Store
+func (m *Memory) Conversations(username string) ([]api.Conversation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	conversations := []api.Conversation{}
+	for key, messages := range m.direct {
+		if key.a != username && key.b != username {
+			continue
+		}
+		conversations = append(conversations, api.Conversation{
+			With:        key.other(username),
+			LastMessage: messages[len(messages)-1],
+			Count:       m.counts[key],
+		})
+	}
+
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].LastMessage.Timestamp > conversations[j].LastMessage.Timestamp
+	})
+	return conversations, nil
+}
+
+// DirectMessages implements Store
+func (m *Memory) DirectMessages(username, with string, limit int) ([]ws.DirectMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	messages := m.direct[newPair(username, with)]
+	if limit > 0 && len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return append([]ws.DirectMessage{}, messages...), nil
+}
+
+// SaveRevision implements Store
+func (m *Memory) SaveRevision(rev api.MessageRevision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !slices.Contains(m.revisions[rev.MessageID], rev) {
+		m.revisions[rev.MessageID] = append(m.revisions[rev.MessageID], rev)
+	}
+	return nil
+}
+
+// Revisions implements Store
+func (m *Memory) Revisions(messageID string) ([]api.MessageRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]api.MessageRevision{}, m.revisions[messageID]...), nil
+}
+
+// Role implements Store
+func (m *Memory) Role(room, username string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if role, ok := m.roles[room][username]; ok {
+		return role, nil
+	}
+	return api.RoleMember, nil
+}
+
+// SetRole implements Store
+func (m *Memory) SetRole(room, username, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if role == api.RoleMember {
+		delete(m.roles[room], username)
+		return nil
+	}
+	roles, ok := m.roles[room]
+	if !ok {
+		roles = make(map[string]string)
+		m.roles[room] = roles
+	}
+	roles[username] = role
+	return nil
+}
+
+// SaveBan implements Store
+func (m *Memory) SaveBan(ban api.Ban) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bans, ok := m.bans[ban.Room]
+	if !ok {
+		bans = make(map[string]api.Ban)
+		m.bans[ban.Room] = bans
+	}
+	bans[ban.Username] = ban
+	return nil
+}
+
+// Ban implements Store
+func (m *Memory) Ban(room, username string) (api.Ban, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ban, ok := m.bans[room][username]
+	if !ok {
+		return api.Ban{}, ErrNotFound
+	}
+	return ban, nil
+}
+
+// DeleteBan implements Store
+func (m *Memory) DeleteBan(room, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.bans[room], username)
+	return nil
+}
+
+// Bans implements Store
+func (m *Memory) Bans(room string) ([]api.Ban, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bans := make([]api.Ban, 0, len(m.bans[room]))
+	for _, ban := range m.bans[room] {
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].At > bans[j].At
+	})
+	return bans, nil
+}
+
+// SaveMute implements Store
+func (m *Memory) SaveMute(room, username string, until int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mutes, ok := m.mutes[room]
+	if !ok {
+		mutes = make(map[string]int64)
+		m.mutes[room] = mutes
+	}
+	mutes[username] = until
+	return nil
+}
+
+// DeleteMute implements Store
+func (m *Memory) DeleteMute(room, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.mutes[room], username)
+	return nil
+}
+
+// Mutes implements Store
+func (m *Memory) Mutes(room string) (map[string]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mutes := make(map[string]int64, len(m.mutes[room]))
+	for username, until := range m.mutes[room] {
+		mutes[username] = until
+	}
+	return mutes, nil
+}
+
+// SaveAudit implements Store
+func (m *Memory) SaveAudit(entry api.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if slices.Contains(m.audit[entry.Room], entry) {
+		return nil
+	}
+	entries := append(m.audit[entry.Room], entry)
+	if len(entries) > maxAuditEntries {
+		entries = entries[len(entries)-maxAuditEntries:]
+	}
+	m.audit[entry.Room] = entries
+	return nil
+}
+
+// AuditLog implements Store
+func (m *Memory) AuditLog(room string, limit int) ([]api.AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := m.audit[room]
+	n := min(limit, len(entries))
+	log := make([]api.AuditEntry, 0, n)
+	for i := len(entries) - 1; i >= len(entries)-n; i-- {
+		log = append(log, entries[i])
+	}
+	return log, nil
+}
+
+// SetReadCursor implements Store
+func (m *Memory) SetReadCursor(room, username string, cursor api.ReadCursor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cursors, ok := m.cursors[room]
+	if !ok {
+		cursors = make(map[string]api.ReadCursor)
+		m.cursors[room] = cursors
+	}
+	cursors[username] = cursor
+	return nil
+}
+
+// ReadCursors implements Store
+func (m *Memory) ReadCursors(room string) (map[string]api.ReadCursor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cursors := make(map[string]api.ReadCursor, len(m.cursors[room]))
+	for username, cursor := range m.cursors[room] {
+		cursors[username] = cursor
+	}
+	return cursors, nil
+}
+
+// SaveMessage implements Store. Messages are kept for RoomMessages while
+// they are among the latest maxRoomMessages of their room, and for search
+// while they are among the latest maxIndexedMessages.
+func (m *Memory) SaveMessage(room string, msg ws.TextMessage) error {
+	m.index.Add(room, msg)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log, ok := m.rooms[room]
+	if !ok {
+		log = &roomLog{}
+		m.rooms[room] = log
+	}
+	log.save(msg)
+	return nil
+}
+
+// save keeps a message among the latest ones, replacing an earlier version
+// of it. Messages older than those kept are dropped.
+func (l *roomLog) save(msg ws.TextMessage) {
+	i := sort.Search(len(l.messages), func(i int) bool { return l.messages[i].Seq >= msg.Seq })
+	switch {
+	case i < len(l.messages) && l.messages[i].Seq == msg.Seq:
+		l.messages[i] = msg
+	case i == 0 && len(l.messages) == maxRoomMessages:
+		return
+	default:
+		l.messages = slices.Insert(l.messages, i, msg)
+		if len(l.messages) > maxRoomMessages {
+			l.messages = slices.Delete(l.messages, 0, len(l.messages)-maxRoomMessages)
+		}
+	}
+}
+
+// RoomMessages implements Store
+func (m *Memory) RoomMessages(room string, limit int) ([]ws.TextMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	log, ok := m.rooms[room]
+	if !ok {
+		return []ws.TextMessage{}, nil
+	}
+	messages := log.messages
+	if limit > 0 && len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return append([]ws.TextMessage{}, messages...), nil
+}
+
+// RoomEpoch implements Store
+func (m *Memory) RoomEpoch(room, epoch string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log, ok := m.rooms[room]
+	if !ok {
+		log = &roomLog{}
+		m.rooms[room] = log
+	}
+	if log.epoch == "" {
+		log.epoch = epoch
+	}
+	return log.epoch, nil
+}
+
+// SearchMessages implements Store
+func (m *Memory) SearchMessages(req api.SearchMessagesRequest) (api.SearchMessagesResponse, error) {
+	results, total := m.index.Search(search.Query{
+		Text:   req.Query,
+		Room:   req.Room,
+		From:   req.From,
+		Since:  req.Since,
+		Until:  req.Until,
+		Offset: req.Offset,
+		Limit:  req.Limit,
+	})
+
+	response := api.SearchMessagesResponse{
+		Results: make([]api.SearchResult, 0, len(results)),
+		Total:   total,
+	}
+	for _, r := range results {
+		response.Results = append(response.Results, api.SearchResult{Room: r.Room, Message: r.Message})
+	}
+	return response, nil
+}
+
+// SaveAttachment implements Store
+func (m *Memory) SaveAttachment(att ws.Attachment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attachments[att.ID] = att
+	return nil
+}
+
+// Attachment implements Store
+func (m *Memory) Attachment(id string) (ws.Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	att, ok := m.attachments[id]
+	if !ok {
+		return ws.Attachment{}, ErrNotFound
+	}
+	return att, nil
+}
+
+// SaveBot implements Store
+func (m *Memory) SaveBot(bot api.Bot, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bots[bot.Name] = botAccount{bot: bot, tokenHash: tokenHash}
+	return nil
+}
+
+// Bot implements Store
+func (m *Memory) Bot(name string) (api.Bot, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	account, ok := m.bots[name]
+	if !ok {
+		return api.Bot{}, "", ErrNotFound
+	}
+	return account.bot, account.tokenHash, nil
+}
+
+// DeleteBot implements Store
+func (m *Memory) DeleteBot(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.bots, name)
+	return nil
+}
+
+// Bots implements Store
+func (m *Memory) Bots() ([]api.Bot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bots := make([]api.Bot, 0, len(m.bots))
+	for _, account := range m.bots {
+		bots = append(bots, account.bot)
+	}
+	sort.Slice(bots, func(i, j int) bool {
+		return bots[i].Name < bots[j].Name
+	})
+	return bots, nil
+}
+
+// SaveAccount implements Store
+func (m *Memory) SaveAccount(account api.Account, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accounts[account.Name] = userAccount{account: account, tokenHash: tokenHash}
+	return nil
+}
+
+// Account implements Store
+func (m *Memory) Account(name string) (api.Account, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	account, ok := m.accounts[name]
+	if !ok {
+		return api.Account{}, "", ErrNotFound
+	}
+	return account.account, account.tokenHash, nil
+}
+
+// DeleteAccount implements Store
+func (m *Memory) DeleteAccount(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.accounts, name)
+	return nil
+}
+
+// Accounts implements Store
+func (m *Memory) Accounts() ([]api.Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	accounts := make([]api.Account, 0, len(m.accounts))
+	for _, account := range m.accounts {
+		accounts = append(accounts, account.account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Name < accounts[j].Name
+	})
+	return accounts, nil
+}
+
+// SaveSession implements Store. Sessions expired by then are dropped.
+func (m *Memory) SaveSession(tokenHash, username string, expires int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveSession(tokenHash, username, expires)
+	return nil
+}
+
+// ClaimSession implements Store
+func (m *Memory) ClaimSession(tokenHash, username string, expires, now int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.username == username && s.expires > now {
+			return ErrTaken
+		}
+	}
+	m.saveSession(tokenHash, username, expires)
+	return nil
+}
+
+// RenameSessions implements Store
+func (m *Memory) RenameSessions(from, to string, now int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.username == to && s.expires > now {
+			return ErrTaken
+		}
+	}
+	for hash, s := range m.sessions {
+		if s.username == from {
+			s.username = to
+			m.sessions[hash] = s
+		}
+	}
+	return nil
+}
+
+// saveSession records a session, dropping the expired ones. m.mu must be
+// held.
+func (m *Memory) saveSession(tokenHash, username string, expires int64) {
+	now := time.Now().UnixMilli()
+	for hash, s := range m.sessions {
+		if s.expires <= now {
+			delete(m.sessions, hash)
+		}
+	}
+	m.sessions[tokenHash] = session{username: username, expires: expires}
+}
+
+// Session implements Store
+func (m *Memory) Session(tokenHash string, now int64) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[tokenHash]
+	if !ok || s.expires <= now {
+		return "", ErrNotFound
+	}
+	return s.username, nil
+}
+
+// HasSession implements Store
+func (m *Memory) HasSession(username string, now int64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, s := range m.sessions {
+		if s.username == username && s.expires > now {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteSession implements Store
+func (m *Memory) DeleteSession(tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, tokenHash)
+	return nil
+}
+
+// DeleteSessions implements Store
+func (m *Memory) DeleteSessions(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, s := range m.sessions {
+		if s.username == username {
+			delete(m.sessions, hash)
+		}
+	}
+	return nil
+}
+
+// Check implements Store. Memory is always usable.
+func (m *Memory) Check(ctx context.Context) error {
+	return nil
+}
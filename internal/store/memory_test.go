@@ -0,0 +1,42 @@
+package store
+
+import (
+	"go-chat/shared/ws"
+	"testing"
+)
+
+// TestRoomMessages checks that a room keeps its latest messages in Seq
+// order, with their latest versions
+func TestRoomMessages(t *testing.T) {
+	m := NewMemory()
+	if messages, err := m.RoomMessages("general", 10); err != nil || len(messages) != 0 {
+		t.Errorf("RoomMessages of a new room = %+v, %v", messages, err)
+	}
+
+	// Saved out of order, as instances sharing the store may
+	for _, seq := range []uint64{2, 1, 3} {
+		if err := m.SaveMessage("general", ws.TextMessage{ID: string(rune('a' + seq)), Seq: seq, Text: "first"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SaveMessage("general", ws.TextMessage{ID: "c", Seq: 2, Text: "edited"}); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := m.RoomMessages("general", 2)
+	if err != nil || len(messages) != 2 || messages[0].Seq != 2 || messages[0].Text != "edited" || messages[1].Seq != 3 {
+		t.Errorf("RoomMessages(general, 2) = %+v, %v; want 2 edited and 3", messages, err)
+	}
+
+	// Only the latest maxRoomMessages are kept
+	for seq := uint64(4); seq <= maxRoomMessages+10; seq++ {
+		m.SaveMessage("general", ws.TextMessage{Seq: seq})
+	}
+	m.SaveMessage("general", ws.TextMessage{Seq: 5, Text: "too old"})
+	messages, _ = m.RoomMessages("general", 0)
+	if len(messages) != maxRoomMessages || messages[0].Seq != 11 {
+		t.Errorf("kept %d messages from %d; want %d from 11", len(messages), messages[0].Seq, maxRoomMessages)
+	}
+	if messages, _ := m.RoomMessages("random", 0); len(messages) != 0 {
+		t.Errorf("RoomMessages of another room = %+v", messages)
+	}
+}
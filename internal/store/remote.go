@@ -0,0 +1,666 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nats-io/nats.go"
+)
+
+// remoteSubject is the NATS subject a served store answers requests on
+const remoteSubject = "chat.store"
+
+// remoteTimeout bounds how long a Remote waits for the served store
+const remoteTimeout = 5 * time.Second
+
+// Errors that cross the wire as codes, so callers can still match them
+// with errors.Is
+var remoteErrors = map[string]error{
+	"not_found": ErrNotFound,
+	"taken":     ErrTaken,
+}
+
+// remoteRequest calls a method of the served store
+type remoteRequest struct {
+	Method string            `json:"method"`
+	Args   []json.RawMessage `json:"args"`
+}
+
+// remoteReply carries what the method returned
+type remoteReply struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Code   string          `json:"code,omitempty"` // Key of remoteErrors
+}
+
+// connect connects to the NATS server at url. The connection reconnects
+// indefinitely if the server goes away.
+func connect(url, name string) (*nats.Conn, error) {
+	conn, err := nats.Connect(url,
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Warn("store disconnected from NATS", "error", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Info("store reconnected to NATS", "url", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS at %s: %w", url, err)
+	}
+	return conn, nil
+}
+
+// Server serves a store to the Remote stores of other instances over NATS,
+// so every instance of a deployment shares it
+type Server struct {
+	conn    *nats.Conn
+	backend Store
+}
+
+// Serve answers the requests of Remote stores connected to the NATS
+// server at url with backend, until the server is closed. Only one
+// instance of a deployment may serve its store.
+func Serve(url string, backend Store) (*Server, error) {
+	conn, err := connect(url, "go-chat-store")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{conn: conn, backend: backend}
+	if _, err := conn.Subscribe(remoteSubject, func(m *nats.Msg) {
+		go s.answer(m)
+	}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to serve store: %w", err)
+	}
+	// The subscription is known to the server once it answered a flush
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to serve store: %w", err)
+	}
+	return s, nil
+}
+
+// Close stops serving, answering the requests that already arrived
+func (s *Server) Close() error {
+	return s.conn.Drain()
+}
+
+// answer runs the request m carries against the backend and replies with
+// its result
+func (s *Server) answer(m *nats.Msg) {
+	var (
+		req    remoteRequest
+		reply  remoteReply
+		result any
+	)
+	err := json.Unmarshal(m.Data, &req)
+	if err == nil {
+		result, err = s.call(req)
+	}
+	if err == nil && result != nil {
+		reply.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+		for code, known := range remoteErrors {
+			if errors.Is(err, known) {
+				reply.Code = code
+			}
+		}
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Error("failed to encode store reply", "method", req.Method, "error", err)
+		return
+	}
+	if err := m.Respond(data); err != nil {
+		log.Error("failed to answer store request", "method", req.Method, "error", err)
+	}
+}
+
+// call runs a request against the backend, returning what the method
+// returned besides its error
+func (s *Server) call(req remoteRequest) (any, error) {
+	b := s.backend
+	args := func(args ...any) error {
+		if len(req.Args) != len(args) {
+			return fmt.Errorf("%s takes %d arguments, not %d", req.Method, len(args), len(req.Args))
+		}
+		for i, arg := range args {
+			if err := json.Unmarshal(req.Args[i], arg); err != nil {
+				return fmt.Errorf("argument %d of %s: %w", i, req.Method, err)
+			}
+		}
+		return nil
+	}
+
+	var (
+		room, username, name, tokenHash, id string
+		limit                               int
+		now, expires                        int64
+	)
+	switch req.Method {
+	case "SaveDirect":
+		var msg ws.DirectMessage
+		if err := args(&msg); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveDirect(msg)
+	case "Conversations":
+		if err := args(&username); err != nil {
+			return nil, err
+		}
+		return b.Conversations(username)
+	case "DirectMessages":
+		var with string
+		if err := args(&username, &with, &limit); err != nil {
+			return nil, err
+		}
+		return b.DirectMessages(username, with, limit)
+	case "SaveRevision":
+		var rev api.MessageRevision
+		if err := args(&rev); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveRevision(rev)
+	case "Revisions":
+		if err := args(&id); err != nil {
+			return nil, err
+		}
+		return b.Revisions(id)
+	case "Role":
+		if err := args(&room, &username); err != nil {
+			return nil, err
+		}
+		return b.Role(room, username)
+	case "SetRole":
+		var role string
+		if err := args(&room, &username, &role); err != nil {
+			return nil, err
+		}
+		return nil, b.SetRole(room, username, role)
+	case "SaveBan":
+		var ban api.Ban
+		if err := args(&ban); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveBan(ban)
+	case "Ban":
+		if err := args(&room, &username); err != nil {
+			return nil, err
+		}
+		return b.Ban(room, username)
+	case "DeleteBan":
+		if err := args(&room, &username); err != nil {
+			return nil, err
+		}
+		return nil, b.DeleteBan(room, username)
+	case "Bans":
+		if err := args(&room); err != nil {
+			return nil, err
+		}
+		return b.Bans(room)
+	case "SaveMute":
+		var until int64
+		if err := args(&room, &username, &until); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveMute(room, username, until)
+	case "DeleteMute":
+		if err := args(&room, &username); err != nil {
+			return nil, err
+		}
+		return nil, b.DeleteMute(room, username)
+	case "Mutes":
+		if err := args(&room); err != nil {
+			return nil, err
+		}
+		return b.Mutes(room)
+	case "SaveAudit":
+		var entry api.AuditEntry
+		if err := args(&entry); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveAudit(entry)
+	case "AuditLog":
+		if err := args(&room, &limit); err != nil {
+			return nil, err
+		}
+		return b.AuditLog(room, limit)
+	case "SetReadCursor":
+		var cursor api.ReadCursor
+		if err := args(&room, &username, &cursor); err != nil {
+			return nil, err
+		}
+		return nil, b.SetReadCursor(room, username, cursor)
+	case "ReadCursors":
+		if err := args(&room); err != nil {
+			return nil, err
+		}
+		return b.ReadCursors(room)
+	case "SaveMessage":
+		var msg ws.TextMessage
+		if err := args(&room, &msg); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveMessage(room, msg)
+	case "RoomMessages":
+		if err := args(&room, &limit); err != nil {
+			return nil, err
+		}
+		return b.RoomMessages(room, limit)
+	case "RoomEpoch":
+		var epoch string
+		if err := args(&room, &epoch); err != nil {
+			return nil, err
+		}
+		return b.RoomEpoch(room, epoch)
+	case "SearchMessages":
+		var search api.SearchMessagesRequest
+		if err := args(&search); err != nil {
+			return nil, err
+		}
+		return b.SearchMessages(search)
+	case "SaveBot":
+		var bot api.Bot
+		if err := args(&bot, &tokenHash); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveBot(bot, tokenHash)
+	case "Bot":
+		if err := args(&name); err != nil {
+			return nil, err
+		}
+		bot, hash, err := b.Bot(name)
+		return fileBot{Bot: bot, TokenHash: hash}, err
+	case "DeleteBot":
+		if err := args(&name); err != nil {
+			return nil, err
+		}
+		return nil, b.DeleteBot(name)
+	case "Bots":
+		return b.Bots()
+	case "SaveAccount":
+		var account api.Account
+		if err := args(&account, &tokenHash); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveAccount(account, tokenHash)
+	case "Account":
+		if err := args(&name); err != nil {
+			return nil, err
+		}
+		account, hash, err := b.Account(name)
+		return fileAccount{Account: account, TokenHash: hash}, err
+	case "DeleteAccount":
+		if err := args(&name); err != nil {
+			return nil, err
+		}
+		return nil, b.DeleteAccount(name)
+	case "Accounts":
+		return b.Accounts()
+	case "SaveSession":
+		if err := args(&tokenHash, &username, &expires); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveSession(tokenHash, username, expires)
+	case "ClaimSession":
+		if err := args(&tokenHash, &username, &expires, &now); err != nil {
+			return nil, err
+		}
+		return nil, b.ClaimSession(tokenHash, username, expires, now)
+	case "RenameSessions":
+		var to string
+		if err := args(&username, &to, &now); err != nil {
+			return nil, err
+		}
+		return nil, b.RenameSessions(username, to, now)
+	case "Session":
+		if err := args(&tokenHash, &now); err != nil {
+			return nil, err
+		}
+		return b.Session(tokenHash, now)
+	case "HasSession":
+		if err := args(&username, &now); err != nil {
+			return nil, err
+		}
+		return b.HasSession(username, now)
+	case "DeleteSession":
+		if err := args(&tokenHash); err != nil {
+			return nil, err
+		}
+		return nil, b.DeleteSession(tokenHash)
+	case "DeleteSessions":
+		if err := args(&username); err != nil {
+			return nil, err
+		}
+		return nil, b.DeleteSessions(username)
+	case "SaveAttachment":
+		var att ws.Attachment
+		if err := args(&att); err != nil {
+			return nil, err
+		}
+		return nil, b.SaveAttachment(att)
+	case "Attachment":
+		if err := args(&id); err != nil {
+			return nil, err
+		}
+		return b.Attachment(id)
+	case "Check":
+		ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+		defer cancel()
+		return nil, b.Check(ctx)
+	default:
+		return nil, fmt.Errorf("unknown store method %q", req.Method)
+	}
+}
+
+// Remote is a Store served by another instance with Serve, reached over
+// NATS. Every call is a round trip to that instance.
+type Remote struct {
+	conn *nats.Conn
+}
+
+// NewRemote connects to the store served through the NATS server at url
+func NewRemote(url string) (*Remote, error) {
+	conn, err := connect(url, "go-chat")
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{conn: conn}, nil
+}
+
+// Close closes the connection
+func (r *Remote) Close() error {
+	return r.conn.Drain()
+}
+
+// call calls method of the served store with args, decoding what it
+// returned besides its error into result, unless result is nil
+func (r *Remote) call(method string, result any, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+	return r.callContext(ctx, method, result, args...)
+}
+
+// callContext is call bounded by ctx rather than remoteTimeout
+func (r *Remote) callContext(ctx context.Context, method string, result any, args ...any) error {
+	req := remoteRequest{Method: method, Args: make([]json.RawMessage, len(args))}
+	for i, arg := range args {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			return fmt.Errorf("argument %d of %s: %w", i, method, err)
+		}
+		req.Args[i] = data
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	m, err := r.conn.RequestWithContext(ctx, remoteSubject, data)
+	if err != nil {
+		return fmt.Errorf("store %s: %w", method, err)
+	}
+	var reply remoteReply
+	if err := json.Unmarshal(m.Data, &reply); err != nil {
+		return fmt.Errorf("store %s: %w", method, err)
+	}
+	if reply.Error != "" {
+		if known, ok := remoteErrors[reply.Code]; ok {
+			return known
+		}
+		return fmt.Errorf("store %s: %s", method, reply.Error)
+	}
+	if result != nil && reply.Result != nil {
+		return json.Unmarshal(reply.Result, result)
+	}
+	return nil
+}
+
+// SaveDirect implements Store
+func (r *Remote) SaveDirect(msg ws.DirectMessage) error {
+	return r.call("SaveDirect", nil, msg)
+}
+
+// Conversations implements Store
+func (r *Remote) Conversations(username string) ([]api.Conversation, error) {
+	var conversations []api.Conversation
+	err := r.call("Conversations", &conversations, username)
+	return conversations, err
+}
+
+// DirectMessages implements Store
+func (r *Remote) DirectMessages(username, with string, limit int) ([]ws.DirectMessage, error) {
+	var messages []ws.DirectMessage
+	err := r.call("DirectMessages", &messages, username, with, limit)
+	return messages, err
+}
+
+// SaveRevision implements Store
+func (r *Remote) SaveRevision(rev api.MessageRevision) error {
+	return r.call("SaveRevision", nil, rev)
+}
+
+// Revisions implements Store
+func (r *Remote) Revisions(messageID string) ([]api.MessageRevision, error) {
+	var revisions []api.MessageRevision
+	err := r.call("Revisions", &revisions, messageID)
+	return revisions, err
+}
+
+// Role implements Store
+func (r *Remote) Role(room, username string) (string, error) {
+	var role string
+	err := r.call("Role", &role, room, username)
+	return role, err
+}
+
+// SetRole implements Store
+func (r *Remote) SetRole(room, username, role string) error {
+	return r.call("SetRole", nil, room, username, role)
+}
+
+// SaveBan implements Store
+func (r *Remote) SaveBan(ban api.Ban) error {
+	return r.call("SaveBan", nil, ban)
+}
+
+// Ban implements Store
+func (r *Remote) Ban(room, username string) (api.Ban, error) {
+	var ban api.Ban
+	err := r.call("Ban", &ban, room, username)
+	return ban, err
+}
+
+// DeleteBan implements Store
+func (r *Remote) DeleteBan(room, username string) error {
+	return r.call("DeleteBan", nil, room, username)
+}
+
+// Bans implements Store
+func (r *Remote) Bans(room string) ([]api.Ban, error) {
+	var bans []api.Ban
+	err := r.call("Bans", &bans, room)
+	return bans, err
+}
+
+// SaveMute implements Store
+func (r *Remote) SaveMute(room, username string, until int64) error {
+	return r.call("SaveMute", nil, room, username, until)
+}
+
+// DeleteMute implements Store
+func (r *Remote) DeleteMute(room, username string) error {
+	return r.call("DeleteMute", nil, room, username)
+}
+
+// Mutes implements Store
+func (r *Remote) Mutes(room string) (map[string]int64, error) {
+	mutes := make(map[string]int64)
+	err := r.call("Mutes", &mutes, room)
+	return mutes, err
+}
+
+// SaveAudit implements Store
+func (r *Remote) SaveAudit(entry api.AuditEntry) error {
+	return r.call("SaveAudit", nil, entry)
+}
+
+// AuditLog implements Store
+func (r *Remote) AuditLog(room string, limit int) ([]api.AuditEntry, error) {
+	var entries []api.AuditEntry
+	err := r.call("AuditLog", &entries, room, limit)
+	return entries, err
+}
+
+// SetReadCursor implements Store
+func (r *Remote) SetReadCursor(room, username string, cursor api.ReadCursor) error {
+	return r.call("SetReadCursor", nil, room, username, cursor)
+}
+
+// ReadCursors implements Store
+func (r *Remote) ReadCursors(room string) (map[string]api.ReadCursor, error) {
+	cursors := make(map[string]api.ReadCursor)
+	err := r.call("ReadCursors", &cursors, room)
+	return cursors, err
+}
+
+// SaveMessage implements Store
+func (r *Remote) SaveMessage(room string, msg ws.TextMessage) error {
+	return r.call("SaveMessage", nil, room, msg)
+}
+
+// RoomMessages implements Store
+func (r *Remote) RoomMessages(room string, limit int) ([]ws.TextMessage, error) {
+	var messages []ws.TextMessage
+	err := r.call("RoomMessages", &messages, room, limit)
+	return messages, err
+}
+
+// RoomEpoch implements Store
+func (r *Remote) RoomEpoch(room, epoch string) (string, error) {
+	var kept string
+	err := r.call("RoomEpoch", &kept, room, epoch)
+	return kept, err
+}
+
+// SearchMessages implements Store
+func (r *Remote) SearchMessages(req api.SearchMessagesRequest) (api.SearchMessagesResponse, error) {
+	var resp api.SearchMessagesResponse
+	err := r.call("SearchMessages", &resp, req)
+	return resp, err
+}
+
+// SaveBot implements Store
+func (r *Remote) SaveBot(bot api.Bot, tokenHash string) error {
+	return r.call("SaveBot", nil, bot, tokenHash)
+}
+
+// Bot implements Store
+func (r *Remote) Bot(name string) (api.Bot, string, error) {
+	var bot fileBot
+	err := r.call("Bot", &bot, name)
+	return bot.Bot, bot.TokenHash, err
+}
+
+// DeleteBot implements Store
+func (r *Remote) DeleteBot(name string) error {
+	return r.call("DeleteBot", nil, name)
+}
+
+// Bots implements Store
+func (r *Remote) Bots() ([]api.Bot, error) {
+	var bots []api.Bot
+	err := r.call("Bots", &bots)
+	return bots, err
+}
+
+// SaveAccount implements Store
+func (r *Remote) SaveAccount(account api.Account, tokenHash string) error {
+	return r.call("SaveAccount", nil, account, tokenHash)
+}
+
+// Account implements Store
+func (r *Remote) Account(name string) (api.Account, string, error) {
+	var account fileAccount
+	err := r.call("Account", &account, name)
+	return account.Account, account.TokenHash, err
+}
+
+// DeleteAccount implements Store
+func (r *Remote) DeleteAccount(name string) error {
+	return r.call("DeleteAccount", nil, name)
+}
+
+// Accounts implements Store
+func (r *Remote) Accounts() ([]api.Account, error) {
+	var accounts []api.Account
+	err := r.call("Accounts", &accounts)
+	return accounts, err
+}
+
+// SaveSession implements Store
+func (r *Remote) SaveSession(tokenHash, username string, expires int64) error {
+	return r.call("SaveSession", nil, tokenHash, username, expires)
+}
+
+// ClaimSession implements Store
+func (r *Remote) ClaimSession(tokenHash, username string, expires, now int64) error {
+	return r.call("ClaimSession", nil, tokenHash, username, expires, now)
+}
+
+// RenameSessions implements Store
+func (r *Remote) RenameSessions(from, to string, now int64) error {
+	return r.call("RenameSessions", nil, from, to, now)
+}
+
+// Session implements Store
+func (r *Remote) Session(tokenHash string, now int64) (string, error) {
+	var username string
+	err := r.call("Session", &username, tokenHash, now)
+	return username, err
+}
+
+// HasSession implements Store
+func (r *Remote) HasSession(username string, now int64) (bool, error) {
+	var held bool
+	err := r.call("HasSession", &held, username, now)
+	return held, err
+}
+
+// DeleteSession implements Store
+func (r *Remote) DeleteSession(tokenHash string) error {
+	return r.call("DeleteSession", nil, tokenHash)
+}
+
+// DeleteSessions implements Store
+func (r *Remote) DeleteSessions(username string) error {
+	return r.call("DeleteSessions", nil, username)
+}
+
+// SaveAttachment implements Store
+func (r *Remote) SaveAttachment(att ws.Attachment) error {
+	return r.call("SaveAttachment", nil, att)
+}
+
+// Attachment implements Store
+func (r *Remote) Attachment(id string) (ws.Attachment, error) {
+	var att ws.Attachment
+	err := r.call("Attachment", &att, id)
+	return att, err
+}
+
+// Check implements Store by having the served store check itself
+func (r *Remote) Check(ctx context.Context) error {
+	return r.callContext(ctx, "Check", nil)
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"testing"
+
+	"github.com/nats-io/nats-server/v2/test"
+)
+
+// serveMemory serves a memory store through an embedded NATS server, and
+// returns it with the URL of the server
+func serveMemory(t *testing.T) (*Memory, string) {
+	t.Helper()
+	opts := test.DefaultTestOptions
+	opts.Port = -1
+	s := test.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+
+	backend := NewMemory()
+	server, err := Serve(s.ClientURL(), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return backend, s.ClientURL()
+}
+
+func newRemote(t *testing.T, url string) *Remote {
+	t.Helper()
+	r, err := NewRemote(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// TestRemoteShares checks that instances using a served store see each
+// other's sessions, accounts, bots and moderation
+func TestRemoteShares(t *testing.T) {
+	backend, url := serveMemory(t)
+	a, b := newRemote(t, url), newRemote(t, url)
+
+	if err := a.Check(context.Background()); err != nil {
+		t.Errorf("Check = %v", err)
+	}
+
+	if err := a.SaveSession("hash", "alice", 1<<62); err != nil {
+		t.Fatal(err)
+	}
+	if username, err := b.Session("hash", 0); err != nil || username != "alice" {
+		t.Errorf("Session on another instance = %q, %v; want alice", username, err)
+	}
+	if _, err := b.Session("other", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Session of an unknown token = %v; want ErrNotFound", err)
+	}
+
+	if err := a.SaveAccount(api.Account{Name: "alice", CreatedAt: 1}, "acct"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SaveBot(api.Bot{Name: "deploy", CreatedBy: "alice"}, "bot"); err != nil {
+		t.Fatal(err)
+	}
+	if account, hash, err := b.Account("alice"); err != nil || account.CreatedAt != 1 || hash != "acct" {
+		t.Errorf("Account(alice) = %+v, %q, %v", account, hash, err)
+	}
+	if bot, hash, err := b.Bot("deploy"); err != nil || bot.CreatedBy != "alice" || hash != "bot" {
+		t.Errorf("Bot(deploy) = %+v, %q, %v", bot, hash, err)
+	}
+	if _, _, err := b.Bot("alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Bot(alice) = %v; want ErrNotFound", err)
+	}
+
+	if err := a.SetRole("general", "alice", api.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SaveMute("general", "mallory", 1<<62); err != nil {
+		t.Fatal(err)
+	}
+	if role, err := b.Role("general", "alice"); err != nil || role != api.RoleOwner {
+		t.Errorf("Role(general, alice) = %q, %v; want %q", role, err, api.RoleOwner)
+	}
+	if mutes, err := b.Mutes("general"); err != nil || mutes["mallory"] != 1<<62 {
+		t.Errorf("Mutes(general) = %v, %v; want mallory muted", mutes, err)
+	}
+
+	// Both instances apply what a room publishes, but it is saved once
+	entry := api.AuditEntry{Room: "general", Action: api.AuditMute, Actor: "alice", Target: "mallory", At: 2}
+	for _, s := range []Store{a, b} {
+		if err := s.SaveAudit(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries, _ := backend.AuditLog("general", 10); len(entries) != 1 {
+		t.Errorf("audit log holds %d entries; want 1", len(entries))
+	}
+
+	if err := a.SaveMessage("general", ws.TextMessage{ID: "1", From: "alice", Text: "shipping the release", Timestamp: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := b.SearchMessages(api.SearchMessagesRequest{Room: "general", Query: "release"}); err != nil || results.Total != 1 {
+		t.Errorf("SearchMessages(release) = %+v, %v; want the message", results, err)
+	}
+	if messages, err := b.RoomMessages("general", 10); err != nil || len(messages) != 1 || messages[0].ID != "1" {
+		t.Errorf("RoomMessages(general) = %+v, %v; want the message", messages, err)
+	}
+
+	// The room's numbering is the one started first
+	if epoch, err := a.RoomEpoch("general", "a"); err != nil || epoch != "a" {
+		t.Errorf("RoomEpoch(general, a) = %q, %v; want a", epoch, err)
+	}
+	if epoch, err := b.RoomEpoch("general", "b"); err != nil || epoch != "a" {
+		t.Errorf("RoomEpoch(general, b) = %q, %v; want a", epoch, err)
+	}
+}
+
+// TestRemoteClaimSession checks that a guest name is given to one instance
+// only
+func TestRemoteClaimSession(t *testing.T) {
+	_, url := serveMemory(t)
+	a, b := newRemote(t, url), newRemote(t, url)
+
+	if err := a.ClaimSession("a", "carol", 1<<62, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ClaimSession("b", "carol", 1<<62, 0); !errors.Is(err, ErrTaken) {
+		t.Errorf("ClaimSession of a held name = %v; want ErrTaken", err)
+	}
+	// Once the session expired, the name is free again
+	if err := b.ClaimSession("b", "carol", 1<<62, 1<<62); err != nil {
+		t.Errorf("ClaimSession of an expired name = %v", err)
+	}
+}
+
+// TestRemoteRenameSessions checks that a guest's sessions move to a free
+// name only, and free the old one
+func TestRemoteRenameSessions(t *testing.T) {
+	_, url := serveMemory(t)
+	a, b := newRemote(t, url), newRemote(t, url)
+
+	if err := a.ClaimSession("a", "carol", 1<<62, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ClaimSession("b", "dave", 1<<62, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RenameSessions("carol", "dave", 0); !errors.Is(err, ErrTaken) {
+		t.Errorf("RenameSessions to a held name = %v; want ErrTaken", err)
+	}
+	if err := a.RenameSessions("carol", "erin", 0); err != nil {
+		t.Fatal(err)
+	}
+	if username, err := b.Session("a", 0); err != nil || username != "erin" {
+		t.Errorf("Session(a) after renaming = %q, %v; want erin", username, err)
+	}
+	if err := b.ClaimSession("c", "carol", 1<<62, 0); err != nil {
+		t.Errorf("ClaimSession of the old name = %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Package store persists chat history
+package store
+
+import (
+	"context"
+	"errors"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+)
+
+var (
+	// ErrNotFound is returned when a record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrTaken is returned when claiming a username someone else holds
+	ErrTaken = errors.New("taken")
+)
+
+// Store persists chat history. Implementations must be safe for concurrent
+// use.
+//
+// Every instance of a room applies what is published to it, so when
+// instances share a store, each saves the same revisions and audit
+// entries. Saving one equal to a kept one again changes nothing.
+type Store interface {
+	// SaveDirect records a direct message
+	SaveDirect(msg ws.DirectMessage) error
+
+	// Conversations lists the direct message conversations of username,
+	// most recently active first
+	Conversations(username string) ([]api.Conversation, error)
+
+	// DirectMessages returns the latest limit messages exchanged between
+	// two users, oldest first
+	DirectMessages(username, with string, limit int) ([]ws.DirectMessage, error)
+
+	// SaveRevision records the text a room message had before an edit or
+	// deletion
+	SaveRevision(rev api.MessageRevision) error
+
+	// Revisions returns the edit history of a message, oldest first
+	Revisions(messageID string) ([]api.MessageRevision, error)
+
+	// Role returns the role of a user in a room, api.RoleMember unless set
+	// otherwise
+	Role(room, username string) (string, error)
+
+	// SetRole changes the role of a user in a room
+	SetRole(room, username, role string) error
+
+	// SaveBan records a ban, replacing any earlier ban of the same user in
+	// the same room
+	SaveBan(ban api.Ban) error
+
+	// Ban returns the ban of a user in a room, or ErrNotFound. Expired bans
+	// are returned too; callers check Until.
+	Ban(room, username string) (api.Ban, error)
+
+	// DeleteBan lifts the ban of a user in a room, if any
+	DeleteBan(room, username string) error
+
+	// Bans returns the bans of a room, most recent first
+	Bans(room string) ([]api.Ban, error)
+
+	// SaveMute records that a user is muted in a room until the given
+	// Unix milliseconds, replacing any earlier mute
+	SaveMute(room, username string, until int64) error
+
+	// DeleteMute lifts the mute of a user in a room, if any
+	DeleteMute(room, username string) error
+
+	// Mutes returns when the mutes of a room end, keyed by username.
+	// Expired mutes are returned too; callers check them.
+	Mutes(room string) (map[string]int64, error)
+
+	// SaveAudit records a moderation action
+	SaveAudit(entry api.AuditEntry) error
+
+	// AuditLog returns the latest limit moderation actions in a room,
+	// newest first
+	AuditLog(room string, limit int) ([]api.AuditEntry, error)
+
+	// SetReadCursor records the last room message a user has read
+	SetReadCursor(room, username string, cursor api.ReadCursor) error
+
+	// ReadCursors returns the read cursors of a room, keyed by username
+	ReadCursors(room string) (map[string]api.ReadCursor, error)
+
+	// SaveMessage records the latest version of a room message and makes
+	// it searchable. Deleted messages stop being searchable.
+	SaveMessage(room string, msg ws.TextMessage) error
+
+	// RoomMessages returns the latest limit messages saved for a room, in
+	// Seq order
+	RoomMessages(room string, limit int) ([]ws.TextMessage, error)
+
+	// RoomEpoch returns the epoch the messages of a room are numbered in,
+	// recording epoch as it when the room has none yet. Rooms resume their
+	// numbering from the store this way, so read cursors and the points
+	// clients resume from stay valid across restarts.
+	RoomEpoch(room, epoch string) (string, error)
+
+	// SearchMessages returns a page of the room messages matching req,
+	// newest first
+	SearchMessages(req api.SearchMessagesRequest) (api.SearchMessagesResponse, error)
+
+	// SaveBot records a bot account with the hash of its token, replacing
+	// any bot of the same name
+	SaveBot(bot api.Bot, tokenHash string) error
+
+	// Bot returns a bot account and the hash of its token, or ErrNotFound
+	Bot(name string) (api.Bot, string, error)
+
+	// DeleteBot deletes a bot account, if it exists
+	DeleteBot(name string) error
+
+	// Bots returns the bot accounts, ordered by name
+	Bots() ([]api.Bot, error)
+
+	// SaveAccount records the account of a person with the hash of its
+	// token, replacing any account of the same name
+	SaveAccount(account api.Account, tokenHash string) error
+
+	// Account returns an account and the hash of its token, or ErrNotFound
+	Account(name string) (api.Account, string, error)
+
+	// DeleteAccount deletes an account, if it exists
+	DeleteAccount(name string) error
+
+	// Accounts returns the accounts of people, ordered by name
+	Accounts() ([]api.Account, error)
+
+	// SaveSession records a session of username until expires, in Unix
+	// milliseconds, keyed by the hash of its token. Saving it again
+	// extends it.
+	SaveSession(tokenHash, username string, expires int64) error
+
+	// ClaimSession records a session like SaveSession, unless another
+	// unexpired session at now holds username, when it returns ErrTaken.
+	// Guests take their names this way, so no two get the same one.
+	ClaimSession(tokenHash, username string, expires, now int64) error
+
+	// RenameSessions moves every session of from to the name to, unless an
+	// unexpired session at now holds to, when it returns ErrTaken. Guests
+	// change their names this way, keeping their tokens.
+	RenameSessions(from, to string, now int64) error
+
+	// Session returns the username of an unexpired session, or ErrNotFound
+	Session(tokenHash string, now int64) (string, error)
+
+	// HasSession reports whether username holds an unexpired session
+	HasSession(username string, now int64) (bool, error)
+
+	// DeleteSession ends a session, if it exists
+	DeleteSession(tokenHash string) error
+
+	// DeleteSessions ends every session of username
+	DeleteSessions(username string) error
+
+	// SaveAttachment records an uploaded file. Its contents are kept in a
+	// blob store.
+	SaveAttachment(att ws.Attachment) error
+
+	// Attachment returns an uploaded file by ID, or ErrNotFound
+	Attachment(id string) (ws.Attachment, error)
+
+	// Check reports whether the store can be used, for readiness checks
+	Check(ctx context.Context) error
+}
@@ -0,0 +1,147 @@
+// Package thumbnail scales images down for inline previews, using only the
+// standard library decoders and encoders.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register decoders
+	"image/jpeg"
+	"image/png"
+)
+
+// Bounds of generated thumbnails and of the images they are made from
+const (
+	MaxSize   = 320        // Pixels along the longer side of a thumbnail
+	MaxPixels = 40_000_000 // Larger images are not decoded, to bound memory
+)
+
+// samples is how many source pixels are averaged per thumbnail pixel along
+// each axis. Averaging a few samples rather than every covered pixel keeps
+// large images cheap while still smoothing away aliasing.
+const samples = 4
+
+// ErrUnsupported is returned for data that is not a GIF, JPEG or PNG image
+var ErrUnsupported = errors.New("unsupported image format")
+
+// ErrTooLarge is returned for images with more than MaxPixels pixels
+var ErrTooLarge = errors.New("image too large")
+
+// Thumbnail is an encoded thumbnail of an image
+type Thumbnail struct {
+	Data        []byte
+	ContentType string // image/jpeg for opaque images, image/png otherwise
+	Width       int    // Of the thumbnail
+	Height      int
+
+	// Dimensions of the image the thumbnail was made from
+	SourceWidth  int
+	SourceHeight int
+}
+
+// Generate decodes an image and scales it to fit within MaxSize pixels on
+// each side. Images that already fit are re-encoded at their own size.
+func Generate(data []byte) (*Thumbnail, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, fmt.Errorf("failed to read image: empty image")
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	width, height := fit(config.Width, config.Height, MaxSize)
+	dst := scale(src, width, height)
+
+	thumb := &Thumbnail{
+		Width:        width,
+		Height:       height,
+		SourceWidth:  config.Width,
+		SourceHeight: config.Height,
+	}
+	var buf bytes.Buffer
+	if opaque, ok := src.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		thumb.ContentType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80})
+	} else {
+		thumb.ContentType = "image/png"
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	thumb.Data = buf.Bytes()
+	return thumb, nil
+}
+
+// fit returns the largest size with the aspect ratio of width x height
+// that fits in a limit x limit square, never enlarging
+func fit(width, height, limit int) (int, int) {
+	if width <= limit && height <= limit {
+		return width, height
+	}
+	if width >= height {
+		return limit, max(1, height*limit/width)
+	}
+	return max(1, width*limit/height), limit
+}
+
+// scale resizes src to width x height, averaging a grid of samples from
+// the area of src each destination pixel covers
+func scale(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+
+			var r, g, b, a, n uint64
+			for _, sy := range sampleAt(y0, y1) {
+				for _, sx := range sampleAt(x0, x1) {
+					pr, pg, pb, pa := src.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			// At returns premultiplied 16 bit channels, as RGBA stores them
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// sampleAt returns up to samples evenly spread offsets in [lo, hi), or lo
+// alone when the range is empty
+func sampleAt(lo, hi int) []int {
+	span := hi - lo
+	if span <= 1 {
+		return []int{lo}
+	}
+	n := min(span, samples)
+	offsets := make([]int, n)
+	for i := range offsets {
+		offsets[i] = lo + (2*i+1)*span/(2*n)
+	}
+	return offsets
+}
@@ -0,0 +1,152 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encode returns img encoded with encoder
+func encode(t *testing.T, img image.Image, encoder func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encoder(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(buf *bytes.Buffer, img image.Image) error  { return png.Encode(buf, img) }
+func encodeJPEG(buf *bytes.Buffer, img image.Image) error { return jpeg.Encode(buf, img, nil) }
+func encodeGIF(buf *bytes.Buffer, img image.Image) error  { return gif.Encode(buf, img, nil) }
+
+// filled returns a width x height image of a single color
+func filled(width, height int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestGenerate(t *testing.T) {
+	opaque := color.NRGBA{R: 200, G: 100, B: 50, A: 255}
+	translucent := color.NRGBA{R: 200, G: 100, B: 50, A: 128}
+
+	for _, tc := range []struct {
+		name          string
+		data          []byte
+		width, height int
+		contentType   string
+	}{
+		{"wide PNG", encode(t, filled(1000, 500, opaque), encodePNG), 320, 160, "image/jpeg"},
+		{"tall JPEG", encode(t, filled(300, 900, opaque), encodeJPEG), 106, 320, "image/jpeg"},
+		{"translucent PNG", encode(t, filled(640, 640, translucent), encodePNG), 320, 320, "image/png"},
+		{"GIF", encode(t, filled(400, 100, opaque), encodeGIF), 320, 80, "image/jpeg"},
+		{"small image", encode(t, filled(20, 10, opaque), encodePNG), 20, 10, "image/jpeg"},
+		{"thin strip", encode(t, filled(2000, 1, opaque), encodePNG), 320, 1, "image/jpeg"},
+	} {
+		thumb, err := Generate(tc.data)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if thumb.Width != tc.width || thumb.Height != tc.height || thumb.ContentType != tc.contentType {
+			t.Errorf("%s: thumbnail is %dx%d %s; want %dx%d %s", tc.name, thumb.Width, thumb.Height, thumb.ContentType, tc.width, tc.height, tc.contentType)
+		}
+
+		// The thumbnail decodes to what it says it is
+		img, format, err := image.Decode(bytes.NewReader(thumb.Data))
+		if err != nil {
+			t.Errorf("%s: thumbnail does not decode: %v", tc.name, err)
+			continue
+		}
+		if "image/"+format != thumb.ContentType || img.Bounds().Dx() != thumb.Width || img.Bounds().Dy() != thumb.Height {
+			t.Errorf("%s: thumbnail decodes to a %dx%d %s", tc.name, img.Bounds().Dx(), img.Bounds().Dy(), format)
+		}
+		config, _, _ := image.DecodeConfig(bytes.NewReader(tc.data))
+		if thumb.SourceWidth != config.Width || thumb.SourceHeight != config.Height {
+			t.Errorf("%s: source is %dx%d; want %dx%d", tc.name, thumb.SourceWidth, thumb.SourceHeight, config.Width, config.Height)
+		}
+	}
+}
+
+// TestGenerateAverages checks that scaling blends the pixels it covers, and
+// keeps the colors of translucent images
+func TestGenerateAverages(t *testing.T) {
+	// Alternating black and white columns average to gray
+	stripes := image.NewNRGBA(image.Rect(0, 0, 640, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 640; x++ {
+			if x%2 == 0 {
+				stripes.Set(x, y, color.White)
+			} else {
+				stripes.Set(x, y, color.Black)
+			}
+		}
+	}
+	dst := scale(stripes, 320, 5)
+	if c := dst.RGBAAt(100, 2); c.R < 120 || c.R > 135 || c.A != 255 {
+		t.Errorf("striped pixel scaled to %+v; want gray", c)
+	}
+
+	// Premultiplied channels stay consistent with alpha
+	half := filled(8, 8, color.NRGBA{R: 255, A: 128})
+	if c := scale(half, 2, 2).RGBAAt(0, 0); c.A != 128 || c.R != 128 {
+		t.Errorf("translucent red scaled to %+v; want R 128, A 128 premultiplied", c)
+	}
+}
+
+func TestGenerateRejects(t *testing.T) {
+	if _, err := Generate([]byte("not an image")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Generate(text) = %v; want ErrUnsupported", err)
+	}
+
+	// The size is checked before the pixels are decoded, so a header is
+	// enough
+	huge := encode(t, image.NewGray(image.Rect(0, 0, 1, 1)), encodePNG)
+	setPNGSize(huge, 10_000, 10_000)
+	if _, err := Generate(huge); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Generate of a 10000x10000 image = %v; want ErrTooLarge", err)
+	}
+
+	// A truncated image fails to decode
+	data := encode(t, filled(100, 100, color.White), encodePNG)
+	if _, err := Generate(data[:len(data)/2]); err == nil {
+		t.Error("Generate of a truncated image succeeded")
+	}
+}
+
+// setPNGSize overwrites the dimensions in the IHDR chunk of a PNG, which
+// follows the signature, and updates the chunk's checksum
+func setPNGSize(data []byte, width, height uint32) {
+	const chunk = 8 + 4 // Signature and length
+	ihdr := data[chunk : chunk+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	binary.BigEndian.PutUint32(data[chunk+len(ihdr):], crc32.ChecksumIEEE(ihdr))
+}
+
+func TestFit(t *testing.T) {
+	for _, tc := range []struct{ width, height, wantWidth, wantHeight int }{
+		{320, 320, 320, 320},
+		{100, 50, 100, 50},
+		{640, 320, 320, 160},
+		{321, 100, 320, 99},
+		{100, 3200, 10, 320},
+		{10000, 1, 320, 1},
+	} {
+		if w, h := fit(tc.width, tc.height, MaxSize); w != tc.wantWidth || h != tc.wantHeight {
+			t.Errorf("fit(%d, %d) = %d, %d; want %d, %d", tc.width, tc.height, w, h, tc.wantWidth, tc.wantHeight)
+		}
+	}
+}
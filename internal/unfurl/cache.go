@@ -0,0 +1,69 @@
+package unfurl
+
+import (
+	"container/list"
+	"go-chat/shared/ws"
+	"sync"
+	"time"
+)
+
+// cache keeps the outcome of recent fetches by URL, evicting the least
+// recently used beyond its size. It is safe for concurrent use.
+type cache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // URL → element holding an *entry
+	order   *list.List               // Most recently used first
+}
+
+type entry struct {
+	url     string
+	preview ws.LinkPreview
+	err     error
+	expires time.Time
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached outcome of fetching url, if it has not expired.
+// Entries are never modified, so it may be read without the lock.
+func (c *cache) get(url string, now time.Time) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !now.Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, url)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e, true
+}
+
+// put caches the outcome of fetching url until expires
+func (c *cache) put(url string, preview ws.LinkPreview, err error, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[url]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[url] = c.order.PushFront(&entry{url: url, preview: preview, err: err, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).url)
+	}
+}
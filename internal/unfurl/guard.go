@@ -0,0 +1,50 @@
+package unfurl
+
+import (
+	"fmt"
+	"net/netip"
+	"syscall"
+)
+
+// blockedPrefixes are special purpose ranges that netip does not classify
+// as private, but that must not be fetched either
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // This network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which reaches any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+	netip.MustParsePrefix("2002::/16"),      // 6to4, likewise
+}
+
+// public reports whether addr is a public unicast address
+func public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// guard returns a net.Dialer Control function refusing connections to
+// addresses that are not public, unless allowPrivate is set. It runs after
+// name resolution, for the address actually dialed.
+func guard(allowPrivate bool) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if !allowPrivate && !public(addrPort.Addr()) {
+			return fmt.Errorf("%s: %w", addrPort.Addr(), ErrBlocked)
+		}
+		return nil
+	}
+}
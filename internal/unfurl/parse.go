@@ -0,0 +1,151 @@
+package unfurl
+
+import (
+	"go-chat/shared/ws"
+	"io"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Bounds on the fields of a preview
+const (
+	maxTitle       = 200  // Runes
+	maxDescription = 300  // Runes
+	maxSiteName    = 100  // Runes
+	maxImageURL    = 2048 // Bytes
+)
+
+// parse reads the preview of an HTML page from its OpenGraph metadata,
+// falling back to Twitter card metadata and then to the page's title and
+// description. Relative image URLs are resolved against base, the URL the
+// page was fetched from.
+func parse(r io.Reader, base *url.URL) (ws.LinkPreview, error) {
+	meta := make(map[string]string) // property or name → first content
+	var title strings.Builder
+	inTitle, titled := false, false
+
+	z := html.NewTokenizer(r)
+scan:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return ws.LinkPreview{}, err
+			}
+			break scan
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				break scan // Metadata is only read from the head
+			case atom.Title:
+				inTitle = !titled
+			case atom.Meta:
+				if hasAttr {
+					if key, content := metaAttrs(z); key != "" {
+						if _, ok := meta[key]; !ok {
+							meta[key] = content
+						}
+					}
+				}
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Head:
+				break scan
+			case atom.Title:
+				inTitle, titled = false, titled || inTitle
+			}
+
+		case html.TextToken:
+			if inTitle {
+				title.Write(z.Text())
+			}
+		}
+	}
+
+	preview := ws.LinkPreview{
+		Title:       clean(first(meta["og:title"], meta["twitter:title"], title.String()), maxTitle),
+		Description: clean(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescription),
+		Image:       resolveImage(base, first(meta["og:image"], meta["og:image:url"], meta["og:image:secure_url"], meta["twitter:image"], meta["twitter:image:src"])),
+		SiteName:    clean(meta["og:site_name"], maxSiteName),
+	}
+	if preview.Title == "" {
+		return ws.LinkPreview{}, ErrNoPreview
+	}
+	return preview, nil
+}
+
+// metaAttrs returns the property, or else name, of the meta tag z is at,
+// lower cased, and its content
+func metaAttrs(z *html.Tokenizer) (key, content string) {
+	var property, name string
+	for {
+		attr, value, more := z.TagAttr()
+		switch string(attr) {
+		case "property":
+			property = strings.ToLower(strings.TrimSpace(string(value)))
+		case "name":
+			name = strings.ToLower(strings.TrimSpace(string(value)))
+		case "content":
+			content = string(value)
+		}
+		if !more {
+			break
+		}
+	}
+	if property != "" {
+		return property, content
+	}
+	return name, content
+}
+
+// first returns the first non-blank value
+func first(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// clean collapses whitespace, drops control characters and cuts s to at
+// most limit runes
+func clean(s string, limit int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, s)
+	if runes := []rune(s); len(runes) > limit {
+		s = strings.TrimSpace(string(runes[:limit-1])) + "…"
+	}
+	return s
+}
+
+// resolveImage resolves an image URL against the page's URL, keeping only
+// absolute http and https URLs
+func resolveImage(base *url.URL, image string) string {
+	image = strings.TrimSpace(image)
+	if image == "" || len(image) > maxImageURL {
+		return ""
+	}
+	u, err := base.Parse(image)
+	if err != nil || !fetchable(u) {
+		return ""
+	}
+	if s := u.String(); len(s) <= maxImageURL {
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+// Package unfurl fetches previews of the pages linked from chat messages,
+// from their OpenGraph metadata
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-chat/shared/ws"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// userAgent identifies the unfurler to the sites it fetches
+const userAgent = "go-chat-unfurler/1.0 (link previews)"
+
+// maxRedirects bounds the redirects followed per page
+const maxRedirects = 5
+
+var (
+	// ErrBlocked is returned for pages on addresses that may not be
+	// fetched, such as private networks
+	ErrBlocked = errors.New("address not allowed")
+	// ErrNoPreview is returned for pages without a title to preview
+	ErrNoPreview = errors.New("page has no preview")
+)
+
+// Config tunes a Client. Zero fields take their defaults.
+type Config struct {
+	// Timeout bounds fetching one page, including redirects; defaults to
+	// 5 seconds
+	Timeout time.Duration
+	// MaxBytes bounds how much of a page is read; defaults to 1 MB
+	MaxBytes int64
+	// CacheSize bounds the number of pages cached; defaults to 1000
+	CacheSize int
+	// CacheTTL is how long a preview is cached; defaults to an hour. Pages
+	// that failed are cached for a tenth of it.
+	CacheTTL time.Duration
+	// AllowPrivate also allows loopback, private and link-local addresses,
+	// e.g. for local test servers
+	AllowPrivate bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = 1 << 20
+	}
+	if c.CacheSize == 0 {
+		c.CacheSize = 1000
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = time.Hour
+	}
+	return c
+}
+
+// Client fetches and caches link previews. It is safe for concurrent use.
+type Client struct {
+	config Config
+	http   *http.Client
+	cache  *cache
+}
+
+// New creates a Client. Unless config.AllowPrivate is set, it only
+// connects to public addresses, checked on every connection so that
+// neither redirects nor DNS answers can point it at internal services.
+func New(config Config) *Client {
+	config = config.withDefaults()
+	c := &Client{
+		config: config,
+		cache:  newCache(config.CacheSize),
+	}
+
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		Control: guard(config.AllowPrivate),
+	}
+	c.http = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 nil, // A proxy would make the dialed address meaningless
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          16,
+			IdleConnTimeout:       30 * time.Second,
+			TLSHandshakeTimeout:   config.Timeout,
+			ResponseHeaderTimeout: config.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return errors.New("too many redirects")
+			}
+			if !fetchable(req.URL) {
+				return fmt.Errorf("redirect to %s: %w", req.URL.Scheme, ErrBlocked)
+			}
+			return nil
+		},
+	}
+	return c
+}
+
+// Unfurl returns the preview of the page at rawURL, from the cache if it
+// was fetched recently
+func (c *Client) Unfurl(ctx context.Context, rawURL string) (ws.LinkPreview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ws.LinkPreview{}, err
+	}
+	if !fetchable(u) {
+		return ws.LinkPreview{}, ErrBlocked
+	}
+
+	now := time.Now()
+	if cached, ok := c.cache.get(rawURL, now); ok {
+		return cached.preview, cached.err
+	}
+
+	preview, err := c.fetch(ctx, u)
+	if err == nil {
+		preview.URL = rawURL
+	}
+	// Failures caused by the caller giving up say nothing about the page
+	if ctx.Err() == nil {
+		ttl := c.config.CacheTTL
+		if err != nil {
+			ttl /= 10
+		}
+		c.cache.put(rawURL, preview, err, now.Add(ttl))
+	}
+	return preview, err
+}
+
+func (c *Client) fetch(ctx context.Context, u *url.URL) (ws.LinkPreview, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return ws.LinkPreview{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return ws.LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ws.LinkPreview{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return ws.LinkPreview{}, fmt.Errorf("unsupported content type %q: %w", contentType, ErrNoPreview)
+	}
+
+	// Previews come from the head, so a truncated page still has one
+	body, err := charset.NewReader(io.LimitReader(resp.Body, c.config.MaxBytes), contentType)
+	if err != nil {
+		return ws.LinkPreview{}, err
+	}
+	return parse(body, resp.Request.URL)
+}
+
+// fetchable reports whether a URL may be fetched at all
+func fetchable(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Hostname() != "" && u.User == nil
+}
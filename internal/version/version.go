@@ -0,0 +1,78 @@
+// Package version reports the build hashes of the server binary and of the
+// WASM frontend it serves.
+package version
+
+import (
+	"go-chat/shared/api"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+)
+
+// FrontendFile is written next to main.wasm by scripts/build_wasm.sh and
+// holds the frontend BuildHash
+const FrontendFile = "version.txt"
+
+// Unknown is reported when a build hash cannot be determined
+const Unknown = api.VersionUnknown
+
+// serverHash can be set at link time with -ldflags "-X go-chat/internal/version.serverHash=..."
+var serverHash string
+
+// Server returns the build hash of the running server binary. It falls back
+// to the VCS revision embedded by the Go toolchain.
+func Server() string {
+	if serverHash != "" {
+		return serverHash
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Unknown
+	}
+
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if revision == "" {
+		return Unknown
+	}
+	if len(revision) > 8 {
+		revision = revision[:8]
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// Frontend returns the build hash of the WASM bundle currently in distDir.
+// The file is read on every call so a redeployed frontend is noticed without
+// restarting the server.
+func Frontend(distDir string) string {
+	data, err := os.ReadFile(filepath.Join(distDir, FrontendFile))
+	if err != nil {
+		return Unknown
+	}
+	hash := strings.TrimSpace(string(data))
+	if hash == "" {
+		return Unknown
+	}
+	return hash
+}
+
+// Mismatch reports whether a client running clientBuild should reload to
+// pick up currentBuild. Unknown builds never count as a mismatch.
+func Mismatch(clientBuild, currentBuild string) bool {
+	if clientBuild == "" || clientBuild == Unknown || currentBuild == Unknown {
+		return false
+	}
+	return clientBuild != currentBuild
+}
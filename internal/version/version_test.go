@@ -0,0 +1,59 @@
+package version
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	defer func(hash string) { serverHash = hash }(serverHash)
+
+	serverHash = "abc123"
+	if got := Server(); got != "abc123" {
+		t.Errorf("Server with a linked hash = %q; want abc123", got)
+	}
+	// Test binaries carry no VCS revision
+	serverHash = ""
+	if got := Server(); got != Unknown {
+		t.Errorf("Server of a test binary = %q; want %q", got, Unknown)
+	}
+}
+
+// TestFrontend checks that the frontend hash is read anew on every call
+func TestFrontend(t *testing.T) {
+	dir := t.TempDir()
+	if got := Frontend(dir); got != Unknown {
+		t.Errorf("Frontend without %s = %q; want %q", FrontendFile, got, Unknown)
+	}
+
+	for content, want := range map[string]string{
+		"1a2b3c\n": "1a2b3c",
+		" \n":      Unknown,
+		"4d5e6f":   "4d5e6f",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, FrontendFile), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got := Frontend(dir); got != want {
+			t.Errorf("Frontend of %q = %q; want %q", content, got, want)
+		}
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		client, current string
+		want            bool
+	}{
+		{"1a2b3c", "1a2b3c", false},
+		{"1a2b3c", "4d5e6f", true},
+		{"", "4d5e6f", false},
+		{Unknown, "4d5e6f", false},
+		{"1a2b3c", Unknown, false},
+	} {
+		if got := Mismatch(tc.client, tc.current); got != tc.want {
+			t.Errorf("Mismatch(%q, %q) = %v; want %v", tc.client, tc.current, got, tc.want)
+		}
+	}
+}
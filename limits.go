@@ -0,0 +1,91 @@
+package main
+
+import (
+	"go-chat/internal/ratelimit"
+	"go-chat/shared/ws"
+	"time"
+)
+
+// WebSocket budgets. Every connection has its own buckets, and all
+// connections of a user additionally share a per-user set, so opening more
+// tabs does not multiply the allowance.
+var (
+	connMessageLimit = ratelimit.Limit{Rate: 5, Burst: 10}
+	connTypingLimit  = ratelimit.Limit{Rate: 10, Burst: 20}
+	connOtherLimit   = ratelimit.Limit{Rate: 10, Burst: 20}
+
+	userMessageLimit = ratelimit.Limit{Rate: 10, Burst: 20}
+	userTypingLimit  = ratelimit.Limit{Rate: 20, Burst: 40}
+)
+
+// apiLimit is the per-IP budget for REST routes
+var apiLimit = ratelimit.Limit{Rate: 20, Burst: 40}
+
+// A connection that is rate limited maxViolations times within
+// violationWindow is disconnected
+const (
+	maxViolations   = 10
+	violationWindow = 10 * time.Second
+)
+
+// userLimits holds the per-user buckets shared by all connections
+type userLimits struct {
+	messages *ratelimit.Limiter
+	typing   *ratelimit.Limiter
+}
+
+func newUserLimits() *userLimits {
+	return &userLimits{
+		messages: ratelimit.NewLimiter(userMessageLimit),
+		typing:   ratelimit.NewLimiter(userTypingLimit),
+	}
+}
+
+// connLimits rate limits the messages read from a single connection. It is
+// only used by the goroutine reading that connection.
+type connLimits struct {
+	username   string // User of the connection's session, keying its per-user buckets
+	users      *userLimits
+	messages   *ratelimit.Bucket
+	typing     *ratelimit.Bucket
+	other      *ratelimit.Bucket
+	violations []time.Time
+}
+
+func newConnLimits(username string, users *userLimits) *connLimits {
+	return &connLimits{
+		username: username,
+		users:    users,
+		messages: ratelimit.NewBucket(connMessageLimit),
+		typing:   ratelimit.NewBucket(connTypingLimit),
+		other:    ratelimit.NewBucket(connOtherLimit),
+	}
+}
+
+// allow reports whether a message of type t may be forwarded, and if not,
+// how long the client should wait before sending another. Messages refused
+// by either the connection's or the user's budget count against neither.
+func (c *connLimits) allow(t ws.MessageType) (bool, time.Duration) {
+	switch t {
+	case ws.TypeMessage, ws.TypeDirect:
+		return ratelimit.AllowAll(c.messages, c.users.messages.Bucket(c.username))
+	case ws.TypeTyping:
+		return ratelimit.AllowAll(c.typing, c.users.typing.Bucket(c.username))
+	default:
+		return c.other.Allow()
+	}
+}
+
+// violation records a rejected message and reports whether the connection
+// has now been rate limited too often and should be dropped
+func (c *connLimits) violation() bool {
+	now := time.Now()
+	recent := c.violations[:0]
+	for _, t := range c.violations {
+		if now.Sub(t) < violationWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.violations = append(recent, now)
+	return len(c.violations) >= maxViolations
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"go-chat/internal/ratelimit"
+	"go-chat/shared/ws"
+	"testing"
+)
+
+// TestConnLimitsRefusalsCostNothing checks that a message refused by the
+// user's budget does not also use up the connection's
+func TestConnLimitsRefusalsCostNothing(t *testing.T) {
+	users := &userLimits{
+		messages: ratelimit.NewLimiter(ratelimit.Limit{Rate: 0.001, Burst: 1}),
+		typing:   ratelimit.NewLimiter(ratelimit.Limit{Rate: 0.001, Burst: 1}),
+	}
+	first, second := newConnLimits("carol", users), newConnLimits("carol", users)
+	if ok, _ := first.allow(ws.TypeMessage); !ok {
+		t.Fatal("first message refused")
+	}
+	// Both connections act for carol, whose budget is spent
+	for range connMessageLimit.Burst {
+		if ok, _ := second.allow(ws.TypeMessage); ok {
+			t.Fatal("message allowed past carol's budget")
+		}
+	}
+
+	// Once carol has budget again, the second connection still has all
+	// of its own
+	second.users = newUserLimits()
+	for i := range connMessageLimit.Burst {
+		if ok, _ := second.allow(ws.TypeMessage); !ok {
+			t.Fatalf("message %d refused; the connection's budget was used by refused messages", i+1)
+		}
+	}
+}
@@ -1,48 +1,148 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"go-chat/internal/actors"
+	"go-chat/internal/blob"
+	"go-chat/internal/broadcast"
+	"go-chat/internal/clustering"
+	"go-chat/internal/metrics"
+	"go-chat/internal/ratelimit"
+	"go-chat/internal/store"
+	"go-chat/internal/unfurl"
+	"go-chat/internal/version"
 	"go-chat/shared/api"
 	"go-chat/shared/ws"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/anthdm/hollywood/actor"
 	"github.com/charmbracelet/log"
 	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
 )
 
+// distDir holds the built frontend served at /
+const distDir = "./dist"
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for demo
 	},
 }
 
-func setupWebSocket(engine *actor.Engine, roomPID *actor.PID) http.HandlerFunc {
+func setupWebSocket(engine *actor.Engine, rooms actors.Rooms, users actors.Users, history store.Store, perUser *userLimits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Connections act for the user of a session
+		token := r.URL.Query().Get(ws.QuerySession)
+		if token == "" {
+			http.Error(w, "sign in first", http.StatusUnauthorized)
+			return
+		}
+		username, _, err := resumeSession(history, token)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "session expired", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Error("failed to look up session", "error", err)
+			http.Error(w, "authentication unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		room := r.URL.Query().Get(ws.QueryRoom)
+		if room == "" {
+			room = actors.DefaultRoom
+		}
+		// Looking a room up creates it, so only known rooms are joined
+		if room != actors.DefaultRoom {
+			http.Error(w, "no such room", http.StatusNotFound)
+			return
+		}
+
+		roomPID, roomGone, err := rooms.Lookup(room)
+		if err != nil {
+			log.Error("failed to locate room", "room", room, "error", err)
+			http.Error(w, "room unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		registryPID, registryGone, err := users.Registry()
+		if err != nil {
+			log.Error("failed to locate registry", "error", err)
+			http.Error(w, "registry unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			log.Fatal(err)
+			log.Error("failed to upgrade connection", "error", err)
+			return
 		}
 
-		pid := engine.Spawn(actors.NewClient(conn), string(actors.TypeClient))
+		pid := engine.Spawn(metrics.InstrumentActor(string(actors.TypeClient), actors.NewClient(conn)), string(actors.TypeClient))
+
+		// Notify room about new client, resuming where it left off if it
+		// reconnected, and register it for direct messages
+		since, _ := strconv.ParseUint(r.URL.Query().Get(ws.QuerySince), 10, 64)
+		engine.Send(roomPID, &actors.ClientJoined{
+			ClientPID: pid,
+			Username:  username,
+			Epoch:     r.URL.Query().Get(ws.QueryEpoch),
+			Since:     since,
+		})
+		engine.Send(registryPID, &actors.ClientJoined{ClientPID: pid, Username: username})
+
+		log.Info(fmt.Sprintf("Client connected: %s", pid), "username", username, "room", room)
 
-		// Notify room about new client
-		engine.Send(roomPID, &actors.ClientJoined{ClientPID: pid})
+		// Tell clients running a stale frontend to reload
+		clientBuild := r.URL.Query().Get(ws.QueryBuild)
+		if currentBuild := version.Frontend(distDir); version.Mismatch(clientBuild, currentBuild) {
+			log.Info("client running stale build", "pid", pid, "client_build", clientBuild, "current_build", currentBuild)
+			engine.Send(pid, &ws.Message{
+				Type: ws.TypeReloadRequired,
+				Payload: &ws.ReloadRequiredMessage{
+					ClientBuild:  clientBuild,
+					CurrentBuild: currentBuild,
+				},
+			})
+		}
 
-		log.Info(fmt.Sprintf("Client connected: %s", pid))
+		// Drop the connection if the cluster member of the room or the
+		// registry goes away; the client reconnects and joins them wherever
+		// they live next
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-roomGone:
+				log.Warn("room moved, closing connection", "pid", pid)
+				conn.Close()
+			case <-registryGone:
+				log.Warn("registry moved, closing connection", "pid", pid)
+				conn.Close()
+			case <-done:
+			}
+		}()
 
 		defer func() {
+			close(done)
 			log.Info(fmt.Sprintf("Closing connection for %s", pid))
 			// Notify room about client leaving
-			engine.Send(roomPID, &actors.ClientLeft{ClientPID: pid})
+			engine.Send(roomPID, &actors.ClientLeft{ClientPID: pid, Username: username})
+			engine.Send(registryPID, &actors.ClientLeft{ClientPID: pid, Username: username})
 			engine.Send(pid, ws.TypeClose)
 			engine.Poison(pid)
 			conn.Close()
+			// Being connected counts as using the session
+			resumeSession(history, token)
 		}()
 
+		limits := newConnLimits(username, perUser)
+
 		// Start reading messages
 		for {
 			var msg ws.Message
@@ -52,47 +152,314 @@ func setupWebSocket(engine *actor.Engine, roomPID *actor.PID) http.HandlerFunc {
 				break
 			}
 
-			// Send message to room instead of directly to client
-			engine.Send(roomPID, &msg)
+			if ok, retryAfter := limits.allow(msg.Type); !ok {
+				if limits.violation() {
+					log.Warn("disconnecting client for exceeding rate limits", "pid", pid, "username", username)
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"),
+						time.Now().Add(time.Second))
+					break
+				}
+				// Chat messages are answered like any other rejection, so
+				// the client can mark them failed
+				if text, ok := msg.Payload.(*ws.TextMessage); ok {
+					engine.Send(pid, &ws.Message{
+						Type: ws.TypeNack,
+						Payload: &ws.NackMessage{
+							Nonce:      text.Nonce,
+							Error:      "rate limit exceeded",
+							RetryAfter: retryAfter.Milliseconds(),
+						},
+					})
+					continue
+				}
+				engine.Send(pid, &ws.Message{
+					Type: ws.TypeError,
+					Payload: &ws.ErrorMessage{
+						Error:      "rate limit exceeded",
+						RetryAfter: retryAfter.Milliseconds(),
+					},
+				})
+				continue
+			}
+
+			// Types are chosen by clients, so any they may not send share a
+			// label, keeping the number of series bounded
+			label := metrics.UnknownType
+			if msg.Type.FromClient() {
+				label = string(msg.Type)
+			}
+			metrics.Messages.WithLabelValues(label).Inc()
+
+			// Anything else the server sends itself, such as CLOSE or SYNC,
+			// must not be relayed on behalf of a client
+			if !msg.Type.FromClient() || msg.Payload == nil {
+				engine.Send(pid, &ws.Message{
+					Type:    ws.TypeError,
+					Payload: &ws.ErrorMessage{Error: "unsupported message type"},
+				})
+				continue
+			}
+
+			// Messages always act as the connection's user, whatever the
+			// payload claims
+			switch p := msg.Payload.(type) {
+			case *ws.TextMessage:
+				p.From = username
+			case *ws.TypingMessage:
+				p.From = username
+			case *ws.DirectMessage:
+				p.From = username
+			case *ws.Presence:
+				// The room tracks presence per connection
+				engine.Send(roomPID, &actors.SetPresence{ClientPID: pid, Status: p.Status})
+				continue
+			}
+
+			// Direct messages are routed by the registry, everything else
+			// goes to the room
+			if msg.Type == ws.TypeDirect {
+				actors.SendWSFrom(engine, registryPID, pid, &msg)
+				continue
+			}
+			actors.SendWSFrom(engine, roomPID, pid, &msg)
 		}
 	}
 }
 
-func setupHealthCheck() http.HandlerFunc {
+func setupVersion() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != api.RouteHealth.Method {
+		if r.Method != api.RouteVersion.Method {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		response := api.HealthResponse{
-			Status:    "ok",
-			Timestamp: time.Now().Unix(),
-		}
-		json.NewEncoder(w).Encode(response)
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(currentVersion())
+	}
+}
+
+// currentVersion returns the build hashes of this server and of the
+// frontend it serves
+func currentVersion() api.VersionResponse {
+	return api.VersionResponse{
+		Server:   version.Server(),
+		Frontend: version.Frontend(distDir),
 	}
 }
 
 func main() {
+	addr := flag.String("addr", ":8080", "HTTP listen address")
+	clusterAddr := flag.String("cluster-addr", "", "address to accept messages from other cluster members on; clustering is disabled when empty")
+	clusterID := flag.String("cluster-id", "", "unique ID of this cluster member (defaults to -cluster-addr)")
+	clusterPeers := flag.String("cluster-peers", "", "comma separated id@host:port list of cluster members to join")
+	clusterDiscovery := flag.String("cluster-discovery", clustering.DiscoveryStatic, "how cluster members find each other: static or mdns")
+	broadcastBackend := flag.String("broadcast", "local", "how room messages fan out to other instances: local or nats; ignored when clustering")
+	natsURL := flag.String("nats-url", nats.DefaultURL, "NATS server used by -broadcast nats and by -store serve and remote")
+	owners := flag.String("owners", "", "comma separated usernames that own the default room: moderators who may also act on moderators and appoint them")
+	moderators := flag.String("moderators", "", "comma separated usernames that moderate the default room: they may edit and delete any message, and kick, ban and mute members")
+	storeMode := flag.String("store", "local", "store of this instance: local keeps its own; serve keeps its own and serves it to other instances over NATS; remote uses the one served there. Instances sharing rooms must share a store")
+	storeFile := flag.String("store-file", "./data/store.json", "file keeping accounts, bots, roles, bans, mutes and the audit log across restarts, used by one instance at a time; everything is kept in memory when empty")
+	blobBackend := flag.String("blob-store", "local", "where uploaded files are kept: local or s3")
+	blobDir := flag.String("blob-dir", "./data/blobs", "directory of -blob-store local")
+	s3Endpoint := flag.String("s3-endpoint", "http://localhost:9000", "S3-compatible service used by -blob-store s3; credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	s3Bucket := flag.String("s3-bucket", "go-chat", "bucket of -blob-store s3, created if missing")
+	s3Region := flag.String("s3-region", "us-east-1", "region of -blob-store s3")
+	unfurlLinks := flag.Bool("unfurl", true, "fetch previews of public pages linked from messages")
+	flag.Parse()
+
 	// Initialize actor system
-	engine, err := actor.NewEngine(actor.NewEngineConfig())
-	if err != nil {
-		log.Fatal(err)
+	var (
+		engine  *actor.Engine
+		rooms   actors.Rooms
+		users   actors.Users
+		history store.Store = store.NewMemory()
+		checks              = make(map[string]func(context.Context) error)
+	)
+
+	// Instances sharing rooms share their users, sessions and moderation
+	// too, which one instance keeps for all of them
+	if (*clusterAddr != "" || *broadcastBackend == "nats") && *storeMode == "local" {
+		log.Fatal("instances sharing rooms must share a store: run one with -store serve and the others with -store remote")
+	}
+	switch *storeMode {
+	case "local", "serve":
+		if *storeFile != "" {
+			file, err := store.NewFile(*storeFile)
+			if errors.Is(err, store.ErrLocked) {
+				log.Fatal("another instance uses the store file; give each instance its own -store-file, or share one store with -store serve and -store remote", "store-file", *storeFile)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+			history = file
+		}
+		if *storeMode == "serve" {
+			server, err := store.Serve(*natsURL, history)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer server.Close()
+		}
+	case "remote":
+		remote, err := store.NewRemote(*natsURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer remote.Close()
+		history = remote
+	default:
+		log.Fatal("unknown store", "store", *storeMode)
 	}
 
-	// Spawn the room actor
-	roomPID := engine.Spawn(actors.NewRoom(), string(actors.TypeRoom))
-	log.Info("Room actor started", "pid", roomPID)
+	checks["history"] = history.Check
+
+	// Roles are only given to accounts, so no one else can sign in as their
+	// holders
+	for _, roles := range []struct{ role, usernames string }{
+		{api.RoleModerator, *moderators},
+		{api.RoleOwner, *owners}, // Owners also listed as moderators stay owners
+	} {
+		for _, username := range strings.Split(roles.usernames, ",") {
+			if username = strings.TrimSpace(username); username == "" {
+				continue
+			}
+			token, err := ensureAccount(history, username)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if token != "" {
+				log.Warn("created account; sign in with its token, which is not shown again", "username", username, "token", token)
+			}
+			if err := history.SetRole(actors.DefaultRoom, username, roles.role); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
 
-	// Setup routes
-	http.HandleFunc("/ws", setupWebSocket(engine, roomPID))
-	http.HandleFunc(api.RouteHealth.Path, setupHealthCheck())
-	http.Handle("/", http.FileServer(http.Dir("./dist")))
+	var blobs blob.Store
+	switch *blobBackend {
+	case "local":
+		local, err := blob.NewLocal(*blobDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		blobs = local
+	case "s3":
+		s3, err := blob.NewS3(blob.S3Config{
+			Endpoint:        *s3Endpoint,
+			Bucket:          *s3Bucket,
+			Region:          *s3Region,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = s3.CreateBucket(ctx)
+		cancel()
+		if err != nil {
+			log.Fatal(err)
+		}
+		blobs = s3
+		checks["blobs"] = s3.Check
+	default:
+		log.Fatal("unknown blob store", "blob-store", *blobBackend)
+	}
+
+	var unfurler *unfurl.Client
+	if *unfurlLinks {
+		unfurler = unfurl.New(unfurl.Config{})
+	}
+
+	if *clusterAddr == "" {
+		var broadcaster broadcast.Broadcaster
+		switch *broadcastBackend {
+		case "local":
+			broadcaster = broadcast.NewLocal()
+		case "nats":
+			n, err := broadcast.NewNATS(*natsURL)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer n.Close()
+			broadcaster = n
+			checks["broadcast"] = n.Check
+		default:
+			log.Fatal("unknown broadcast backend", "broadcast", *broadcastBackend)
+		}
+
+		var err error
+		engine, err = actor.NewEngine(actor.NewEngineConfig())
+		if err != nil {
+			log.Fatal(err)
+		}
+		users = actors.NewLocalUsers(engine, broadcaster, history)
+		rooms = actors.NewLocalRooms(engine, broadcaster, history, users, unfurler)
+	} else {
+		peers, err := clustering.ParsePeers(*clusterPeers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var clusterActors *clustering.Actors
+		engine, clusterActors, err = clustering.Start(clustering.Config{
+			ListenAddr: *clusterAddr,
+			ID:         *clusterID,
+			Discovery:  *clusterDiscovery,
+			Peers:      peers,
+		}, history, unfurler)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rooms, users = clusterActors, clusterActors
+	}
+
+	health := newHealthChecker(engine, rooms)
+	for name, check := range checks {
+		health.AddCheck(name, check)
+	}
 
 	// Start server
-	log.Info("Server starting on port 8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	log.Info("Server starting", "addr", *addr, "build", version.Server())
+	if err := http.ListenAndServe(*addr, newMux(engine, rooms, users, history, blobs, health)); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// newMux routes WebSocket, API and frontend requests to their handlers
+func newMux(engine *actor.Engine, rooms actors.Rooms, users actors.Users, history store.Store, blobs blob.Store, health *healthChecker) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// API routes are instrumented and rate limited per client IP
+	limitAPI := ratelimit.Middleware(ratelimit.NewLimiter(apiLimit), ratelimit.ClientIP)
+	handleAPI := func(path string, h http.Handler) {
+		mux.Handle(path, metrics.InstrumentRoute(path, limitAPI(h)))
+	}
+
+	mux.HandleFunc("/ws", setupWebSocket(engine, rooms, users, history, newUserLimits()))
+	handleAPI(api.RouteHealth.Path, setupHealthReady(health))
+	handleAPI(api.RouteHealthLive.Path, setupHealthLive(health))
+	handleAPI(api.RouteHealthReady.Path, setupHealthReady(health))
+	handleAPI(api.RouteVersion.Path, setupVersion())
+	handleAPI(api.RouteRoomMembers.Path, setupRoomMembers(engine, rooms))
+	handleAPI(api.RouteReplies.Path, setupReplies(engine, rooms))
+	handleAPI(api.RouteSearchMessages.Path, setupSearchMessages(history))
+	handleAPI(api.RouteBans.Path, setupBans(engine, rooms, history))
+	handleAPI(api.RouteAuditLog.Path, setupAuditLog(engine, rooms, history))
+	handleAPI(api.RouteCommands.Path, setupCommands())
+	handleAPI(api.RouteSession.Path, setupSession(history))
+	handleAPI(api.RouteAccounts.Path, setupAccounts(history))
+	handleAPI(api.RouteBots.Path, setupBots(history))
+	handleAPI(api.RouteUploadAttachment.Path, setupUploadAttachment(blobs, history))
+	// Pages load many previews at once, so downloads are not rate limited
+	// like other API routes
+	mux.Handle(api.RouteAttachment.Path, metrics.InstrumentRoute(api.RouteAttachment.Path, setupAttachment(blobs)))
+	handleAPI(api.RouteConversations.Path, setupConversations(engine, users, history))
+	handleAPI(api.RouteDirectMessages.Path, setupDirectMessages(engine, users, history))
+	mux.Handle(metrics.Path, metrics.Handler())
+	mux.Handle("/", http.FileServer(http.Dir(distDir)))
+	return mux
+}
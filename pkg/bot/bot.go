@@ -0,0 +1,262 @@
+// Package bot is a client library for programs that chat as bot accounts.
+// A Bot signs in with the token of its account, joins rooms over
+// WebSocket connections, calls the handlers registered for the events it
+// receives, and sends messages.
+//
+//	b := bot.New(bot.Config{URL: "http://localhost:8080", Name: "echo", Token: token})
+//	b.OnMessage(func(ctx context.Context, m *bot.Message) {
+//		m.Reply(ctx, m.Text)
+//	})
+//	err := b.Run(ctx, "general")
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"go-chat/shared/ws"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotConnected is returned when sending to a room the bot is not
+	// connected to
+	ErrNotConnected = errors.New("not connected")
+	// ErrTimeout is returned when the room does not answer a message in
+	// time
+	ErrTimeout = errors.New("no response from server")
+)
+
+// Config tunes a Bot. Zero fields take their defaults, except URL, Name
+// and Token.
+type Config struct {
+	// URL is the base URL of the server, e.g. http://localhost:8080
+	URL string
+	// Name and Token are the credentials of the bot account
+	Name  string
+	Token string
+	// AckTimeout bounds how long Send waits for the room to accept a
+	// message; defaults to 10 seconds
+	AckTimeout time.Duration
+	// ReconnectDelay is how long to wait before reconnecting to a room;
+	// defaults to 3 seconds
+	ReconnectDelay time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	c.URL = strings.TrimSuffix(c.URL, "/")
+	if c.AckTimeout == 0 {
+		c.AckTimeout = 10 * time.Second
+	}
+	if c.ReconnectDelay == 0 {
+		c.ReconnectDelay = 3 * time.Second
+	}
+	return c
+}
+
+// Message is a chat message posted in a room the bot joined
+type Message struct {
+	ws.TextMessage
+	Room string
+
+	bot *Bot
+}
+
+// Reply posts text in the thread of the message, and returns the ID of the
+// reply
+func (m *Message) Reply(ctx context.Context, text string) (string, error) {
+	parentID := m.ParentID
+	if parentID == "" {
+		parentID = m.ID
+	}
+	return m.bot.send(ctx, m.Room, parentID, text)
+}
+
+// Mentioned reports whether the message mentions username
+func (m *Message) Mentioned(username string) bool {
+	for _, mention := range m.TextMessage.Mentions {
+		if mention == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Bot is a client acting as a bot account. Register handlers before
+// calling Run; they are called one at a time per room, so a slow handler
+// delays the room's later events.
+type Bot struct {
+	config Config
+	signIn *apihttp.Client[api.SessionRequest, api.Session]
+
+	onMessage func(context.Context, *Message)
+	onDirect  func(context.Context, *ws.DirectMessage)
+	onMention func(context.Context, *ws.MentionNotification)
+	onJoin    func(ctx context.Context, room, username string)
+	onLeave   func(ctx context.Context, room, username string)
+	handlers  map[ws.MessageType]func(ctx context.Context, room string, payload any)
+
+	primary string // The room whose connection handles direct messages and mentions
+
+	mu      sync.Mutex
+	session string           // Token of the session connections act for
+	conns   map[string]*conn // Room → open connection
+}
+
+// New creates a Bot
+func New(config Config) *Bot {
+	config = config.withDefaults()
+	return &Bot{
+		config:   config,
+		signIn:   apihttp.NewClient(config.URL, api.RouteCreateSession),
+		handlers: make(map[ws.MessageType]func(context.Context, string, any)),
+		conns:    make(map[string]*conn),
+	}
+}
+
+// Name returns the username the bot chats as
+func (b *Bot) Name() string {
+	return b.config.Name
+}
+
+// OnMessage sets the handler of chat messages posted by others, including
+// those missed while reconnecting
+func (b *Bot) OnMessage(h func(ctx context.Context, m *Message)) {
+	b.onMessage = h
+}
+
+// OnDirect sets the handler of direct messages sent to the bot
+func (b *Bot) OnDirect(h func(ctx context.Context, dm *ws.DirectMessage)) {
+	b.onDirect = h
+}
+
+// OnMention sets the handler of mentions of the bot
+func (b *Bot) OnMention(h func(ctx context.Context, mention *ws.MentionNotification)) {
+	b.onMention = h
+}
+
+// OnJoin sets the handler of other users joining a room
+func (b *Bot) OnJoin(h func(ctx context.Context, room, username string)) {
+	b.onJoin = h
+}
+
+// OnLeave sets the handler of other users leaving a room
+func (b *Bot) OnLeave(h func(ctx context.Context, room, username string)) {
+	b.onLeave = h
+}
+
+// On sets the handler of any other message type, such as ws.TypeEdit or
+// ws.TypeTopic. payload is the pointer ws.Message decodes it to.
+func (b *Bot) On(typ ws.MessageType, h func(ctx context.Context, room string, payload any)) {
+	b.handlers[typ] = h
+}
+
+// Run signs the bot in and keeps it in rooms, reconnecting when a
+// connection drops, until ctx is done or the bot is removed from a room.
+// It returns the reason it stopped.
+func (b *Bot) Run(ctx context.Context, rooms ...string) error {
+	if len(rooms) == 0 {
+		return errors.New("no rooms to join")
+	}
+	if err := b.login(); err != nil {
+		return fmt.Errorf("signing in as %s: %w", b.config.Name, err)
+	}
+
+	b.primary = rooms[0]
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, len(rooms))
+	for _, room := range rooms {
+		go func() {
+			errc <- b.stay(ctx, room)
+		}()
+	}
+	err := <-errc
+	cancel()
+	for range rooms[1:] {
+		<-errc
+	}
+	return err
+}
+
+// login starts a new session for the bot's connections
+func (b *Bot) login() error {
+	session, err := b.signIn.Request(api.SessionRequest{Username: b.config.Name, Token: b.config.Token})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.session = session.Token
+	b.mu.Unlock()
+	return nil
+}
+
+// Send posts text in a room, and returns the ID of the message once the
+// room accepted it
+func (b *Bot) Send(ctx context.Context, room, text string) (string, error) {
+	return b.send(ctx, room, "", text)
+}
+
+// SendDirect sends a direct message to a user through any room connection
+func (b *Bot) SendDirect(ctx context.Context, to, text string) error {
+	b.mu.Lock()
+	var c *conn
+	for _, open := range b.conns {
+		c = open
+		break
+	}
+	b.mu.Unlock()
+	if c == nil {
+		return ErrNotConnected
+	}
+	return c.write(&ws.Message{
+		Type:    ws.TypeDirect,
+		Payload: &ws.DirectMessage{To: to, Text: text},
+	})
+}
+
+func (b *Bot) send(ctx context.Context, room, parentID, text string) (string, error) {
+	b.mu.Lock()
+	c := b.conns[room]
+	b.mu.Unlock()
+	if c == nil {
+		return "", ErrNotConnected
+	}
+	return c.send(ctx, parentID, text)
+}
+
+// dispatch calls the handler of a message received in a room
+func (b *Bot) dispatch(ctx context.Context, room string, msg *ws.Message) {
+	switch payload := msg.Payload.(type) {
+	case *ws.TextMessage:
+		if b.onMessage != nil && payload.From != b.config.Name {
+			b.onMessage(ctx, &Message{TextMessage: *payload, Room: room, bot: b})
+		}
+	case *ws.DirectMessage:
+		if b.onDirect != nil && payload.From != b.config.Name {
+			b.onDirect(ctx, payload)
+		}
+	case *ws.MentionNotification:
+		if b.onMention != nil {
+			b.onMention(ctx, payload)
+		}
+	case *ws.JoinMessage:
+		if b.onJoin != nil && payload.From != b.config.Name {
+			b.onJoin(ctx, room, payload.From)
+		}
+	case *ws.LeaveMessage:
+		if b.onLeave != nil && payload.From != b.config.Name {
+			b.onLeave(ctx, room, payload.From)
+		}
+	default:
+		if h, ok := b.handlers[msg.Type]; ok {
+			h(ctx, room, msg.Payload)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	apihttp "go-chat/shared/http"
+	"go-chat/shared/ws"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// errSessionExpired is returned when connecting with a session the server
+// no longer knows, e.g. because it restarted
+var errSessionExpired = errors.New("session expired")
+
+// maxQueuedEvents bounds the events a room connection queues for its
+// handlers. Reading never waits for the handlers, so beyond it the oldest
+// events are dropped rather than kept without bound.
+const maxQueuedEvents = 4096
+
+// RemovedError is returned by Run when the server removed the bot from a
+// room, e.g. because a moderator kicked or banned it, or refused to let it
+// join
+type RemovedError struct {
+	Room   string
+	Reason string
+}
+
+func (e *RemovedError) Error() string {
+	return fmt.Sprintf("removed from #%s: %s", e.Room, e.Reason)
+}
+
+// result is the answer of a room to a sent message: the ID it was given,
+// or why it was rejected
+type result struct {
+	id  string
+	err error
+}
+
+// conn is a WebSocket connection to a room
+type conn struct {
+	room    string
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan result // Nonce → waiting sender
+	ackWait time.Duration
+}
+
+// stay keeps the bot connected to a room until ctx is done or the server
+// refuses it. Each connection resumes after the last message seen, so
+// messages posted while disconnected are still handled.
+func (b *Bot) stay(ctx context.Context, room string) error {
+	var epoch string
+	var seq uint64
+	for {
+		err := b.connect(ctx, room, &epoch, &seq)
+		var removed *RemovedError
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.As(err, &removed) {
+			return err
+		}
+		// The next attempt connects with a new session, unless the
+		// account is gone
+		if errors.Is(err, errSessionExpired) {
+			var apiErr *apihttp.APIError
+			if err := b.login(); errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+				return &RemovedError{Room: room, Reason: "credentials no longer valid"}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.config.ReconnectDelay):
+		}
+	}
+}
+
+// connect joins a room and handles its events until the connection closes.
+// epoch and seq are where the previous connection stopped, and are kept up
+// to date.
+func (b *Bot) connect(ctx context.Context, room string, epoch *string, seq *uint64) error {
+	conn, err := b.dial(ctx, room, *epoch, *seq)
+	if err != nil {
+		return err
+	}
+	defer conn.ws.Close()
+
+	// Handlers run apart from the reader, which never waits for them, so
+	// it keeps reading the ACKs of the messages they send
+	events := newQueue()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			msg, ok := events.pop()
+			if !ok {
+				return
+			}
+			b.dispatch(ctx, room, msg)
+		}
+	}()
+	defer func() {
+		events.close()
+		<-done
+	}()
+
+	// Senders still waiting are answered before the handlers are waited for
+	b.mu.Lock()
+	b.conns[room] = conn
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		if b.conns[room] == conn {
+			delete(b.conns, room)
+		}
+		b.mu.Unlock()
+		conn.fail(ErrNotConnected)
+	}()
+
+	// Closing the connection ends the read loop when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.ws.Close() })
+	defer stop()
+
+	resumed := *epoch != ""
+	for {
+		var msg ws.Message
+		if err := conn.ws.ReadJSON(&msg); err != nil {
+			return err
+		}
+
+		switch payload := msg.Payload.(type) {
+		case *ws.AckMessage:
+			conn.resolve(payload.Nonce, result{id: payload.ID})
+			continue
+		case *ws.NackMessage:
+			conn.resolve(payload.Nonce, result{err: errors.New(payload.Error)})
+			continue
+		case *ws.CloseMessage:
+			return &RemovedError{Room: room, Reason: payload.Reason}
+		case *ws.SyncMessage:
+			// Only the messages missed since the last connection are news;
+			// the history sent on first joining is not
+			missed := resumed && !payload.Reset && payload.Epoch == *epoch
+			*epoch, *seq = payload.Epoch, payload.Seq
+			if missed {
+				for i := range payload.Messages {
+					events.push(&ws.Message{Type: ws.TypeMessage, Payload: &payload.Messages[i]})
+				}
+			}
+			continue
+		case *ws.TextMessage:
+			*seq = max(*seq, payload.Seq)
+		case *ws.DirectMessage, *ws.MentionNotification:
+			// They reach every connection of the bot, so only its first
+			// room's handles them
+			if room != b.primary {
+				continue
+			}
+		}
+		events.push(&msg)
+	}
+}
+
+// dial opens a connection to a room, resuming after seq of epoch if set
+func (b *Bot) dial(ctx context.Context, room, epoch string, seq uint64) (*conn, error) {
+	u, err := url.Parse(b.config.URL + "/ws")
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	b.mu.Lock()
+	session := b.session
+	b.mu.Unlock()
+
+	query := url.Values{}
+	query.Set(ws.QuerySession, session)
+	query.Set(ws.QueryRoom, room)
+	if epoch != "" {
+		query.Set(ws.QueryEpoch, epoch)
+		query.Set(ws.QuerySince, strconv.FormatUint(seq, 10))
+	}
+	u.RawQuery = query.Encode()
+
+	c, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, errSessionExpired
+		}
+		// Unknown rooms will not appear by retrying
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, &RemovedError{Room: room, Reason: "no such room"}
+		}
+		return nil, err
+	}
+	return &conn{
+		room:    room,
+		ws:      c,
+		pending: make(map[string]chan result),
+		ackWait: b.config.AckTimeout,
+	}, nil
+}
+
+// write sends a message on the connection
+func (c *conn) write(msg *ws.Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteJSON(msg)
+}
+
+// send posts a chat message and waits for the room to accept or reject it
+func (c *conn) send(ctx context.Context, parentID, text string) (string, error) {
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(rand.Int63(), 36)
+	answer := make(chan result, 1)
+	c.mu.Lock()
+	c.pending[nonce] = answer
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, nonce)
+		c.mu.Unlock()
+	}()
+
+	err := c.write(&ws.Message{
+		Type:    ws.TypeMessage,
+		Payload: &ws.TextMessage{Nonce: nonce, Text: text, ParentID: parentID},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	timeout := time.NewTimer(c.ackWait)
+	defer timeout.Stop()
+	select {
+	case res := <-answer:
+		return res.id, res.err
+	case <-timeout.C:
+		return "", ErrTimeout
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// resolve answers the sender of the message with nonce, if it still waits
+func (c *conn) resolve(nonce string, res result) {
+	c.mu.Lock()
+	answer, ok := c.pending[nonce]
+	delete(c.pending, nonce)
+	c.mu.Unlock()
+	if ok {
+		answer <- res
+	}
+}
+
+// fail answers every sender still waiting with err
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for nonce, answer := range c.pending {
+		answer <- result{err: err}
+		delete(c.pending, nonce)
+	}
+}
+
+// queue hands the events read from a connection to its handlers, oldest
+// first. Pushing never blocks.
+type queue struct {
+	mu     sync.Mutex
+	events []*ws.Message
+	closed bool
+	ready  chan struct{} // Holds a signal while events may be waiting
+}
+
+func newQueue() *queue {
+	return &queue{ready: make(chan struct{}, 1)}
+}
+
+// push queues an event, dropping the oldest one if maxQueuedEvents are
+// waiting
+func (q *queue) push(msg *ws.Message) {
+	q.mu.Lock()
+	if len(q.events) == maxQueuedEvents {
+		q.events[0] = nil
+		q.events = q.events[1:]
+	}
+	q.events = append(q.events, msg)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// close makes pop return false once the queued events are taken
+func (q *queue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+// pop takes the oldest event, waiting for one, or returns false once the
+// queue is closed and empty
+func (q *queue) pop() (*ws.Message, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.events) > 0 {
+			msg := q.events[0]
+			q.events[0] = nil
+			q.events = q.events[1:]
+			q.mu.Unlock()
+			return msg, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
+
+func (q *queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
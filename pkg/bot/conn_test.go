@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"go-chat/shared/api"
+	"go-chat/shared/ws"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// backlog is how many messages the fake room sends at once, more than a
+// connection used to buffer for its handlers
+const backlog = 200
+
+// fakeRoom is a server with a room that posts backlog messages as soon as
+// the bot joins, and acknowledges what the bot sends afterwards
+func fakeRoom(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc(api.RouteCreateSession.Path, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(api.Session{Username: "echo", Token: "sess_test"})
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(&ws.Message{Type: ws.TypeSync, Payload: &ws.SyncMessage{Room: "general", Epoch: "e", Reset: true}})
+		for seq := uint64(1); seq <= backlog; seq++ {
+			conn.WriteJSON(&ws.Message{
+				Type:    ws.TypeMessage,
+				Payload: &ws.TextMessage{ID: strconv.FormatUint(seq, 10), From: "alice", Text: "hi", Seq: seq},
+			})
+		}
+		for {
+			var msg ws.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if text, ok := msg.Payload.(*ws.TextMessage); ok {
+				conn.WriteJSON(&ws.Message{Type: ws.TypeAck, Payload: &ws.AckMessage{Nonce: text.Nonce, ID: "reply"}})
+			}
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestReplyBehindBacklog checks that a handler waiting for the ACK of its
+// reply gets it while many events are still queued behind the one it
+// handles
+func TestReplyBehindBacklog(t *testing.T) {
+	server := fakeRoom(t)
+	b := New(Config{URL: server.URL, Name: "echo", Token: "bot_test", AckTimeout: 5 * time.Second})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replied := make(chan error, 1)
+	handled := make(chan struct{}, backlog)
+	b.OnMessage(func(ctx context.Context, m *Message) {
+		if m.Seq == 1 {
+			_, err := m.Reply(ctx, "hello")
+			replied <- err
+		}
+		handled <- struct{}{}
+	})
+	go b.Run(ctx, "general")
+
+	select {
+	case err := <-replied:
+		if err != nil {
+			t.Fatalf("Reply = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Reply never returned")
+	}
+	for range backlog {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("not every message was handled")
+		}
+	}
+}
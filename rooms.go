@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"go-chat/internal/actors"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"net/http"
+	"strings"
+
+	"github.com/anthdm/hollywood/actor"
+	"github.com/charmbracelet/log"
+)
+
+func setupRoomMembers(engine *actor.Engine, rooms actors.Rooms) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteRoomMembers.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req api.MembersRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if req.Room == "" {
+			req.Room = actors.DefaultRoom
+		}
+		// Looking a room up creates it, so only known rooms are served
+		if req.Room != actors.DefaultRoom {
+			writeError(w, http.StatusNotFound, api.ErrCodeNotFound, "no such room")
+			return
+		}
+
+		roomPID, _, err := rooms.Lookup(req.Room)
+		if err != nil {
+			log.Error("failed to locate room", "room", req.Room, "error", err)
+			writeError(w, http.StatusServiceUnavailable, api.ErrCodeServerError, "room unavailable")
+			return
+		}
+		askActor(w, engine, roomPID, &actors.MembersRequest{})
+	}
+}
+
+func setupReplies(engine *actor.Engine, rooms actors.Rooms) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteReplies.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req api.RepliesRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if req.ParentID == "" {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "parent_id is required")
+			return
+		}
+		if req.Room == "" {
+			req.Room = actors.DefaultRoom
+		}
+		if req.Room != actors.DefaultRoom {
+			writeError(w, http.StatusNotFound, api.ErrCodeNotFound, "no such room")
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = api.DefaultRepliesLimit
+		}
+		req.Limit = min(req.Limit, api.MaxRepliesLimit)
+
+		roomPID, _, err := rooms.Lookup(req.Room)
+		if err != nil {
+			log.Error("failed to locate room", "room", req.Room, "error", err)
+			writeError(w, http.StatusServiceUnavailable, api.ErrCodeServerError, "room unavailable")
+			return
+		}
+		askActor(w, engine, roomPID, &actors.RepliesRequest{
+			ParentID: req.ParentID,
+			Before:   req.Before,
+			Limit:    int32(req.Limit),
+		})
+	}
+}
+
+func setupSearchMessages(history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteSearchMessages.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if _, ok := requireSession(w, r, history); !ok {
+			return
+		}
+
+		var req api.SearchMessagesRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "query is required")
+			return
+		}
+		if req.Offset < 0 {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "offset must not be negative")
+			return
+		}
+		if req.Room == "" {
+			req.Room = actors.DefaultRoom
+		}
+		if req.Room != actors.DefaultRoom {
+			writeError(w, http.StatusNotFound, api.ErrCodeNotFound, "no such room")
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = api.DefaultSearchLimit
+		}
+		req.Limit = min(req.Limit, api.MaxSearchLimit)
+
+		// Messages are indexed by the store as rooms save them, so searching
+		// takes no room actor. In a cluster, every member forwards the
+		// search to the one store they share with -store remote.
+		results, err := history.SearchMessages(req)
+		if err != nil {
+			log.Error("failed to search messages", "room", req.Room, "error", err)
+			writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "messages could not be searched")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+func setupBans(engine *actor.Engine, rooms actors.Rooms, history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteBans.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		username, ok := requireSession(w, r, history)
+		if !ok {
+			return
+		}
+
+		var req api.BansRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if req.Room == "" {
+			req.Room = actors.DefaultRoom
+		}
+		if req.Room != actors.DefaultRoom {
+			writeError(w, http.StatusNotFound, api.ErrCodeNotFound, "no such room")
+			return
+		}
+
+		roomPID, _, err := rooms.Lookup(req.Room)
+		if err != nil {
+			log.Error("failed to locate room", "room", req.Room, "error", err)
+			writeError(w, http.StatusServiceUnavailable, api.ErrCodeServerError, "room unavailable")
+			return
+		}
+		askActor(w, engine, roomPID, &actors.BansRequest{Username: username})
+	}
+}
+
+func setupAuditLog(engine *actor.Engine, rooms actors.Rooms, history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteAuditLog.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		username, ok := requireSession(w, r, history)
+		if !ok {
+			return
+		}
+
+		var req api.AuditLogRequest
+		if err := apihttp.DecodeQuery(r.URL.Query(), &req); err != nil {
+			writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if req.Room == "" {
+			req.Room = actors.DefaultRoom
+		}
+		if req.Room != actors.DefaultRoom {
+			writeError(w, http.StatusNotFound, api.ErrCodeNotFound, "no such room")
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = api.DefaultAuditLogLimit
+		}
+		req.Limit = min(req.Limit, api.MaxAuditLogLimit)
+
+		roomPID, _, err := rooms.Lookup(req.Room)
+		if err != nil {
+			log.Error("failed to locate room", "room", req.Room, "error", err)
+			writeError(w, http.StatusServiceUnavailable, api.ErrCodeServerError, "room unavailable")
+			return
+		}
+		askActor(w, engine, roomPID, &actors.AuditLogRequest{
+			Username: username,
+			Limit:    int32(req.Limit),
+		})
+	}
+}
+
+func setupCommands() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != api.RouteCommands.Method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(actors.Commands())
+	}
+}
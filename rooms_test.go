@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"go-chat/internal/actors"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"go-chat/shared/ws"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestSearchMessages checks that messages posted to a room are found by
+// searching the store
+func TestSearchMessages(t *testing.T) {
+	serverURL, _ := newTestServer(t)
+	session := signIn(t, serverURL, "carol", "")
+
+	u, _ := url.Parse(serverURL + "/ws")
+	u.Scheme = "ws"
+	u.RawQuery = url.Values{ws.QuerySession: {session}}.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	for i, text := range []string{"deploying the release", "lunch anyone?"} {
+		nonce := string(rune('a' + i))
+		if err := conn.WriteJSON(&ws.Message{Type: ws.TypeMessage, Payload: &ws.TextMessage{Nonce: nonce, Text: text}}); err != nil {
+			t.Fatal(err)
+		}
+		for acked := false; !acked; {
+			var msg ws.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("waiting for %q to be accepted: %v", text, err)
+			}
+			ack, ok := msg.Payload.(*ws.AckMessage)
+			acked = ok && ack.Nonce == nonce
+		}
+	}
+
+	search := apihttp.NewClient(serverURL, api.RouteSearchMessages)
+	var apiErr *apihttp.APIError
+	if _, err := search.Request(api.SearchMessagesRequest{Query: "release"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("search without a session = %v; want 401", err)
+	}
+
+	search = search.WithToken(session)
+	results, err := search.Request(api.SearchMessagesRequest{Query: "release"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 1 || len(results.Results) != 1 || results.Results[0].Message.Text != "deploying the release" {
+		t.Errorf("search for release = %+v; want the message about the release", results)
+	}
+
+	_, err = search.Request(api.SearchMessagesRequest{Query: "release", Room: actors.DefaultRoom + "-other"})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("search of an unknown room = %v; want 404", err)
+	}
+}
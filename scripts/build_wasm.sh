@@ -31,6 +31,9 @@ EOL
 # Rebuild with the correct hash
 GOOS=js GOARCH=wasm go build -o dist/main.wasm frontend/main.go 
 
+# Record the hash so the server can detect clients running a stale build
+echo "${WASM_HASH}" > dist/version.txt
+
 # Copy index.html to dist if it exists
 if [ -f frontend/index.html ]; then
     cp frontend/index.html dist/
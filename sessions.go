@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go-chat/internal/store"
+	"go-chat/shared/api"
+	"go-chat/shared/markdown"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+)
+
+// sessionTTL is how long a session lasts after it was last used
+const sessionTTL = 24 * time.Hour
+
+// maxGuestName bounds the names guests sign in with, like those of
+// accounts and bots
+const maxGuestName = 32 // Runes
+
+// Token prefixes, so leaked tokens are easy to spot
+const (
+	accountTokenPrefix = "acct_"
+	botTokenPrefix     = "bot_"
+	sessionTokenPrefix = "sess_"
+)
+
+var (
+	// errBadCredentials is returned when signing in as an account or bot
+	// with the wrong token, or as a guest with a token
+	errBadCredentials = errors.New("invalid credentials")
+	// errNameTaken is returned when signing in as a guest with a name
+	// someone else holds a session for
+	errNameTaken = errors.New("username is taken")
+	// errInvalidName is returned when signing in as a guest with a name
+	// that could not be mentioned
+	errInvalidName = errors.New("usernames are up to 32 letters, digits, '_', '.' or '-', and do not end in '.' or '-'")
+)
+
+// newToken returns a random token starting with prefix and the hash kept
+// of it
+func newToken(prefix string) (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = prefix + hex.EncodeToString(secret)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// accountToken returns the token hash of the account or bot named
+// username, or store.ErrNotFound if the name is free for guests
+func accountToken(history store.Store, username string) (string, error) {
+	if _, hash, err := history.Account(username); !errors.Is(err, store.ErrNotFound) {
+		return hash, err
+	}
+	_, hash, err := history.Bot(username)
+	return hash, err
+}
+
+// authenticate checks that whoever signs in as username may, and reports
+// whether username is a guest name. Accounts of people and bots need their
+// token; any other name is a guest name, signed in as without one.
+func authenticate(history store.Store, username, token string) (bool, error) {
+	hash, err := accountToken(history, username)
+	if errors.Is(err, store.ErrNotFound) {
+		if token != "" {
+			return false, errBadCredentials
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) != 1 {
+		return false, errBadCredentials
+	}
+	return false, nil
+}
+
+// resumeSession returns the username of the session with token, and
+// extends it
+func resumeSession(history store.Store, token string) (string, time.Time, error) {
+	hash := hashToken(token)
+	now := time.Now()
+	username, err := history.Session(hash, now.UnixMilli())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := now.Add(sessionTTL)
+	return username, expires, history.SaveSession(hash, username, expires.UnixMilli())
+}
+
+// bearerToken returns the token of the Authorization header of r
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// requireSession writes an error and reports false unless r carries the
+// token of an unexpired session. It returns the username of the session.
+func requireSession(w http.ResponseWriter, r *http.Request, history store.Store) (string, bool) {
+	token := bearerToken(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, api.ErrCodeUnauthorized, "sign in first")
+		return "", false
+	}
+	username, _, err := resumeSession(history, token)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusUnauthorized, api.ErrCodeUnauthorized, "session expired")
+		return "", false
+	} else if err != nil {
+		log.Error("failed to look up session", "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "session could not be checked")
+		return "", false
+	}
+	return username, true
+}
+
+// setupSession serves the session routes, which share a path
+func setupSession(history store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case api.RouteCreateSession.Method:
+			createSession(w, r, history)
+		case api.RouteSession.Method:
+			currentSession(w, r, history)
+		case api.RouteEndSession.Method:
+			endSession(w, r, history)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func createSession(w http.ResponseWriter, r *http.Request, history store.Store) {
+	var req api.SessionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid JSON body")
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, "username is required")
+		return
+	}
+
+	now := time.Now()
+	guest, err := authenticate(history, req.Username, req.Token)
+	if err == nil && guest && (!markdown.Mentionable(req.Username) || utf8.RuneCountInString(req.Username) > maxGuestName) {
+		err = errInvalidName
+	}
+	var token, hash string
+	if err == nil {
+		token, hash, err = newToken(sessionTokenPrefix)
+	}
+	if err == nil {
+		// Guests keep their name to themselves while signed in, which the
+		// store checks as it records the session
+		expires := now.Add(sessionTTL).UnixMilli()
+		if guest {
+			err = history.ClaimSession(hash, req.Username, expires, now.UnixMilli())
+		} else {
+			err = history.SaveSession(hash, req.Username, expires)
+		}
+	}
+	switch {
+	case errors.Is(err, errBadCredentials):
+		writeError(w, http.StatusUnauthorized, api.ErrCodeUnauthorized, err.Error())
+		return
+	case errors.Is(err, errInvalidName):
+		writeError(w, http.StatusBadRequest, api.ErrCodeInvalidRequest, err.Error())
+		return
+	case errors.Is(err, store.ErrTaken):
+		writeError(w, http.StatusConflict, api.ErrCodeInvalidRequest, errNameTaken.Error())
+		return
+	case err != nil:
+		log.Error("failed to sign in", "username", req.Username, "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "could not sign in")
+		return
+	}
+	log.Info("signed in", "username", req.Username, "guest", req.Token == "")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(api.Session{
+		Username: req.Username,
+		Token:    token,
+		Expires:  now.Add(sessionTTL).UnixMilli(),
+	})
+}
+
+func currentSession(w http.ResponseWriter, r *http.Request, history store.Store) {
+	token := bearerToken(r)
+	username, expires, err := resumeSession(history, token)
+	if token == "" || errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusUnauthorized, api.ErrCodeUnauthorized, "session expired")
+		return
+	} else if err != nil {
+		log.Error("failed to look up session", "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "session could not be checked")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(api.Session{Username: username, Expires: expires.UnixMilli()})
+}
+
+func endSession(w http.ResponseWriter, r *http.Request, history store.Store) {
+	username, ok := requireSession(w, r, history)
+	if !ok {
+		return
+	}
+	if err := history.DeleteSession(hashToken(bearerToken(r))); err != nil {
+		log.Error("failed to end session", "username", username, "error", err)
+		writeError(w, http.StatusInternalServerError, api.ErrCodeServerError, "could not sign out")
+		return
+	}
+	log.Info("signed out", "username", username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"go-chat/shared/api"
+	apihttp "go-chat/shared/http"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestCreateSessionGuestNames checks which names guests may sign in with,
+// and that a guest name is held by one session at a time
+func TestCreateSessionGuestNames(t *testing.T) {
+	serverURL, history := newTestServer(t)
+	token, err := ensureAccount(history, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := apihttp.NewClient(serverURL, api.RouteCreateSession)
+
+	for _, tc := range []struct {
+		username, token string
+		status          int // Zero when signing in succeeds
+	}{
+		{username: "carol"},
+		{username: "carol", status: http.StatusConflict},
+		{username: " dave.b "},
+		{username: "ünal"},
+		{username: "eve!", status: http.StatusBadRequest},
+		{username: "eve smith", status: http.StatusBadRequest},
+		{username: "eve.", status: http.StatusBadRequest},
+		{username: "<b>eve</b>", status: http.StatusBadRequest},
+		{username: strings.Repeat("e", 33), status: http.StatusBadRequest},
+		{username: strings.Repeat("e", 32)},
+		{username: "alice", status: http.StatusUnauthorized},
+		{username: "alice", token: token},
+		{username: "alice", token: token}, // Accounts sign in on every device
+		{username: "frank", token: "acct_made_up", status: http.StatusUnauthorized},
+	} {
+		_, err := create.Request(api.SessionRequest{Username: tc.username, Token: tc.token})
+		var apiErr *apihttp.APIError
+		switch {
+		case tc.status == 0 && err != nil:
+			t.Errorf("signing in as %q: %v", tc.username, err)
+		case tc.status != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tc.status):
+			t.Errorf("signing in as %q = %v; want %d", tc.username, err, tc.status)
+		}
+	}
+}
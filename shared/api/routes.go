@@ -1,7 +1,12 @@
 package api
 
 import (
+	"fmt"
 	"go-chat/shared/http"
+	"go-chat/shared/ws"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // WebSocket message types
@@ -21,8 +26,239 @@ type (
 
 	// HealthResponse represents the health check response
 	HealthResponse struct {
-		Status    string `json:"status"`
-		Timestamp int64  `json:"timestamp"`
+		Status     string                     `json:"status"`
+		Timestamp  int64                      `json:"timestamp"`
+		Uptime     int64                      `json:"uptime"` // Seconds since the server started
+		Clients    int                        `json:"clients"`
+		Version    VersionResponse            `json:"version"`
+		Components map[string]ComponentHealth `json:"components,omitempty"`
+	}
+
+	// ComponentHealth is the readiness of a single server component
+	ComponentHealth struct {
+		Status  string `json:"status"`
+		Latency int64  `json:"latency"` // Round-trip time in milliseconds
+		Error   string `json:"error,omitempty"`
+	}
+
+	// VersionResponse reports the build hashes of the running server and of
+	// the frontend bundle it currently serves
+	VersionResponse struct {
+		Server   string `json:"server"`
+		Frontend string `json:"frontend"`
+	}
+
+	// ConversationsRequest asks for the direct message conversations of the
+	// user signed in
+	ConversationsRequest struct {
+		Username string `json:"username,omitempty"` // Defaults to the user signed in, the only one allowed
+	}
+
+	// Conversation summarizes the direct messages between a user and
+	// another user
+	Conversation struct {
+		With        string           `json:"with"`         // The other user
+		LastMessage ws.DirectMessage `json:"last_message"` // Most recent message in either direction
+		Count       int              `json:"count"`        // Number of messages exchanged
+	}
+
+	// DirectMessagesRequest asks for the latest direct messages between the
+	// user signed in and another user
+	DirectMessagesRequest struct {
+		Username string `json:"username,omitempty"` // Defaults to the user signed in, the only one allowed
+		With     string `json:"with"`
+		Limit    int    `json:"limit,omitempty"` // Defaults to DefaultDirectMessagesLimit
+	}
+
+	// MembersRequest asks for the presence of a room's members
+	MembersRequest struct {
+		Room string `json:"room,omitempty"` // Defaults to the room every client joins
+	}
+
+	// RepliesRequest asks for a page of the replies to a room message
+	RepliesRequest struct {
+		Room     string `json:"room,omitempty"`   // Defaults to the room every client joins
+		ParentID string `json:"parent_id"`        // ID of the root message
+		Before   uint64 `json:"before,omitempty"` // Only replies with a lower Seq; the latest when zero
+		Limit    int    `json:"limit,omitempty"`  // Defaults to DefaultRepliesLimit
+	}
+
+	// RepliesResponse is a page of the replies to a room message
+	RepliesResponse struct {
+		Parent  ws.TextMessage   `json:"parent"`   // The root message
+		Replies []ws.TextMessage `json:"replies"`  // Replies ordered by Seq
+		HasMore bool             `json:"has_more"` // Older replies are available before the first one
+	}
+
+	// SearchMessagesRequest searches the messages of a room
+	SearchMessagesRequest struct {
+		Query  string `json:"query"`            // Words that must all occur in a message
+		Room   string `json:"room,omitempty"`   // Defaults to the room every client joins
+		From   string `json:"from,omitempty"`   // Only messages by this user
+		Since  int64  `json:"since,omitempty"`  // Only messages sent at or after, in Unix milliseconds
+		Until  int64  `json:"until,omitempty"`  // Only messages sent before, in Unix milliseconds
+		Offset int    `json:"offset,omitempty"` // Number of matches to skip
+		Limit  int    `json:"limit,omitempty"`  // Defaults to DefaultSearchLimit
+	}
+
+	// SearchMessagesResponse is a page of the messages matching a search,
+	// newest first
+	SearchMessagesResponse struct {
+		Results []SearchResult `json:"results"`
+		Total   int            `json:"total"` // Number of matches across all pages
+	}
+
+	// SearchResult is a message matching a search
+	SearchResult struct {
+		Room    string         `json:"room"`
+		Message ws.TextMessage `json:"message"`
+	}
+
+	// UploadAttachmentRequest uploads a file as multipart form data, in a
+	// part named AttachmentFormField. The response is the ws.Attachment to
+	// reference in a chat message.
+	UploadAttachmentRequest struct {
+		Username string `json:"username"`
+	}
+
+	// AttachmentRequest asks for the contents of an uploaded file
+	AttachmentRequest struct {
+		ID        string `json:"id"`
+		Thumbnail bool   `json:"thumbnail,omitempty"` // Ask for the preview instead, if it has one
+	}
+
+	// MessageRevision is a text a message had before it was edited or
+	// deleted
+	MessageRevision struct {
+		MessageID string `json:"message_id"`
+		Room      string `json:"room"`
+		Text      string `json:"text"`       // The text that was replaced
+		ChangedBy string `json:"changed_by"` // Username of the author or moderator who changed it
+		ChangedAt int64  `json:"changed_at"` // Unix milliseconds of the change
+		Deleted   bool   `json:"deleted"`    // The change deleted the message
+	}
+
+	// BansRequest asks for the bans of a room. Only moderators and owners
+	// may list them.
+	BansRequest struct {
+		Room string `json:"room,omitempty"` // Defaults to the room every client joins
+	}
+
+	// Ban keeps a user out of a room
+	Ban struct {
+		Room     string `json:"room"`
+		Username string `json:"username"`         // The banned user
+		By       string `json:"by"`               // Username of the moderator or owner who banned them
+		Reason   string `json:"reason,omitempty"` // Shown to the banned user
+		At       int64  `json:"at"`               // Unix milliseconds of the ban
+		Until    int64  `json:"until,omitempty"`  // Unix milliseconds the ban ends; zero when permanent
+	}
+
+	// AuditLogRequest asks for the latest moderation actions in a room. Only
+	// moderators and owners may read them.
+	AuditLogRequest struct {
+		Room  string `json:"room,omitempty"`  // Defaults to the room every client joins
+		Limit int    `json:"limit,omitempty"` // Defaults to DefaultAuditLogLimit
+	}
+
+	// AuditEntry records a moderation action
+	AuditEntry struct {
+		Room      string `json:"room"`
+		Action    string `json:"action"`               // One of the Audit actions
+		Actor     string `json:"actor"`                // Username of the moderator or owner
+		Target    string `json:"target"`               // Username of the user acted on
+		Role      string `json:"role,omitempty"`       // New role of Target, for AuditRole
+		MessageID string `json:"message_id,omitempty"` // The changed message, for AuditEdit and AuditDelete
+		Reason    string `json:"reason,omitempty"`
+		Until     int64  `json:"until,omitempty"` // Unix milliseconds a ban or mute ends; zero for permanent bans
+		At        int64  `json:"at"`              // Unix milliseconds of the action
+	}
+
+	// Command describes a slash command rooms run from chat messages
+	Command struct {
+		Name        string `json:"name"`            // Typed after the slash
+		Usage       string `json:"usage,omitempty"` // Arguments, e.g. "<username> [reason]"
+		Description string `json:"description"`
+		Role        string `json:"role,omitempty"` // Least role that may run it; empty when anyone may
+	}
+
+	// CreateBotRequest creates a bot account. Only owners of the room every
+	// client joins may manage them.
+	CreateBotRequest struct {
+		Name        string `json:"name"` // Username the bot connects as
+		Description string `json:"description,omitempty"`
+	}
+
+	// CreateBotResponse is a new bot account with its token, which is not
+	// shown again
+	CreateBotResponse struct {
+		Bot   Bot    `json:"bot"`
+		Token string `json:"token"`
+	}
+
+	// DeleteBotRequest deletes a bot account. Its connections stay open
+	// until they close.
+	DeleteBotRequest struct {
+		Name string `json:"name"`
+	}
+
+	// Bot is a user account that programs connect as, with a token
+	Bot struct {
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+		CreatedBy   string `json:"created_by"` // Username of the owner who created it
+		CreatedAt   int64  `json:"created_at"` // Unix milliseconds
+	}
+
+	// SessionRequest signs in as a user. Names of accounts, including bots,
+	// need the account's token; any other name is taken as a guest, while
+	// no one else holds a session for it.
+	SessionRequest struct {
+		Username string `json:"username"`
+		Token    string `json:"token,omitempty"` // Token of the account named Username
+	}
+
+	// Session identifies a signed in user. Requests send Token as a bearer
+	// token, and WebSocket connections in their ws.QuerySession parameter.
+	Session struct {
+		Username string `json:"username"`
+		Token    string `json:"token,omitempty"` // Only sent when the session is created
+		Expires  int64  `json:"expires"`         // Unix milliseconds; using the session extends it
+	}
+
+	// CreateAccountRequest creates an account for a person, so they can
+	// sign in with its token and hold a role
+	CreateAccountRequest struct {
+		Name string `json:"name"` // Username the account signs in as
+	}
+
+	// CreateAccountResponse is a new account with its token, which is not
+	// shown again
+	CreateAccountResponse struct {
+		Account Account `json:"account"`
+		Token   string  `json:"token"`
+	}
+
+	// DeleteAccountRequest deletes an account, ending its sessions and
+	// taking its role away
+	DeleteAccountRequest struct {
+		Name string `json:"name"`
+	}
+
+	// Account is a username reserved for whoever holds its token
+	Account struct {
+		Name      string `json:"name"`
+		CreatedBy string `json:"created_by"` // Username of the owner who created it; empty when created at startup
+		CreatedAt int64  `json:"created_at"` // Unix milliseconds
+	}
+
+	// ReadCursor is the last room message a user has read. Seq is only
+	// meaningful within Epoch, since sequence numbers restart with it.
+	ReadCursor struct {
+		Epoch     string `json:"epoch"`
+		Seq       uint64 `json:"seq"`
+		MessageID string `json:"message_id"`
+		ReadAt    int64  `json:"read_at"` // Unix milliseconds
 	}
 
 	// ChatMessage represents a chat message
@@ -60,7 +296,47 @@ type (
 // API Routes - Single source of truth for all API endpoints
 var (
 	// Health Routes
-	RouteHealth = http.NewRoute[struct{}, HealthResponse]("/api/health", http.MethodGet)
+	RouteHealth      = http.NewRoute[struct{}, HealthResponse]("/api/health", http.MethodGet) // Same as RouteHealthReady
+	RouteHealthLive  = http.NewRoute[struct{}, HealthResponse]("/api/health/live", http.MethodGet)
+	RouteHealthReady = http.NewRoute[struct{}, HealthResponse]("/api/health/ready", http.MethodGet)
+
+	// Version Routes
+	RouteVersion = http.NewRoute[struct{}, VersionResponse]("/api/version", http.MethodGet)
+
+	// Direct Message Routes
+	RouteConversations  = http.NewRoute[ConversationsRequest, []Conversation]("/api/dm/conversations", http.MethodGet)
+	RouteDirectMessages = http.NewRoute[DirectMessagesRequest, []ws.DirectMessage]("/api/dm/messages", http.MethodGet)
+
+	// Room Routes
+	RouteRoomMembers = http.NewRoute[MembersRequest, []ws.Presence]("/api/rooms/members", http.MethodGet)
+	RouteReplies     = http.NewRoute[RepliesRequest, RepliesResponse]("/api/rooms/replies", http.MethodGet)
+	RouteBans        = http.NewRoute[BansRequest, []Ban]("/api/rooms/bans", http.MethodGet)
+	RouteAuditLog    = http.NewRoute[AuditLogRequest, []AuditEntry]("/api/rooms/audit", http.MethodGet)
+
+	// Command Routes
+	RouteCommands = http.NewRoute[struct{}, []Command]("/api/commands", http.MethodGet)
+
+	// Bot Routes
+	RouteBots      = http.NewRoute[struct{}, []Bot]("/api/bots", http.MethodGet)
+	RouteCreateBot = http.NewRoute[CreateBotRequest, CreateBotResponse]("/api/bots", http.MethodPost)
+	RouteDeleteBot = http.NewRoute[DeleteBotRequest, struct{}]("/api/bots", http.MethodDelete)
+
+	// Session Routes
+	RouteCreateSession = http.NewRoute[SessionRequest, Session]("/api/session", http.MethodPost)
+	RouteSession       = http.NewRoute[struct{}, Session]("/api/session", http.MethodGet)
+	RouteEndSession    = http.NewRoute[struct{}, struct{}]("/api/session", http.MethodDelete)
+
+	// Account Routes
+	RouteAccounts      = http.NewRoute[struct{}, []Account]("/api/accounts", http.MethodGet)
+	RouteCreateAccount = http.NewRoute[CreateAccountRequest, CreateAccountResponse]("/api/accounts", http.MethodPost)
+	RouteDeleteAccount = http.NewRoute[DeleteAccountRequest, struct{}]("/api/accounts", http.MethodDelete)
+
+	// Search Routes
+	RouteSearchMessages = http.NewRoute[SearchMessagesRequest, SearchMessagesResponse]("/api/search/messages", http.MethodGet)
+
+	// Attachment Routes
+	RouteUploadAttachment = http.NewRoute[UploadAttachmentRequest, ws.Attachment]("/api/attachments", http.MethodPost)
+	RouteAttachment       = http.NewRoute[AttachmentRequest, struct{}]("/api/attachments/file", http.MethodGet) // Answers with the file itself
 
 	// Chat Routes
 	RouteSendMessage   = http.NewRoute[SendMessageRequest, ChatMessage]("/api/chat/messages", http.MethodPost)
@@ -68,10 +344,117 @@ var (
 	RouteWebSocketChat = http.NewRoute[struct{}, struct{}]("/api/chat/ws", http.MethodGet)
 )
 
+// Health statuses reported in HealthResponse and ComponentHealth
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+// User roles in a room, from the least to the most privileged
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator" // May edit and delete anyone's messages, and kick, ban and mute members
+	RoleOwner     = "owner"     // May also act on moderators, and appoint them
+)
+
+// RoleRank orders roles by privilege. Unknown roles rank as members.
+func RoleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 2
+	case RoleModerator:
+		return 1
+	}
+	return 0
+}
+
+// Actions recorded in AuditEntry
+const (
+	AuditKick   = "kick"
+	AuditBan    = "ban"
+	AuditUnban  = "unban"
+	AuditMute   = "mute"
+	AuditUnmute = "unmute"
+	AuditRole   = "role"
+	AuditEdit   = "edit"   // A moderator edited someone else's message
+	AuditDelete = "delete" // A moderator deleted someone else's message
+)
+
+// VersionUnknown is reported in a VersionResponse when a build hash cannot
+// be determined
+const VersionUnknown = "unknown"
+
+// Bounds of DirectMessagesRequest.Limit
+const (
+	DefaultDirectMessagesLimit = 50
+	MaxDirectMessagesLimit     = 200
+)
+
+// Bounds of RepliesRequest.Limit
+const (
+	DefaultRepliesLimit = 50
+	MaxRepliesLimit     = 200
+)
+
+// ParseDuration parses a duration typed by a user: a Go duration such as
+// 30m or 12h, or a number of days such as 7d
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Bounds of AuditLogRequest.Limit
+const (
+	DefaultAuditLogLimit = 50
+	MaxAuditLogLimit     = 500
+)
+
+// Bounds of SearchMessagesRequest.Limit
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// Attachment limits
+const (
+	MaxAttachmentSize   = 10 << 20 // Bytes per file
+	MaxAttachments      = 10       // Files per chat message
+	AttachmentFormField = "file"   // Multipart part holding an uploaded file
+)
+
+// AttachmentTypes are the content types files may be uploaded as. Content
+// types are detected from the file contents, not taken from the upload.
+var AttachmentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"application/zip",
+	"text/plain",
+}
+
+// AttachmentURL returns the URL serving an uploaded file, or its preview
+func AttachmentURL(id string, thumbnail bool) string {
+	url := RouteAttachment.Path + "?id=" + id
+	if thumbnail {
+		url += "&thumbnail=true"
+	}
+	return url
+}
+
 // APIError codes for standardized error handling
 const (
 	ErrCodeInvalidRequest = "INVALID_REQUEST"
 	ErrCodeUnauthorized   = "UNAUTHORIZED"
+	ErrCodeForbidden      = "FORBIDDEN"
 	ErrCodeNotFound       = "NOT_FOUND"
 	ErrCodeServerError    = "SERVER_ERROR"
+	ErrCodeRateLimited    = "RATE_LIMITED"
 )
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 )
 
@@ -24,6 +25,7 @@ type Client[Req any, Res any] struct {
 	baseURL    string
 	httpClient *http.Client
 	route      Route[Req, Res]
+	token      string
 }
 
 // NewClient creates a new HTTP client with the given base URL and route
@@ -35,18 +37,38 @@ func NewClient[Req any, Res any](baseURL string, route Route[Req, Res]) *Client[
 	}
 }
 
-// Request makes a type-safe HTTP request using the client's route
+// WithToken returns a copy of the client that sends token as a bearer
+// token, to act for a session
+func (c *Client[Req, Res]) WithToken(token string) *Client[Req, Res] {
+	clone := *c
+	clone.token = token
+	return &clone
+}
+
+// Request makes a type-safe HTTP request using the client's route. GET and
+// DELETE requests send req as query parameters, other methods as a JSON body.
 func (c *Client[Req, Res]) Request(req Req) (*Res, error) {
+	url := c.baseURL + c.route.Path
+
 	var body io.Reader
 	if !isEmptyStruct(req) {
-		jsonData, err := json.Marshal(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		if hasBody(c.route.Method) {
+			jsonData, err := json.Marshal(req)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal request: %w", err)
+			}
+			body = bytes.NewBuffer(jsonData)
+		} else {
+			query, err := EncodeQuery(req)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode request: %w", err)
+			}
+			if len(query) > 0 {
+				url += "?" + query.Encode()
+			}
 		}
-		body = bytes.NewBuffer(jsonData)
 	}
 
-	url := c.baseURL + c.route.Path
 	httpReq, err := http.NewRequest(c.route.Method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -56,6 +78,46 @@ func (c *Client[Req, Res]) Request(req Req) (*Res, error) {
 		httpReq.Header.Set("Content-Type", "application/json")
 	}
 
+	return c.do(httpReq)
+}
+
+// Upload sends data as a file named filename in the multipart form part
+// field, with req as query parameters
+func (c *Client[Req, Res]) Upload(req Req, field, filename string, data []byte) (*Res, error) {
+	url := c.baseURL + c.route.Path
+	query, err := EncodeQuery(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	if len(query) > 0 {
+		url += "?" + query.Encode()
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode file: %w", err)
+	}
+	part.Write(data)
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode file: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(c.route.Method, url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", form.FormDataContentType())
+
+	return c.do(httpReq)
+}
+
+// do sends a request and decodes the JSON response
+func (c *Client[Req, Res]) do(httpReq *http.Request) (*Res, error) {
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// hasBody reports whether requests using method carry their parameters in
+// a JSON body rather than in the query string
+func hasBody(method string) bool {
+	return method != MethodGet && method != MethodDelete
+}
+
+// EncodeQuery encodes the exported fields of a request struct as query
+// parameters, named after their json tags. Zero values are omitted.
+func EncodeQuery(req any) (url.Values, error) {
+	values := url.Values{}
+	v := reflect.Indirect(reflect.ValueOf(req))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("query parameters must be a struct, got %T", req)
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := queryName(t.Field(i))
+		if !ok || v.Field(i).IsZero() {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			values.Set(name, field.String())
+		case reflect.Bool:
+			values.Set(name, strconv.FormatBool(field.Bool()))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			values.Set(name, strconv.FormatInt(field.Int(), 10))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			values.Set(name, strconv.FormatUint(field.Uint(), 10))
+		case reflect.Float32, reflect.Float64:
+			values.Set(name, strconv.FormatFloat(field.Float(), 'f', -1, 64))
+		default:
+			return nil, fmt.Errorf("unsupported query parameter %s of type %s", name, field.Type())
+		}
+	}
+	return values, nil
+}
+
+// DecodeQuery is the inverse of EncodeQuery. req must be a pointer to a
+// struct; parameters missing from values leave their fields untouched.
+func DecodeQuery(values url.Values, req any) error {
+	v := reflect.ValueOf(req)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("query parameters must be decoded into a struct pointer, got %T", req)
+	}
+	v = v.Elem()
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := queryName(t.Field(i))
+		if !ok || !values.Has(name) {
+			continue
+		}
+		raw := values.Get(name)
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(raw)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			field.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			field.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			field.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(raw, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			field.SetFloat(f)
+		default:
+			return fmt.Errorf("unsupported query parameter %s of type %s", name, field.Type())
+		}
+	}
+	return nil
+}
+
+// queryName returns the parameter name of a struct field, or false if the
+// field is not a parameter
+func queryName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	}
+	return name, true
+}
@@ -0,0 +1,492 @@
+// Package markdown parses the Markdown subset chat messages are written
+// in: bold, italics, inline code, fenced code blocks, links and mentions.
+// The frontend renders the parsed nodes, and the server uses the same
+// parser to find who a message mentions and what it links to.
+//
+// Parsing never fails. Markup that does not match the subset is kept as
+// literal text, and raw HTML is never interpreted.
+package markdown
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind is the type of a Node
+type Kind int
+
+const (
+	Text      Kind = iota // Literal text in Node.Text
+	LineBreak             // A newline within a paragraph
+	Bold                  // Node.Children in bold
+	Italic                // Node.Children in italics
+	Code                  // Inline code in Node.Text
+	CodeBlock             // Fenced code in Node.Text, its language in Node.Lang
+	Link                  // A link to Node.URL labelled by Node.Children
+	Mention               // A mention of the username in Node.Text
+)
+
+// Node is an element of a parsed message
+type Node struct {
+	Kind     Kind
+	Text     string
+	URL      string // Always http, https or mailto
+	Lang     string
+	Children []Node
+}
+
+// maxDepth bounds how deeply bold, italics and links nest, so hostile
+// input cannot recurse without limit. Deeper markup stays literal.
+const maxDepth = 8
+
+// fence opens and closes code blocks
+const fence = "```"
+
+// Parse parses a message
+func Parse(src string) []Node {
+	var nodes []Node
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			nodes = append(nodes, parseInline(strings.Join(paragraph, "\n"), 0, false)...)
+			paragraph = nil
+		}
+	}
+
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		lang, ok := openFence(lines[i])
+		if !ok {
+			paragraph = append(paragraph, lines[i])
+			continue
+		}
+
+		// An unclosed block runs to the end of the message
+		end := len(lines)
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == fence {
+				end = j
+				break
+			}
+		}
+		flush()
+		nodes = append(nodes, Node{
+			Kind: CodeBlock,
+			Text: strings.Join(lines[i+1:end], "\n"),
+			Lang: lang,
+		})
+		i = end
+	}
+	flush()
+	return nodes
+}
+
+// openFence reports whether line opens a code block, and the language
+// named after the fence
+func openFence(line string) (string, bool) {
+	info, ok := strings.CutPrefix(strings.TrimSpace(line), fence)
+	if !ok || strings.Contains(info, "`") {
+		return "", false
+	}
+	lang, _, _ := strings.Cut(strings.TrimSpace(info), " ")
+	return lang, true
+}
+
+// inline parses the text of a paragraph
+type inline struct {
+	src     string
+	depth   int
+	inLink  bool // Links do not nest, so link labels contain no links
+	nodes   []Node
+	pending strings.Builder // Literal text not yet added to nodes
+
+	// unclosed holds, per closing delimiter, a position from which the
+	// text has no valid closing delimiter. Openers after it fail at once,
+	// which keeps text full of unmatched openers linear to parse.
+	unclosed map[string]int
+}
+
+func parseInline(src string, depth int, inLink bool) []Node {
+	p := &inline{src: src, depth: depth, inLink: inLink, unclosed: make(map[string]int)}
+	p.parse()
+	return p.nodes
+}
+
+// closable reports whether a closing delimiter may follow start
+func (p *inline) closable(delim string, start int) bool {
+	at, ok := p.unclosed[delim]
+	return !ok || start < at
+}
+
+// noClose records that no closing delimiter follows start
+func (p *inline) noClose(delim string, start int) {
+	if p.closable(delim, start) {
+		p.unclosed[delim] = start
+	}
+}
+
+// text adds literal text
+func (p *inline) text(s string) {
+	p.pending.WriteString(s)
+}
+
+// add adds a node after any pending literal text
+func (p *inline) add(n Node) {
+	p.flush()
+	p.nodes = append(p.nodes, n)
+}
+
+func (p *inline) flush() {
+	if p.pending.Len() > 0 {
+		p.nodes = append(p.nodes, Node{Kind: Text, Text: p.pending.String()})
+		p.pending.Reset()
+	}
+}
+
+func (p *inline) parse() {
+	s := p.src
+	for i := 0; i < len(s); {
+		var n int
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			p.text(s[i+1 : i+2])
+			n = 2
+		case c == '\n':
+			p.add(Node{Kind: LineBreak})
+			n = 1
+		case c == '`':
+			n = p.code(i)
+		case c == '*' || c == '_':
+			n = p.emphasis(i)
+		case c == '[' && !p.inLink:
+			n = p.link(i)
+		case c == '@':
+			n = p.mention(i)
+		case (c == 'h' || c == 'H') && !p.inLink:
+			n = p.autolink(i)
+		}
+		if n == 0 {
+			// Not markup; copy the whole rune
+			_, n = utf8.DecodeRuneInString(s[i:])
+			p.text(s[i : i+n])
+		}
+		i += n
+	}
+	p.flush()
+}
+
+// code parses an inline code span opened by the backticks at i, returning
+// the bytes consumed. A run of backticks without a closing run of the same
+// length is literal.
+func (p *inline) code(i int) int {
+	s := p.src
+	run := runLength(s, i)
+	delim := s[i : i+run]
+	for j := i + run; j < len(s) && p.closable(delim, i+run); {
+		k := strings.IndexByte(s[j:], '`')
+		if k < 0 {
+			break
+		}
+		j += k
+		if closing := runLength(s, j); closing != run {
+			j += closing
+			continue
+		}
+		content := s[i+run : j]
+		// A space on both sides allows code starting or ending in a backtick
+		if len(content) >= 2 && content[0] == ' ' && content[len(content)-1] == ' ' && strings.TrimSpace(content) != "" {
+			content = content[1 : len(content)-1]
+		}
+		p.add(Node{Kind: Code, Text: content})
+		return j + run - i
+	}
+	p.noClose(delim, i+run)
+	p.text(s[i : i+run])
+	return run
+}
+
+// emphasis parses bold (** or __) or italics (* or _) opened at i,
+// returning the bytes consumed
+func (p *inline) emphasis(i int) int {
+	s := p.src
+	c := s[i]
+	run := runLength(s, i)
+	if p.depth >= maxDepth {
+		p.text(s[i : i+run])
+		return run
+	}
+	// Underscores inside words, as in snake_case, are literal
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		p.text(s[i : i+run])
+		return run
+	}
+
+	width, kind := 1, Italic
+	if run >= 2 {
+		width, kind = 2, Bold
+	}
+	delim := s[i : i+width]
+	start := i + width
+	if start >= len(s) || isSpace(s[start]) {
+		p.text(s[i : i+run])
+		return run
+	}
+
+	for j := start; j < len(s) && p.closable(delim, start); {
+		k := strings.Index(s[j:], delim)
+		if k < 0 {
+			break
+		}
+		j += k
+		closing := runLength(s, j)
+		switch {
+		case width == 1 && closing != 1:
+			// Part of a bold delimiter nested in the italics
+			j += closing
+			continue
+		case j == start || isSpace(s[j-1]):
+			j += closing
+			continue
+		case c == '_' && j+width < len(s) && isWordByte(s[j+width]):
+			j += closing
+			continue
+		}
+		// In a longer closing run, as in ***both***, this delimiter is last
+		j += closing - width
+		p.add(Node{Kind: kind, Children: parseInline(s[start:j], p.depth+1, p.inLink)})
+		return j + width - i
+	}
+	p.noClose(delim, start)
+	p.text(s[i : i+run])
+	return run
+}
+
+// link parses a [label](url) link opened at i, returning the bytes
+// consumed, or zero if there is none
+func (p *inline) link(i int) int {
+	s := p.src
+	if p.depth >= maxDepth {
+		return 0
+	}
+	if !p.closable("]", i) {
+		return 0
+	}
+	labelEnd := strings.IndexByte(s[i:], ']')
+	if labelEnd < 0 {
+		p.noClose("]", i)
+		return 0
+	}
+	if i+labelEnd+1 >= len(s) || s[i+labelEnd+1] != '(' || !p.closable(")", i+labelEnd+2) {
+		return 0
+	}
+	label := s[i+1 : i+labelEnd]
+	rest := s[i+labelEnd+2:]
+	if strings.IndexByte(rest, ')') < 0 {
+		p.noClose(")", i+labelEnd+2)
+		return 0
+	}
+	end := destinationEnd(rest)
+	if end < 0 || label == "" {
+		return 0
+	}
+	target, ok := safeURL(strings.TrimSpace(rest[:end]))
+	if !ok {
+		return 0
+	}
+	p.add(Node{Kind: Link, URL: target, Children: parseInline(label, p.depth+1, true)})
+	return labelEnd + 2 + end + 1
+}
+
+// maxDestination bounds the bytes searched for the end of a link
+// destination, so text full of unclosed parentheses stays quick to parse
+const maxDestination = 2048
+
+// destinationEnd returns the index of the parenthesis closing the link
+// destination rest starts with, or -1 if there is none. Parentheses
+// within it are balanced, as in https://en.wikipedia.org/wiki/Go_(game).
+func destinationEnd(rest string) int {
+	depth := 0
+	for j := 0; j < min(len(rest), maxDestination); j++ {
+		switch rest[j] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return j
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// mention parses an @username mention at i, returning the bytes consumed,
+// or zero if there is none. An @ following a word, as in an email
+// address, is not a mention.
+func (p *inline) mention(i int) int {
+	s := p.src
+	if i > 0 && isWordByte(s[i-1]) {
+		return 0
+	}
+	end := i + 1
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if !isUsernameRune(r) {
+			break
+		}
+		end += size
+	}
+	// Punctuation ending a sentence is not part of the name
+	username := strings.TrimRight(s[i+1:end], ".-")
+	if username == "" {
+		return 0
+	}
+	p.add(Node{Kind: Mention, Text: username})
+	return 1 + len(username)
+}
+
+// autolink parses a bare http or https URL at i, returning the bytes
+// consumed, or zero if there is none
+func (p *inline) autolink(i int) int {
+	s := p.src
+	if i > 0 && isWordByte(s[i-1]) {
+		return 0
+	}
+	lower := strings.ToLower(s[i:min(len(s), i+8)])
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return 0
+	}
+	end := i
+	for end < len(s) && !isSpace(s[end]) && s[end] != '<' && s[end] != '>' && s[end] != '"' {
+		end++
+	}
+	raw := trimURL(s[i:end])
+	target, ok := safeURL(raw)
+	if !ok {
+		return 0
+	}
+	p.add(Node{Kind: Link, URL: target, Children: []Node{{Kind: Text, Text: raw}}})
+	return len(raw)
+}
+
+// trimURL drops punctuation that ends the sentence around a bare URL, and
+// closing parentheses that the URL did not open
+func trimURL(raw string) string {
+	for raw != "" {
+		last := raw[len(raw)-1]
+		switch {
+		case strings.IndexByte(".,:;!?'*_", last) >= 0:
+			raw = raw[:len(raw)-1]
+		case last == ')' && strings.Count(raw, "(") < strings.Count(raw, ")"):
+			raw = raw[:len(raw)-1]
+		default:
+			return raw
+		}
+	}
+	return raw
+}
+
+// safeURL reports whether raw is an absolute http, https or mailto URL,
+// the only links messages may contain, and returns it normalized
+func safeURL(raw string) (string, bool) {
+	if raw == "" || strings.ContainsFunc(raw, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) {
+		return "", false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
+
+// runLength counts the bytes equal to s[i] starting at i
+func runLength(s string, i int) int {
+	n := 1
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isPunct reports whether c is ASCII punctuation, which a backslash
+// escapes
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+// isWordByte reports whether c is part of a word. Bytes of multi-byte
+// runes count as word bytes, so markup touching non-ASCII letters is
+// treated like markup touching ASCII ones.
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// Mentionable reports whether a username can be mentioned in full, so
+// that @username reaches its user
+func Mentionable(username string) bool {
+	return username != "" &&
+		strings.TrimRight(username, ".-") == username &&
+		!strings.ContainsFunc(username, func(r rune) bool { return !isUsernameRune(r) })
+}
+
+// isUsernameRune reports whether r may be part of a mentioned username
+func isUsernameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+// Mentions returns the usernames mentioned in nodes, in the order they are
+// first mentioned
+func Mentions(nodes []Node) []string {
+	var usernames []string
+	walk(nodes, func(n Node) {
+		if n.Kind == Mention && !slices.Contains(usernames, n.Text) {
+			usernames = append(usernames, n.Text)
+		}
+	})
+	return usernames
+}
+
+// Links returns the URLs linked from nodes, in the order they are first
+// linked
+func Links(nodes []Node) []string {
+	var urls []string
+	walk(nodes, func(n Node) {
+		if n.Kind == Link && !slices.Contains(urls, n.URL) {
+			urls = append(urls, n.URL)
+		}
+	})
+	return urls
+}
+
+// Extract parses a message and returns who it mentions and what it links
+// to
+func Extract(src string) (mentions, links []string) {
+	nodes := Parse(src)
+	return Mentions(nodes), Links(nodes)
+}
+
+func walk(nodes []Node, visit func(Node)) {
+	for _, n := range nodes {
+		visit(n)
+		walk(n.Children, visit)
+	}
+}
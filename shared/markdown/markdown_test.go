@@ -0,0 +1,147 @@
+package markdown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func text(s string) Node {
+	return Node{Kind: Text, Text: s}
+}
+
+func link(url string, children ...Node) Node {
+	return Node{Kind: Link, URL: url, Children: children}
+}
+
+func mention(username string) Node {
+	return Node{Kind: Mention, Text: username}
+}
+
+func code(s string) Node {
+	return Node{Kind: Code, Text: s}
+}
+
+func bold(children ...Node) Node {
+	return Node{Kind: Bold, Children: children}
+}
+
+// parseTest is a message and the nodes it parses to
+type parseTest struct {
+	src  string
+	want []Node
+}
+
+func testParse(t *testing.T, tests []parseTest) {
+	t.Helper()
+	for _, tc := range tests {
+		if got := Parse(tc.src); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) =\n%+v\nwant\n%+v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	testParse(t, []parseTest{
+		{"[x](https://e.com)", []Node{link("https://e.com", text("x"))}},
+		{"see [docs](https://e.com/docs).", []Node{text("see "), link("https://e.com/docs", text("docs")), text(".")}},
+		{"[x]( https://e.com )", []Node{link("https://e.com", text("x"))}},
+		{"[mail me](mailto:a@e.com)", []Node{link("mailto:a@e.com", text("mail me"))}},
+		{"[**bold** label](https://e.com)", []Node{link("https://e.com", bold(text("bold")), text(" label"))}},
+		// Parentheses in the destination are balanced
+		{"[x](https://e.com/a_(b))", []Node{link("https://e.com/a_(b)", text("x"))}},
+		{"[Go](https://en.wikipedia.org/wiki/Go_(game)) rocks", []Node{link("https://en.wikipedia.org/wiki/Go_(game)", text("Go")), text(" rocks")}},
+		{"([x](https://e.com/a))", []Node{text("("), link("https://e.com/a", text("x")), text(")")}},
+		{"[x](https://e.com/a)b)", []Node{link("https://e.com/a", text("x")), text("b)")}},
+		// Without a link, the URL is still found bare
+		{"[x](https://e.com/(a)", []Node{text("[x]("), link("https://e.com/(a)", text("https://e.com/(a)"))}},
+		{"[](https://e.com)", []Node{text("[]("), link("https://e.com", text("https://e.com")), text(")")}},
+		// A label ends at its first closing bracket
+		{"[[x](https://a.com)](https://b.com)", []Node{link("https://a.com", text("[x")), text("]("), link("https://b.com", text("https://b.com")), text(")")}},
+	})
+}
+
+func TestParseRejectsUnsafeLinks(t *testing.T) {
+	for _, src := range []string{
+		"[x](javascript:alert(1))",
+		"[x](JavaScript:alert(1))",
+		"[x](  javascript:alert(1)  )",
+		"[x](data:text/html,<script>alert(1)</script>)",
+		"[x](vbscript:msgbox)",
+		"[x](//evil.com)",
+		"[x](/relative)",
+		"[x](https:///no-host)",
+		"javascript:alert(1)",
+	} {
+		nodes := Parse(src)
+		if links := Links(nodes); len(links) != 0 {
+			t.Errorf("Parse(%q) links to %q", src, links)
+		}
+	}
+}
+
+func TestParseAutolinks(t *testing.T) {
+	testParse(t, []parseTest{
+		{"go to https://e.com/a.", []Node{text("go to "), link("https://e.com/a", text("https://e.com/a")), text(".")}},
+		{"(https://e.com/a_(b))", []Node{text("("), link("https://e.com/a_(b)", text("https://e.com/a_(b)")), text(")")}},
+		{"http://e.com?q=1, then", []Node{link("http://e.com?q=1", text("http://e.com?q=1")), text(", then")}},
+		{"HTTPS://E.COM", []Node{link("https://E.COM", text("HTTPS://E.COM"))}},
+		{"<https://e.com>", []Node{text("<"), link("https://e.com", text("https://e.com")), text(">")}},
+		{"xhttps://e.com", []Node{text("xhttps://e.com")}},
+		{"https://", []Node{text("https://")}},
+		{"ftp://e.com", []Node{text("ftp://e.com")}},
+	})
+}
+
+func TestParseCode(t *testing.T) {
+	testParse(t, []parseTest{
+		{"`a*b*`", []Node{code("a*b*")}},
+		{"run `go test` now", []Node{text("run "), code("go test"), text(" now")}},
+		{"``a ` b``", []Node{code("a ` b")}},
+		{"`` `tick` ``", []Node{code("`tick`")}},
+		{"`@bob https://e.com`", []Node{code("@bob https://e.com")}},
+		{"`unclosed", []Node{text("`unclosed")}},
+		{"``a`", []Node{text("``a`")}},
+		{"\\`not code\\`", []Node{text("`not code`")}},
+		{"```go\nfmt.Println()\n```", []Node{{Kind: CodeBlock, Text: "fmt.Println()", Lang: "go"}}},
+	})
+}
+
+func TestParseMentions(t *testing.T) {
+	testParse(t, []parseTest{
+		{"hi @bob!", []Node{text("hi "), mention("bob"), text("!")}},
+		{"@bob.", []Node{mention("bob"), text(".")}},
+		{"@bob.smith-jr", []Node{mention("bob.smith-jr")}},
+		{"@ünal", []Node{mention("ünal")}},
+		{"**@bob**", []Node{bold(mention("bob"))}},
+		{"a@e.com", []Node{text("a@e.com")}},
+		{"@", []Node{text("@")}},
+		{"@@bob", []Node{text("@"), mention("bob")}},
+	})
+
+	mentions, links := Extract("@bob @carol @bob https://e.com [x](https://e.com) `@dave`")
+	if want := []string{"bob", "carol"}; !reflect.DeepEqual(mentions, want) {
+		t.Errorf("Extract mentions = %q; want %q", mentions, want)
+	}
+	if want := []string{"https://e.com"}; !reflect.DeepEqual(links, want) {
+		t.Errorf("Extract links = %q; want %q", links, want)
+	}
+}
+
+func TestMentionable(t *testing.T) {
+	for username, want := range map[string]bool{
+		"bob":       true,
+		"bob.smith": true,
+		"ünal":      true,
+		"bob_1":     true,
+		"":          false,
+		"bob.":      false,
+		"bob-":      false,
+		"bob smith": false,
+		"bob!":      false,
+		"<b>":       false,
+	} {
+		if got := Mentionable(username); got != want {
+			t.Errorf("Mentionable(%q) = %v; want %v", username, got, want)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package ws
 
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
 // MessageType represents different types of WebSocket messages
 type MessageType string
 
@@ -9,12 +15,88 @@ const (
 	TypePong  MessageType = "PONG"  // Pong response to ping
 	TypeClose MessageType = "CLOSE" // Connection close message
 	TypeError MessageType = "ERROR" // Error message
+	TypeAck   MessageType = "ACK"   // A message sent by the client was accepted
+	TypeNack  MessageType = "NACK"  // A message sent by the client was rejected
+
+	TypeReloadRequired MessageType = "RELOAD_REQUIRED" // Client is running a stale frontend build
 
 	// Chat message types
 	TypeMessage MessageType = "MESSAGE" // Regular chat message
 	TypeTyping  MessageType = "TYPING"  // Typing indicator
 	TypeJoin    MessageType = "JOIN"    // User joined notification
 	TypeLeave   MessageType = "LEAVE"   // User left notification
+	TypeDirect  MessageType = "DIRECT"  // Direct message between two users
+	TypeSync    MessageType = "SYNC"    // Room history sent on join: missed messages, or everything after a reset
+	TypeEdit    MessageType = "EDIT"    // Change the text of a message
+	TypeDelete  MessageType = "DELETE"  // Replace a message with a tombstone
+	TypeReact   MessageType = "REACT"   // Add a reaction to a message
+	TypeUnreact MessageType = "UNREACT" // Remove a reaction from a message
+	TypeThread  MessageType = "THREAD"  // The replies to a message changed
+	TypeRead    MessageType = "READ"    // A user read the room up to a message
+	TypeMention MessageType = "MENTION" // A user was mentioned in a room's chat message
+	TypeUnfurl  MessageType = "UNFURL"  // Previews of the links of a message were fetched
+	TypeSystem  MessageType = "SYSTEM"  // A notice from the server, such as the reply to a slash command
+	TypeTopic   MessageType = "TOPIC"   // The topic of a room changed, or is sent on join
+	TypeNick    MessageType = "NICK"    // A user changed their username
+
+	TypeReadState MessageType = "READ_STATE" // Unread count and read cursors, sent on join
+
+	// Moderation message types
+	TypeKick   MessageType = "KICK"   // Remove a user from the room
+	TypeBan    MessageType = "BAN"    // Remove a user from the room and keep them out, for a while or for good
+	TypeUnban  MessageType = "UNBAN"  // Let a banned user back in
+	TypeMute   MessageType = "MUTE"   // Keep a user from posting for a while
+	TypeUnmute MessageType = "UNMUTE" // Let a muted user post again
+	TypeRole   MessageType = "ROLE"   // Change the role of a user in the room
+
+	// Presence message types
+	TypePresenceSnapshot MessageType = "PRESENCE_SNAPSHOT" // Everyone in the room, sent on join
+	TypePresenceUpdate   MessageType = "PRESENCE_UPDATE"   // A user's status changed; clients send it to go away or come back
+)
+
+// clientTypes are the message types clients may send. The server sends
+// every other type, and drops them when a client does.
+var clientTypes = map[MessageType]bool{
+	TypeMessage:        true,
+	TypeTyping:         true,
+	TypeDirect:         true,
+	TypeEdit:           true,
+	TypeDelete:         true,
+	TypeReact:          true,
+	TypeUnreact:        true,
+	TypeRead:           true,
+	TypeKick:           true,
+	TypeBan:            true,
+	TypeUnban:          true,
+	TypeMute:           true,
+	TypeUnmute:         true,
+	TypeRole:           true,
+	TypePresenceUpdate: true,
+}
+
+// FromClient reports whether clients may send messages of type t
+func (t MessageType) FromClient() bool {
+	return clientTypes[t]
+}
+
+// Presence statuses
+const (
+	PresenceOnline  = "online"  // At least one connection is active
+	PresenceAway    = "away"    // Connected, but every connection is idle
+	PresenceOffline = "offline" // No connections
+)
+
+// TypingTimeout is how long a user counts as typing after their last
+// TYPING. Clients typing for longer resend it well within this time.
+const TypingTimeout = 5 * time.Second
+
+// Query parameters sent with the WebSocket handshake
+const (
+	QueryBuild   = "build"   // Frontend BuildHash of the connecting client
+	QuerySession = "session" // Token of the session the connection acts for
+	QueryEpoch   = "epoch"   // SyncMessage.Epoch of the room the client last saw
+	QuerySince   = "since"   // Seq of the last room message the client saw
+	QueryRoom    = "room"    // Room to join; defaults to the room every client joins
 )
 
 // Message represents a WebSocket message structure
@@ -23,20 +105,383 @@ type Message struct {
 	Payload any         `json:"payload,omitempty"` // Message payload, can be any of the payload types below
 }
 
+// UnmarshalJSON decodes Payload into the payload type of the message's
+// Type, so receivers can use type assertions such as *TextMessage. Payloads
+// of unknown types are left as generic JSON values.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type    MessageType     `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	m.Type = raw.Type
+	m.Payload = nil
+	if len(raw.Payload) == 0 || string(raw.Payload) == "null" {
+		return nil
+	}
+
+	payload := newPayload(raw.Type)
+	if payload == nil {
+		return json.Unmarshal(raw.Payload, &m.Payload)
+	}
+	if err := json.Unmarshal(raw.Payload, payload); err != nil {
+		return err
+	}
+	m.Payload = payload
+	return nil
+}
+
+// newPayload returns a pointer to the payload type for t, or nil if t has
+// no structured payload
+func newPayload(t MessageType) any {
+	switch t {
+	case TypeClose:
+		return &CloseMessage{}
+	case TypeError:
+		return &ErrorMessage{}
+	case TypeAck:
+		return &AckMessage{}
+	case TypeNack:
+		return &NackMessage{}
+	case TypeReloadRequired:
+		return &ReloadRequiredMessage{}
+	case TypeSystem:
+		return &SystemMessage{}
+	case TypeTopic:
+		return &TopicMessage{}
+	case TypeNick:
+		return &NickMessage{}
+	case TypeMessage:
+		return &TextMessage{}
+	case TypeTyping:
+		return &TypingMessage{}
+	case TypeJoin:
+		return &JoinMessage{}
+	case TypeLeave:
+		return &LeaveMessage{}
+	case TypeDirect:
+		return &DirectMessage{}
+	case TypeSync:
+		return &SyncMessage{}
+	case TypeEdit:
+		return &EditMessage{}
+	case TypeDelete:
+		return &DeleteMessage{}
+	case TypeReact, TypeUnreact:
+		return &ReactionMessage{}
+	case TypeThread:
+		return &ThreadUpdate{}
+	case TypeRead:
+		return &ReadMarker{}
+	case TypeReadState:
+		return &ReadState{}
+	case TypeMention:
+		return &MentionNotification{}
+	case TypeUnfurl:
+		return &UnfurlMessage{}
+	case TypeKick, TypeBan, TypeUnban, TypeMute, TypeUnmute, TypeRole:
+		return &ModerationMessage{}
+	case TypePresenceSnapshot:
+		return &PresenceSnapshot{}
+	case TypePresenceUpdate:
+		return &Presence{}
+	}
+	return nil
+}
+
 // Payload types for different message types
 
-// TextMessage is the payload for TypeMessage
+// TextMessage is the payload for TypeMessage. ID, Seq and Timestamp are
+// assigned by the server; clients leave them empty. A message with a
+// ParentID is a reply in the thread of that message.
 type TextMessage struct {
-	Text string `json:"text"` // The actual message text
-	From string `json:"from"` // Username of the sender
+	ID        string `json:"id,omitempty"`        // Unique ULID of the message
+	Seq       uint64 `json:"seq,omitempty"`       // Position of the message in its room, increasing by one per message
+	Timestamp int64  `json:"timestamp,omitempty"` // Unix milliseconds when the server accepted the message
+	Nonce     string `json:"nonce,omitempty"`     // Chosen by the sending client to match the message to its ACK or NACK
+	Text      string `json:"text"`                // The actual message text
+	From      string `json:"from"`                // Username of the sender
+	EditedAt  int64  `json:"edited_at,omitempty"` // Unix milliseconds of the latest edit, if edited
+	Deleted   bool   `json:"deleted,omitempty"`   // The message was deleted and Text is empty
+	Action    bool   `json:"action,omitempty"`    // Sent with /me: Text says what From does
+
+	Reactions []Reaction `json:"reactions,omitempty"` // Reactions in the order they were first added
+
+	ParentID    string `json:"parent_id,omitempty"`     // ID of the message this replies to
+	ReplyCount  int    `json:"reply_count,omitempty"`   // Number of replies to this message, set by the server
+	LastReplyAt int64  `json:"last_reply_at,omitempty"` // Unix milliseconds of the latest reply, set by the server
+
+	Attachments []Attachment `json:"attachments,omitempty"` // Files uploaded by the sender; only their IDs are read from clients
+
+	// Found in Text by the server with the markdown package
+	Mentions []string `json:"mentions,omitempty"` // Usernames mentioned, in order of first mention
+	Links    []string `json:"links,omitempty"`    // URLs linked, in order of first link
+
+	Previews []LinkPreview `json:"previews,omitempty"` // Previews of Links, added by the server after sending
+}
+
+// LinkPreview describes a page linked from a chat message, from the
+// page's OpenGraph metadata
+type LinkPreview struct {
+	URL         string `json:"url"`                   // The link, as found in the message's text
+	Title       string `json:"title"`                 // Title of the page
+	Description string `json:"description,omitempty"` // Summary of the page
+	Image       string `json:"image,omitempty"`       // Absolute http or https URL of an image for the page
+	SiteName    string `json:"site_name,omitempty"`   // Name of the site the page belongs to
+}
+
+// Attachment describes a file uploaded through api.RouteUploadAttachment.
+// Its contents are served by api.RouteAttachment.
+type Attachment struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`                // File name given by the uploader
+	ContentType string `json:"content_type"`        // Detected by the server from the contents
+	Size        int64  `json:"size"`                // Bytes
+	Width       int    `json:"width,omitempty"`     // Pixels, for images the server could decode
+	Height      int    `json:"height,omitempty"`    // Pixels, for images the server could decode
+	Thumbnail   bool   `json:"thumbnail,omitempty"` // A scaled down preview is available
+	UploadedBy  string `json:"uploaded_by"`
+}
+
+// Reaction aggregates everyone who reacted to a message with one emoji
+type Reaction struct {
+	Emoji string   `json:"emoji"` // The emoji reacted with
+	Count int      `json:"count"` // Number of users who reacted with it
+	Users []string `json:"users"` // Usernames of those users, in the order they reacted
+}
+
+// EditMessage is the payload for TypeEdit. Clients send ID and Text; the
+// server broadcasts it with EditedBy and EditedAt set.
+type EditMessage struct {
+	ID       string `json:"id"`                  // ID of the message to change
+	Text     string `json:"text"`                // The new text
+	EditedBy string `json:"edited_by,omitempty"` // Username of the author or moderator who edited it
+	EditedAt int64  `json:"edited_at,omitempty"` // Unix milliseconds of the edit
+}
+
+// DeleteMessage is the payload for TypeDelete. Clients send ID; the server
+// broadcasts it with DeletedBy and DeletedAt set.
+type DeleteMessage struct {
+	ID        string `json:"id"`                   // ID of the message to delete
+	DeletedBy string `json:"deleted_by,omitempty"` // Username of the author or moderator who deleted it
+	DeletedAt int64  `json:"deleted_at,omitempty"` // Unix milliseconds of the deletion
+}
+
+// ReactionMessage is the payload for TypeReact and TypeUnreact. Clients
+// send ID and Emoji; the server broadcasts it with From set.
+type ReactionMessage struct {
+	ID    string `json:"id"`             // ID of the message reacted to
+	Emoji string `json:"emoji"`          // The emoji added or removed
+	From  string `json:"from,omitempty"` // Username of the user reacting
+}
+
+// React returns reactions with from's reaction added, or removed when add
+// is false, and whether anything changed. reactions is never modified, so
+// it may be shared with other readers.
+func React(reactions []Reaction, emoji, from string, add bool) ([]Reaction, bool) {
+	result := make([]Reaction, 0, len(reactions)+1)
+	changed, found := false, false
+	for _, r := range reactions {
+		if r.Emoji != emoji {
+			result = append(result, r)
+			continue
+		}
+		found = true
+
+		users := make([]string, 0, len(r.Users)+1)
+		reacted := false
+		for _, u := range r.Users {
+			if u == from {
+				reacted = true
+				if !add {
+					continue
+				}
+			}
+			users = append(users, u)
+		}
+		if add && !reacted {
+			users = append(users, from)
+		}
+		changed = add != reacted
+		if len(users) > 0 {
+			result = append(result, Reaction{Emoji: emoji, Count: len(users), Users: users})
+		}
+	}
+	if add && !found {
+		result = append(result, Reaction{Emoji: emoji, Count: 1, Users: []string{from}})
+		changed = true
+	}
+	if !changed {
+		return reactions, false
+	}
+	return result, true
+}
+
+// MergePreviews returns a preview for each of links that has one, in the
+// order of links. Previews in update take precedence over those in
+// previews, and previews of other links are dropped.
+func MergePreviews(links []string, previews, update []LinkPreview) []LinkPreview {
+	var merged []LinkPreview
+	for _, link := range links {
+		for _, candidates := range [][]LinkPreview{update, previews} {
+			if i := slices.IndexFunc(candidates, func(p LinkPreview) bool { return p.URL == link }); i >= 0 {
+				merged = append(merged, candidates[i])
+				break
+			}
+		}
+	}
+	return merged
+}
+
+// ThreadUpdate is the payload for TypeThread, sent after a reply is
+// delivered so clients can update the root message's reply count
+type ThreadUpdate struct {
+	ParentID    string `json:"parent_id"`     // ID of the root message
+	ReplyCount  int    `json:"reply_count"`   // Number of replies to it
+	LastReplyAt int64  `json:"last_reply_at"` // Unix milliseconds of the latest reply
+}
+
+// ReadMarker is the payload for TypeRead. Clients send Seq; the server
+// broadcasts it with the other fields set, once the user's cursor moved
+// forward.
+type ReadMarker struct {
+	Room     string `json:"room,omitempty"`     // Name of the room
+	Username string `json:"username,omitempty"` // User who read the messages
+	Seq      uint64 `json:"seq"`                // Seq of the last message read
+	ID       string `json:"id,omitempty"`       // ID of the last message read
+}
+
+// ReadState is the payload for TypeReadState
+type ReadState struct {
+	Room    string       `json:"room"`     // Name of the room
+	ReadSeq uint64       `json:"read_seq"` // Seq of the last message the user read
+	Unread  int          `json:"unread"`   // Messages by others after ReadSeq, not counting replies
+	Readers []ReadMarker `json:"readers"`  // Read cursors of every user who read the room
+}
+
+// UnfurlMessage is the payload for TypeUnfurl, sent by the server once it
+// fetched the previews of the links of a chat message. The previews
+// replace those the message had.
+type UnfurlMessage struct {
+	ID       string        `json:"id"`       // ID of the message
+	Previews []LinkPreview `json:"previews"` // Previews in the order of the message's links
+}
+
+// MentionNotification is the payload for TypeMention. The server sends it
+// to every connection of a room member mentioned in a chat message, in
+// whichever room they are.
+type MentionNotification struct {
+	To        string `json:"to"`                  // Username of the mentioned user
+	Room      string `json:"room"`                // Name of the room of the message
+	MessageID string `json:"message_id"`          // ID of the message
+	ParentID  string `json:"parent_id,omitempty"` // Set when the message is a reply
+	From      string `json:"from"`                // Username of the sender
+	Text      string `json:"text"`                // Text of the message
+	Timestamp int64  `json:"timestamp"`           // Unix milliseconds when the message was sent
+}
+
+// SyncMessage is the payload for TypeSync. A client that reconnects with
+// the Epoch and Seq it last saw is sent only the messages it missed;
+// otherwise, or when it fell too far behind, Reset is set and Messages
+// holds the room's recent history.
+type SyncMessage struct {
+	Room     string        `json:"room"`     // Name of the room
+	Epoch    string        `json:"epoch"`    // Identifies this run of the room; sequence numbers restart when it changes
+	Seq      uint64        `json:"seq"`      // Seq of the latest message in the room
+	Reset    bool          `json:"reset"`    // The client must drop the messages it has and keep only these
+	Messages []TextMessage `json:"messages"` // Messages ordered by Seq
+}
+
+// DirectMessage is the payload for TypeDirect. It is delivered to every
+// connection of both the sender and the recipient.
+type DirectMessage struct {
+	From      string `json:"from"`                // Username of the sender
+	To        string `json:"to"`                  // Username of the recipient
+	Text      string `json:"text"`                // The actual message text
+	Timestamp int64  `json:"timestamp,omitempty"` // Unix milliseconds, set by the server
+}
+
+// ModerationMessage is the payload for TypeKick, TypeBan, TypeUnban,
+// TypeMute, TypeUnmute and TypeRole. Clients send Username and, depending
+// on the type, Duration, Role and Reason; the server broadcasts it with the
+// other fields set.
+type ModerationMessage struct {
+	Username string `json:"username"`              // The user acted on
+	Duration int64  `json:"duration_ms,omitempty"` // Milliseconds a ban or mute lasts; bans without one are permanent
+	Role     string `json:"role,omitempty"`        // New role for TypeRole: api.RoleModerator or api.RoleMember
+	Reason   string `json:"reason,omitempty"`      // Shown to the user acted on
+	Room     string `json:"room,omitempty"`        // Name of the room
+	By       string `json:"by,omitempty"`          // Username of the moderator or owner
+	At       int64  `json:"at,omitempty"`          // Unix milliseconds of the action
+	Until    int64  `json:"until,omitempty"`       // Unix milliseconds a ban or mute ends; zero for permanent bans
+}
+
+// CloseMessage is the payload for TypeClose, sent before the server closes
+// a connection, e.g. because its user was kicked or banned. Clients should
+// not reconnect on their own.
+type CloseMessage struct {
+	Reason string `json:"reason"`          // Why the connection is closed, for people
+	Until  int64  `json:"until,omitempty"` // Unix milliseconds a ban ends, if it does
 }
 
 // ErrorMessage is the payload for TypeError
 type ErrorMessage struct {
-	Error string `json:"error"` // Error description
+	Error      string `json:"error"`                    // Error description
+	RetryAfter int64  `json:"retry_after_ms,omitempty"` // Milliseconds to wait before retrying, when rate limited
+}
+
+// AckMessage is the payload for TypeAck
+type AckMessage struct {
+	Nonce     string `json:"nonce"`     // Nonce of the accepted message
+	ID        string `json:"id"`        // ID assigned to the message
+	Timestamp int64  `json:"timestamp"` // Server timestamp assigned to the message
+
+	// Set when the message was a slash command that ran without posting a
+	// chat message, so no message with ID will be delivered
+	Command string `json:"command,omitempty"`
+}
+
+// NackMessage is the payload for TypeNack
+type NackMessage struct {
+	Nonce      string `json:"nonce"`                    // Nonce of the rejected message
+	Error      string `json:"error"`                    // Why the message was rejected
+	RetryAfter int64  `json:"retry_after_ms,omitempty"` // Milliseconds to wait before retrying, when rate limited
+}
+
+// SystemMessage is the payload for TypeSystem
+type SystemMessage struct {
+	Room      string `json:"room,omitempty"` // Room the notice is about
+	Text      string `json:"text"`           // The notice, in plain text
+	Timestamp int64  `json:"timestamp"`      // Unix milliseconds of the notice
+}
+
+// TopicMessage is the payload for TypeTopic
+type TopicMessage struct {
+	Room  string `json:"room"`
+	Topic string `json:"topic"`        // Empty when cleared
+	By    string `json:"by,omitempty"` // Username of who set it
+	At    int64  `json:"at,omitempty"` // Unix milliseconds it was set
+}
+
+// NickMessage is the payload for TypeNick. The connections of From
+// reconnect as To.
+type NickMessage struct {
+	Room string `json:"room"`
+	From string `json:"from"` // Former username
+	To   string `json:"to"`   // New username
+}
+
+// ReloadRequiredMessage is the payload for TypeReloadRequired
+type ReloadRequiredMessage struct {
+	ClientBuild  string `json:"client_build"`  // Build hash the client connected with
+	CurrentBuild string `json:"current_build"` // Build hash the server currently serves
 }
 
-// TypingMessage is the payload for TypeTyping
+// TypingMessage is the payload for TypeTyping. Clients send it while the
+// user types; the server sends only when a user starts or stops typing.
 type TypingMessage struct {
 	From     string `json:"from"`      // Username of the person typing
 	IsTyping bool   `json:"is_typing"` // Whether the user is typing
@@ -51,3 +496,20 @@ type JoinMessage struct {
 type LeaveMessage struct {
 	From string `json:"from"` // Username of the person who left
 }
+
+// Presence is the payload for TypePresenceUpdate
+type Presence struct {
+	Username string `json:"username"`            // The user whose status changed
+	Status   string `json:"status"`              // PresenceOnline, PresenceAway or PresenceOffline
+	LastSeen int64  `json:"last_seen,omitempty"` // Unix milliseconds of the user's last status change
+
+	Role       string `json:"role,omitempty"`        // Role in the room, unless a member
+	MutedUntil int64  `json:"muted_until,omitempty"` // Unix milliseconds a mute ends, while muted
+	Bot        bool   `json:"bot,omitempty"`         // The user is a bot account
+}
+
+// PresenceSnapshot is the payload for TypePresenceSnapshot
+type PresenceSnapshot struct {
+	Room    string     `json:"room"`    // Name of the room
+	Members []Presence `json:"members"` // Connected and recently seen users
+}
@@ -0,0 +1,142 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestReact checks that reactions are added and removed per user, and
+// that the reactions passed in are never modified
+func TestReact(t *testing.T) {
+	thumbs := []Reaction{{Emoji: "👍", Count: 2, Users: []string{"alice", "bob"}}}
+
+	for _, tc := range []struct {
+		name      string
+		reactions []Reaction
+		emoji     string
+		from      string
+		add       bool
+		want      []Reaction
+		changed   bool
+	}{{
+		name:    "first reaction",
+		emoji:   "🎉",
+		from:    "alice",
+		add:     true,
+		want:    []Reaction{{Emoji: "🎉", Count: 1, Users: []string{"alice"}}},
+		changed: true,
+	}, {
+		name:      "joining a reaction",
+		reactions: thumbs,
+		emoji:     "👍",
+		from:      "carol",
+		add:       true,
+		want:      []Reaction{{Emoji: "👍", Count: 3, Users: []string{"alice", "bob", "carol"}}},
+		changed:   true,
+	}, {
+		name:      "reacting twice",
+		reactions: thumbs,
+		emoji:     "👍",
+		from:      "bob",
+		add:       true,
+		want:      thumbs,
+	}, {
+		name:      "another emoji",
+		reactions: thumbs,
+		emoji:     "🎉",
+		from:      "bob",
+		add:       true,
+		want:      []Reaction{thumbs[0], {Emoji: "🎉", Count: 1, Users: []string{"bob"}}},
+		changed:   true,
+	}, {
+		name:      "removing one of several",
+		reactions: thumbs,
+		emoji:     "👍",
+		from:      "alice",
+		want:      []Reaction{{Emoji: "👍", Count: 1, Users: []string{"bob"}}},
+		changed:   true,
+	}, {
+		name:      "removing the last",
+		reactions: []Reaction{{Emoji: "👍", Count: 1, Users: []string{"bob"}}},
+		emoji:     "👍",
+		from:      "bob",
+		want:      []Reaction{},
+		changed:   true,
+	}, {
+		name:      "removing what was not there",
+		reactions: thumbs,
+		emoji:     "👍",
+		from:      "carol",
+		want:      thumbs,
+	}, {
+		name:      "removing an emoji no one used",
+		reactions: thumbs,
+		emoji:     "🎉",
+		from:      "alice",
+		want:      thumbs,
+	}} {
+		before := clone(tc.reactions)
+		got, changed := React(tc.reactions, tc.emoji, tc.from, tc.add)
+		if !reflect.DeepEqual(got, tc.want) || changed != tc.changed {
+			t.Errorf("%s: React = %+v, %v; want %+v, %v", tc.name, got, changed, tc.want, tc.changed)
+		}
+		if !reflect.DeepEqual(tc.reactions, before) {
+			t.Errorf("%s: React modified its input to %+v", tc.name, tc.reactions)
+		}
+	}
+}
+
+func clone(reactions []Reaction) []Reaction {
+	if reactions == nil {
+		return nil
+	}
+	cloned := make([]Reaction, len(reactions))
+	for i, r := range reactions {
+		cloned[i] = Reaction{Emoji: r.Emoji, Count: r.Count, Users: append([]string(nil), r.Users...)}
+	}
+	return cloned
+}
+
+// TestMergePreviews checks that previews follow the links of a message,
+// updated ones replacing those it had
+func TestMergePreviews(t *testing.T) {
+	a := LinkPreview{URL: "https://a.example", Title: "A"}
+	newA := LinkPreview{URL: "https://a.example", Title: "A, updated"}
+	b := LinkPreview{URL: "https://b.example", Title: "B"}
+
+	for _, tc := range []struct {
+		name             string
+		links            []string
+		previews, update []LinkPreview
+		want             []LinkPreview
+	}{{
+		name:   "first previews",
+		links:  []string{b.URL, a.URL},
+		update: []LinkPreview{a, b},
+		want:   []LinkPreview{b, a},
+	}, {
+		name:     "updated preview",
+		links:    []string{a.URL, b.URL},
+		previews: []LinkPreview{a, b},
+		update:   []LinkPreview{newA},
+		want:     []LinkPreview{newA, b},
+	}, {
+		name:     "link edited away",
+		links:    []string{b.URL},
+		previews: []LinkPreview{a, b},
+		want:     []LinkPreview{b},
+	}, {
+		name:     "link without a preview",
+		links:    []string{a.URL, "https://c.example"},
+		previews: []LinkPreview{a},
+		want:     []LinkPreview{a},
+	}, {
+		name:     "no links",
+		previews: []LinkPreview{a},
+		update:   []LinkPreview{b},
+	}} {
+		if got := MergePreviews(tc.links, tc.previews, tc.update); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: MergePreviews = %+v; want %+v", tc.name, got, tc.want)
+		}
+	}
+}